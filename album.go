@@ -0,0 +1,242 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "album",
+		usage: "Apply the fields shared by an album, such as album, year and cover, to all of its tracks",
+		run:   runAlbum,
+	})
+}
+
+// albumFlags maps the flags of the album command to the labels of editFields:
+// the fields all tracks of an album share.
+var albumFlags = [][2]string{
+	{"album", "Album"},
+	{"album-artist", "Album Artist"},
+	{"year", "Year"},
+	{"genre", "Genre"},
+}
+
+// albumChanges are the changes the album command makes to each track.
+type albumChanges struct {
+	values map[string]string // text fields by label
+
+	// cover is the image file or "auto" for the front cover, or "" to leave
+	// it alone.
+	cover string
+	// template is the front cover taken from the file of -from, if any.
+	template *id3v2.PictureFrame
+	art      *albumArtCache
+
+	// compilation is one of compilationModes, or "" to leave the compilation
+	// flag alone. For "auto", compilations holds the directories that look
+	// like compilations.
+	compilation  string
+	compilations map[string]int
+}
+
+// runAlbum implements the album command.
+func runAlbum(fs *flag.FlagSet) func() error {
+	flags := map[string]*string{}
+	for _, f := range albumFlags {
+		flags[f[0]] = fs.String(f[0], "", "Set the "+f[1]+" field of every track; an empty value removes it")
+	}
+	c := &albumChanges{values: map[string]string{}, art: &albumArtCache{}}
+	var from string
+	var dryRun bool
+	fs.StringVar(&c.cover, "cover", "", "Set the front cover of every track from an image file, or \"auto\" to fetch it once per album")
+	fs.StringVar(&c.compilation, "compilation", "", "Mark the tracks as a compilation with Various Artists as album artist (yes), unmark them (no), or mark the folders whose tracks are by many artists (auto)")
+	fs.StringVar(&from, "from", "", "Take the shared fields and the cover not set by other flags from this MP3 file, e.g. the one track that is tagged right")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the files")
+	manifest := manifestFlag(fs)
+	setupProviders := providerFlags(fs)
+	setupTLS := tlsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s album [flags] dir|file.mp3...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+
+		// Only the flags given on the command line are applied.
+		fs.Visit(func(f *flag.Flag) {
+			for _, af := range albumFlags {
+				if f.Name == af[0] {
+					c.values[af[1]] = *flags[af[0]]
+				}
+			}
+		})
+		if len(c.values) == 0 && c.cover == "" && c.compilation == "" && from == "" || fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if c.compilation != "" && !slices.Contains(compilationModes, c.compilation) {
+			return fmt.Errorf("invalid -compilation %q: want %s", c.compilation, strings.Join(compilationModes, ", "))
+		}
+		if err := setupProviders(); err != nil {
+			return err
+		}
+		if err := setupTLS(); err != nil {
+			return err
+		}
+		if from != "" {
+			if err := c.loadTemplate(from); err != nil {
+				return err
+			}
+		}
+		if c.cover != "" && c.cover != "auto" {
+			b, ct, err := loadImage(c.cover, nil)
+			if err != nil {
+				return err
+			}
+			c.template = &id3v2.PictureFrame{Picture: b, MimeType: ct}
+			c.cover = ""
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		if c.compilation == "auto" {
+			fields := map[string]map[string]string{}
+			for _, path := range files {
+				tag, err := openTag(path)
+				if err != nil {
+					continue // reported when the file is processed
+				}
+				fields[path] = albumValues(tag)
+				tag.Close()
+			}
+			c.compilations = compilationDirs(files, fields)
+		}
+		failed := 0
+		var done []string
+		for _, name := range files {
+			if err := albumFile(name, c, dryRun); err != nil {
+				log.Printf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			done = append(done, name)
+		}
+		if !dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
+
+// loadTemplate takes the shared fields and the cover that no flag set from the
+// MP3 file at path. Fields the file does not have are left alone.
+func (c *albumChanges) loadTemplate(path string) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	for _, f := range editFields(tag) {
+		if !isAlbumField(f[1]) {
+			continue
+		}
+		if _, ok := c.values[f[1]]; ok {
+			continue
+		}
+		if v := textOf(tag, f[0]); v != "" {
+			c.values[f[1]] = v
+		}
+	}
+	if c.cover == "" {
+		c.template = coverPicture(tag)
+	}
+	if c.compilation == "" && isCompilation(tag) {
+		c.compilation = "yes"
+	}
+	return nil
+}
+
+// isAlbumField reports whether the field with the given label is shared by the
+// tracks of an album.
+func isAlbumField(label string) bool {
+	for _, f := range albumFlags {
+		if f[1] == label {
+			return true
+		}
+	}
+	return false
+}
+
+// albumFile makes the changes c to the MP3 file at path, leaving the fields of
+// the track itself alone.
+func albumFile(path string, c *albumChanges, dryRun bool) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+
+	before := captureFrames(tag)
+	switch c.compilation {
+	case "yes", "no":
+		setCompilation(tag, c.compilation == "yes")
+	case "auto":
+		if _, ok := c.compilations[filepath.Dir(path)]; ok {
+			setCompilation(tag, true)
+		}
+	}
+	// Fields that already hold their value are left as they are, so that
+	// running the command again changes nothing. An album artist given on its
+	// own overrides Various Artists.
+	values := map[string]string{}
+	for _, f := range editFields(tag) {
+		if v, ok := c.values[f[1]]; ok && v != textOf(tag, f[0]) {
+			values[f[1]] = v
+		}
+	}
+	setTextFields(tag, values)
+	switch {
+	case c.template != nil:
+		placePicture(tag, c.template.Picture, c.template.MimeType, id3v2.PTFrontCover, true)
+	case c.cover == "auto":
+		// The album is looked up as set above, once for all its tracks.
+		b, ct, _, _, err := c.art.load(path, tag)
+		if err != nil {
+			return err
+		}
+		placePicture(tag, b, ct, id3v2.PTFrontCover, true)
+	}
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	changes := diffFrames(before, captureFrames(tag))
+	if dryRun {
+		fmt.Printf("==> %s <==\n", path)
+		printFrameDiff(os.Stdout, changes)
+		return nil
+	}
+	if len(changes) == 0 {
+		fmt.Println("Nothing to change in", path)
+		return nil
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	fmt.Println("Updated", path)
+	return nil
+}
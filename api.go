@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// apiFrame is a frame as reported by the JSON API.
+type apiFrame struct {
+	ID      string `json:"id"`
+	Summary string `json:"summary"`
+	Size    int    `json:"size"`
+}
+
+// apiTags is the tag of a file as reported by the JSON API.
+type apiTags struct {
+	Path        string     `json:"path"`
+	Title       string     `json:"title"`
+	Artist      string     `json:"artist"`
+	Album       string     `json:"album"`
+	AlbumArtist string     `json:"album_artist"`
+	Frames      []apiFrame `json:"frames"`
+
+	// LyricsMatch is the record of the lyrics provider fetched lyrics were
+	// taken from.
+	LyricsMatch *reviewCandidate `json:"lyrics_match,omitempty"`
+}
+
+// apiEmbedRequest is the body of POST /embed. Image and Lyrics are "auto" to fetch
+// them; alternatively the image data or lyrics text can be given directly.
+type apiEmbedRequest struct {
+	Path       string `json:"path"`
+	Image      string `json:"image"`
+	ImageData  []byte `json:"image_data"`
+	Lyrics     string `json:"lyrics"`
+	LyricsText string `json:"lyrics_text"`
+	Lang       string `json:"lang"`
+}
+
+// apiSearchRequest is the body of POST /search/lyrics. Duration, in seconds,
+// is optional and prefers records of about that length.
+type apiSearchRequest struct {
+	Artist   string  `json:"artist"`
+	Title    string  `json:"title"`
+	Duration float64 `json:"duration"`
+}
+
+// apiError is an error carrying the HTTP status to report it with.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+// writeJSON sends v as the JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+	}
+}
+
+// writeJSONError sends err as a JSON error response.
+func writeJSONError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var ae *apiError
+	if errors.As(err, &ae) {
+		status = ae.status
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// maxRequestBytes bounds request bodies to the servers of the serve command
+// unless a memory ceiling sets a lower bound.
+const maxRequestBytes = 64 << 20
+
+// readJSON decodes the request body into v. Only JSON bodies are accepted, which
+// also keeps web pages from posting to the API with plain forms.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != "application/json" {
+		return &apiError{http.StatusUnsupportedMediaType, "expected application/json"}
+	}
+	limit := int64(maxRequestBytes)
+	if maxImageBytes > 0 {
+		// Base64 makes the image data a third larger.
+		limit = maxImageBytes/3*4 + maxLyricsBytes + 64<<10
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &apiError{http.StatusRequestEntityTooLarge, fmt.Sprintf("request body larger than %d bytes", limit)}
+		}
+		return &apiError{http.StatusBadRequest, "invalid request: " + err.Error()}
+	}
+	return nil
+}
+
+// resolve returns the absolute form of path, with symbolic links resolved, if
+// it lies below one of the library roots. The API must not touch files outside
+// the library, including through links that point out of it.
+func (l *library) resolve(path string) (string, error) {
+	if path == "" {
+		return "", &apiError{http.StatusBadRequest, "missing path"}
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", &apiError{http.StatusNotFound, "no such file: " + path}
+		}
+		return "", err
+	}
+	for _, root := range l.roots {
+		if rel, err := filepath.Rel(root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			if !isMP3(abs) {
+				return "", &apiError{http.StatusBadRequest, "not an MP3 file: " + path}
+			}
+			return abs, nil
+		}
+	}
+	return "", &apiError{http.StatusForbidden, "outside of the library: " + path}
+}
+
+// readAPITags reads the tag of the file at path for the JSON API.
+func readAPITags(path string) (*apiTags, error) {
+	if err := checkTagLimit(path); err != nil {
+		return nil, err
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, &apiError{http.StatusNotFound, fmt.Sprintf("error opening MP3 file: %v", err)}
+	}
+	defer tag.Close()
+	t := &apiTags{
+		Path:        path,
+		Title:       tag.Title(),
+		Artist:      tag.Artist(),
+		Album:       tag.Album(),
+		AlbumArtist: textOf(tag, "TPE2"),
+		Frames:      []apiFrame{},
+	}
+	for _, s := range captureFrames(tag) {
+		t.Frames = append(t.Frames, apiFrame{ID: s.ID, Summary: s.Summary, Size: len(s.Data)})
+	}
+	return t, nil
+}
+
+// handleAPITags implements GET /tags?path=...
+func (l *library) handleAPITags(w http.ResponseWriter, r *http.Request) {
+	path, err := l.resolve(r.FormValue("path"))
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	t, err := readAPITags(path)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// handleAPIEmbed implements POST /embed.
+func (l *library) handleAPIEmbed(w http.ResponseWriter, r *http.Request) {
+	var req apiEmbedRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	path, err := l.resolve(req.Path)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if req.Image != "" && req.Image != "auto" || req.Lyrics != "" && req.Lyrics != "auto" {
+		writeJSONError(w, &apiError{http.StatusBadRequest, `image and lyrics must be "auto" or empty`})
+		return
+	}
+	if req.Lang == "" {
+		req.Lang = l.lang
+	}
+
+	var match *reviewCandidate
+	err = l.update(path, func(tag *id3v2.Tag) error {
+		switch {
+		case len(req.ImageData) > 0:
+			if err := checkSize("album art image", int64(len(req.ImageData)), maxImageBytes); err != nil {
+				return err
+			}
+			setCover(tag, req.ImageData, http.DetectContentType(req.ImageData))
+		case req.Image == "auto":
+			b, ct, err := loadImage("auto", tag)
+			if err != nil {
+				return err
+			}
+			setCover(tag, b, ct)
+		}
+		switch {
+		case req.LyricsText != "":
+			setLyrics(tag, req.LyricsText, req.Lang, "")
+		case req.Lyrics == "auto":
+			lyrics, m, err := loadLyrics("auto", path, tag)
+			if err != nil {
+				return err
+			}
+			match = m
+			setLyrics(tag, lyrics, req.Lang, "")
+		}
+		return nil
+	})
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	t, err := readAPITags(path)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	t.LyricsMatch = match
+	writeJSON(w, http.StatusOK, t)
+}
+
+// handleAPISearchLyrics implements POST /search/lyrics.
+func (l *library) handleAPISearchLyrics(w http.ResponseWriter, r *http.Request) {
+	var req apiSearchRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if req.Artist == "" || req.Title == "" {
+		writeJSONError(w, &apiError{http.StatusBadRequest, "artist and title are required"})
+		return
+	}
+	match, err := lookupLyrics(req.Artist, req.Title, time.Duration(req.Duration*float64(time.Second)))
+	if err != nil {
+		writeJSONError(w, &apiError{http.StatusNotFound, err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, match)
+}
+
+// apiHandler returns the handler of the JSON API.
+func (l *library) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tags", l.handleAPITags)
+	mux.HandleFunc("POST /embed", l.handleAPIEmbed)
+	mux.HandleFunc("POST /search/lyrics", l.handleAPISearchLyrics)
+	return mux
+}
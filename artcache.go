@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// useArtCache is cleared by -art-cache=false to fetch all cover art anew.
+var useArtCache = true
+
+// artCacheEntry records cover art fetched for an album or from a URL. The
+// image itself is stored once under its digest, however many entries refer to
+// it.
+type artCacheEntry struct {
+	Digest      string           `json:"digest"`
+	ContentType string           `json:"content_type"`
+	Match       *reviewCandidate `json:"match,omitempty"`
+	Time        time.Time        `json:"time"`
+}
+
+// artCacheDir returns the directory of the artwork cache. Being a cache, it is
+// not part of the state in appDir and may be deleted at any time.
+func artCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mp3extra", "artwork"), nil
+}
+
+// albumCacheKey returns the key of the cover art of the album with the given
+// albumKey, as found by the art provider of automatic lookups.
+func albumCacheKey(album string) string {
+	return "album\x00" + artProviderName + "\x00" + album
+}
+
+// urlCacheKey returns the key of the cover art fetched for c, or "" if c has
+// no URL that identifies its image.
+func urlCacheKey(c *reviewCandidate) string {
+	src := c.source()
+	if !strings.Contains(src, "://") {
+		return ""
+	}
+	return "url\x00" + src
+}
+
+// loadArtCacheIndex reads the entries of the artwork cache by key.
+func loadArtCacheIndex(dir string) (map[string]*artCacheEntry, error) {
+	index := map[string]*artCacheEntry{}
+	b, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return index, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, "index.json"), err)
+	}
+	return index, nil
+}
+
+// cachedArt returns the cover art cached under key, with its content type and
+// the candidate it was fetched for, if any. ok is false if there is none or
+// the cache is disabled. An image that no longer matches its digest counts as
+// missing.
+func cachedArt(key string) (b []byte, ct string, match *reviewCandidate, ok bool) {
+	if !useArtCache || key == "" {
+		return nil, "", nil, false
+	}
+	dir, err := artCacheDir()
+	if err != nil {
+		return nil, "", nil, false
+	}
+	index, err := loadArtCacheIndex(dir)
+	if err != nil {
+		log.Printf("Error reading the artwork cache: %v", err)
+		return nil, "", nil, false
+	}
+	e := index[key]
+	if e == nil {
+		return nil, "", nil, false
+	}
+	b, err = readFileLimited(filepath.Join(dir, e.Digest), "album art image", maxImageBytes)
+	if err != nil {
+		return nil, "", nil, false
+	}
+	if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != e.Digest {
+		return nil, "", nil, false
+	}
+	return b, e.ContentType, e.Match, true
+}
+
+// cacheArt stores the cover art b of content type ct, fetched for match, in
+// the artwork cache under the given keys. Empty keys are ignored. The cache is
+// best effort, so errors are only logged.
+func cacheArt(b []byte, ct string, match *reviewCandidate, keys ...string) {
+	if !useArtCache || strings.Join(keys, "") == "" {
+		return
+	}
+	if err := storeArt(b, ct, match, keys); err != nil {
+		log.Printf("Error updating the artwork cache: %v", err)
+	}
+}
+
+// storeArt implements cacheArt.
+func storeArt(b []byte, ct string, match *reviewCandidate, keys []string) error {
+	dir, err := artCacheDir()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	digest := hex.EncodeToString(sum[:])
+	// Images are content-addressed, so an existing one is already correct.
+	name := filepath.Join(dir, digest)
+	if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(name, b); err != nil {
+			return err
+		}
+	}
+	return withStateLock(func() error {
+		index, err := loadArtCacheIndex(dir)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if key != "" {
+				index[key] = &artCacheEntry{Digest: digest, ContentType: ct, Match: match, Time: time.Now()}
+			}
+		}
+		return writeJSONFile(filepath.Join(dir, "index.json"), index)
+	})
+}
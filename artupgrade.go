@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "art-upgrade",
+		usage: "Slowly replace small embedded covers with larger ones, resuming where the last run stopped",
+		run:   runArtUpgrade,
+	})
+}
+
+// artUpgradeRecord is what art-upgrade found for a file. Files are not looked
+// up again until they change.
+type artUpgradeRecord struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Result  string    `json:"result"`
+	Time    time.Time `json:"time"`
+}
+
+// artUpgradePath returns the location of the art-upgrade progress file.
+func artUpgradePath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "art-upgrade.json"), nil
+}
+
+// loadArtUpgrade reads the art-upgrade progress by absolute file path.
+func loadArtUpgrade() (map[string]*artUpgradeRecord, error) {
+	name, err := artUpgradePath()
+	if err != nil {
+		return nil, err
+	}
+	records := map[string]*artUpgradeRecord{}
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return records, nil
+}
+
+// recordArtUpgrade stores the result for the file at abs in the progress file.
+func recordArtUpgrade(abs, result string) error {
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	return withStateLock(func() error {
+		records, err := loadArtUpgrade()
+		if err != nil {
+			return err
+		}
+		records[abs] = &artUpgradeRecord{Size: fi.Size(), ModTime: fi.ModTime(), Result: result, Time: time.Now()}
+		name, err := artUpgradePath()
+		if err != nil {
+			return err
+		}
+		return writeJSONFile(name, records)
+	})
+}
+
+// artUpgradeOptions configures the art-upgrade command.
+type artUpgradeOptions struct {
+	minSize int // covers smaller than this in either direction are upgraded
+	size    int // size of the covers asked for
+	dryRun  bool
+}
+
+// coverSize returns the dimensions of the cover of tag, or ok false if it has
+// none or it cannot be decoded.
+func coverSize(tag *id3v2.Tag) (w, h int, ok bool) {
+	cover := coverPicture(tag)
+	if cover == nil {
+		return 0, 0, false
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(cover.Picture))
+	if err != nil {
+		return 0, 0, false
+	}
+	return cfg.Width, cfg.Height, true
+}
+
+// needsArtUpgrade reports whether the MP3 file at path has a cover smaller
+// than opts.minSize.
+func needsArtUpgrade(path string, opts *artUpgradeOptions) (bool, error) {
+	tag, err := openTag(path)
+	if err != nil {
+		return false, fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	w, h, ok := coverSize(tag)
+	return ok && (w < opts.minSize || h < opts.minSize), nil
+}
+
+// upgradeArt looks up a larger cover for the MP3 file at path and embeds it if
+// the match is exact and the cover is larger than the one in the file. It
+// returns the result to remember for the file.
+func upgradeArt(path string, opts *artUpgradeOptions) (string, error) {
+	tag, err := openTag(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	w, h, _ := coverSize(tag)
+
+	start := time.Now()
+	// Only iTunes serves the artwork in any size.
+	tracks, err := searchITunes(context.Background(), tag.Artist(), tag.Title(), quarantineCandidates)
+	cands := bestCandidates(itunesCandidates(tag.Artist(), tag.Title(), tracks))
+	if err == nil && len(cands) == 0 {
+		err = errArtNotFound
+	}
+	recordLookup(providerITunes, start, err)
+	if errors.Is(err, errArtNotFound) {
+		fmt.Printf("%s: no cover found\n", path)
+		return "not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if cands[0].Score < 1 {
+		fmt.Printf("%s: no exact match\n", path)
+		return "no exact match", nil
+	}
+	b, ct, err := fetchArtworkSize(context.Background(), cands[0].ArtworkURL, opts.size)
+	if err != nil {
+		return "", fmt.Errorf("error fetching album art image: %w", err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return "", fmt.Errorf("error decoding album art image: %w", err)
+	}
+	if cfg.Width <= w && cfg.Height <= h {
+		fmt.Printf("%s: no larger cover than %dx%d\n", path, w, h)
+		return "not larger", nil
+	}
+	if opts.dryRun {
+		fmt.Printf("%s: would replace %dx%d cover with %dx%d\n", path, w, h, cfg.Width, cfg.Height)
+		return "", nil
+	}
+	placePicture(tag, b, ct, id3v2.PTFrontCover, true)
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return "", fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	fmt.Printf("%s: replaced %dx%d cover with %dx%d\n", path, w, h, cfg.Width, cfg.Height)
+	return "upgraded", nil
+}
+
+// runArtUpgrade implements the art-upgrade command.
+func runArtUpgrade(fs *flag.FlagSet) func() error {
+	opts := &artUpgradeOptions{}
+	var interval, runFor time.Duration
+	var maxLookups int
+	fs.IntVar(&opts.minSize, "min-size", 500, "Upgrade covers narrower or lower than this many pixels")
+	fs.IntVar(&opts.size, "size", 1200, "Width and height of the covers to fetch")
+	fs.DurationVar(&interval, "interval", 30*time.Second, "Time to wait between lookups, to go easy on the provider")
+	fs.DurationVar(&runFor, "for", 0, "Stop after this long, e.g. 6h for a nightly run; the next run resumes (0 runs until done)")
+	fs.IntVar(&maxLookups, "max", 0, "Stop after this many lookups (0 for no limit)")
+	fs.BoolVar(&opts.dryRun, "dryrun", false, "Show which covers would be replaced without modifying the files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s art-upgrade [flags] dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if opts.minSize < 1 || opts.size < opts.minSize {
+			return fmt.Errorf("invalid sizes: -min-size %d, -size %d", opts.minSize, opts.size)
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		records, err := loadArtUpgrade()
+		if err != nil {
+			return err
+		}
+		var deadline time.Time
+		if runFor > 0 {
+			deadline = time.Now().Add(runFor)
+		}
+
+		lookups, upgraded, failed := 0, 0, 0
+		var last time.Time
+		for _, path := range files {
+			if maxLookups > 0 && lookups >= maxLookups || !deadline.IsZero() && time.Now().Add(interval).After(deadline) {
+				fmt.Printf("Stopping at %s, the next run resumes there\n", path)
+				break
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			// Skip files looked at before, unless they changed since.
+			if r := records[abs]; r != nil {
+				if fi, err := os.Stat(abs); err == nil && fi.Size() == r.Size && fi.ModTime().Equal(r.ModTime) {
+					continue
+				}
+			}
+			need, err := needsArtUpgrade(path, opts)
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			if !need {
+				if !opts.dryRun {
+					if err := recordArtUpgrade(abs, "no upgrade needed"); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			if wait := interval - time.Since(last); !last.IsZero() && wait > 0 {
+				time.Sleep(wait)
+			}
+			last = time.Now()
+			lookups++
+			result, err := upgradeArt(path, opts)
+			if err != nil {
+				// Failures are tried again by the next run.
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			if result == "upgraded" {
+				upgraded++
+			}
+			if result != "" && !opts.dryRun {
+				if err := recordArtUpgrade(abs, result); err != nil {
+					return err
+				}
+			}
+		}
+		fmt.Printf("Looked up %d covers, upgraded %d\n", lookups, upgraded)
+		if failed > 0 {
+			return fmt.Errorf("%d files failed", failed)
+		}
+		return nil
+	}
+}
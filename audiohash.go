@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "audio-hash",
+		usage: "Print or check the SHA-256 of the audio frames of MP3 files, leaving out the tags",
+		run:   runAudioHash,
+	})
+}
+
+// checkManifest compares the files recorded in the manifest at name with their
+// current audio hashes and prints the result for each. It returns the number
+// of files checked and of those that do not match or cannot be read.
+func checkManifest(name string) (checked, failed int, err error) {
+	sums, err := readManifest(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	files := make([]string, 0, len(sums))
+	for file := range sums {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		path := filepath.Join(filepath.Dir(name), filepath.FromSlash(file))
+		sum, err := audioDigest(path)
+		switch {
+		case err != nil:
+			fmt.Printf("%s: FAILED (%v)\n", path, err)
+			failed++
+		case sum != sums[file]:
+			fmt.Printf("%s: FAILED\n", path)
+			failed++
+		default:
+			fmt.Printf("%s: OK\n", path)
+		}
+	}
+	return len(files), failed, nil
+}
+
+// runAudioHash implements the audio-hash command.
+func runAudioHash(fs *flag.FlagSet) func() error {
+	check := fs.Bool("c", false, "Check the files recorded in the given manifests, or in the "+manifestName+" files of the given directories")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s audio-hash file.mp3|dir...\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s audio-hash -c manifest|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		if *check {
+			var manifests []string
+			for _, arg := range fs.Args() {
+				fi, err := os.Stat(arg)
+				if err != nil {
+					return err
+				}
+				if !fi.IsDir() {
+					manifests = append(manifests, arg)
+					continue
+				}
+				err = filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+					if err == nil && !d.IsDir() && d.Name() == manifestName {
+						manifests = append(manifests, path)
+					}
+					return err
+				})
+				if err != nil {
+					return err
+				}
+			}
+			total, failed := 0, 0
+			for _, name := range manifests {
+				n, f, err := checkManifest(name)
+				if err != nil {
+					return err
+				}
+				total += n
+				failed += f
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d files do not match", failed, total)
+			}
+			return nil
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		failed := 0
+		for _, name := range files {
+			sum, err := audioDigest(name)
+			if err != nil {
+				log.Printf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s  %s\n", sum, name)
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "chapters",
+		usage: "List, add, edit and delete the chapters (CHAP and CTOC frames) of an MP3 file",
+		run:   runChapters,
+	})
+}
+
+// The id3v2 library reads CHAP frames, but keeps only their title and
+// description, and does not know CTOC frames at all. chapterFrame and
+// tocFrame read and write both with all their embedded frames, such as
+// per-chapter pictures, and openTag uses them in place of the library's.
+
+// subFrame is a frame embedded in a CHAP or CTOC frame.
+type subFrame struct {
+	ID    string
+	Frame id3v2.Framer
+}
+
+// errBadSubFrames is returned for CHAP and CTOC frames whose embedded frames
+// cannot be read.
+var errBadSubFrames = errors.New("malformed embedded frames")
+
+// parseSubFrames parses the frames embedded in a CHAP or CTOC frame of an
+// ID3v2 tag of the given version. synchsafe tells how their sizes are stored.
+func parseSubFrames(b []byte, version byte, synchsafe bool) ([]subFrame, error) {
+	var subs []subFrame
+	for len(b) > 0 && b[0] != 0 {
+		if len(b) < 10 {
+			return nil, errBadSubFrames
+		}
+		id := string(b[:4])
+		var n int
+		if synchsafe {
+			n = int(b[4]&0x7f)<<21 | int(b[5]&0x7f)<<14 | int(b[6]&0x7f)<<7 | int(b[7]&0x7f)
+		} else {
+			n = int(b[4])<<24 | int(b[5])<<16 | int(b[6])<<8 | int(b[7])
+		}
+		if !validFrameID(id) || n > len(b)-10 {
+			return nil, errBadSubFrames
+		}
+		f := rawFrame{ID: id, Flags: [2]byte{b[8], b[9]}, Body: b[10 : 10+n]}
+		body, err := f.plainBody(version)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, subFrame{id, parseRawFrame(id, body, version)})
+		b = b[10+n:]
+	}
+	return subs, nil
+}
+
+// parseSubFramesAny is parseSubFrames for the sizes of the given version, or
+// else for the other kind of sizes: the id3v2 library writes them synchsafe in
+// ID3v2.3 tags as well.
+func parseSubFramesAny(b []byte, version byte) ([]subFrame, error) {
+	subs, err := parseSubFrames(b, version, version == 4)
+	if err != nil {
+		subs, err = parseSubFrames(b, version, version != 4)
+	}
+	return subs, err
+}
+
+// writeSubFrames writes subs to buf as frames of an ID3v2 tag of the given
+// version.
+func writeSubFrames(buf *bytes.Buffer, subs []subFrame, version byte) {
+	for _, s := range subs {
+		var body bytes.Buffer
+		s.Frame.WriteTo(&body)
+		var size [4]byte
+		putSize(size[:], body.Len(), version == 4)
+		buf.WriteString(s.ID)
+		buf.Write(size[:])
+		buf.Write([]byte{0, 0})
+		buf.Write(body.Bytes())
+	}
+}
+
+// subText returns the text of the embedded text frame id of subs, or "".
+func subText(subs []subFrame, id string) string {
+	for _, s := range subs {
+		if tf, ok := s.Frame.(id3v2.TextFrame); ok && s.ID == id {
+			return tf.Text
+		}
+	}
+	return ""
+}
+
+// setSub returns subs with the embedded frames id replaced by f, or removed if
+// f is nil.
+func setSub(subs []subFrame, id string, f id3v2.Framer) []subFrame {
+	subs = slices.DeleteFunc(slices.Clone(subs), func(s subFrame) bool { return s.ID == id })
+	if f != nil {
+		subs = append(subs, subFrame{id, f})
+	}
+	return subs
+}
+
+// chapterFrame is a CHAP frame: a part of the audio with its own title and
+// possibly picture.
+type chapterFrame struct {
+	ElementID              string
+	Start, End             time.Duration
+	StartOffset, EndOffset uint32
+	Sub                    []subFrame
+
+	// version is the version of the tag the frame is written to, which
+	// decides how the sizes of the embedded frames are stored.
+	version byte
+}
+
+// parseChapterFrame parses the body of a CHAP frame of an ID3v2 tag of the
+// given version.
+func parseChapterFrame(b []byte, version byte) (chapterFrame, error) {
+	id, rest, ok := bytes.Cut(b, []byte{0})
+	if !ok || len(rest) < 16 {
+		return chapterFrame{}, errors.New("CHAP frame too short")
+	}
+	cf := chapterFrame{
+		ElementID:   string(id),
+		Start:       time.Duration(binary.BigEndian.Uint32(rest[0:])) * time.Millisecond,
+		End:         time.Duration(binary.BigEndian.Uint32(rest[4:])) * time.Millisecond,
+		StartOffset: binary.BigEndian.Uint32(rest[8:]),
+		EndOffset:   binary.BigEndian.Uint32(rest[12:]),
+		version:     version,
+	}
+	subs, err := parseSubFramesAny(rest[16:], version)
+	if err != nil {
+		return chapterFrame{}, fmt.Errorf("CHAP frame %s: %w", cf.ElementID, err)
+	}
+	cf.Sub = subs
+	return cf, nil
+}
+
+// chapterFromLibrary converts a CHAP frame read by the id3v2 library.
+func chapterFromLibrary(lf id3v2.ChapterFrame, version byte) chapterFrame {
+	cf := chapterFrame{
+		ElementID:   lf.ElementID,
+		Start:       lf.StartTime,
+		End:         lf.EndTime,
+		StartOffset: lf.StartOffset,
+		EndOffset:   lf.EndOffset,
+		version:     version,
+	}
+	if lf.Title != nil && lf.Title.Text != "" {
+		cf.Sub = append(cf.Sub, subFrame{"TIT2", *lf.Title})
+	}
+	if lf.Description != nil && lf.Description.Text != "" {
+		cf.Sub = append(cf.Sub, subFrame{"TIT3", *lf.Description})
+	}
+	return cf
+}
+
+// body returns the encoded body of cf.
+func (cf chapterFrame) body() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(cf.ElementID)
+	buf.WriteByte(0)
+	for _, v := range []uint32{uint32(cf.Start / time.Millisecond), uint32(cf.End / time.Millisecond), cf.StartOffset, cf.EndOffset} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeSubFrames(&buf, cf.Sub, cf.version)
+	return buf.Bytes()
+}
+
+func (cf chapterFrame) Size() int                { return len(cf.body()) }
+func (cf chapterFrame) UniqueIdentifier() string { return cf.ElementID }
+func (cf chapterFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(cf.body())
+	return int64(n), err
+}
+
+// title returns the title of the chapter, or "".
+func (cf chapterFrame) title() string {
+	return subText(cf.Sub, "TIT2")
+}
+
+// picture returns the picture of the chapter, or nil.
+func (cf chapterFrame) picture() *id3v2.PictureFrame {
+	for _, s := range cf.Sub {
+		if pic, ok := s.Frame.(id3v2.PictureFrame); ok {
+			return &pic
+		}
+	}
+	return nil
+}
+
+// tocFrame is a CTOC frame: a table of contents listing chapters or other
+// tables of contents.
+type tocFrame struct {
+	ElementID string
+	TopLevel  bool
+	Ordered   bool
+	Children  []string
+	Sub       []subFrame
+	version   byte
+}
+
+// parseTOCFrame parses the body of a CTOC frame of an ID3v2 tag of the given
+// version.
+func parseTOCFrame(b []byte, version byte) (tocFrame, error) {
+	id, rest, ok := bytes.Cut(b, []byte{0})
+	if !ok || len(rest) < 2 {
+		return tocFrame{}, errors.New("CTOC frame too short")
+	}
+	tf := tocFrame{
+		ElementID: string(id),
+		TopLevel:  rest[0]&0x02 != 0,
+		Ordered:   rest[0]&0x01 != 0,
+		version:   version,
+	}
+	n := int(rest[1])
+	rest = rest[2:]
+	for range n {
+		child, r, ok := bytes.Cut(rest, []byte{0})
+		if !ok {
+			return tocFrame{}, fmt.Errorf("CTOC frame %s: too few entries", tf.ElementID)
+		}
+		tf.Children = append(tf.Children, string(child))
+		rest = r
+	}
+	subs, err := parseSubFramesAny(rest, version)
+	if err != nil {
+		return tocFrame{}, fmt.Errorf("CTOC frame %s: %w", tf.ElementID, err)
+	}
+	tf.Sub = subs
+	return tf, nil
+}
+
+// body returns the encoded body of tf.
+func (tf tocFrame) body() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(tf.ElementID)
+	buf.WriteByte(0)
+	var flags byte
+	if tf.TopLevel {
+		flags |= 0x02
+	}
+	if tf.Ordered {
+		flags |= 0x01
+	}
+	buf.WriteByte(flags)
+	buf.WriteByte(byte(len(tf.Children)))
+	for _, c := range tf.Children {
+		buf.WriteString(c)
+		buf.WriteByte(0)
+	}
+	writeSubFrames(&buf, tf.Sub, tf.version)
+	return buf.Bytes()
+}
+
+func (tf tocFrame) Size() int                { return len(tf.body()) }
+func (tf tocFrame) UniqueIdentifier() string { return tf.ElementID }
+func (tf tocFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(tf.body())
+	return int64(n), err
+}
+
+// parseChapterOrTOC parses the body of a CHAP or CTOC frame into a chapterFrame
+// or tocFrame. ok is false for other frames.
+func parseChapterOrTOC(id string, body []byte, version byte) (f id3v2.Framer, ok bool, err error) {
+	switch id {
+	case "CHAP":
+		f, err = parseChapterFrame(body, version)
+	case "CTOC":
+		f, err = parseTOCFrame(body, version)
+	default:
+		return nil, false, nil
+	}
+	return f, true, err
+}
+
+// readChapters returns the chapters of tag, sorted by start time, and its
+// tables of contents.
+func readChapters(tag *id3v2.Tag) ([]chapterFrame, []tocFrame, error) {
+	var chapters []chapterFrame
+	for _, f := range tag.GetFrames("CHAP") {
+		switch f := f.(type) {
+		case chapterFrame:
+			chapters = append(chapters, f)
+		case id3v2.ChapterFrame:
+			chapters = append(chapters, chapterFromLibrary(f, tag.Version()))
+		case id3v2.UnknownFrame:
+			cf, err := parseChapterFrame(f.Body, tag.Version())
+			if err != nil {
+				return nil, nil, err
+			}
+			chapters = append(chapters, cf)
+		}
+	}
+	slices.SortStableFunc(chapters, func(a, b chapterFrame) int { return cmp.Compare(a.Start, b.Start) })
+	var tocs []tocFrame
+	for _, f := range tag.GetFrames("CTOC") {
+		switch f := f.(type) {
+		case tocFrame:
+			tocs = append(tocs, f)
+		case id3v2.UnknownFrame:
+			tf, err := parseTOCFrame(f.Body, tag.Version())
+			if err != nil {
+				return nil, nil, err
+			}
+			tocs = append(tocs, tf)
+		}
+	}
+	return chapters, tocs, nil
+}
+
+// errNestedTOC is returned when the chapters of a tag with more than one table
+// of contents are to be changed.
+var errNestedTOC = errors.New("tags with more than one table of contents (CTOC) cannot be edited")
+
+// writeChapters replaces the chapters of tag with chapters, and lists them in
+// order in its top-level table of contents, which is created if needed.
+func writeChapters(tag *id3v2.Tag, chapters []chapterFrame) error {
+	_, tocs, err := readChapters(tag)
+	if err != nil {
+		return err
+	}
+	if len(tocs) > 1 {
+		return errNestedTOC
+	}
+	tag.DeleteFrames("CHAP")
+	tag.DeleteFrames("CTOC")
+	if len(chapters) == 0 {
+		return nil
+	}
+	toc := tocFrame{ElementID: "toc"}
+	if len(tocs) == 1 {
+		toc = tocs[0]
+	}
+	toc.TopLevel, toc.Ordered, toc.Children, toc.version = true, true, nil, tag.Version()
+	for _, cf := range chapters {
+		cf.version = tag.Version()
+		tag.AddFrame("CHAP", cf)
+		toc.Children = append(toc.Children, cf.ElementID)
+	}
+	tag.AddFrame("CTOC", toc)
+	return nil
+}
+
+// setChapterVersion prepares the chapters of tag for being written as an ID3v2
+// tag of the given version.
+func setChapterVersion(tag *id3v2.Tag, version byte) {
+	mapFrames(tag, func(f id3v2.Framer) (id3v2.Framer, bool) {
+		switch f := f.(type) {
+		case chapterFrame:
+			f.version, f.Sub = version, subsForVersion(f.Sub, version)
+			return f, true
+		case tocFrame:
+			f.version, f.Sub = version, subsForVersion(f.Sub, version)
+			return f, true
+		}
+		return nil, false
+	})
+}
+
+// subsForVersion returns subs with the text frames in UTF-8, which ID3v2.3
+// does not have, converted to UTF-16.
+func subsForVersion(subs []subFrame, version byte) []subFrame {
+	subs = slices.Clone(subs)
+	for i, s := range subs {
+		if tf, ok := s.Frame.(id3v2.TextFrame); ok && version == 3 && tf.Encoding.Equals(id3v2.EncodingUTF8) {
+			tf.Encoding = id3v2.EncodingUTF16
+			subs[i].Frame = tf
+		}
+	}
+	return subs
+}
+
+// parseClock parses a position in the audio given as [[h:]m:]s[.fff], or as a
+// Go duration such as 1m30s.
+func parseClock(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q: want [[h:]m:]s[.fff]", s)
+	}
+	var d time.Duration
+	for i, p := range parts {
+		unit := time.Second
+		if i < len(parts)-1 {
+			unit = time.Minute
+			if i == 0 && len(parts) == 3 {
+				unit = time.Hour
+			}
+		}
+		if i < len(parts)-1 {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid time %q: want [[h:]m:]s[.fff]", s)
+			}
+			d += time.Duration(n) * unit
+			continue
+		}
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("invalid time %q: want [[h:]m:]s[.fff]", s)
+		}
+		d += time.Duration(f * float64(time.Second))
+	}
+	return d.Round(time.Millisecond), nil
+}
+
+// formatClock formats d as h:mm:ss.fff.
+func formatClock(d time.Duration) string {
+	d = d.Round(time.Millisecond)
+	return fmt.Sprintf("%d:%02d:%02d.%03d", d/time.Hour, d/time.Minute%60, d/time.Second%60, d/time.Millisecond%1000)
+}
+
+// checkChapters returns an error if chapters, sorted by start time, are empty
+// or overlap, or reach beyond total.
+func checkChapters(chapters []chapterFrame, total time.Duration) error {
+	for i, cf := range chapters {
+		if cf.End <= cf.Start {
+			return fmt.Errorf("chapter %s ends at %s, before it starts", cf.ElementID, formatClock(cf.End))
+		}
+		if cf.End > total+time.Second {
+			return fmt.Errorf("chapter %s ends at %s, after the audio", cf.ElementID, formatClock(cf.End))
+		}
+		if i+1 < len(chapters) && cf.End > chapters[i+1].Start {
+			return fmt.Errorf("chapter %s overlaps chapter %s", cf.ElementID, chapters[i+1].ElementID)
+		}
+	}
+	return nil
+}
+
+// printChapters lists chapters.
+func printChapters(chapters []chapterFrame) {
+	for _, cf := range chapters {
+		line := fmt.Sprintf("%-6s %s - %s  %s", cf.ElementID, formatClock(cf.Start), formatClock(cf.End), cf.title())
+		if desc := subText(cf.Sub, "TIT3"); desc != "" {
+			line += " (" + desc + ")"
+		}
+		if pic := cf.picture(); pic != nil {
+			line += "  [" + describeImage(pic.Picture) + "]"
+		}
+		fmt.Println(line)
+	}
+}
+
+// chapterEdit is a change to a chapter given by the flags of the chapters
+// command. Fields that are nil are left as they are.
+type chapterEdit struct {
+	start, end  *time.Duration
+	title, desc *string
+	image       *string // image file, or "none" to remove the picture
+}
+
+// apply changes cf as e says. Text is written in enc.
+func (e *chapterEdit) apply(cf *chapterFrame, enc id3v2.Encoding) error {
+	if e.start != nil {
+		cf.Start = *e.start
+	}
+	if e.end != nil {
+		cf.End = *e.end
+	}
+	for _, t := range []struct {
+		id   string
+		text *string
+	}{{"TIT2", e.title}, {"TIT3", e.desc}} {
+		if t.text == nil {
+			continue
+		}
+		var f id3v2.Framer
+		if *t.text != "" {
+			f = id3v2.TextFrame{Encoding: enc, Text: *t.text}
+		}
+		cf.Sub = setSub(cf.Sub, t.id, f)
+	}
+	if e.image != nil {
+		var f id3v2.Framer
+		if *e.image != "none" {
+			b, err := readFileLimited(*e.image, "chapter image", maxImageBytes)
+			if err != nil {
+				return fmt.Errorf("error reading chapter image: %w", err)
+			}
+			f = id3v2.PictureFrame{
+				Encoding:    id3v2.EncodingISO,
+				MimeType:    http.DetectContentType(b),
+				PictureType: id3v2.PTOther,
+				Description: "Chapter",
+				Picture:     b,
+			}
+		}
+		cf.Sub = setSub(cf.Sub, "APIC", f)
+	}
+	return nil
+}
+
+// newChapterID returns an element ID no chapter uses yet.
+func newChapterID(chapters []chapterFrame) string {
+	for n := 1; ; n++ {
+		id := fmt.Sprintf("ch%d", n)
+		if !slices.ContainsFunc(chapters, func(cf chapterFrame) bool { return cf.ElementID == id }) {
+			return id
+		}
+	}
+}
+
+// chapterFlags lists the actions of the chapters command that each of its
+// flags applies to.
+var chapterFlags = map[string][]string{
+	"dryrun": {"add", "edit", "delete"},
+	"id":     {"edit", "delete"},
+	"all":    {"delete"},
+	"start":  {"add", "edit"},
+	"end":    {"add", "edit"},
+	"title":  {"add", "edit"},
+	"desc":   {"add", "edit"},
+	"image":  {"add", "edit"},
+}
+
+// runChapters implements the chapters command.
+func runChapters(fs *flag.FlagSet) func() error {
+	var id, start, end, title, desc, image string
+	var all, dryRun bool
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the file (add, edit, delete)")
+	fs.StringVar(&id, "id", "", "Element ID of the chapter, as listed by 'chapters list' (edit, delete)")
+	fs.BoolVar(&all, "all", false, "Delete all chapters and the table of contents (delete)")
+	fs.StringVar(&start, "start", "", "Start of the chapter, as [[h:]m:]s[.fff] (add, edit)")
+	fs.StringVar(&end, "end", "", "End of the chapter (defaults to the start of the next chapter or the end of the audio when adding) (add, edit)")
+	fs.StringVar(&title, "title", "", "Title of the chapter (add, edit)")
+	fs.StringVar(&desc, "desc", "", "Description of the chapter (add, edit)")
+	fs.StringVar(&image, "image", "", "Image file to show during the chapter, or none to remove it (add, edit)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s chapters [list] file.mp3\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s chapters add -start time [-end time] -title title [flags] file.mp3\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s chapters edit -id ID [flags] file.mp3\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s chapters delete -id ID|-all [flags] file.mp3\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		action := "list"
+		if slices.Contains([]string{"list", "add", "edit", "delete"}, fs.Arg(0)) {
+			// The flags follow the action, as in "chapters add -start 1:00 song.mp3".
+			action = fs.Arg(0)
+			parseFlags(fs, fs.Args()[1:])
+		}
+		var misplaced []string
+		fs.Visit(func(f *flag.Flag) {
+			if !slices.Contains(chapterFlags[f.Name], action) {
+				misplaced = append(misplaced, "-"+f.Name)
+			}
+		})
+		if len(misplaced) > 0 {
+			return fmt.Errorf("%s cannot be used with chapters %s", strings.Join(misplaced, ", "), action)
+		}
+		if fs.NArg() != 1 || action == "add" && start == "" || action == "edit" && id == "" || action == "delete" && (id == "") == !all {
+			fs.Usage()
+			os.Exit(1)
+		}
+		path := fs.Arg(0)
+
+		e := &chapterEdit{}
+		var parseErr error
+		fs.Visit(func(f *flag.Flag) {
+			v := f.Value.String()
+			switch f.Name {
+			case "start", "end":
+				d, err := parseClock(v)
+				if err != nil && parseErr == nil {
+					parseErr = err
+				}
+				if f.Name == "start" {
+					e.start = &d
+				} else {
+					e.end = &d
+				}
+			case "title":
+				e.title = &v
+			case "desc":
+				e.desc = &v
+			case "image":
+				e.image = &v
+			}
+		})
+		if parseErr != nil {
+			return parseErr
+		}
+
+		tag, err := openTag(path)
+		if err != nil {
+			return fmt.Errorf("error opening MP3 file: %w", err)
+		}
+		defer tag.Close()
+		tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+		chapters, _, err := readChapters(tag)
+		if err != nil {
+			return err
+		}
+		if action == "list" {
+			printChapters(chapters)
+			return nil
+		}
+		total, err := audioDuration(path)
+		if err != nil {
+			return err
+		}
+
+		idx := slices.IndexFunc(chapters, func(cf chapterFrame) bool { return cf.ElementID == id })
+		if id != "" && idx < 0 {
+			return fmt.Errorf("%s: no chapter %s", path, id)
+		}
+		switch {
+		case action == "add":
+			cf := chapterFrame{ElementID: newChapterID(chapters), Start: *e.start, End: total, StartOffset: id3v2.IgnoredOffset, EndOffset: id3v2.IgnoredOffset}
+			i, _ := slices.BinarySearchFunc(chapters, cf.Start, func(c chapterFrame, t time.Duration) int { return cmp.Compare(c.Start, t) })
+			if i < len(chapters) {
+				cf.End = chapters[i].Start
+			}
+			// A chapter added within another one splits it.
+			if i > 0 && chapters[i-1].End > cf.Start {
+				chapters[i-1].End = cf.Start
+			}
+			if err := e.apply(&cf, tag.DefaultEncoding()); err != nil {
+				return err
+			}
+			chapters = slices.Insert(chapters, i, cf)
+		case action == "edit":
+			old := chapters[idx].Start
+			if err := e.apply(&chapters[idx], tag.DefaultEncoding()); err != nil {
+				return err
+			}
+			// Keep the previous chapter ending where this one starts.
+			if idx > 0 && chapters[idx-1].End == old {
+				chapters[idx-1].End = chapters[idx].Start
+			}
+			slices.SortStableFunc(chapters, func(a, b chapterFrame) int { return cmp.Compare(a.Start, b.Start) })
+		case all:
+			chapters = nil
+		default:
+			// The previous chapter takes over the time of a deleted one.
+			if idx > 0 && chapters[idx-1].End == chapters[idx].Start {
+				chapters[idx-1].End = chapters[idx].End
+			}
+			chapters = slices.Delete(chapters, idx, idx+1)
+		}
+		if err := checkChapters(chapters, total); err != nil {
+			return err
+		}
+
+		before := captureFrames(tag)
+		if err := writeChapters(tag, chapters); err != nil {
+			return err
+		}
+		if err := keepProtected(tag, path); err != nil {
+			return fmt.Errorf("error checking write-protected frames: %w", err)
+		}
+		changes := diffFrames(before, captureFrames(tag))
+		if dryRun {
+			fmt.Printf("==> %s <==\n", path)
+			printFrameDiff(os.Stdout, changes)
+			return nil
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+			return fmt.Errorf("error saving MP3 file: %w", err)
+		}
+		printChapters(chapters)
+		return nil
+	}
+}
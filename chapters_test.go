@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// testChapter returns a chapter with the given sub-frames in a tag of the
+// given version.
+func testChapter(version byte, subs ...subFrame) chapterFrame {
+	return chapterFrame{
+		ElementID:   "chp1",
+		Start:       90 * time.Second,
+		End:         3*time.Minute + 250*time.Millisecond,
+		StartOffset: 0xffffffff,
+		EndOffset:   0xffffffff,
+		Sub:         subs,
+		version:     version,
+	}
+}
+
+func TestChapterFrameRoundTrip(t *testing.T) {
+	// A title longer than 127 bytes has a size that differs when synchsafe.
+	long := strings.Repeat("A long chapter title ", 10)
+	pic := id3v2.PictureFrame{
+		Encoding:    id3v2.EncodingISO,
+		MimeType:    "image/png",
+		PictureType: id3v2.PTOther,
+		Description: "slide",
+		Picture:     []byte("\x89PNG\r\n\x1a\nimage data"),
+	}
+	tests := []struct {
+		name    string
+		version byte
+		subs    []subFrame
+		title   string
+		desc    string
+	}{
+		{"no sub-frames", 4, nil, "", ""},
+		{"title v4", 4, []subFrame{{"TIT2", id3v2.TextFrame{Encoding: id3v2.EncodingUTF8, Text: "Intro"}}}, "Intro", ""},
+		{"title v3", 3, []subFrame{{"TIT2", id3v2.TextFrame{Encoding: id3v2.EncodingUTF16, Text: "Einführung"}}}, "Einführung", ""},
+		{"long title v3", 3, []subFrame{{"TIT2", id3v2.TextFrame{Encoding: id3v2.EncodingISO, Text: long}}}, long, ""},
+		{"title, description and picture", 4, []subFrame{
+			{"TIT2", id3v2.TextFrame{Encoding: id3v2.EncodingUTF8, Text: "Part 1"}},
+			{"TIT3", id3v2.TextFrame{Encoding: id3v2.EncodingUTF8, Text: "Where it starts"}},
+			{"APIC", pic},
+		}, "Part 1", "Where it starts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := testChapter(tt.version, tt.subs...)
+			got, err := parseChapterFrame(want.body(), tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ElementID != want.ElementID || got.Start != want.Start || got.End != want.End ||
+				got.StartOffset != want.StartOffset || got.EndOffset != want.EndOffset {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+			if len(got.Sub) != len(tt.subs) {
+				t.Fatalf("%d sub-frames, want %d", len(got.Sub), len(tt.subs))
+			}
+			if got.title() != tt.title || subText(got.Sub, "TIT3") != tt.desc {
+				t.Errorf("title %q, description %q; want %q, %q", got.title(), subText(got.Sub, "TIT3"), tt.title, tt.desc)
+			}
+			if !bytes.Equal(got.body(), want.body()) {
+				t.Error("body changed when written again")
+			}
+		})
+	}
+}
+
+func TestChapterPictureRoundTrip(t *testing.T) {
+	data := []byte("\xff\xd8\xff\xe0 jpeg data")
+	cf := testChapter(4, subFrame{"APIC", id3v2.PictureFrame{
+		Encoding:    id3v2.EncodingUTF8,
+		MimeType:    "image/jpeg",
+		PictureType: id3v2.PTOther,
+		Picture:     data,
+	}})
+	got, err := parseChapterFrame(cf.body(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pic := got.picture()
+	if pic == nil || pic.MimeType != "image/jpeg" || !bytes.Equal(pic.Picture, data) {
+		t.Errorf("picture = %+v", pic)
+	}
+}
+
+func TestSubFrameSizes(t *testing.T) {
+	// The id3v2 library writes synchsafe sizes into ID3v2.3 tags as well, so
+	// both kinds are read there.
+	long := strings.Repeat("x", 200)
+	subs := []subFrame{{"TIT2", id3v2.TextFrame{Encoding: id3v2.EncodingISO, Text: long}}}
+	for _, synchsafe := range []bool{false, true} {
+		var buf bytes.Buffer
+		writeSubFrames(&buf, subs, map[bool]byte{false: 3, true: 4}[synchsafe])
+		got, err := parseSubFramesAny(buf.Bytes(), 3)
+		if err != nil {
+			t.Errorf("synchsafe %v: %v", synchsafe, err)
+			continue
+		}
+		if subText(got, "TIT2") != long {
+			t.Errorf("synchsafe %v: title %q", synchsafe, subText(got, "TIT2"))
+		}
+	}
+}
+
+func TestTOCFrameRoundTrip(t *testing.T) {
+	tests := []tocFrame{
+		{ElementID: "toc", TopLevel: true, Ordered: true, Children: []string{"chp0", "chp1", "chp2"}},
+		{ElementID: "toc2", Children: []string{"chp9"}},
+		{ElementID: "empty", TopLevel: true},
+		{ElementID: "toc", TopLevel: true, Ordered: true, Children: []string{"a"},
+			Sub: []subFrame{{"TIT2", id3v2.TextFrame{Encoding: id3v2.EncodingUTF8, Text: "Contents"}}}},
+	}
+	for _, want := range tests {
+		for _, version := range []byte{3, 4} {
+			want.version = version
+			got, err := parseTOCFrame(want.body(), version)
+			if err != nil {
+				t.Fatalf("%s v%d: %v", want.ElementID, version, err)
+			}
+			if got.ElementID != want.ElementID || got.TopLevel != want.TopLevel || got.Ordered != want.Ordered ||
+				!slices.Equal(got.Children, want.Children) || subText(got.Sub, "TIT2") != subText(want.Sub, "TIT2") {
+				t.Errorf("v%d: got %+v, want %+v", version, got, want)
+			}
+		}
+	}
+}
+
+func TestParseMalformedChapters(t *testing.T) {
+	valid := testChapter(4, subFrame{"TIT2", id3v2.TextFrame{Encoding: id3v2.EncodingUTF8, Text: "Intro"}}).body()
+	tests := []struct {
+		name, id string
+		body     []byte
+	}{
+		{"no terminated element ID", "CHAP", []byte("chp1")},
+		{"times cut short", "CHAP", []byte("chp1\x00\x00\x00\x00\x01")},
+		{"sub-frame cut short", "CHAP", valid[:len(valid)-2]},
+		{"invalid sub-frame ID", "CHAP", append(bytes.Clone(valid[:21]), "tit2\x00\x00\x00\x01\x00\x00x"...)},
+		{"no entry count", "CTOC", []byte("toc\x00\x03")},
+		{"fewer entries than counted", "CTOC", []byte("toc\x00\x03\x02chp1\x00")},
+	}
+	for _, tt := range tests {
+		if _, ok, err := parseChapterOrTOC(tt.id, tt.body, 4); !ok || err == nil {
+			t.Errorf("%s: ok %v, err %v; want an error", tt.name, ok, err)
+		}
+	}
+}
+
+func TestWriteChaptersToFile(t *testing.T) {
+	for _, version := range []byte{3, 4} {
+		tag := id3v2.NewEmptyTag()
+		tag.SetVersion(version)
+		tag.SetTitle("Audiobook")
+		chapters := []chapterFrame{
+			{ElementID: "chp0", End: time.Minute, Sub: []subFrame{{"TIT2", id3v2.TextFrame{Encoding: tag.DefaultEncoding(), Text: "Opening"}}}},
+			{ElementID: "chp1", Start: time.Minute, End: 2 * time.Minute, Sub: []subFrame{{"TIT2", id3v2.TextFrame{Encoding: tag.DefaultEncoding(), Text: "Ending"}}}},
+		}
+		if err := writeChapters(tag, chapters); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := tag.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "book.mp3")
+		if err := os.WriteFile(path, append(buf.Bytes(), "audio"...), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		read, err := openTag(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, tocs, err := readChapters(read)
+		read.Close()
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if len(got) != 2 || got[0].title() != "Opening" || got[1].title() != "Ending" || got[1].Start != time.Minute {
+			t.Errorf("v%d: chapters %+v", version, got)
+		}
+		if len(tocs) != 1 || !tocs[0].TopLevel || !tocs[0].Ordered || !slices.Equal(tocs[0].Children, []string{"chp0", "chp1"}) {
+			t.Errorf("v%d: tables of contents %+v", version, tocs)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"42", 42 * time.Second, true},
+		{"1:30", 90 * time.Second, true},
+		{"1:02:03.5", time.Hour + 2*time.Minute + 3500*time.Millisecond, true},
+		{"12.3456", 12346 * time.Millisecond, true},
+		{"1m30s", 90 * time.Second, true},
+		{"1:2:3:4", 0, false},
+		{"a:30", 0, false},
+		{"-5", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseClock(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseClock(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+		if tt.ok {
+			if back, err := parseClock(formatClock(got)); err != nil || back != got {
+				t.Errorf("parseClock(formatClock(%v)) = %v, %v", got, back, err)
+			}
+		}
+	}
+}
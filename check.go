@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "check",
+		usage: "Check MP3 files for missing tags, art and lyrics and for inconsistent albums",
+		run:   runCheck,
+	})
+}
+
+// checkRules are the rules of the check command.
+var checkRules = []string{"tags", "art", "art-size", "lyrics", "lyrics-lang", "album", "compilation"}
+
+// checkOptions configures the check command.
+type checkOptions struct {
+	skip       map[string]bool
+	minArtSize int    // smallest acceptable width and height of the cover
+	lang       string // language lyrics must be in, if set
+}
+
+// enabled reports whether rule is checked.
+func (o *checkOptions) enabled(rule string) bool {
+	return !o.skip[rule]
+}
+
+// checkProblem is a rule a file breaks.
+type checkProblem struct {
+	Rule    string
+	Message string
+}
+
+// albumFields are the fields that should be the same for all files in a
+// directory, see albumValues.
+var albumFields = []string{"album", "album artist", "year", "art"}
+
+// tagYear returns the year of tag from the recording time of ID3v2.4, or else
+// from the year frame of ID3v2.3.
+func tagYear(tag *id3v2.Tag) string {
+	year := textOf(tag, "TDRC")
+	if year == "" {
+		year = textOf(tag, "TYER")
+	}
+	if len(year) > 4 {
+		year = year[:4]
+	}
+	return strings.TrimSpace(year)
+}
+
+// albumValues returns the albumFields of tag. The cover art is represented by
+// a digest of the picture. The artist and whether tag is marked as part of a
+// compilation ("1" or "") are included for compilationDirs.
+func albumValues(tag *id3v2.Tag) map[string]string {
+	art := ""
+	if cover := coverPicture(tag); cover != nil {
+		sum := sha256.Sum256(cover.Picture)
+		art = hex.EncodeToString(sum[:])
+	}
+	compilation := ""
+	if isCompilation(tag) {
+		compilation = "1"
+	}
+	return map[string]string{
+		"album":        strings.TrimSpace(textOf(tag, "TALB")),
+		"album artist": strings.TrimSpace(textOf(tag, "TPE2")),
+		"year":         tagYear(tag),
+		"art":          art,
+		"artist":       strings.TrimSpace(textOf(tag, "TPE1")),
+		"compilation":  compilation,
+	}
+}
+
+// checkTag returns the problems of tag.
+func checkTag(tag *id3v2.Tag, o *checkOptions) []checkProblem {
+	var problems []checkProblem
+	add := func(rule, format string, args ...any) {
+		problems = append(problems, checkProblem{rule, fmt.Sprintf(format, args...)})
+	}
+
+	if o.enabled("tags") {
+		var missing []string
+		for _, f := range [][2]string{{"TPE1", "artist"}, {"TIT2", "title"}, {"TALB", "album"}} {
+			if strings.TrimSpace(textOf(tag, f[0])) == "" {
+				missing = append(missing, f[1])
+			}
+		}
+		if len(missing) > 0 {
+			add("tags", "no %s", strings.Join(missing, ", "))
+		}
+	}
+
+	cover := coverPicture(tag)
+	if cover == nil && o.enabled("art") {
+		add("art", "no cover art")
+	}
+	if cover != nil && o.enabled("art-size") && o.minArtSize > 0 {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(cover.Picture))
+		switch {
+		case err != nil:
+			add("art-size", "cover art cannot be decoded: %v", err)
+		case cfg.Width < o.minArtSize || cfg.Height < o.minArtSize:
+			add("art-size", "cover art is only %dx%d", cfg.Width, cfg.Height)
+		}
+	}
+
+	uslt := tag.GetFrames(tag.CommonID("Unsynchronised lyrics/text transcription"))
+	if len(uslt) == 0 && len(tag.GetFrames("SYLT")) == 0 && o.enabled("lyrics") {
+		add("lyrics", "no lyrics")
+	}
+	if o.lang != "" && o.enabled("lyrics-lang") {
+		for _, f := range uslt {
+			if uslf, ok := f.(id3v2.UnsynchronisedLyricsFrame); ok && !strings.EqualFold(uslf.Language, o.lang) {
+				add("lyrics-lang", "lyrics in %q instead of %q", uslf.Language, o.lang)
+			}
+		}
+	}
+	return problems
+}
+
+// albumOutlier is a field of a file that differs from the other files in its
+// directory.
+type albumOutlier struct {
+	Field string
+	Value string
+
+	// Want is the value the rest of the album agrees on, taken from the file
+	// Source. If they do not agree, Want and Source are empty.
+	Want   string
+	Source string
+}
+
+// message describes o for the check command.
+func (o *albumOutlier) message() string {
+	switch {
+	case o.Field == "art" && o.Value == "":
+		return "no cover art, unlike the other files in the folder"
+	case o.Field == "art" && o.Source != "":
+		return "cover art differs from that of the other files in the folder"
+	case o.Field == "art":
+		return "the files in the folder have different cover art"
+	case o.Source != "":
+		return fmt.Sprintf("%s %q differs from %q of the other files in the folder", o.Field, o.Value, o.Want)
+	}
+	return fmt.Sprintf("%s %q, but the files in the folder do not agree on one", o.Field, o.Value)
+}
+
+// albumTarget returns the value of a field the files of an album should have,
+// given the value of each file in paths: the only value any of them has, so
+// that missing values are filled in, or else the value of more than half of
+// them. It also returns the first file with that value. ok is false if there
+// is no such value, or if more than half of the files have none.
+func albumTarget(paths []string, values map[string]string) (want, source string, ok bool) {
+	counts := map[string]int{}
+	var nonEmpty []string
+	for _, path := range paths {
+		v := values[path]
+		if counts[v] == 0 && v != "" {
+			nonEmpty = append(nonEmpty, v)
+		}
+		counts[v]++
+	}
+	switch len(nonEmpty) {
+	case 0:
+		return "", paths[0], true
+	case 1:
+		want = nonEmpty[0]
+	default:
+		for v, n := range counts {
+			if 2*n > len(paths) {
+				want = v
+			}
+		}
+		if want == "" {
+			return "", "", false
+		}
+	}
+	for _, path := range paths {
+		if values[path] == want {
+			return want, path, true
+		}
+	}
+	return "", "", false
+}
+
+// checkAlbums returns the fields of files that differ from those of the other
+// files in their directory, given the albumValues of each file by path.
+func checkAlbums(files []string, fields map[string]map[string]string) map[string][]albumOutlier {
+	dirs := map[string][]string{}
+	for _, path := range files {
+		if fields[path] != nil {
+			dirs[filepath.Dir(path)] = append(dirs[filepath.Dir(path)], path)
+		}
+	}
+	outliers := map[string][]albumOutlier{}
+	for _, paths := range dirs {
+		if len(paths) < 2 {
+			continue
+		}
+		for _, f := range albumFields {
+			values := map[string]string{}
+			for _, path := range paths {
+				values[path] = fields[path][f]
+			}
+			want, source, ok := albumTarget(paths, values)
+			for _, path := range paths {
+				v := values[path]
+				if ok && v == want {
+					continue
+				}
+				outliers[path] = append(outliers[path], albumOutlier{Field: f, Value: v, Want: want, Source: source})
+			}
+		}
+	}
+	return outliers
+}
+
+// fixAlbum gives the MP3 file at path the values the rest of its album agrees
+// on for the fields in outliers, copying the cover art from another file. It
+// returns the fields it changed.
+func fixAlbum(path string, outliers []albumOutlier, dryRun bool) ([]string, error) {
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+
+	var fixed []string
+	for _, o := range outliers {
+		if o.Source == "" {
+			continue
+		}
+		switch o.Field {
+		case "album":
+			tag.SetAlbum(o.Want)
+		case "album artist":
+			tag.AddTextFrame("TPE2", tag.DefaultEncoding(), o.Want)
+		case "year":
+			tag.SetYear(o.Want)
+		case "art":
+			src, err := openTag(o.Source)
+			if err != nil {
+				return nil, fmt.Errorf("error opening MP3 file: %w", err)
+			}
+			cover := coverPicture(src)
+			src.Close()
+			if cover == nil {
+				return nil, fmt.Errorf("%s: cover art is gone", o.Source)
+			}
+			placePicture(tag, cover.Picture, cover.MimeType, id3v2.PTFrontCover, true)
+		}
+		fixed = append(fixed, o.Field)
+	}
+	if len(fixed) == 0 || dryRun {
+		return fixed, nil
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return nil, fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	return fixed, nil
+}
+
+// runCheck implements the check command.
+func runCheck(fs *flag.FlagSet) func() error {
+	o := &checkOptions{skip: map[string]bool{}}
+	var skip string
+	fs.StringVar(&skip, "skip", "", "Comma-separated rules not to check: "+strings.Join(checkRules, ", "))
+	fs.IntVar(&o.minArtSize, "min-art-size", 500, "Smallest acceptable width and height of the cover art in pixels; 0 accepts any size")
+	fs.StringVar(&o.lang, "lang", "", "Language code lyrics must be in (e.g., jpn, eng)")
+	fix := fs.Bool("fix", false, "Give files whose album, album artist, year or cover art differ from the rest of their folder the values the others agree on")
+	dryRun := fs.Bool("dryrun", false, "With -fix, show what would be fixed without modifying the files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s check [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if skip != "" {
+			for _, rule := range strings.Split(skip, ",") {
+				rule = strings.TrimSpace(rule)
+				if !slices.Contains(checkRules, rule) {
+					return fmt.Errorf("unknown rule: %s", rule)
+				}
+				o.skip[rule] = true
+			}
+		}
+		if o.minArtSize < 0 {
+			return fmt.Errorf("invalid art size: %d", o.minArtSize)
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		problems := map[string][]checkProblem{}
+		fields := map[string]map[string]string{}
+		for _, path := range files {
+			tag, err := openTag(path)
+			if err != nil {
+				problems[path] = []checkProblem{{"read", fmt.Sprintf("error opening MP3 file: %v", err)}}
+				continue
+			}
+			problems[path] = checkTag(tag, o)
+			fields[path] = albumValues(tag)
+			tag.Close()
+		}
+		if o.enabled("album") {
+			albums := checkAlbums(files, fields)
+			for _, path := range files {
+				outliers := albums[path]
+				if *fix && len(outliers) > 0 {
+					fixed, err := fixAlbum(path, outliers, *dryRun)
+					if err != nil {
+						log.Printf("%s: %v", path, err)
+					} else if len(fixed) > 0 {
+						if *dryRun {
+							fmt.Printf("%s: would fix %s\n", path, strings.Join(fixed, ", "))
+						} else {
+							fmt.Printf("%s: fixed %s\n", path, strings.Join(fixed, ", "))
+							outliers = slices.DeleteFunc(outliers, func(a albumOutlier) bool { return a.Source != "" })
+							// Copied cover art may have fixed other problems.
+							if tag, err := openTag(path); err == nil {
+								problems[path] = checkTag(tag, o)
+								tag.Close()
+							}
+						}
+					}
+				}
+				for _, a := range outliers {
+					problems[path] = append(problems[path], checkProblem{"album", a.message()})
+				}
+			}
+		}
+
+		if o.enabled("compilation") {
+			dirs := compilationDirs(files, fields)
+			for _, path := range files {
+				n, ok := dirs[filepath.Dir(path)]
+				if ok && fields[path]["compilation"] == "" {
+					problems[path] = append(problems[path], checkProblem{"compilation",
+						fmt.Sprintf("the folder looks like a compilation of %d artists, but the file is not marked as one", n)})
+				}
+			}
+		}
+
+		bad := 0
+		for _, path := range files {
+			if len(problems[path]) == 0 {
+				continue
+			}
+			bad++
+			for _, p := range problems[path] {
+				fmt.Printf("%s: %s: %s\n", path, p.Rule, p.Message)
+			}
+		}
+		if bad > 0 {
+			return fmt.Errorf("%d of %d files have problems", bad, len(files))
+		}
+		fmt.Printf("All %d files passed\n", len(files))
+		return nil
+	}
+}
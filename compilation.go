@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// variousArtists is the album artist of compilations.
+const variousArtists = "Various Artists"
+
+// compilationModes are the values of the -compilation flag of the album
+// command: mark the tracks as a compilation, unmark them, or mark those in
+// directories that look like one.
+var compilationModes = []string{"yes", "no", "auto"}
+
+// isVariousArtists reports whether the album artist s stands for many artists.
+func isVariousArtists(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "various artists", "various", "va", "v.a.":
+		return true
+	}
+	return false
+}
+
+// isCompilation reports whether tag belongs to a compilation: it has the
+// iTunes compilation flag (TCMP) or Various Artists as its album artist.
+func isCompilation(tag *id3v2.Tag) bool {
+	return strings.TrimSpace(textOf(tag, "TCMP")) == "1" || isVariousArtists(textOf(tag, "TPE2"))
+}
+
+// setCompilation marks tag as belonging to a compilation, with the iTunes
+// compilation flag and Various Artists as the album artist, or removes both.
+func setCompilation(tag *id3v2.Tag, on bool) {
+	if !on {
+		tag.DeleteFrames("TCMP")
+		if isVariousArtists(textOf(tag, "TPE2")) {
+			tag.DeleteFrames("TPE2")
+		}
+		return
+	}
+	if strings.TrimSpace(textOf(tag, "TCMP")) != "1" {
+		tag.AddTextFrame("TCMP", tag.DefaultEncoding(), "1")
+	}
+	if !isVariousArtists(textOf(tag, "TPE2")) {
+		tag.AddTextFrame("TPE2", tag.DefaultEncoding(), variousArtists)
+	}
+}
+
+// compilationArtists returns the number of different artists if artists, those
+// of the tracks of one album, are those of a compilation: no artist has more
+// than half of the tracks, which leaves albums with a few guest appearances
+// alone. Otherwise it returns 0. Tracks without an artist do not count.
+func compilationArtists(artists []string) int {
+	counts := map[string]int{}
+	total := 0
+	for _, a := range artists {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			counts[a]++
+			total++
+		}
+	}
+	if len(counts) < 2 {
+		return 0
+	}
+	for _, n := range counts {
+		if 2*n > total {
+			return 0
+		}
+	}
+	return len(counts)
+}
+
+// compilationDirs returns the directories of files whose files share an album
+// and look like a compilation, with the number of artists on each, given the
+// albumValues of each file by path. Files without values are left out.
+func compilationDirs(files []string, fields map[string]map[string]string) map[string]int {
+	dirs := map[string][]string{}
+	for _, path := range files {
+		if fields[path] != nil {
+			dirs[filepath.Dir(path)] = append(dirs[filepath.Dir(path)], path)
+		}
+	}
+	found := map[string]int{}
+	for dir, paths := range dirs {
+		var albums, artists []string
+		for _, path := range paths {
+			albums = append(albums, fields[path]["album"])
+			artists = append(artists, fields[path]["artist"])
+		}
+		slices.Sort(albums)
+		if albums[0] == "" || len(slices.Compact(albums)) != 1 {
+			continue
+		}
+		if n := compilationArtists(artists); n > 0 {
+			found[dir] = n
+		}
+	}
+	return found
+}
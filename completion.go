@@ -0,0 +1,256 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "completion",
+		usage: "Print a completion script for bash, zsh or fish",
+		run:   runCompletion,
+	})
+}
+
+// commandFlags returns the flags defined on fs, sorted by name.
+func commandFlags(fs *flag.FlagSet) (flags []*flag.Flag) {
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+	return flags
+}
+
+// flagValues lists the values completed for flags that take one of a fixed
+// set of words, by flag name.
+var flagValues = map[string]func() []string{
+	"picture-type":       pictureTypeNames,
+	"overwrite":          func() []string { return overwritePolicies },
+	"id3v1":              func() []string { return id3v1Modes },
+	"romanize":           func() []string { return romanizeModes },
+	"output":             func() []string { return []string{"text", "json"} },
+	"log-format":         func() []string { return []string{"text", "json"} },
+	"translate-provider": func() []string { return []string{providerDeepL, providerGoogle, providerLibreTranslate} },
+}
+
+// completionValues returns the words completed for the value of the flag f of
+// the command named cmd, and whether file names are completed too.
+func completionValues(cmd string, f *flag.Flag) (words []string, files bool) {
+	if isBoolFlag(f) {
+		return nil, false
+	}
+	// The sources of the default mode and watch may also be fetched.
+	if (cmd == "" || cmd == "embed" || cmd == "watch") && (f.Name == "image" || f.Name == "lyrics") {
+		return []string{"auto"}, true
+	}
+	if values, ok := flagValues[f.Name]; ok {
+		return values(), false
+	}
+	return nil, true
+}
+
+// completionCommand is a command with its flags, as completed by the scripts.
+// The default embed mode has an empty name.
+type completionCommand struct {
+	name, usage string
+	flags       []*flag.Flag
+}
+
+// completionCommands returns the default mode and the subcommands.
+func completionCommands() []completionCommand {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	embedFlags(fs, nil)
+	cmds := []completionCommand{{flags: commandFlags(fs)}}
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := commands[name]
+		cmds = append(cmds, completionCommand{name: name, usage: c.usage, flags: commandFlags(c.flags())})
+	}
+	return cmds
+}
+
+// isBoolFlag reports whether f takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// runCompletion implements the completion command.
+func runCompletion(fs *flag.FlagSet) func() error {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s completion bash|zsh|fish\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Load the script in the shell, as in: source <(%s completion bash)\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		cmds := completionCommands()
+		switch fs.Arg(0) {
+		case "bash":
+			writeBashCompletion(os.Stdout, cmds)
+		case "zsh":
+			writeZshCompletion(os.Stdout, cmds)
+		case "fish":
+			writeFishCompletion(os.Stdout, cmds)
+		default:
+			return fmt.Errorf("unknown shell: %s", fs.Arg(0))
+		}
+		return nil
+	}
+}
+
+// writeBashCompletion writes a bash completion script for cmds to w.
+func writeBashCompletion(w io.Writer, cmds []completionCommand) {
+	var names []string
+	for _, c := range cmds[1:] {
+		names = append(names, c.name)
+	}
+	fmt.Fprintln(w, "# bash completion for mp3extra")
+	fmt.Fprintln(w, "_mp3extra() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd="" flags=""`)
+	fmt.Fprintln(w, `	if [[ $COMP_CWORD -gt 1 && ${COMP_WORDS[1]} != -* ]]; then cmd="${COMP_WORDS[1]}"; fi`)
+	fmt.Fprintln(w, `	case "$cmd" in`)
+	for _, c := range cmds {
+		pattern := c.name
+		if pattern == "" {
+			pattern = `""`
+		}
+		var flags []string
+		for _, f := range c.flags {
+			flags = append(flags, "-"+f.Name)
+		}
+		fmt.Fprintf(w, "\t%s)\n", pattern)
+		fmt.Fprintf(w, "\t\tflags=%q\n", strings.Join(flags, " "))
+		fmt.Fprintln(w, `		case "$prev" in`)
+		for _, f := range c.flags {
+			words, files := completionValues(c.name, f)
+			if words == nil {
+				continue
+			}
+			reply := fmt.Sprintf(`$(compgen -W %q -- "$cur")`, strings.Join(words, " "))
+			if files {
+				reply += ` $(compgen -f -- "$cur")`
+			}
+			fmt.Fprintf(w, "\t\t-%s) COMPREPLY=(%s); return ;;\n", f.Name, reply)
+		}
+		fmt.Fprintln(w, "\t\tesac ;;")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [[ $cur == -* ]]; then`)
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	fmt.Fprintln(w, `	elif [[ $COMP_CWORD -eq 1 ]]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\") $(compgen -f -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _mp3extra mp3extra")
+}
+
+// writeZshCompletion writes a zsh completion script for cmds to w.
+func writeZshCompletion(w io.Writer, cmds []completionCommand) {
+	fmt.Fprintln(w, "#compdef mp3extra")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "_mp3extra() {")
+	fmt.Fprintln(w, "\tlocal -a commands")
+	fmt.Fprintln(w, "\tcommands=(")
+	for _, c := range cmds[1:] {
+		fmt.Fprintf(w, "\t\t%s\n", shellQuote(c.name+":"+c.usage))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, `	if (( CURRENT > 2 )) && (( ${commands[(I)${words[2]}:*]} )); then`)
+	fmt.Fprintln(w, "\t\tlocal cmd=${words[2]}")
+	fmt.Fprintln(w, "\t\tshift words")
+	fmt.Fprintln(w, "\t\t(( CURRENT-- ))")
+	fmt.Fprintln(w, "\t\tcase $cmd in")
+	for _, c := range cmds[1:] {
+		fmt.Fprintf(w, "\t\t%s) _arguments %s '*:file:_files' ;;\n", c.name, zshFlagSpecs(c))
+	}
+	fmt.Fprintln(w, "\t\tesac")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintf(w, "\t_arguments %s '1:command or file:->first' '*:file:_files'\n", zshFlagSpecs(cmds[0]))
+	fmt.Fprintln(w, "\tif [[ $state == first ]]; then")
+	fmt.Fprintln(w, "\t\t_describe command commands")
+	fmt.Fprintln(w, "\t\t_files")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, `_mp3extra "$@"`)
+}
+
+// zshFlagSpecs returns the _arguments specs of the flags of c.
+func zshFlagSpecs(c completionCommand) string {
+	var specs []string
+	for _, f := range c.flags {
+		desc := strings.NewReplacer("[", "(", "]", ")", ":", " ").Replace(firstLine(f.Usage))
+		spec := "-" + f.Name + "[" + desc + "]"
+		switch words, files := completionValues(c.name, f); {
+		case words != nil && files:
+			spec += ":" + f.Name + `:_alternative "values:value:(` + strings.Join(words, " ") + `)" files:file:_files`
+		case words != nil:
+			spec += ":" + f.Name + ":(" + strings.Join(words, " ") + ")"
+		case files:
+			spec += ":" + f.Name + ":_files"
+		}
+		specs = append(specs, shellQuote(spec))
+	}
+	return strings.Join(specs, " ")
+}
+
+// writeFishCompletion writes a fish completion script for cmds to w.
+func writeFishCompletion(w io.Writer, cmds []completionCommand) {
+	fmt.Fprintln(w, "# fish completion for mp3extra")
+	fmt.Fprintln(w, "complete -c mp3extra -f")
+	for _, c := range cmds[1:] {
+		fmt.Fprintf(w, "complete -c mp3extra -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.usage))
+	}
+	fmt.Fprintln(w, "complete -c mp3extra -n __fish_use_subcommand -F")
+	for _, c := range cmds {
+		cond := "__fish_use_subcommand"
+		if c.name != "" {
+			cond = fishQuote("__fish_seen_subcommand_from " + c.name)
+			fmt.Fprintf(w, "complete -c mp3extra -n %s -F\n", cond)
+		}
+		for _, f := range c.flags {
+			line := fmt.Sprintf("complete -c mp3extra -n %s -o %s -d %s", cond, f.Name, fishQuote(firstLine(f.Usage)))
+			switch words, files := completionValues(c.name, f); {
+			case words != nil:
+				line += " -x -a " + fishQuote(strings.Join(words, " "))
+				if files {
+					line += " -F"
+				}
+			case files:
+				line += " -r -F"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// shellQuote quotes s in single quotes for bash and zsh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes s in single quotes for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// confidence is how far a frame written by embedFile can be trusted.
+type confidence int
+
+const (
+	// confHuman frames were set by the user, e.g. from an image file given on
+	// the command line, or were left as they were.
+	confHuman confidence = iota
+	// confHigh frames were fetched for an exact match or derived by a fixed
+	// rule, such as a repaired encoding.
+	confHigh
+	// confLow frames were fetched for a loose match or guessed, e.g. from the
+	// file name.
+	confLow
+)
+
+// fingerprintConfidence is the AcoustID score from which an identification
+// by audio fingerprint is trusted.
+const fingerprintConfidence = 0.9
+
+// scoreConfidence returns the confidence of a lookup result with the given
+// match score, which is high only for exact matches as in confidentMatch.
+func scoreConfidence(score float64) confidence {
+	if score >= 1 {
+		return confHigh
+	}
+	return confLow
+}
+
+// frameSources records the confidence of the frames changed while embedding,
+// by frame key. A nil frameSources records nothing, which saves capturing the
+// tag when nobody looks at the result.
+type frameSources map[string]confidence
+
+// track records conf for the frames of tag that differ from before and returns
+// the frames of tag as they are now, for tracking the next step.
+func (s frameSources) track(tag *id3v2.Tag, before []frameState, conf confidence) []frameState {
+	if s == nil {
+		return nil
+	}
+	after := captureFrames(tag)
+	for _, c := range diffFrames(before, after) {
+		if c.After != nil {
+			s[c.After.Key] = conf
+		}
+	}
+	return after
+}
+
+// ANSI colors of the confidence levels.
+const (
+	colorHigh  = "\x1b[32m" // green
+	colorLow   = "\x1b[33m" // yellow
+	colorReset = "\x1b[0m"
+)
+
+// useColor reports whether w is a terminal that colors may be written to.
+// Setting NO_COLOR turns colors off, see https://no-color.org.
+func useColor(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f) && os.Getenv("NO_COLOR") == ""
+}
+
+// printFrameDiffSources is like printFrameDiff, but marks the frames that were
+// fetched or guessed according to sources: green for high and yellow for low
+// confidence on a terminal, or with "[auto]" and "[check]" otherwise.
+func printFrameDiffSources(w io.Writer, changes []frameChange, sources frameSources) {
+	if len(changes) == 0 || len(sources) == 0 {
+		printFrameDiff(w, changes)
+		return
+	}
+	color := useColor(w)
+	var b strings.Builder
+	marked := false
+	for _, c := range changes {
+		b.Reset()
+		printFrameDiff(&b, []frameChange{c})
+		line := strings.TrimSuffix(b.String(), "\n")
+		conf := confHuman
+		if c.After != nil {
+			conf = sources[c.After.Key]
+		}
+		switch {
+		case conf == confHuman:
+		case color && conf == confHigh:
+			line = colorHigh + line + colorReset
+		case color:
+			line = colorLow + line + colorReset
+		case conf == confHigh:
+			line += " [auto]"
+		default:
+			line += " [check]"
+		}
+		marked = marked || conf != confHuman
+		fmt.Fprintln(w, line)
+	}
+	if marked && color {
+		fmt.Fprintf(w, "(%sgreen%s: fetched for an exact match, %syellow%s: loose match or guess, please check)\n",
+			colorHigh, colorReset, colorLow, colorReset)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "convert",
+		usage: "Convert tags between ID3v2.3 and ID3v2.4",
+		run:   runConvert,
+	})
+}
+
+// v24UserText pairs ID3v2.4 text frames without an ID3v2.3 counterpart with the
+// TXXX descriptions they are kept under in ID3v2.3.
+var v24UserText = [][2]string{
+	{"TMOO", "MOOD"},
+	{"TPRO", "PRODUCEDNOTICE"},
+	{"TSST", "SETSUBTITLE"},
+}
+
+// Frames that only exist in one version and cannot be converted.
+var (
+	v23Only = []string{"EQUA", "RVAD", "TRDA", "TSIZ"}
+	v24Only = []string{"ASPI", "EQU2", "RVA2", "SEEK", "SIGN", "TDEN", "TDRL", "TDTG"}
+)
+
+// runConvert implements the convert command.
+func runConvert(fs *flag.FlagSet) func() error {
+	var to int
+	var dryRun bool
+	fs.IntVar(&to, "to", 3, "ID3v2 version to convert to: 3 or 4")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the files")
+	manifest := manifestFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s convert -to 3|4 [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if to != 3 && to != 4 {
+			return fmt.Errorf("unsupported ID3v2 version: 2.%d", to)
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		failed := 0
+		var done []string
+		for _, name := range files {
+			if err := convertFile(name, byte(to), dryRun); err != nil {
+				log.Printf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			done = append(done, name)
+		}
+		if !dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
+
+// convertFile rewrites the tag of the MP3 file at path as the given ID3v2 version.
+func convertFile(path string, version byte, dryRun bool) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+
+	if tag.Count() == 0 {
+		fmt.Println("No tag in", path)
+		return nil
+	}
+	if tag.Version() == version {
+		fmt.Printf("%s: already ID3v2.%d\n", path, version)
+		return nil
+	}
+
+	from := tag.Version()
+	before := captureFrames(tag)
+	dropped := convertTag(tag, version)
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	if dryRun {
+		fmt.Printf("==> %s <==\n", path)
+		fmt.Printf("ID3v2.%d -> ID3v2.%d\n", from, version)
+		printFrameDiff(os.Stdout, diffFrames(before, captureFrames(tag)))
+		if len(dropped) > 0 {
+			fmt.Println("Would drop", strings.Join(dropped, ", "))
+		}
+		return nil
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	fmt.Printf("Converted %s to ID3v2.%d\n", path, version)
+	if len(dropped) > 0 {
+		fmt.Printf("%s: dropped %s, which ID3v2.%d has no equivalent for\n", path, strings.Join(dropped, ", "), version)
+	}
+	return nil
+}
+
+// convertTag converts the frames of tag to the given ID3v2 version and sets the
+// version. It returns the IDs of frames that were dropped.
+func convertTag(tag *id3v2.Tag, version byte) []string {
+	var dropped []string
+	if version == 4 {
+		dropped = convertTo24(tag)
+	} else {
+		dropped = convertTo23(tag)
+	}
+	setChapterVersion(tag, version)
+	tag.SetVersion(version)
+	return dropped
+}
+
+// textOf returns the text of the text frame with the given ID, or "" if there is none.
+func textOf(tag *id3v2.Tag, id string) string {
+	return tag.GetTextFrame(id).Text
+}
+
+// encodingOf returns the encoding of the text frame with the given ID.
+func encodingOf(tag *id3v2.Tag, id string) id3v2.Encoding {
+	if tf, ok := tag.GetLastFrame(id).(id3v2.TextFrame); ok {
+		return tf.Encoding
+	}
+	return tag.DefaultEncoding()
+}
+
+// isDigits reports whether s consists of n ASCII digits.
+func isDigits(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// dropFrames deletes the frames with the given IDs and returns those that existed.
+func dropFrames(tag *id3v2.Tag, ids []string) []string {
+	var dropped []string
+	for _, id := range ids {
+		if len(tag.GetFrames(id)) > 0 {
+			tag.DeleteFrames(id)
+			dropped = append(dropped, id)
+		}
+	}
+	return dropped
+}
+
+// convertTo24 converts ID3v2.3 frames to their ID3v2.4 counterparts.
+func convertTo24(tag *id3v2.Tag) []string {
+	// TYER, TDAT and TIME become a single TDRC timestamp.
+	if year := textOf(tag, "TYER"); year != "" && textOf(tag, "TDRC") == "" {
+		ts := year
+		if date := textOf(tag, "TDAT"); isDigits(year, 4) && isDigits(date, 4) {
+			ts += "-" + date[2:] + "-" + date[:2]
+			if t := textOf(tag, "TIME"); isDigits(t, 4) {
+				ts += "T" + t[:2] + ":" + t[2:]
+			}
+		}
+		tag.AddTextFrame("TDRC", encodingOf(tag, "TYER"), ts)
+	}
+	if year := textOf(tag, "TORY"); year != "" && textOf(tag, "TDOR") == "" {
+		tag.AddTextFrame("TDOR", encodingOf(tag, "TORY"), year)
+	}
+	for _, id := range []string{"TYER", "TDAT", "TIME", "TORY"} {
+		tag.DeleteFrames(id)
+	}
+
+	// IPLS has the layout of a text frame and becomes TIPL unchanged.
+	if f, ok := tag.GetLastFrame("IPLS").(id3v2.UnknownFrame); ok {
+		tag.AddFrame("TIPL", f)
+	}
+	tag.DeleteFrames("IPLS")
+
+	// TXXX frames standing in for ID3v2.4 text frames become those frames again.
+	for _, ut := range v24UserText {
+		deleteFramesFunc(tag, "TXXX", func(_ int, f id3v2.Framer) bool {
+			udtf, ok := f.(id3v2.UserDefinedTextFrame)
+			if !ok || udtf.Description != ut[1] || textOf(tag, ut[0]) != "" {
+				return false
+			}
+			tag.AddTextFrame(ut[0], udtf.Encoding, udtf.Value)
+			return true
+		})
+	}
+
+	return dropFrames(tag, v23Only)
+}
+
+// convertTo23 converts ID3v2.4 frames to their ID3v2.3 counterparts.
+func convertTo23(tag *id3v2.Tag) []string {
+	// The TDRC timestamp, or the release time if there is none, is split into
+	// TYER, TDAT and TIME.
+	id := "TDRC"
+	if textOf(tag, id) == "" {
+		id = "TDRL"
+	}
+	if ts := textOf(tag, id); len(ts) >= 4 {
+		enc := encodingOf(tag, id)
+		tag.AddTextFrame("TYER", enc, ts[:4])
+		if len(ts) >= 10 && isDigits(ts[5:7], 2) && isDigits(ts[8:10], 2) {
+			tag.AddTextFrame("TDAT", enc, ts[8:10]+ts[5:7])
+			if len(ts) >= 16 && isDigits(ts[11:13], 2) && isDigits(ts[14:16], 2) {
+				tag.AddTextFrame("TIME", enc, ts[11:13]+ts[14:16])
+			}
+		}
+		tag.DeleteFrames(id)
+	}
+	tag.DeleteFrames("TDRC")
+	if ts := textOf(tag, "TDOR"); len(ts) >= 4 {
+		tag.AddTextFrame("TORY", encodingOf(tag, "TDOR"), ts[:4])
+	}
+	tag.DeleteFrames("TDOR")
+
+	// TIPL and TMCL are merged into IPLS.
+	var people []string
+	for _, id := range []string{"TIPL", "TMCL"} {
+		if s := textOf(tag, id); s != "" {
+			people = append(people, s)
+		}
+		tag.DeleteFrames(id)
+	}
+	if len(people) > 0 {
+		var buf bytes.Buffer
+		id3v2.TextFrame{Encoding: id3v2.EncodingUTF16, Text: strings.Join(people, "\x00")}.WriteTo(&buf)
+		tag.AddFrame("IPLS", id3v2.UnknownFrame{Body: buf.Bytes()})
+	}
+
+	for _, ut := range v24UserText {
+		if s := textOf(tag, ut[0]); s != "" {
+			tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+				Encoding:    encodingOf(tag, ut[0]),
+				Description: ut[1],
+				Value:       s,
+			})
+		}
+		tag.DeleteFrames(ut[0])
+	}
+
+	dropped := dropFrames(tag, v24Only)
+	downgradeEncodings(tag)
+	return dropped
+}
+
+// downgradeEncodings rewrites the UTF-8 frames of tag as UTF-16, which ID3v2.3
+// does not know, and joins multiple values of text frames with "/" as ID3v2.3
+// expects.
+func downgradeEncodings(tag *id3v2.Tag) {
+	mapFrames(tag, func(f id3v2.Framer) (id3v2.Framer, bool) {
+		_, isText := f.(id3v2.TextFrame)
+		return mapText(f, func(enc *id3v2.Encoding, texts ...*string) bool {
+			changed := false
+			if enc.Equals(id3v2.EncodingUTF8) {
+				*enc, changed = id3v2.EncodingUTF16, true
+			}
+			if isText && strings.Contains(*texts[0], "\x00") {
+				*texts[0], changed = strings.ReplaceAll(*texts[0], "\x00", "/"), true
+			}
+			return changed
+		})
+	})
+}
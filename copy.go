@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "copy",
+		usage: "Copy tag frames from one MP3 file to others, e.g. after re-encoding",
+		run:   runCopy,
+	})
+}
+
+// runCopy implements the copy command.
+func runCopy(fs *flag.FlagSet) func() error {
+	var frames string
+	var dryRun bool
+	fs.StringVar(&frames, "frames", "", "Comma-separated IDs of the frames to copy, e.g. APIC,USLT (default: all frames)")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the files")
+	manifest := manifestFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s copy [flags] src.mp3 dst.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		var ids []string
+		if frames != "" {
+			for _, id := range strings.Split(frames, ",") {
+				id = strings.ToUpper(strings.TrimSpace(id))
+				if !validFrameID(id) {
+					return fmt.Errorf("invalid frame ID: %s", id)
+				}
+				ids = append(ids, id)
+			}
+		}
+
+		src := fs.Arg(0)
+		srcTag, err := openTag(src)
+		if err != nil {
+			return fmt.Errorf("error opening MP3 file: %w", err)
+		}
+		defer srcTag.Close()
+		if ids != nil {
+			for id := range srcTag.AllFrames() {
+				if !slices.Contains(ids, id) {
+					srcTag.DeleteFrames(id)
+				}
+			}
+		}
+		if srcTag.Count() == 0 {
+			return fmt.Errorf("%s: no frames to copy", src)
+		}
+
+		files, err := collectMP3Files(fs.Args()[1:])
+		if err != nil {
+			return err
+		}
+		failed := 0
+		var done []string
+		for _, name := range files {
+			if sameFile(name, src) {
+				continue
+			}
+			if err := copyFrames(srcTag, src, name, dryRun); err != nil {
+				log.Printf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			done = append(done, name)
+		}
+		if !dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
+
+// sameFile reports whether a and b are the same file.
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+// copyFrames replaces the frames of the MP3 file at path with the IDs of the
+// frames of from, the tag of the file src, by those frames. Frames with other
+// IDs are kept. The frames are converted to the ID3v2 version of the file
+// first, so that e.g. TYER becomes TDRC.
+func copyFrames(from *id3v2.Tag, src, path string, dryRun bool) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+
+	// The source tag is converted on a copy, as it is shared by all files.
+	frames := from.AllFrames()
+	var dropped []string
+	if v := tag.Version(); v != from.Version() {
+		conv := id3v2.NewEmptyTag()
+		conv.SetVersion(from.Version())
+		for id, fs := range frames {
+			for _, f := range fs {
+				conv.AddFrame(id, f)
+			}
+		}
+		dropped = convertTag(conv, v)
+		frames = conv.AllFrames()
+	}
+
+	before := captureFrames(tag)
+	for id, fs := range frames {
+		tag.DeleteFrames(id)
+		for _, f := range fs {
+			tag.AddFrame(id, f)
+		}
+	}
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	changes := diffFrames(before, captureFrames(tag))
+	if dryRun {
+		fmt.Printf("==> %s <==\n", path)
+		printFrameDiff(os.Stdout, changes)
+		if len(dropped) > 0 {
+			fmt.Printf("Would not copy %s, which ID3v2.%d has no equivalent for\n", strings.Join(dropped, ", "), tag.Version())
+		}
+		return nil
+	}
+	if len(dropped) > 0 {
+		fmt.Printf("%s: not copying %s, which ID3v2.%d has no equivalent for\n", path, strings.Join(dropped, ", "), tag.Version())
+	}
+	if len(changes) == 0 {
+		fmt.Printf("%s already has the frames of %s\n", path, src)
+		return nil
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	fmt.Printf("Copied %d frames from %s to %s\n", len(changes), src, path)
+	return nil
+}
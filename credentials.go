@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "auth",
+		usage: "Store, list or delete the API keys of the providers that need one",
+		run:   runAuth,
+	})
+}
+
+// credentialProviders are the providers whose API keys the auth command
+// manages.
+var credentialProviders = []string{providerAcoustID, providerDeepL, providerGoogle, providerLibreTranslate}
+
+// keychainService is the service name keys are stored under in the keychain.
+const keychainService = "mp3extra"
+
+// credentialEnv returns the environment variable holding the key of provider,
+// as in MP3EXTRA_DEEPL_KEY.
+func credentialEnv(provider string) string {
+	return envName(provider + "-key")
+}
+
+// credentialsPath returns the location of the stored keys.
+func credentialsPath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "credentials.json"), nil
+}
+
+// loadCredentials reads the keys stored in the config directory, by provider.
+func loadCredentials() (map[string]string, error) {
+	name, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	creds := map[string]string{}
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return creds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return creds, nil
+}
+
+// saveCredentials writes creds to the config directory, readable only by the
+// user.
+func saveCredentials(creds map[string]string) error {
+	name, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := writeJSONFile(name, creds); err != nil {
+		return err
+	}
+	return os.Chmod(name, 0600)
+}
+
+// errNoCredential is returned for providers that need a key none was found for.
+var errNoCredential = errors.New("no API key")
+
+// credential returns the API key of provider and where it was found: the
+// environment, the keychain or the config directory. The legacy variable
+// ACOUSTID_KEY is still read for AcoustID.
+func credential(provider string) (key, source string, err error) {
+	if key := os.Getenv(credentialEnv(provider)); key != "" {
+		return key, credentialEnv(provider), nil
+	}
+	if provider == providerAcoustID {
+		if key := os.Getenv("ACOUSTID_KEY"); key != "" {
+			return key, "ACOUSTID_KEY", nil
+		}
+	}
+	if key, err := keychainLookup(provider); err == nil && key != "" {
+		return key, "keychain", nil
+	}
+	creds, err := loadCredentials()
+	if err != nil {
+		return "", "", err
+	}
+	if key := creds[provider]; key != "" {
+		return key, "credentials.json", nil
+	}
+	return "", "", fmt.Errorf("%s: %w: run 'mp3extra auth %s' or set %s", provider, errNoCredential, provider, credentialEnv(provider))
+}
+
+// keychainLookup returns the key of provider stored in the keychain of the
+// system: the login keychain on macOS and the Secret Service (through
+// secret-tool) elsewhere.
+func keychainLookup(provider string) (string, error) {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", provider, "-w")
+	case hasCommand("secret-tool"):
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "provider", provider)
+	default:
+		return "", errors.New("no keychain available")
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keychainStore stores the key of provider in the keychain of the system. The
+// key is passed on standard input, never as an argument, which any user could
+// see in the process list.
+func keychainStore(provider, key string) error {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		// security -i reads its commands from standard input, where the key is
+		// quoted.
+		if strings.ContainsAny(key, "\"\\\r\n") {
+			return errors.New("a key with quotes, backslashes or line breaks cannot be stored in the keychain")
+		}
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w \"%s\"\n", keychainService, provider, key))
+	default:
+		cmd = exec.Command("secret-tool", "store", "--label", "mp3extra "+provider+" API key", "service", keychainService, "provider", provider)
+		cmd.Stdin = strings.NewReader(key)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error storing the key in the keychain: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	// security -i succeeds even if one of its commands failed.
+	if got, err := keychainLookup(provider); err != nil || got != key {
+		return fmt.Errorf("error storing the key in the keychain: %s", bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// keychainDelete removes the key of provider from the keychain of the system,
+// if there is one.
+func keychainDelete(provider string) {
+	switch {
+	case runtime.GOOS == "darwin":
+		exec.Command("security", "delete-generic-password", "-s", keychainService, "-a", provider).Run()
+	case hasCommand("secret-tool"):
+		exec.Command("secret-tool", "clear", "service", keychainService, "provider", provider).Run()
+	}
+}
+
+// hasKeychain reports whether keys can be stored in the keychain.
+func hasKeychain() bool {
+	return runtime.GOOS == "darwin" || hasCommand("secret-tool")
+}
+
+// hasCommand reports whether the program name is found in PATH.
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// runAuth implements the auth command.
+func runAuth(fs *flag.FlagSet) func() error {
+	keychain := fs.Bool("keychain", false, "Store the key in the keychain of the system instead of the config directory")
+	del := fs.Bool("delete", false, "Delete the stored key of the provider")
+	list := fs.Bool("list", false, "List the providers and where their keys are found")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s auth [flags] %s\n", os.Args[0], strings.Join(credentialProviders, "|"))
+		fmt.Fprintf(fs.Output(), "       %s auth -list\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "The key is read from standard input.\n")
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if *list {
+			tc, err := loadTranslateConfig()
+			if err != nil {
+				return err
+			}
+			for _, p := range credentialProviders {
+				_, source, err := credential(p)
+				switch {
+				case err == nil:
+				case errors.Is(err, errNoCredential) && tc.key(p) != "":
+					source = "translate.json"
+				case errors.Is(err, errNoCredential):
+					source = "not set"
+				default:
+					return err
+				}
+				fmt.Printf("%-16s %s\n", p, source)
+			}
+			return nil
+		}
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		provider := fs.Arg(0)
+		if !slices.Contains(credentialProviders, provider) {
+			return fmt.Errorf("unknown provider %q: want %s", provider, strings.Join(credentialProviders, ", "))
+		}
+
+		creds, err := loadCredentials()
+		if err != nil {
+			return err
+		}
+		if *del {
+			keychainDelete(provider)
+			delete(creds, provider)
+			if err := saveCredentials(creds); err != nil {
+				return err
+			}
+			fmt.Println("Deleted the key of", provider)
+			return nil
+		}
+		if *keychain && !hasKeychain() {
+			return errors.New("no keychain available: security (macOS) or secret-tool (libsecret) is needed")
+		}
+		key, err := askSecret(fmt.Sprintf("API key for %s: ", provider))
+		if err != nil || key == "" {
+			return errors.New("no key given")
+		}
+		if *keychain {
+			if err := keychainStore(provider, key); err != nil {
+				return err
+			}
+			// A key left in the config directory would no longer be used.
+			if _, ok := creds[provider]; ok {
+				delete(creds, provider)
+				if err := saveCredentials(creds); err != nil {
+					return err
+				}
+			}
+			fmt.Println("Stored the key of", provider, "in the keychain")
+			return nil
+		}
+		creds[provider] = key
+		if err := saveCredentials(creds); err != nil {
+			return err
+		}
+		name, _ := credentialsPath()
+		fmt.Println("Stored the key of", provider, "in", name)
+		return nil
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bogem/id3v2/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "cue",
+		usage: "Write the tracks of a cue sheet to a single-file album rip as chapters",
+		run:   runCue,
+	})
+}
+
+// cueSheet is the part of a cue sheet that describes the tracks of a single
+// audio file.
+type cueSheet struct {
+	Performer string
+	Title     string
+	Date      string
+	Genre     string
+	Tracks    []cueTrack
+}
+
+// cueTrack is a track of a cue sheet.
+type cueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	Start     time.Duration // INDEX 01
+}
+
+// cueValue returns the value of a cue sheet command, without the quotes it may
+// be enclosed in.
+func cueValue(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `"`) {
+		if i := strings.IndexByte(s[1:], '"'); i >= 0 {
+			return s[1 : i+1]
+		}
+		return s[1:]
+	}
+	return s
+}
+
+// parseCueTime parses a cue sheet time in the form mm:ss:ff, where ff counts
+// the 75 frames of a CD second.
+func parseCueTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %q: want mm:ss:ff", s)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time %q: want mm:ss:ff", s)
+		}
+		n[i] = v
+	}
+	if n[1] >= 60 || n[2] >= 75 {
+		return 0, fmt.Errorf("invalid time %q: want mm:ss:ff", s)
+	}
+	return time.Duration(n[0])*time.Minute + time.Duration(n[1])*time.Second + time.Duration(n[2])*time.Second/75, nil
+}
+
+// errCueFiles is returned for cue sheets that describe several audio files.
+var errCueFiles = errors.New("cue sheets with more than one FILE are not supported")
+
+// parseCueSheet parses the cue sheet in b. Text that is not UTF-8 is read as
+// Windows-1252, as written by most ripping software on Windows.
+func parseCueSheet(b []byte) (*cueSheet, error) {
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(b) {
+		var err error
+		if b, err = charmap.Windows1252.NewDecoder().Bytes(b); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet := &cueSheet{}
+	var track *cueTrack
+	files := 0
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		cmd, rest, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		switch strings.ToUpper(cmd) {
+		case "FILE":
+			if files++; files > 1 {
+				return nil, errCueFiles
+			}
+		case "TRACK":
+			num, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+			v, err := strconv.Atoi(num)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid track number %q", n, num)
+			}
+			sheet.Tracks = append(sheet.Tracks, cueTrack{Number: v, Start: -1})
+			track = &sheet.Tracks[len(sheet.Tracks)-1]
+		case "TITLE":
+			if track != nil {
+				track.Title = cueValue(rest)
+			} else {
+				sheet.Title = cueValue(rest)
+			}
+		case "PERFORMER":
+			if track != nil {
+				track.Performer = cueValue(rest)
+			} else {
+				sheet.Performer = cueValue(rest)
+			}
+		case "INDEX":
+			idx, t, _ := strings.Cut(strings.TrimSpace(rest), " ")
+			if track == nil || idx != "01" {
+				continue
+			}
+			start, err := parseCueTime(strings.TrimSpace(t))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			track.Start = start
+		case "REM":
+			key, value, _ := strings.Cut(strings.TrimSpace(rest), " ")
+			switch strings.ToUpper(key) {
+			case "DATE":
+				sheet.Date = cueValue(value)
+			case "GENRE":
+				sheet.Genre = cueValue(value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(sheet.Tracks) == 0 {
+		return nil, errors.New("no tracks in cue sheet")
+	}
+	for i, t := range sheet.Tracks {
+		if t.Start < 0 {
+			return nil, fmt.Errorf("track %d has no INDEX 01", t.Number)
+		}
+		if i > 0 && t.Start <= sheet.Tracks[i-1].Start {
+			return nil, fmt.Errorf("track %d does not start after track %d", t.Number, sheet.Tracks[i-1].Number)
+		}
+	}
+	return sheet, nil
+}
+
+// findCueSheet returns the cue sheet next to the MP3 file at path with the
+// same base name, or "" if there is none.
+func findCueSheet(path string) string {
+	name := strings.TrimSuffix(path, filepath.Ext(path)) + ".cue"
+	if _, err := os.Stat(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// readCueSheet reads and parses the cue sheet at name.
+func readCueSheet(name string) (*cueSheet, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := parseCueSheet(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return sheet, nil
+}
+
+// chapters returns a chapter for each track of the sheet, the last one ending
+// at total. Titles are written in enc.
+func (s *cueSheet) chapters(total time.Duration, enc id3v2.Encoding) []chapterFrame {
+	var chapters []chapterFrame
+	for i, t := range s.Tracks {
+		end := total
+		if i+1 < len(s.Tracks) {
+			end = s.Tracks[i+1].Start
+		}
+		title := t.Title
+		if title == "" {
+			title = fmt.Sprintf("Track %02d", t.Number)
+		}
+		chapters = append(chapters, chapterFrame{
+			ElementID:   fmt.Sprintf("ch%d", i+1),
+			Start:       t.Start,
+			End:         end,
+			StartOffset: id3v2.IgnoredOffset,
+			EndOffset:   id3v2.IgnoredOffset,
+			Sub:         []subFrame{{"TIT2", id3v2.TextFrame{Encoding: enc, Text: title}}},
+		})
+	}
+	return chapters
+}
+
+// track returns the track of the sheet that chapters gave the element ID id,
+// or nil if there is none.
+func (s *cueSheet) track(id string) *cueTrack {
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "ch"))
+	if err != nil || n < 1 || n > len(s.Tracks) {
+		return nil
+	}
+	return &s.Tracks[n-1]
+}
+
+// runCue implements the cue command.
+func runCue(fs *flag.FlagSet) func() error {
+	var cueFile string
+	var dryRun bool
+	fs.StringVar(&cueFile, "cue", "", "Cue sheet to read (defaults to the .cue file of the same name next to the MP3 file)")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s cue [flags] album.mp3\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		path := fs.Arg(0)
+		if cueFile == "" {
+			if cueFile = findCueSheet(path); cueFile == "" {
+				return fmt.Errorf("%s: no cue sheet found; use -cue", path)
+			}
+		}
+		sheet, err := readCueSheet(cueFile)
+		if err != nil {
+			return err
+		}
+		total, err := audioDuration(path)
+		if err != nil {
+			return err
+		}
+		if last := sheet.Tracks[len(sheet.Tracks)-1]; last.Start >= total {
+			return fmt.Errorf("%s: track %d starts at %v, after the end of %s", cueFile, last.Number, last.Start, path)
+		}
+
+		tag, err := openTag(path)
+		if err != nil {
+			return fmt.Errorf("error opening MP3 file: %w", err)
+		}
+		defer tag.Close()
+		tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+
+		before := captureFrames(tag)
+		if err := writeChapters(tag, sheet.chapters(total, tag.DefaultEncoding())); err != nil {
+			return err
+		}
+		if sheet.Title != "" {
+			tag.SetAlbum(sheet.Title)
+		}
+		if sheet.Performer != "" {
+			tag.SetArtist(sheet.Performer)
+		}
+		if sheet.Date != "" {
+			tag.SetYear(sheet.Date)
+		}
+		if sheet.Genre != "" {
+			tag.SetGenre(sheet.Genre)
+		}
+		if err := keepProtected(tag, path); err != nil {
+			return fmt.Errorf("error checking write-protected frames: %w", err)
+		}
+		changes := diffFrames(before, captureFrames(tag))
+		if dryRun {
+			fmt.Printf("==> %s <==\n", path)
+			printFrameDiff(os.Stdout, changes)
+			return nil
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+			return fmt.Errorf("error saving MP3 file: %w", err)
+		}
+		fmt.Printf("Wrote %d chapters from %s to %s\n", len(sheet.Tracks), cueFile, path)
+		return nil
+	}
+}
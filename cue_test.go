@@ -0,0 +1,192 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func TestParseCueTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"00:00:00", 0, true},
+		{"03:25:00", 3*time.Minute + 25*time.Second, true},
+		{"01:02:74", time.Minute + 2*time.Second + 74*time.Second/75, true},
+		{"75:00:00", 75 * time.Minute, true},
+		{"00:60:00", 0, false},
+		{"00:00:75", 0, false},
+		{"03:25", 0, false},
+		{"03:25:00:00", 0, false},
+		{"-1:00:00", 0, false},
+		{"aa:00:00", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseCueTime(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseCueTime(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCueValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`"Quoted Title"`, "Quoted Title"},
+		{`  "Padded"  `, "Padded"},
+		{`"Unterminated`, "Unterminated"},
+		{`Bare words`, "Bare words"},
+		{`""`, ""},
+	}
+	for _, tt := range tests {
+		if got := cueValue(tt.in); got != tt.want {
+			t.Errorf("cueValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseCueSheet(t *testing.T) {
+	tests := []struct {
+		name  string
+		sheet string
+		want  *cueSheet
+		err   string
+	}{
+		{
+			name: "album",
+			sheet: "\xef\xbb\xbfREM GENRE \"Jazz\"\r\n" +
+				"REM DATE 1959\r\n" +
+				"PERFORMER \"Miles Davis\"\r\n" +
+				"TITLE \"Kind of Blue\"\r\n" +
+				"FILE \"Kind of Blue.mp3\" MP3\r\n" +
+				"  TRACK 01 AUDIO\r\n" +
+				"    TITLE \"So What\"\r\n" +
+				"    INDEX 01 00:00:00\r\n" +
+				"  TRACK 02 AUDIO\r\n" +
+				"    TITLE \"Freddie Freeloader\"\r\n" +
+				"    PERFORMER \"Miles Davis Sextet\"\r\n" +
+				"    INDEX 00 09:20:10\r\n" +
+				"    INDEX 01 09:22:37\r\n",
+			want: &cueSheet{
+				Performer: "Miles Davis",
+				Title:     "Kind of Blue",
+				Date:      "1959",
+				Genre:     "Jazz",
+				Tracks: []cueTrack{
+					{Number: 1, Title: "So What"},
+					{Number: 2, Title: "Freddie Freeloader", Performer: "Miles Davis Sextet", Start: 9*time.Minute + 22*time.Second + 37*time.Second/75},
+				},
+			},
+		},
+		{
+			name: "missing FILE",
+			sheet: "TITLE Mix\n" +
+				"TRACK 1 AUDIO\n" +
+				"INDEX 01 00:00:00\n" +
+				"TRACK 2 AUDIO\n" +
+				"TITLE \"Second\"\n" +
+				"INDEX 01 01:00:00\n",
+			want: &cueSheet{
+				Title: "Mix",
+				Tracks: []cueTrack{
+					{Number: 1},
+					{Number: 2, Title: "Second", Start: time.Minute},
+				},
+			},
+		},
+		{
+			name:  "Windows-1252",
+			sheet: "FILE \"a.mp3\" MP3\nTRACK 01 AUDIO\nTITLE \"Caf\xe9\"\nINDEX 01 00:00:00\n",
+			want:  &cueSheet{Tracks: []cueTrack{{Number: 1, Title: "Café"}}},
+		},
+		{
+			name:  "two FILEs",
+			sheet: "FILE \"a.mp3\" MP3\nTRACK 01 AUDIO\nINDEX 01 00:00:00\nFILE \"b.mp3\" MP3\n",
+			err:   errCueFiles.Error(),
+		},
+		{
+			name:  "no tracks",
+			sheet: "FILE \"a.mp3\" MP3\n",
+			err:   "no tracks",
+		},
+		{
+			name:  "no INDEX 01",
+			sheet: "TRACK 01 AUDIO\nINDEX 00 00:00:00\n",
+			err:   "track 1 has no INDEX 01",
+		},
+		{
+			name:  "bad INDEX time",
+			sheet: "TRACK 01 AUDIO\nINDEX 01 00:00:99\n",
+			err:   "line 2: invalid time",
+		},
+		{
+			name:  "bad track number",
+			sheet: "TRACK one AUDIO\n",
+			err:   "line 1: invalid track number",
+		},
+		{
+			name:  "tracks out of order",
+			sheet: "TRACK 01 AUDIO\nINDEX 01 02:00:00\nTRACK 02 AUDIO\nINDEX 01 01:00:00\n",
+			err:   "track 2 does not start after track 1",
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseCueSheet([]byte(tt.sheet))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCueChapters(t *testing.T) {
+	sheet := &cueSheet{Tracks: []cueTrack{
+		{Number: 1, Title: "Opening"},
+		{Number: 2, Start: 3 * time.Minute},
+		{Number: 3, Title: "Closing", Start: 7 * time.Minute},
+	}}
+	chapters := sheet.chapters(10*time.Minute, id3v2.EncodingUTF8)
+	want := []struct {
+		id, title  string
+		start, end time.Duration
+	}{
+		{"ch1", "Opening", 0, 3 * time.Minute},
+		{"ch2", "Track 02", 3 * time.Minute, 7 * time.Minute},
+		{"ch3", "Closing", 7 * time.Minute, 10 * time.Minute},
+	}
+	if len(chapters) != len(want) {
+		t.Fatalf("%d chapters, want %d", len(chapters), len(want))
+	}
+	for i, w := range want {
+		cf := chapters[i]
+		if cf.ElementID != w.id || cf.title() != w.title || cf.Start != w.start || cf.End != w.end {
+			t.Errorf("chapter %d = %s %q %v-%v, want %s %q %v-%v", i, cf.ElementID, cf.title(), cf.Start, cf.End, w.id, w.title, w.start, w.end)
+		}
+		if tr := sheet.track(cf.ElementID); tr != &sheet.Tracks[i] {
+			t.Errorf("track(%q) = %v, want track %d", cf.ElementID, tr, i+1)
+		}
+	}
+	if err := checkChapters(chapters, 10*time.Minute); err != nil {
+		t.Error(err)
+	}
+	for _, id := range []string{"ch0", "ch4", "chapter", ""} {
+		if tr := sheet.track(id); tr != nil {
+			t.Errorf("track(%q) = %+v, want nil", id, tr)
+		}
+	}
+}
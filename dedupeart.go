@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"math/bits"
+	"os"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "dedupe-art",
+		usage: "Remove pictures embedded more than once in MP3 files, keeping the best copy",
+		run:   runDedupeArt,
+	})
+}
+
+// dedupeOptions configures the dedupe-art command.
+type dedupeOptions struct {
+	exact    bool // only byte-identical pictures are duplicates
+	distance int  // largest number of differing bits of similar pictures' hashes
+	dryRun   bool
+}
+
+// maxLumaDifference is the largest difference in average brightness, out of
+// 255, between copies of a picture.
+const maxLumaDifference = 16
+
+// embeddedPicture is a picture of a tag with what dedupe-art compares it by.
+type embeddedPicture struct {
+	frame  id3v2.Framer // as in the tag, possibly a duplicateFrame
+	pic    id3v2.PictureFrame
+	pixels int    // width times height, 0 if the picture cannot be decoded
+	hash   uint64 // perceptual hash, valid if hashed is set
+	luma   int    // average brightness, valid if hashed is set
+	hashed bool
+	aspect float64 // width divided by height
+}
+
+// newEmbeddedPicture decodes pic, the picture of frame f, for comparison.
+// Pictures that cannot be decoded, or are too large to decode within
+// -max-memory, are only compared byte by byte.
+func newEmbeddedPicture(f id3v2.Framer, pic id3v2.PictureFrame, exact bool) *embeddedPicture {
+	p := &embeddedPicture{frame: f, pic: pic}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(pic.Picture))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return p
+	}
+	p.pixels = cfg.Width * cfg.Height
+	p.aspect = float64(cfg.Width) / float64(cfg.Height)
+	if exact {
+		return p
+	}
+	if err := checkDecodedSize(cfg); err != nil {
+		debugf("comparing a %s picture byte by byte: %v", pictureTypeName(pic.PictureType), err)
+		return p
+	}
+	img, _, err := image.Decode(bytes.NewReader(pic.Picture))
+	if err != nil {
+		return p
+	}
+	p.hash, p.luma = differenceHash(img)
+	p.hashed = true
+	return p
+}
+
+// differenceHash returns the difference hash (dHash) of img: the image is
+// scaled down to 9×8 gray pixels, and each bit tells whether a pixel is
+// brighter than its right neighbour. Re-encoded, rescaled or slightly
+// recompressed copies of a picture have hashes that differ in few bits. The
+// average brightness of the gray pixels is returned as well, as plain
+// pictures all hash to 0.
+func differenceHash(img image.Image) (hash uint64, luma int) {
+	small := downscale(flatten(img), 9, 8)
+	for y := 0; y < 8; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray(row[x*4:]) > gray(row[(x+1)*4:]) {
+				hash |= 1
+			}
+		}
+		for x := 0; x < 9; x++ {
+			luma += gray(row[x*4:])
+		}
+	}
+	return hash, luma / (9 * 8)
+}
+
+// gray returns the luma of the RGB pixel p.
+func gray(p []byte) int {
+	return (299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])) / 1000
+}
+
+// sameAs reports whether p and q show the same picture: their bytes are
+// identical or, unless opts.exact is set, they have the same proportions and
+// brightness and their hashes differ in at most opts.distance bits.
+func (p *embeddedPicture) sameAs(q *embeddedPicture, opts *dedupeOptions) bool {
+	if bytes.Equal(p.pic.Picture, q.pic.Picture) {
+		return true
+	}
+	if opts.exact || !p.hashed || !q.hashed {
+		return false
+	}
+	// Covers cropped to another shape are different pictures, however alike
+	// their hashes.
+	if ratio := p.aspect / q.aspect; ratio < 0.95 || ratio > 1/0.95 {
+		return false
+	}
+	if d := p.luma - q.luma; d < -maxLumaDifference || d > maxLumaDifference {
+		return false
+	}
+	return bits.OnesCount64(p.hash^q.hash) <= opts.distance
+}
+
+// better reports whether p is a better copy to keep than q: it is larger, or
+// as large and takes more bytes, being less compressed.
+func (p *embeddedPicture) better(q *embeddedPicture) bool {
+	if p.pixels != q.pixels {
+		return p.pixels > q.pixels
+	}
+	return len(p.pic.Picture) > len(q.pic.Picture)
+}
+
+// dedupePictures removes the pictures of tag that show the same as another
+// one, including duplicate frames the id3v2 library would have merged. Of each
+// group of copies the largest is kept, in the place of the first copy, and
+// becomes the front cover if any of the copies was one. It returns the number
+// of pictures removed.
+func dedupePictures(tag *id3v2.Tag, opts *dedupeOptions) int {
+	id := tag.CommonID("Attached picture")
+	frames := append([]id3v2.Framer(nil), tag.GetFrames(id)...)
+	var groups [][]*embeddedPicture
+	var order []id3v2.Framer // frames in their order, nil in place of each group
+	for _, f := range frames {
+		pic, ok := plainFrame(f).(id3v2.PictureFrame)
+		if !ok {
+			order = append(order, f)
+			continue
+		}
+		p := newEmbeddedPicture(f, pic, opts.exact)
+		found := false
+		for i, g := range groups {
+			for _, q := range g {
+				if p.sameAs(q, opts) {
+					groups[i], found = append(g, p), true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, []*embeddedPicture{p})
+			order = append(order, nil)
+		}
+	}
+	removed := len(frames) - len(order)
+	if removed == 0 {
+		return 0
+	}
+
+	// Pick the copy to keep of each group first, so that a copy made the front
+	// cover can be checked against all frames that stay.
+	kept := make([]id3v2.Framer, len(order))
+	var promoted []int
+	next := 0
+	for i, f := range order {
+		if f != nil {
+			kept[i] = f
+			continue
+		}
+		g := groups[next]
+		next++
+		keep := g[0]
+		front := false
+		for _, p := range g {
+			if p.better(keep) {
+				keep = p
+			}
+			front = front || p.pic.PictureType == id3v2.PTFrontCover
+		}
+		kept[i] = keep.frame
+		if front && keep.pic.PictureType != id3v2.PTFrontCover {
+			pic := keep.pic
+			// Descriptions written by placePicture follow the type.
+			if int(pic.PictureType) < len(pictureTypes) && pic.Description == pictureTypes[pic.PictureType].desc {
+				pic.Description = pictureTypes[id3v2.PTFrontCover].desc
+			}
+			pic.PictureType = id3v2.PTFrontCover
+			kept[i] = pic
+			promoted = append(promoted, i)
+		}
+	}
+	// The library replaces a picture with the same type and description, so a
+	// copy made the front cover must not take the place of another one.
+	for _, i := range promoted {
+		pic := kept[i].(id3v2.PictureFrame)
+		desc := pic.Description
+		for n := 2; clashes(kept, i, pic); n++ {
+			pic.Description = fmt.Sprintf("%s (%d)", desc, n)
+		}
+		kept[i] = pic
+	}
+
+	tag.DeleteFrames(id)
+	for _, f := range kept {
+		tag.AddFrame(id, f)
+	}
+	return removed
+}
+
+// clashes reports whether f has the identity of any frame of frames but the
+// i-th.
+func clashes(frames []id3v2.Framer, i int, f id3v2.Framer) bool {
+	for j, g := range frames {
+		if j != i && g.UniqueIdentifier() == f.UniqueIdentifier() {
+			return true
+		}
+	}
+	return false
+}
+
+// runDedupeArt implements the dedupe-art command.
+func runDedupeArt(fs *flag.FlagSet) func() error {
+	opts := &dedupeOptions{}
+	fs.BoolVar(&opts.exact, "exact", false, "Only remove byte-identical pictures, not re-encoded or resized copies")
+	fs.IntVar(&opts.distance, "distance", 4, "Largest difference between the perceptual hashes of copies, in bits out of 64")
+	fs.BoolVar(&opts.dryRun, "dryrun", false, "Show the changes without modifying the files")
+	manifest := manifestFlag(fs)
+	setupMemory := memoryFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s dedupe-art [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if opts.distance < 0 || opts.distance > 64 {
+			return fmt.Errorf("invalid -distance: %d", opts.distance)
+		}
+		if err := setupMemory(); err != nil {
+			return err
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		failed := 0
+		var done []string
+		for _, name := range files {
+			if err := dedupeFile(name, opts); err != nil {
+				log.Printf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			done = append(done, name)
+		}
+		if !opts.dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
+
+// dedupeFile removes duplicate pictures from the MP3 file at path.
+func dedupeFile(path string, opts *dedupeOptions) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+
+	before := captureFrames(tag)
+	removed := dedupePictures(tag, opts)
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	changes := diffFrames(before, captureFrames(tag))
+	if opts.dryRun {
+		fmt.Printf("==> %s <==\n", path)
+		printFrameDiff(os.Stdout, changes)
+		return nil
+	}
+	if len(changes) == 0 {
+		fmt.Println("No duplicate pictures in", path)
+		return nil
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	fmt.Printf("Removed %d duplicate pictures from %s\n", removed, path)
+	return nil
+}
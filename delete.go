@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "delete",
+		usage: "Delete frames by ID from MP3 files",
+		run:   runDelete,
+	})
+}
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// frameDescription returns the description and language of frames that have
+// them. ok is false for frames without a description.
+func frameDescription(f id3v2.Framer) (desc, lang string, ok bool) {
+	switch f := f.(type) {
+	case id3v2.CommentFrame:
+		return f.Description, f.Language, true
+	case id3v2.UnsynchronisedLyricsFrame:
+		return f.ContentDescriptor, f.Language, true
+	case id3v2.PictureFrame:
+		return f.Description, "", true
+	case id3v2.UserDefinedTextFrame:
+		return f.Description, "", true
+	case userURLFrame:
+		return f.Description, "", true
+	}
+	return "", "", false
+}
+
+// frameFilter selects frames to delete.
+type frameFilter struct {
+	ids  []string
+	desc string // if set, only frames with this description
+	lang string // if set, only frames in this language
+
+	userText []string // descriptions of TXXX frames to delete besides ids
+}
+
+// match reports whether f is selected.
+func (ff *frameFilter) match(f id3v2.Framer) bool {
+	if ff.desc == "" && ff.lang == "" {
+		return true
+	}
+	desc, lang, ok := frameDescription(f)
+	if !ok {
+		return false
+	}
+	if ff.desc != "" && desc != ff.desc {
+		return false
+	}
+	if ff.lang != "" && !strings.EqualFold(lang, ff.lang) {
+		return false
+	}
+	return true
+}
+
+// deleteFrames removes the frames of tag selected by ff.
+func deleteFrames(tag *id3v2.Tag, ff *frameFilter) {
+	for _, id := range ff.ids {
+		deleteFramesFunc(tag, id, func(_ int, f id3v2.Framer) bool {
+			return ff.match(f)
+		})
+	}
+	for _, desc := range ff.userText {
+		setUserText(tag, desc, "")
+	}
+}
+
+// runDelete implements the delete command.
+func runDelete(fs *flag.FlagSet) func() error {
+	var ids stringList
+	ff := &frameFilter{}
+	var dryRun bool
+	fs.Var(&ids, "frame", "ID of the frames to delete, e.g. USLT (may be repeated)")
+	fs.Var((*stringList)(&ff.userText), "txxx", "Description of a user-defined text frame (TXXX) to delete, e.g. \"MusicBrainz Album Id\" (may be repeated)")
+	fs.StringVar(&ff.desc, "desc", "", "Only delete frames with this description")
+	fs.StringVar(&ff.lang, "lang", "", "Only delete frames in this language (e.g., jpn, eng)")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the files")
+	manifest := manifestFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s delete -frame ID|-txxx DESCRIPTION [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if len(ids)+len(ff.userText) == 0 || fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		for _, id := range ids {
+			if len(id) != 4 {
+				return fmt.Errorf("invalid frame ID: %s", id)
+			}
+			ff.ids = append(ff.ids, strings.ToUpper(id))
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		failed := 0
+		var done []string
+		for _, name := range files {
+			if err := deleteFile(name, ff, dryRun); err != nil {
+				log.Printf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			done = append(done, name)
+		}
+		if !dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
+
+// deleteFile removes the frames selected by ff from the MP3 file at path.
+func deleteFile(path string, ff *frameFilter, dryRun bool) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+
+	before := captureFrames(tag)
+	deleteFrames(tag, ff)
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	changes := diffFrames(before, captureFrames(tag))
+	if dryRun {
+		fmt.Printf("==> %s <==\n", path)
+		printFrameDiff(os.Stdout, changes)
+		return nil
+	}
+	if len(changes) == 0 {
+		fmt.Println("Nothing to delete in", path)
+		return nil
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	fmt.Printf("Deleted %d frames from %s\n", len(changes), path)
+	return nil
+}
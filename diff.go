@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "diff",
+		usage: "Compare the tags of two MP3 files frame by frame",
+		run:   runDiff,
+	})
+}
+
+// frameState is a frame of a tag captured at a point in time.
+type frameState struct {
+	ID      string
+	Key     string // identifies the frame among frames with the same ID
+	Summary string
+	Data    []byte // encoded frame body
+}
+
+// frameKey returns the identity of a frame used to match frames between two tags.
+// Frames of unknown type have no stable identity, so their position is used.
+func frameKey(id string, f id3v2.Framer, index int) string {
+	switch f.(type) {
+	case id3v2.UnknownFrame, duplicateFrame:
+		return id + "#" + strconv.Itoa(index)
+	}
+	return id + ":" + f.UniqueIdentifier()
+}
+
+// captureFrames records the current frames of tag, sorted by key.
+func captureFrames(tag *id3v2.Tag) []frameState {
+	var states []frameState
+	for id, frames := range tag.AllFrames() {
+		for i, f := range frames {
+			var buf bytes.Buffer
+			f.WriteTo(&buf)
+			states = append(states, frameState{
+				ID:      id,
+				Key:     frameKey(id, f, i),
+				Summary: frameSummary(f),
+				Data:    buf.Bytes(),
+			})
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Key < states[j].Key
+	})
+	return states
+}
+
+// frameChange describes how a frame differs between two tags.
+type frameChange struct {
+	Op     byte // '+' added, '-' deleted, '~' replaced
+	Before *frameState
+	After  *frameState
+}
+
+// diffFrames compares two captured tags and returns the frames that were added,
+// deleted or replaced, in key order.
+func diffFrames(before, after []frameState) []frameChange {
+	var changes []frameChange
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case j >= len(after) || (i < len(before) && before[i].Key < after[j].Key):
+			changes = append(changes, frameChange{Op: '-', Before: &before[i]})
+			i++
+		case i >= len(before) || after[j].Key < before[i].Key:
+			changes = append(changes, frameChange{Op: '+', After: &after[j]})
+			j++
+		default:
+			if !bytes.Equal(before[i].Data, after[j].Data) {
+				changes = append(changes, frameChange{Op: '~', Before: &before[i], After: &after[j]})
+			}
+			i++
+			j++
+		}
+	}
+	return changes
+}
+
+// printFrameDiff prints changes one per line along with the frame sizes.
+func printFrameDiff(w io.Writer, changes []frameChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+	for _, c := range changes {
+		switch c.Op {
+		case '+':
+			fmt.Fprintf(w, "+ %s: %s (%d bytes)\n", c.After.ID, c.After.Summary, len(c.After.Data))
+		case '-':
+			fmt.Fprintf(w, "- %s: %s (%d bytes)\n", c.Before.ID, c.Before.Summary, len(c.Before.Data))
+		case '~':
+			fmt.Fprintf(w, "~ %s: %s (%d -> %d bytes)\n", c.After.ID, c.After.Summary, len(c.Before.Data), len(c.After.Data))
+		}
+	}
+}
+
+// runDiff implements the diff command.
+func runDiff(fs *flag.FlagSet) func() error {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff a.mp3 b.mp3\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		a, b := fs.Arg(0), fs.Arg(1)
+		tagA, err := openTag(a)
+		if err != nil {
+			return fmt.Errorf("%s: error opening MP3 file: %w", a, err)
+		}
+		defer tagA.Close()
+		tagB, err := openTag(b)
+		if err != nil {
+			return fmt.Errorf("%s: error opening MP3 file: %w", b, err)
+		}
+		defer tagB.Close()
+
+		fmt.Printf("--- %s (ID3v2.%d)\n+++ %s (ID3v2.%d)\n", a, tagA.Version(), b, tagB.Version())
+		// Tags of different versions are compared as the version of a, so that
+		// e.g. TYER and TDRC count as the same frame. Neither is saved.
+		if v := tagA.Version(); tagB.Version() != v {
+			convertTag(tagB, v)
+		}
+		// Text encodings are a matter of the tool that wrote the tag, not of its
+		// content.
+		unifyEncodings(tagA)
+		unifyEncodings(tagB)
+		printTagDiff(os.Stdout, a, b, tagA, tagB)
+		return nil
+	}
+}
+
+// printTagDiff prints the frames of tag b that were added, removed or
+// changed compared to tag a, with the dimensions and digests of pictures.
+func printTagDiff(w io.Writer, a, b string, tagA, tagB *id3v2.Tag) {
+	changes := diffFrames(captureFrames(tagA), captureFrames(tagB))
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+	detailsA, detailsB := frameDetails(tagA), frameDetails(tagB)
+	for _, c := range changes {
+		switch c.Op {
+		case '+':
+			fmt.Fprintf(w, "+ %s: %s\n", c.After.ID, detailsB[c.After.Key])
+		case '-':
+			fmt.Fprintf(w, "- %s: %s\n", c.Before.ID, detailsA[c.Before.Key])
+		case '~':
+			fmt.Fprintf(w, "~ %s\n    %s: %s\n    %s: %s\n", c.After.ID, a, detailsA[c.Before.Key], b, detailsB[c.After.Key])
+		}
+	}
+	fmt.Fprintf(w, "%d frames differ\n", len(changes))
+}
+
+// frameDetails returns the summaries of the frames of tag by their keys, see
+// captureFrames. Pictures are described by their dimensions, format, size and
+// the start of their SHA-256 digest, which tells re-encoded covers apart.
+func frameDetails(tag *id3v2.Tag) map[string]string {
+	details := map[string]string{}
+	for id, frames := range tag.AllFrames() {
+		for i, f := range frames {
+			s := frameSummary(f)
+			if pic, ok := f.(id3v2.PictureFrame); ok {
+				sum := sha256.Sum256(pic.Picture)
+				s += fmt.Sprintf(", %s, sha256 %s", describeImage(pic.Picture), hex.EncodeToString(sum[:6]))
+			}
+			details[frameKey(id, f, i)] = s
+		}
+	}
+	return details
+}
+
+// unifyEncodings rewrites the frames of tag with text in UTF-8, leaving the
+// text itself alone.
+func unifyEncodings(tag *id3v2.Tag) {
+	all := tag.AllFrames()
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		frames := tag.GetFrames(id)
+		conv := make([]id3v2.Framer, len(frames))
+		for i, f := range frames {
+			df, dup := f.(duplicateFrame)
+			f = plainFrame(f)
+			switch t := f.(type) {
+			case id3v2.TextFrame:
+				t.Encoding = id3v2.EncodingUTF8
+				f = t
+			case id3v2.CommentFrame:
+				t.Encoding = id3v2.EncodingUTF8
+				f = t
+			case id3v2.UnsynchronisedLyricsFrame:
+				t.Encoding = id3v2.EncodingUTF8
+				f = t
+			case id3v2.UserDefinedTextFrame:
+				t.Encoding = id3v2.EncodingUTF8
+				f = t
+			case id3v2.PictureFrame:
+				t.Encoding = id3v2.EncodingUTF8
+				f = t
+			}
+			if dup {
+				df.Framer = f
+				f = df
+			}
+			conv[i] = f
+		}
+		tag.DeleteFrames(id)
+		for _, f := range conv {
+			tag.AddFrame(id, f)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "dupes",
+		usage: "Find likely duplicate tracks by artist, title and duration, or by audio fingerprint",
+		run:   runDupes,
+	})
+}
+
+// dupeFingerprintSimilarity is the fingerprintSimilarity from which two files
+// count as the same recording.
+const dupeFingerprintSimilarity = 0.85
+
+// dupeFile is what dupes compares of an MP3 file.
+type dupeFile struct {
+	path          string
+	artist, title string
+	key           string // normalized artist and title, empty if either is missing
+	duration      time.Duration
+	fingerprint   []uint32
+}
+
+// dupeKey returns the normalized form of artist and title under which likely
+// duplicates are grouped: case, punctuation and spacing are ignored.
+func dupeKey(artist, title string) string {
+	a, t := matchWords(artist), matchWords(title)
+	if len(a) == 0 || len(t) == 0 {
+		return ""
+	}
+	return strings.Join(a, " ") + "\x00" + strings.Join(t, " ")
+}
+
+// readDupeFile reads what dupes needs of the MP3 file at path.
+func readDupeFile(path string) (*dupeFile, error) {
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	d, err := audioDuration(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &dupeFile{path: path, artist: tag.Artist(), title: tag.Title(), duration: d}
+	if !isPlaceholder(f.artist) && !isPlaceholder(f.title) {
+		f.key = dupeKey(f.artist, f.title)
+	}
+	return f, nil
+}
+
+// groupDupes returns the groups of files that are likely the same track: same
+// key, or with fingerprints similar enough, and durations no more than tolerance
+// apart. Each group is sorted by path.
+func groupDupes(files []*dupeFile, tolerance time.Duration) [][]*dupeFile {
+	slices.SortFunc(files, func(a, b *dupeFile) int {
+		return cmp.Compare(a.duration, b.duration)
+	})
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i, a := range files {
+		for j := i + 1; j < len(files) && files[j].duration-a.duration <= tolerance; j++ {
+			b := files[j]
+			same := a.key != "" && a.key == b.key
+			if !same && a.fingerprint != nil && b.fingerprint != nil {
+				same = fingerprintSimilarity(a.fingerprint, b.fingerprint) >= dupeFingerprintSimilarity
+			}
+			if same {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	byRoot := map[int][]*dupeFile{}
+	for i, f := range files {
+		byRoot[find(i)] = append(byRoot[find(i)], f)
+	}
+	var groups [][]*dupeFile
+	for _, g := range byRoot {
+		if len(g) < 2 {
+			continue
+		}
+		slices.SortFunc(g, func(a, b *dupeFile) int { return strings.Compare(a.path, b.path) })
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b []*dupeFile) int { return strings.Compare(a[0].path, b[0].path) })
+	return groups
+}
+
+// runDupes implements the dupes command.
+func runDupes(fs *flag.FlagSet) func() error {
+	var tolerance time.Duration
+	var fingerprint bool
+	fs.DurationVar(&tolerance, "duration", 3*time.Second, "Largest difference in duration between duplicates")
+	fs.BoolVar(&fingerprint, "fingerprint", false, "Also compare the audio fingerprints, to find duplicates with different or missing tags (requires fpcalc)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s dupes [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if tolerance < 0 {
+			return fmt.Errorf("invalid duration: %v", tolerance)
+		}
+
+		paths, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		var files []*dupeFile
+		failed := 0
+		for _, path := range paths {
+			f, err := readDupeFile(path)
+			if err == nil && fingerprint {
+				f.fingerprint, err = rawFingerprint(path)
+			}
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			files = append(files, f)
+		}
+
+		groups := groupDupes(files, tolerance)
+		n := 0
+		for i, g := range groups {
+			if i > 0 {
+				fmt.Println()
+			}
+			for _, f := range g {
+				fmt.Printf("%s  %s  %s - %s\n", f.path, f.duration.Round(time.Second), f.artist, f.title)
+			}
+			n += len(g)
+		}
+		if len(groups) > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Found %d groups of likely duplicates with %d files\n", len(groups), n)
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(paths), code: exitFile}
+		}
+		return nil
+	}
+}
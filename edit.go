@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "edit",
+		usage: "Edit the tags of an MP3 file in a full-screen terminal editor",
+		run:   runEdit,
+	})
+}
+
+// editItem is a row of the editor: a frame of the tag, or an empty slot for a
+// common text field that is not set yet.
+type editItem struct {
+	id    string
+	label string
+	index int // position among the frames with the same ID
+	frame id3v2.Framer
+}
+
+// editor holds the state of an interactive editing session.
+type editor struct {
+	path  string
+	lang  string
+	tag   *id3v2.Tag
+	saved []frameState
+	msg   string
+}
+
+// editFields lists the text fields always shown by the editor, in display order.
+func editFields(tag *id3v2.Tag) [][2]string {
+	return [][2]string{
+		{tag.CommonID("Title"), "Title"},
+		{tag.CommonID("Artist"), "Artist"},
+		{tag.CommonID("Album/Movie/Show title"), "Album"},
+		{tag.CommonID("Band/Orchestra/Accompaniment"), "Album Artist"},
+		{tag.CommonID("Year"), "Year"},
+		{tag.CommonID("Genre"), "Genre"},
+		{tag.CommonID("Track number/Position in set"), "Track"},
+		{tag.CommonID("Part of a set"), "Disc"},
+	}
+}
+
+// items returns the rows to display: the common text fields first, then all
+// other frames sorted by ID.
+func (e *editor) items() []editItem {
+	var items []editItem
+	seen := map[string]bool{}
+	for _, f := range editFields(e.tag) {
+		item := editItem{id: f[0], label: f[1]}
+		if frames := e.tag.GetFrames(f[0]); len(frames) > 0 {
+			item.frame = frames[0]
+		}
+		items = append(items, item)
+		seen[f[0]] = true
+	}
+
+	all := e.tag.AllFrames()
+	var ids []string
+	for id := range all {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		for i, f := range all[id] {
+			items = append(items, editItem{id: id, index: i, frame: f})
+		}
+	}
+	return items
+}
+
+// modified reports whether the tag differs from what was last saved.
+func (e *editor) modified() bool {
+	return len(diffFrames(e.saved, captureFrames(e.tag))) > 0
+}
+
+// draw clears the screen and shows all rows along with the available commands.
+func (e *editor) draw(items []editItem) {
+	if isTerminal(os.Stdout) {
+		fmt.Print("\x1b[H\x1b[2J")
+	}
+	state := ""
+	if e.modified() {
+		state = " (modified)"
+	}
+	fmt.Printf("mp3extra edit: %s%s\n\n", e.path, state)
+	for i, item := range items {
+		value := ""
+		if item.frame != nil {
+			value = frameSummary(item.frame)
+		}
+		fmt.Printf("%3d  %-4s  %-12s  %s\n", i+1, item.id, item.label, value)
+	}
+	fmt.Println()
+	fmt.Println("<n> edit  d <n> delete  t <ID> set text frame  l lyrics  p picture")
+	fmt.Println("a fetch art  f fetch lyrics  w write  q quit")
+	if e.msg != "" {
+		fmt.Println()
+		fmt.Println(e.msg)
+		e.msg = ""
+	}
+}
+
+// pause waits for the user to press enter.
+func pause() {
+	ask("\nPress enter to continue...")
+}
+
+// editText asks for a new value of the text frame id. An empty answer keeps the
+// current value and "-" removes the frame.
+func (e *editor) editText(id, current string) {
+	fmt.Printf("\n%s: %s\n", id, current)
+	v, _ := ask("New value (empty keeps, - deletes): ")
+	switch v {
+	case "":
+	case "-":
+		e.tag.DeleteFrames(id)
+	default:
+		e.tag.AddTextFrame(id, e.tag.DefaultEncoding(), v)
+	}
+}
+
+// showLyrics prints all lyrics frames of the tag.
+func (e *editor) showLyrics() {
+	frames := e.tag.GetFrames(e.tag.CommonID("Unsynchronised lyrics/text transcription"))
+	if len(frames) == 0 {
+		e.msg = "No lyrics embedded."
+		return
+	}
+	for _, f := range frames {
+		if uslt, ok := f.(id3v2.UnsynchronisedLyricsFrame); ok {
+			fmt.Printf("\n[%s %s]\n%s\n", uslt.Language, uslt.ContentDescriptor, uslt.Lyrics)
+		}
+	}
+	pause()
+}
+
+// showPicture previews the cover of the tag.
+func (e *editor) showPicture() {
+	if coverPicture(e.tag) == nil {
+		e.msg = "No picture embedded."
+		return
+	}
+	if err := previewArt(os.Stdout, e.tag, detectGraphics()); err != nil {
+		e.msg = "Error previewing cover art: " + err.Error()
+		return
+	}
+	pause()
+}
+
+// fetchArt looks up the cover art of the tag and embeds it.
+func (e *editor) fetchArt() {
+	fmt.Println("Fetching cover art...")
+	b, ct, err := loadImage("auto", e.tag)
+	if err != nil {
+		e.msg = err.Error()
+		return
+	}
+	setCover(e.tag, b, ct)
+	e.msg = "Cover art fetched."
+}
+
+// fetchLyrics looks up the lyrics of the tag and embeds them.
+func (e *editor) fetchLyrics() {
+	fmt.Println("Fetching lyrics...")
+	lyrics, _, err := loadLyrics("auto", e.path, e.tag)
+	if err != nil {
+		e.msg = err.Error()
+		return
+	}
+	setLyrics(e.tag, lyrics, e.lang, "")
+	e.msg = "Lyrics fetched."
+}
+
+// runPrompt is the editor for input that is not a terminal, such as a script:
+// it shows the rows and reads commands line by line. It returns when the user
+// quits or the input ends.
+func (e *editor) runPrompt() error {
+	for {
+		items := e.items()
+		e.draw(items)
+		line, err := ask("> ")
+		if errors.Is(err, io.EOF) {
+			if e.modified() {
+				return errors.New("input ended with unsaved changes, which were not written")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		if n, err := strconv.Atoi(cmd); err == nil {
+			if n < 1 || n > len(items) {
+				e.msg = "No such row."
+				continue
+			}
+			item := items[n-1]
+			switch f := item.frame.(type) {
+			case nil:
+				e.editText(item.id, "")
+			case id3v2.TextFrame:
+				e.editText(item.id, f.Text)
+			case id3v2.UnsynchronisedLyricsFrame:
+				e.showLyrics()
+			case id3v2.PictureFrame:
+				e.showPicture()
+			default:
+				e.msg = "Frames of this type can only be deleted."
+			}
+			continue
+		}
+
+		switch cmd {
+		case "d":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > len(items) || items[n-1].frame == nil {
+				e.msg = "No such frame."
+				continue
+			}
+			deleteFrameAt(e.tag, items[n-1].id, items[n-1].index)
+		case "t":
+			id := strings.ToUpper(arg)
+			if len(id) != 4 || id[0] != 'T' || id == "TXXX" {
+				e.msg = "Give the ID of a text frame, e.g. t TCOM."
+				continue
+			}
+			e.editText(id, e.tag.GetTextFrame(id).Text)
+		case "l":
+			e.showLyrics()
+		case "p":
+			e.showPicture()
+		case "a":
+			e.fetchArt()
+		case "f":
+			e.fetchLyrics()
+		case "w":
+			if err := saveTag(e.tag, e.path, &saveOptions{snapshot: true}); err != nil {
+				e.msg = "Error saving MP3 file: " + err.Error()
+				continue
+			}
+			e.saved = captureFrames(e.tag)
+			e.msg = "Saved."
+		case "q":
+			if e.modified() && !confirm("Discard unsaved changes?") {
+				continue
+			}
+			return nil
+		case "":
+		default:
+			e.msg = "Unknown command: " + cmd
+		}
+	}
+}
+
+// runEdit implements the edit command.
+func runEdit(fs *flag.FlagSet) func() error {
+	var lang string
+	var plain bool
+	fs.StringVar(&lang, "lang", "jpn", "Language code for fetched lyrics (e.g., jpn, eng)")
+	fs.BoolVar(&plain, "plain", false, "Read commands line by line instead of editing full-screen, as when not run in a terminal")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s edit [flags] file.mp3\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		tag, err := openTag(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("error opening MP3 file: %w", err)
+		}
+		defer tag.Close()
+		tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+
+		e := &editor{
+			path:  fs.Arg(0),
+			lang:  lang,
+			tag:   tag,
+			saved: captureFrames(tag),
+		}
+		if plain || !isTerminal(os.Stdin) || !isTerminal(os.Stdout) || os.Getenv("TERM") == "dumb" {
+			return e.runPrompt()
+		}
+		return e.runScreen()
+	}
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bogem/id3v2/v2"
+	"golang.org/x/term"
+)
+
+// tuiKey is a key read from the terminal in raw mode: a printable rune, or else
+// the name of a special key such as "up" or "enter".
+type tuiKey struct {
+	r    rune
+	name string
+}
+
+// escapeKeys names the keys sent as CSI or SS3 escape sequences, by the bytes
+// that follow ESC [ or ESC O.
+var escapeKeys = map[string]string{
+	"A": "up", "B": "down", "C": "right", "D": "left",
+	"H": "home", "F": "end", "1~": "home", "7~": "home", "4~": "end", "8~": "end",
+	"5~": "pgup", "6~": "pgdn", "3~": "delete",
+}
+
+// parseKeys splits the bytes of a read from the terminal into keys. Unknown
+// escape sequences and control characters are left out.
+func parseKeys(b []byte) []tuiKey {
+	var keys []tuiKey
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 0x1b && len(b) > 2 && (b[1] == '[' || b[1] == 'O'):
+			end := 2
+			for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+				end++
+			}
+			if end == len(b) {
+				return keys
+			}
+			if name, ok := escapeKeys[string(b[2:end+1])]; ok {
+				keys = append(keys, tuiKey{name: name})
+			}
+			b = b[end+1:]
+			continue
+		case c == 0x1b:
+			keys = append(keys, tuiKey{name: "esc"})
+		case c == '\r' || c == '\n':
+			keys = append(keys, tuiKey{name: "enter"})
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, tuiKey{name: "backspace"})
+		case c == 0x03:
+			keys = append(keys, tuiKey{name: "ctrl-c"})
+		case c == 0x15:
+			keys = append(keys, tuiKey{name: "ctrl-u"})
+		case c == 0x01:
+			keys = append(keys, tuiKey{name: "home"})
+		case c == 0x05:
+			keys = append(keys, tuiKey{name: "end"})
+		case c < 0x20:
+		default:
+			r, n := utf8.DecodeRune(b)
+			keys = append(keys, tuiKey{r: r})
+			b = b[n:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// tuiScreen is the terminal in raw mode, showing the alternate screen.
+type tuiScreen struct {
+	fd  int
+	old *term.State
+}
+
+// openScreen switches the terminal to raw mode and the alternate screen.
+func openScreen() (*tuiScreen, error) {
+	s := &tuiScreen{fd: int(os.Stdin.Fd())}
+	if err := s.resume(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// resume enters raw mode and the alternate screen, with the cursor hidden.
+func (s *tuiScreen) resume() error {
+	old, err := term.MakeRaw(s.fd)
+	if err != nil {
+		return err
+	}
+	s.old = old
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	return nil
+}
+
+// close restores the terminal as it was before openScreen.
+func (s *tuiScreen) close() {
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	term.Restore(s.fd, s.old)
+}
+
+// suspend restores the terminal for fn, which may print and prompt as usual,
+// and returns to the screen afterwards.
+func (s *tuiScreen) suspend(fn func()) error {
+	s.close()
+	fn()
+	return s.resume()
+}
+
+// size returns the width and height of the terminal.
+func (s *tuiScreen) size() (w, h int) {
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || w < 20 || h < 6 {
+		return 80, 24
+	}
+	return w, h
+}
+
+// readKeys waits for input and returns the keys read.
+func (s *tuiScreen) readKeys() ([]tuiKey, error) {
+	var buf [256]byte
+	n, err := os.Stdin.Read(buf[:])
+	if err != nil {
+		return nil, err
+	}
+	return parseKeys(buf[:n]), nil
+}
+
+// draw shows lines from the top of the screen, each cut to the width w and
+// the rest of the screen cleared.
+func (s *tuiScreen) draw(lines []string, w int) {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(fitWidth(line, w))
+		b.WriteString("\x1b[0m\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	fmt.Print(b.String())
+}
+
+// fitWidth cuts the plain text of s, which may contain SGR escape sequences,
+// to w runes.
+func fitWidth(s string, w int) string {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			j := strings.IndexByte(s[i:], 'm')
+			if j < 0 {
+				break
+			}
+			b.WriteString(s[i : i+j+1])
+			i += j + 1
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if n < w {
+			b.WriteRune(r)
+		}
+		n++
+		i += size
+	}
+	return b.String()
+}
+
+// lineInput is a line of text being edited, with a cursor.
+type lineInput struct {
+	text []rune
+	pos  int
+}
+
+// newLineInput returns an input holding s, with the cursor at its end.
+func newLineInput(s string) *lineInput {
+	r := []rune(s)
+	return &lineInput{text: r, pos: len(r)}
+}
+
+// handle applies k to the input. It reports whether k ends the input, and if
+// so, whether the text was accepted rather than cancelled.
+func (in *lineInput) handle(k tuiKey) (done, ok bool) {
+	switch k.name {
+	case "enter":
+		return true, true
+	case "esc", "ctrl-c":
+		return true, false
+	case "left":
+		in.pos = max(in.pos-1, 0)
+	case "right":
+		in.pos = min(in.pos+1, len(in.text))
+	case "home":
+		in.pos = 0
+	case "end":
+		in.pos = len(in.text)
+	case "backspace":
+		if in.pos > 0 {
+			in.text = append(in.text[:in.pos-1], in.text[in.pos:]...)
+			in.pos--
+		}
+	case "delete":
+		if in.pos < len(in.text) {
+			in.text = append(in.text[:in.pos], in.text[in.pos+1:]...)
+		}
+	case "ctrl-u":
+		in.text, in.pos = nil, 0
+	case "":
+		in.text = append(in.text[:in.pos], append([]rune{k.r}, in.text[in.pos:]...)...)
+		in.pos++
+	}
+	return false, false
+}
+
+// render returns the text with the cursor shown in reverse video, scrolled so
+// that the cursor is within w runes.
+func (in *lineInput) render(w int) string {
+	start := max(in.pos-w+1, 0)
+	var b strings.Builder
+	for i := start; i <= len(in.text) && i-start < w; i++ {
+		c := " "
+		if i < len(in.text) {
+			c = string(in.text[i])
+		}
+		if i == in.pos {
+			c = "\x1b[7m" + c + "\x1b[27m"
+		}
+		b.WriteString(c)
+	}
+	return b.String()
+}
+
+// screenMode is what the full-screen editor shows.
+type screenMode int
+
+const (
+	modeList   screenMode = iota // the frames
+	modeEdit                     // a value edited in its row
+	modeNewID                    // the ID of a text frame to add
+	modeLyrics                   // the lyrics, scrolled
+	modeQuit                     // whether to discard unsaved changes
+)
+
+// screenEditor is the full-screen editor on a terminal.
+type screenEditor struct {
+	*editor
+	scr   *tuiScreen
+	mode  screenMode
+	items []editItem
+	sel   int // selected row
+	top   int // first row shown
+
+	input  *lineInput
+	editID string // text frame edited, or the TXXX description if txxx is set
+	txxx   bool   // editID is a TXXX description
+
+	lyrics    []string
+	lyricsTop int
+}
+
+// runScreen runs the editor full-screen until the user quits.
+func (e *editor) runScreen() error {
+	scr, err := openScreen()
+	if err != nil {
+		return err
+	}
+	se := &screenEditor{editor: e, scr: scr}
+	defer func() { se.scr.close() }()
+	se.refresh()
+	for {
+		se.draw()
+		keys, err := scr.readKeys()
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			quit, err := se.handle(k)
+			if err != nil || quit {
+				return err
+			}
+			if se.mode == modeList {
+				se.refresh()
+			}
+		}
+	}
+}
+
+// refresh updates the rows after the tag changed.
+func (se *screenEditor) refresh() {
+	se.items = se.editor.items()
+	se.sel = min(se.sel, len(se.items)-1)
+}
+
+// listHeight returns the number of rows shown for a screen h lines high.
+func listHeight(h int) int {
+	return h - 3
+}
+
+// draw shows the current mode on the screen.
+func (se *screenEditor) draw() {
+	w, h := se.scr.size()
+	state := ""
+	if se.modified() {
+		state = " (modified)"
+	}
+	lines := []string{"\x1b[7m" + fmt.Sprintf(" mp3extra edit: %s%s", se.path, state) + strings.Repeat(" ", w)}
+	status, help := se.msg, ""
+
+	if se.mode == modeLyrics {
+		n := listHeight(h)
+		se.lyricsTop = max(min(se.lyricsTop, len(se.lyrics)-n), 0)
+		for i := se.lyricsTop; i < len(se.lyrics) && i < se.lyricsTop+n; i++ {
+			lines = append(lines, " "+se.lyrics[i])
+		}
+		help = "↑↓ PgUp PgDn scroll  q back"
+	} else {
+		n := listHeight(h)
+		if se.sel < se.top {
+			se.top = se.sel
+		}
+		if se.sel >= se.top+n {
+			se.top = se.sel - n + 1
+		}
+		for i := se.top; i < len(se.items) && i < se.top+n; i++ {
+			item := se.items[i]
+			row := fmt.Sprintf("%3d  %-4s  %-12s  ", i+1, item.id, item.label)
+			switch {
+			case i == se.sel && se.mode == modeEdit:
+				row += se.input.render(w - utf8.RuneCountInString(row))
+			case item.frame != nil:
+				row += frameSummary(item.frame)
+			}
+			if i == se.sel && se.mode != modeEdit {
+				row = "\x1b[7m" + row + strings.Repeat(" ", w)
+			}
+			lines = append(lines, row)
+		}
+		help = "↑↓ move  enter edit  d delete  t add text frame  l lyrics  p picture  a fetch art  f fetch lyrics  w write  q quit"
+	}
+	for len(lines) < h-2 {
+		lines = append(lines, "")
+	}
+	switch se.mode {
+	case modeEdit:
+		status = "Enter keeps the new value, an empty one deletes the frame, Esc cancels"
+	case modeNewID:
+		prompt := "ID of the text frame to set, e.g. TCOM: "
+		status = prompt + se.input.render(w-len(prompt))
+	case modeQuit:
+		status = "Discard unsaved changes? [y/N]"
+	}
+	lines = append(lines, status, "\x1b[2m"+help)
+	se.scr.draw(lines, w)
+}
+
+// handle applies the key k in the current mode. It reports whether the editor
+// is done.
+func (se *screenEditor) handle(k tuiKey) (bool, error) {
+	switch se.mode {
+	case modeEdit:
+		if done, ok := se.input.handle(k); done {
+			if ok {
+				se.setValue(string(se.input.text))
+			}
+			se.mode = modeList
+		}
+		return false, nil
+	case modeNewID:
+		if done, ok := se.input.handle(k); done {
+			se.mode = modeList
+			if ok {
+				se.addText(strings.ToUpper(strings.TrimSpace(string(se.input.text))))
+			}
+		}
+		return false, nil
+	case modeLyrics:
+		_, h := se.scr.size()
+		switch {
+		case k.name == "up" || k.r == 'k':
+			se.lyricsTop--
+		case k.name == "down" || k.r == 'j':
+			se.lyricsTop++
+		case k.name == "pgup":
+			se.lyricsTop -= listHeight(h)
+		case k.name == "pgdn" || k.r == ' ':
+			se.lyricsTop += listHeight(h)
+		case k.name == "esc" || k.name == "enter" || k.r == 'q' || k.r == 'l':
+			se.mode = modeList
+		}
+		se.lyricsTop = max(se.lyricsTop, 0)
+		return false, nil
+	case modeQuit:
+		se.mode = modeList
+		return k.r == 'y' || k.r == 'Y', nil
+	}
+
+	_, h := se.scr.size()
+	se.msg = ""
+	switch {
+	case k.name == "up" || k.r == 'k':
+		se.sel = max(se.sel-1, 0)
+	case k.name == "down" || k.r == 'j':
+		se.sel = min(se.sel+1, len(se.items)-1)
+	case k.name == "pgup":
+		se.sel = max(se.sel-listHeight(h), 0)
+	case k.name == "pgdn":
+		se.sel = min(se.sel+listHeight(h), len(se.items)-1)
+	case k.name == "home" || k.r == 'g':
+		se.sel = 0
+	case k.name == "end" || k.r == 'G':
+		se.sel = len(se.items) - 1
+	case k.name == "enter" || k.r == 'e':
+		se.open(se.items[se.sel])
+	case k.r == 'd':
+		item := se.items[se.sel]
+		if item.frame == nil {
+			se.msg = "Nothing to delete."
+			break
+		}
+		deleteFrameAt(se.tag, item.id, item.index)
+	case k.r == 't':
+		se.mode, se.input = modeNewID, newLineInput("")
+	case k.r == 'l':
+		se.openLyrics()
+	case k.r == 'p':
+		return false, se.showPicture()
+	case k.r == 'a':
+		return false, se.fetch("art")
+	case k.r == 'f':
+		return false, se.fetch("lyrics")
+	case k.r == 'w':
+		if err := saveTag(se.tag, se.path, &saveOptions{snapshot: true}); err != nil {
+			se.msg = "Error saving MP3 file: " + err.Error()
+			break
+		}
+		se.saved = captureFrames(se.tag)
+		se.msg = "Saved."
+	case k.r == 'q' || k.name == "ctrl-c":
+		if !se.modified() {
+			return true, nil
+		}
+		se.mode = modeQuit
+	}
+	return false, nil
+}
+
+// open acts on the selected row: text is edited in place, lyrics and pictures
+// are shown.
+func (se *screenEditor) open(item editItem) {
+	switch f := item.frame.(type) {
+	case nil:
+		se.startEdit(item.id, "", false)
+	case id3v2.TextFrame:
+		se.startEdit(item.id, f.Text, false)
+	case id3v2.UserDefinedTextFrame:
+		se.startEdit(f.Description, f.Value, true)
+	case id3v2.UnsynchronisedLyricsFrame:
+		se.openLyrics()
+	case id3v2.PictureFrame:
+		se.showPicture()
+	default:
+		se.msg = "Frames of this type can only be deleted."
+	}
+}
+
+// startEdit edits the value of the text frame id, or of the TXXX frame with
+// the description id if txxx is set, in its row.
+func (se *screenEditor) startEdit(id, value string, txxx bool) {
+	se.mode, se.input = modeEdit, newLineInput(value)
+	se.editID, se.txxx = id, txxx
+}
+
+// setValue sets the frame being edited to v, or deletes it if v is empty.
+func (se *screenEditor) setValue(v string) {
+	switch {
+	case se.txxx:
+		setUserText(se.tag, se.editID, v)
+	case v == "":
+		se.tag.DeleteFrames(se.editID)
+	default:
+		se.tag.AddTextFrame(se.editID, se.tag.DefaultEncoding(), v)
+	}
+}
+
+// addText selects the row of the text frame id, adding one if needed, and
+// edits it.
+func (se *screenEditor) addText(id string) {
+	if len(id) != 4 || id[0] != 'T' || id == "TXXX" || !validFrameID(id) {
+		se.msg = "Give the ID of a text frame, e.g. TCOM."
+		return
+	}
+	row := -1
+	for i, item := range se.items {
+		if item.id == id {
+			row = i
+			break
+		}
+	}
+	if row < 0 {
+		// Until it is set, the frame is edited in a row of its own at the end.
+		se.items = append(se.items, editItem{id: id})
+		row = len(se.items) - 1
+	}
+	se.sel = row
+	se.startEdit(id, se.tag.GetTextFrame(id).Text, false)
+}
+
+// openLyrics shows the lyrics of the tag.
+func (se *screenEditor) openLyrics() {
+	se.lyrics = nil
+	for _, f := range se.tag.GetFrames(se.tag.CommonID("Unsynchronised lyrics/text transcription")) {
+		if uslt, ok := f.(id3v2.UnsynchronisedLyricsFrame); ok {
+			se.lyrics = append(se.lyrics, fmt.Sprintf("[%s %s]", uslt.Language, uslt.ContentDescriptor))
+			se.lyrics = append(se.lyrics, strings.Split(strings.ReplaceAll(uslt.Lyrics, "\r\n", "\n"), "\n")...)
+			se.lyrics = append(se.lyrics, "")
+		}
+	}
+	if len(se.lyrics) == 0 {
+		se.msg = "No lyrics embedded."
+		return
+	}
+	se.mode, se.lyricsTop = modeLyrics, 0
+}
+
+// showPicture previews the cover outside of the screen, as image protocols
+// need the terminal as it normally is.
+func (se *screenEditor) showPicture() error {
+	if coverPicture(se.tag) == nil {
+		se.msg = "No picture embedded."
+		return nil
+	}
+	return se.scr.suspend(se.editor.showPicture)
+}
+
+// fetch looks up cover art or lyrics (kind) outside of the screen, so that
+// messages of the lookup are shown as usual.
+func (se *screenEditor) fetch(kind string) error {
+	return se.scr.suspend(func() {
+		fmt.Print("\x1b[H\x1b[2J")
+		if kind == "art" {
+			se.fetchArt()
+		} else {
+			se.fetchLyrics()
+		}
+	})
+}
@@ -0,0 +1,940 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// embedOptions holds the settings of the default embed mode.
+type embedOptions struct {
+	image  string
+	lyrics lyricsSpecs
+	lang   string
+	dryRun bool
+	notify bool
+	force  bool
+
+	// interactive asks for confirmation before each file is written.
+	interactive bool
+
+	// pictureType is the APIC picture type the image is embedded as. With
+	// imageAdd, pictures of other types are kept instead of being replaced.
+	pictureType byte
+	imageAdd    bool
+
+	// imageMaxSize scales images larger than this many pixels in either
+	// direction down to fit, and imageQuality re-encodes them as JPEG with this
+	// quality. 0 leaves images as they are.
+	imageMaxSize int
+	imageQuality int
+
+	// maxArtBytes is the largest image that is embedded, if not 0. Larger ones
+	// are recompressed to fit, or rejected if maxArtReject is set.
+	maxArtBytes  int
+	maxArtReject bool
+
+	// webOptimize cuts the tag down to a few text frames and a small cover for
+	// progressive HTTP streaming.
+	webOptimize bool
+
+	// lyricsSourceFrame and artSourceFrame are the descriptions of TXXX frames
+	// recording where fetched lyrics and cover art came from, if not empty.
+	lyricsSourceFrame string
+	artSourceFrame    string
+
+	// translateTo is the language lyrics in lang are translated into by
+	// translator, if not empty.
+	translateTo string
+	translator  *translator
+
+	// hints fills in a missing artist, title and album from companion files
+	// before automatic lookups.
+	hints bool
+
+	// albumArtist is set as the album artist (TPE2) of every file before the
+	// cover art is looked up, if not empty.
+	albumArtist string
+
+	// fingerprint identifies files without any tag by their audio fingerprint.
+	fingerprint bool
+
+	// fixEncoding names the legacy encoding that ISO-8859-1 frames were really
+	// written in, if not empty.
+	fixEncoding string
+
+	// romanize writes romanized title, artist and album into the sort frames
+	// ("sort") or TXXX frames ("txxx"), if not empty.
+	romanize string
+
+	// sortTags writes the sort frames of title, artist, album and album
+	// artist where they sort differently than they read.
+	sortTags bool
+
+	// normalizeGenre replaces numeric genre references and variant spellings
+	// with canonical genre names.
+	normalizeGenre bool
+
+	// quarantine queues automatic lookups without a confident match for review
+	// instead of failing.
+	quarantine bool
+
+	// pick lets the user choose among the candidates of automatic lookups.
+	pick bool
+
+	// keepArt and keepLyrics leave cover art and lyrics alone that are already
+	// there, only adding missing ones. Cover art counts only if it is at least
+	// minArtSize pixels in width and height.
+	keepArt    bool
+	keepLyrics bool
+	minArtSize int
+
+	// trace collects what is done to the file being processed for the JSON
+	// output, if not nil.
+	trace *embedTrace
+
+	// stream, if not nil, is where the file is read from and written to
+	// instead of the file at the path given to embedFile.
+	stream *tagStream
+
+	// albumArt shares automatically fetched cover art between the tracks of an
+	// album, if not nil.
+	albumArt *albumArtCache
+
+	// out receives progress, dry run listings and prompts, if not nil instead
+	// of standard output, which may carry JSON or the MP3 file.
+	out io.Writer
+
+	save saveOptions
+}
+
+// output returns where progress and other messages of the run go.
+func (opts *embedOptions) output() io.Writer {
+	if opts.out != nil {
+		return opts.out
+	}
+	return os.Stdout
+}
+
+// infof is infof for the messages of the run.
+func (opts *embedOptions) infof(format string, args ...any) {
+	infoTo(opts.output(), format, args...)
+}
+
+// planDigest returns a digest of the operations opts describes. Embedding with
+// the same plan into an unchanged file has no further effect, which allows such
+// files to be skipped. Local files are identified by their content.
+func (opts *embedOptions) planDigest() (string, error) {
+	h := sha256.New()
+	sources := []string{opts.image}
+	for _, spec := range opts.lyrics {
+		fmt.Fprintf(h, "lyrics %q %q\n", spec.lang, spec.desc)
+		sources = append(sources, spec.source)
+	}
+	for _, v := range sources {
+		fmt.Fprintf(h, "%q\n", v)
+		if v != "" && v != "auto" {
+			sum, err := fileDigest(v)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s\n", sum)
+		}
+	}
+	fmt.Fprintf(h, "%q\n", opts.lang)
+	if lyricsProviderName != providerLrclib || artProviderName != providerITunes {
+		fmt.Fprintf(h, "providers %q %q\n", lyricsProviderName, artProviderName)
+	}
+	// What automatic lookups find and accept. Defaults add nothing, so that
+	// digests recorded before these were added still match.
+	if !opts.hints || opts.fingerprint || opts.quarantine || opts.pick {
+		fmt.Fprintf(h, "lookup %t %t %t %t\n", opts.hints, opts.fingerprint, opts.quarantine, opts.pick)
+	}
+	if opts.image == "auto" && opts.albumArt == nil {
+		fmt.Fprintln(h, "track-art")
+	}
+	if opts.imageMaxSize != 0 || opts.imageQuality != 0 {
+		fmt.Fprintf(h, "image-size %d %d\n", opts.imageMaxSize, opts.imageQuality)
+	}
+	if opts.maxArtBytes != 0 {
+		fmt.Fprintf(h, "max-art-bytes %d %t\n", opts.maxArtBytes, opts.maxArtReject)
+	}
+	if opts.pictureType != id3v2.PTFrontCover || opts.imageAdd {
+		fmt.Fprintf(h, "picture %d %t\n", opts.pictureType, opts.imageAdd)
+	}
+	if opts.lyricsSourceFrame != "" || opts.artSourceFrame != "" {
+		fmt.Fprintf(h, "%q %q\n", opts.lyricsSourceFrame, opts.artSourceFrame)
+	}
+	if opts.fixEncoding != "" {
+		fmt.Fprintf(h, "fix-encoding %q\n", opts.fixEncoding)
+	}
+	if opts.keepArt || opts.keepLyrics {
+		fmt.Fprintf(h, "keep %t %t %d\n", opts.keepArt, opts.keepLyrics, opts.minArtSize)
+	}
+	if opts.translateTo != "" {
+		fmt.Fprintf(h, "translate %q %q\n", opts.translateTo, opts.translator.provider)
+	}
+	if opts.romanize != "" {
+		fmt.Fprintf(h, "romanize %q\n", opts.romanize)
+	}
+	if opts.albumArtist != "" {
+		fmt.Fprintf(h, "album-artist %q\n", opts.albumArtist)
+	}
+	if opts.sortTags {
+		fmt.Fprintln(h, "sort-tags")
+	}
+	if opts.normalizeGenre {
+		fmt.Fprintln(h, "normalize-genre")
+	}
+	if opts.webOptimize {
+		fmt.Fprintln(h, "web-optimize")
+	}
+	if opts.save.id3v1 != "" && opts.save.id3v1 != "keep" {
+		fmt.Fprintf(h, "id3v1 %q\n", opts.save.id3v1)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openTag opens the tag of the MP3 file at path, or reads it from opts.stream.
+func (opts *embedOptions) openTag(path string) (*id3v2.Tag, error) {
+	if opts.stream != nil {
+		return opts.stream.readTag()
+	}
+	if err := checkTagLimit(path); err != nil {
+		return nil, err
+	}
+	return openTag(path)
+}
+
+// fileDigest returns the hex-encoded SHA-256 of the file at name, reading it in
+// chunks so that large files need not fit in memory.
+func fileDigest(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// alreadyApplied reports whether the journal shows that plan was the last thing
+// written to the file at path and that the file has not been touched since.
+func alreadyApplied(path, plan string) (bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	entries, err := readJournal(abs)
+	if err != nil || len(entries) == 0 {
+		return false, err
+	}
+	last := entries[len(entries)-1]
+	if last.Op != "write" || last.Plan != plan {
+		return false, nil
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return false, err
+	}
+	return fi.Size() == last.Size && fi.ModTime().Equal(last.ModTime), nil
+}
+
+// matchCandidate looks up the lyrics or art (kind) for the file at path, to be
+// embedded in the language lang, and returns the candidate the user picks if
+// opts.pick is set, or otherwise a confident match. The confidence of the
+// returned candidate is returned along with it.
+func (opts *embedOptions) matchCandidate(path, kind string, tag *id3v2.Tag, lang string) (*reviewCandidate, confidence, error) {
+	if opts.pick {
+		c, err := pickCandidate(opts.output(), path, kind, tag)
+		return c, confHuman, err
+	}
+	c, err := confidentMatch(path, kind, tag, lang, opts.dryRun)
+	return c, confHigh, err
+}
+
+// loadImage returns the image data and its content type for an image spec, which
+// is either the path of an image file or "auto" to fetch the cover from the
+// provider of automatic lookups.
+func loadImage(spec string, tag *id3v2.Tag) ([]byte, string, error) {
+	b, ct, _, err := loadImageSource(spec, tag)
+	return b, ct, err
+}
+
+// loadImageSource is like loadImage, but also returns the candidate a fetched
+// image belongs to.
+func loadImageSource(spec string, tag *id3v2.Tag) ([]byte, string, *reviewCandidate, error) {
+	// If "auto" is specified, automatically fetch album art.
+	if spec == "auto" {
+		b, ct, match, err := lookupArt(tag.Artist(), tag.Title())
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("error fetching album art image: %w", err)
+		}
+		return b, ct, match, nil
+	}
+	// If a specific file path is provided, read and embed that image.
+	b, err := readFileLimited(spec, "album art image", maxImageBytes)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error reading album art image: %w", err)
+	}
+	return b, http.DetectContentType(b), nil, nil
+}
+
+// overwritePolicies are the values of the -overwrite flag: the kinds of
+// frames already in a file that are replaced.
+var overwritePolicies = []string{"all", "art", "lyrics", "none"}
+
+// setOverwrite sets which of cover art and lyrics already in files are kept
+// from an -overwrite policy.
+func (opts *embedOptions) setOverwrite(policy string) error {
+	if !slices.Contains(overwritePolicies, policy) {
+		return fmt.Errorf("unknown overwrite policy %q: want %s", policy, strings.Join(overwritePolicies, ", "))
+	}
+	opts.keepArt = policy == "lyrics" || policy == "none"
+	opts.keepLyrics = policy == "art" || policy == "none"
+	return nil
+}
+
+// hasGoodArt reports whether tag has a picture of type pt that can be decoded
+// and is at least minSize pixels in width and height.
+func hasGoodArt(tag *id3v2.Tag, pt byte, minSize int) bool {
+	for _, f := range tag.GetFrames(tag.CommonID("Attached picture")) {
+		pic, ok := f.(id3v2.PictureFrame)
+		if !ok || pic.PictureType != pt {
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(pic.Picture))
+		if err == nil && cfg.Width >= minSize && cfg.Height >= minSize {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLyrics reports whether tag has plain or synchronised lyrics in the
+// language lang, with the content descriptor desc if it is not empty.
+func hasLyrics(tag *id3v2.Tag, lang, desc string) bool {
+	lf := &lyricsFilter{lang: lang, desc: desc}
+	for _, text := range unsyncedLyrics(tag, lf) {
+		if strings.TrimSpace(text) != "" {
+			return true
+		}
+	}
+	synced, _ := syncedLyricsText(tag, lf)
+	return len(synced) > 0
+}
+
+// albumArtCache holds the cover art fetched for the albums of one directory,
+// so that all tracks of an album share one lookup. It is dropped when files of
+// another directory come up, which keeps its size down in large batches.
+type albumArtCache struct {
+	dir    string
+	albums map[string]*albumArt
+}
+
+// albumArt is the outcome of fetching the cover art of an album.
+type albumArt struct {
+	b     []byte
+	ct    string
+	match *reviewCandidate
+	err   error
+}
+
+// albumKey returns the key of the album of tag in an albumArtCache: the album
+// artist, or the artist, and the album, or "" if the album is not known. The
+// tracks of a compilation are looked up by their own artist, as a lookup for
+// the first track finds the art of its artist's album rather than that of the
+// compilation.
+func albumKey(tag *id3v2.Tag) string {
+	album := strings.TrimSpace(textOf(tag, "TALB"))
+	if album == "" {
+		return ""
+	}
+	artist := strings.TrimSpace(textOf(tag, "TPE2"))
+	if artist == "" || isCompilation(tag) {
+		artist = strings.TrimSpace(textOf(tag, "TPE1"))
+	}
+	return strings.ToLower(artist + "\x00" + album)
+}
+
+// load is like loadImageSource for the spec "auto", but reuses the cover art
+// fetched for an earlier track of the same album in the same directory as the
+// file at path, or in an earlier run, which shared reports. Failed lookups are
+// only reused within the run, and only if the provider had no art. A nil cache
+// fetches the art of every track.
+func (c *albumArtCache) load(path string, tag *id3v2.Tag) (b []byte, ct string, match *reviewCandidate, shared bool, err error) {
+	key := albumKey(tag)
+	if c == nil || key == "" {
+		b, ct, match, err = loadImageSource("auto", tag)
+		return b, ct, match, false, err
+	}
+	if dir := filepath.Dir(path); dir != c.dir || c.albums == nil {
+		c.dir, c.albums = dir, map[string]*albumArt{}
+	}
+	if a, ok := c.albums[key]; ok {
+		debugf("%s: reusing the cover art lookup of the album", path)
+		return a.b, a.ct, a.match, true, a.err
+	}
+	if b, ct, match, ok := cachedArt(albumCacheKey(key)); ok && match != nil {
+		debugf("%s: reusing the cached cover art of the album", path)
+		c.albums[key] = &albumArt{b: b, ct: ct, match: match}
+		return b, ct, match, true, nil
+	}
+	b, ct, match, err = loadImageSource("auto", tag)
+	if err == nil || errors.Is(err, errArtNotFound) {
+		c.albums[key] = &albumArt{b: b, ct: ct, match: match, err: err}
+	}
+	if err == nil {
+		cacheArt(b, ct, match, albumCacheKey(key))
+	}
+	return b, ct, match, false, err
+}
+
+// embedImage scales and re-encodes the image b of content type ct as opts
+// requires and adds it to tag, noting a conversion in review.
+func (opts *embedOptions) embedImage(tag *id3v2.Tag, b []byte, ct string, review *[]string) error {
+	fitted, fittedCT, err := fitImage(b, ct, opts.imageMaxSize, opts.imageQuality)
+	if err != nil {
+		return fmt.Errorf("error converting album art image: %w", err)
+	}
+	if opts.maxArtBytes > 0 && len(fitted) > opts.maxArtBytes {
+		if opts.maxArtReject {
+			return fmt.Errorf("%w: %d bytes, limit %d", errArtTooLarge, len(fitted), opts.maxArtBytes)
+		}
+		if fitted, err = shrinkImage(fitted, opts.maxArtBytes); err != nil {
+			return fmt.Errorf("error converting album art image: %w", err)
+		}
+		fittedCT = "image/jpeg"
+	}
+	if len(fitted) != len(b) || fittedCT != ct {
+		*review = append(*review, fmt.Sprintf("Converted cover art from %s to %s", describeImage(b), describeImage(fitted)))
+	}
+	placePicture(tag, fitted, fittedCT, opts.pictureType, opts.imageAdd)
+	return nil
+}
+
+// setCover replaces all pictures of tag with b as the front cover.
+func setCover(tag *id3v2.Tag, b []byte, ct string) {
+	placePicture(tag, b, ct, id3v2.PTFrontCover, false)
+}
+
+// loadLyrics returns the lyrics for a lyrics spec, which is either the path of a
+// lyrics file or "auto" to fetch them from the provider of automatic lookups
+// for the MP3 file at path with tag. For fetched lyrics the matched candidate
+// is returned as well.
+func loadLyrics(spec, path string, tag *id3v2.Tag) (string, *reviewCandidate, error) {
+	// If "auto" is specified, automatically fetch lyrics.
+	if spec == "auto" {
+		// Files whose audio cannot be read match records of any duration.
+		duration, _ := audioDuration(path)
+		match, err := lookupLyrics(tag.Artist(), tag.Title(), duration)
+		if err != nil {
+			return "", nil, err
+		}
+		return match.Lyrics, match, nil
+	}
+	// If a specific lyrics file path is provided, read and embed those lyrics.
+	b, err := readFileLimited(spec, "lyrics file", maxLyricsBytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading lyrics file: %w", err)
+	}
+	return string(b), nil, nil
+}
+
+// defaultLyricsDescriptor is the content descriptor of embedded lyrics unless
+// a lyrics spec names another one.
+const defaultLyricsDescriptor = "Lyrics"
+
+// setLyrics replaces the unsynchronised lyrics of tag in the language lang with
+// lyrics. If desc is not empty, only the lyrics with that content descriptor are
+// replaced, so that several can be kept in one language; otherwise all lyrics in
+// the language are, and the new ones are described as defaultLyricsDescriptor.
+func setLyrics(tag *id3v2.Tag, lyrics, lang, desc string) {
+	id := tag.CommonID("Unsynchronised lyrics/text transcription")
+	deleteFramesFunc(tag, id, func(_ int, f id3v2.Framer) bool {
+		uslt, ok := f.(id3v2.UnsynchronisedLyricsFrame)
+		return !ok || strings.EqualFold(uslt.Language, lang) && (desc == "" || uslt.ContentDescriptor == desc)
+	})
+	if desc == "" {
+		desc = defaultLyricsDescriptor
+	}
+	tag.AddUnsynchronisedLyricsFrame(id3v2.UnsynchronisedLyricsFrame{
+		Encoding:          id3v2.EncodingUTF8,
+		Language:          lang,
+		ContentDescriptor: desc,
+		Lyrics:            lyrics,
+	})
+}
+
+// lyricsSpec is one value of the -lyrics flag: the source of the lyrics, a
+// file or "auto", and the language and content descriptor to embed them with.
+type lyricsSpec struct {
+	lang, desc, source string
+}
+
+// lyricsSpecs is the -lyrics flag, which can be given several times as
+// [lang[:descriptor]=]file|auto to embed lyrics in several languages. Without
+// a language the lyrics are embedded in the language of -lang.
+type lyricsSpecs []lyricsSpec
+
+func (l *lyricsSpecs) String() string {
+	var s []string
+	for _, spec := range *l {
+		s = append(s, spec.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *lyricsSpecs) Set(v string) error {
+	if v == "" {
+		return nil
+	}
+	spec := lyricsSpec{source: v}
+	// A prefix that is not a language code belongs to the path.
+	if prefix, source, ok := strings.Cut(v, "="); ok {
+		lang, desc, _ := strings.Cut(prefix, ":")
+		if isLanguageCode(lang) && source != "" {
+			spec = lyricsSpec{lang: strings.ToLower(lang), desc: desc, source: source}
+		}
+	}
+	*l = append(*l, spec)
+	return nil
+}
+
+func (spec lyricsSpec) String() string {
+	switch {
+	case spec.lang == "":
+		return spec.source
+	case spec.desc == "":
+		return spec.lang + "=" + spec.source
+	}
+	return spec.lang + ":" + spec.desc + "=" + spec.source
+}
+
+// auto reports whether any of the specs fetches lyrics automatically.
+func (l lyricsSpecs) auto() bool {
+	for _, spec := range l {
+		if spec.source == "auto" {
+			return true
+		}
+	}
+	return false
+}
+
+// embedResult is the outcome of embedFile.
+type embedResult int
+
+const (
+	embedDone    embedResult = iota // written, or would be on a dry run
+	embedSkipped                    // already up to date, or declined by the user
+	embedQueued                     // written, but lookups were queued for review
+	embedFailed
+)
+
+// embedFile embeds album art and lyrics into the MP3 file at path as configured by opts.
+func embedFile(path string, opts *embedOptions) (embedResult, error) {
+	result, w, err := prepareEmbed(path, opts)
+	if err != nil || w == nil {
+		return result, err
+	}
+	if err := w.commit(); err != nil {
+		return embedFailed, err
+	}
+	return result, nil
+}
+
+// tagWrite is a tag that prepareEmbed made ready to be saved to its file.
+type tagWrite struct {
+	path   string
+	tag    *id3v2.Tag
+	save   saveOptions
+	notify bool
+	out    io.Writer // for progress
+}
+
+// commit saves the tag of w to its file.
+func (w *tagWrite) commit() error {
+	if err := saveTag(w.tag, w.path, &w.save); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	infoTo(w.out, "Embedded successfully in %s", w.path)
+	logFileEvent(w.path, "embedded")
+	if w.notify {
+		desktopNotify("mp3extra", "Tagged "+filepath.Base(w.path))
+	}
+	return nil
+}
+
+// prepareEmbed does everything embedFile does short of saving the file: it
+// looks up and embeds album art and lyrics into the tag of the MP3 file at
+// path, which it returns ready to be committed. It returns no write on a dry
+// run, for a stream, which it writes itself, and for skipped files.
+func prepareEmbed(path string, opts *embedOptions) (embedResult, *tagWrite, error) {
+	plan, err := opts.planDigest()
+	if err != nil {
+		return embedFailed, nil, err
+	}
+	if !opts.force && !opts.dryRun && opts.stream == nil {
+		applied, err := alreadyApplied(path, plan)
+		if err != nil {
+			return embedFailed, nil, err
+		}
+		if applied {
+			opts.infof("Already up to date: %s", path)
+			logFileEvent(path, "up to date")
+			return embedSkipped, nil, nil
+		}
+	}
+
+	// Open the MP3 file with ID3v2 tags.
+	tag, err := opts.openTag(path)
+	if err != nil {
+		return embedFailed, nil, fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	untagged := tag.Count() == 0
+
+	// If opts.dryRun is enabled, print out all current ID3v2 frames and the cover for review.
+	if opts.dryRun {
+		printFrames(opts.output(), tag)
+		if err := previewArt(opts.output(), tag, "auto"); err != nil {
+			log.Printf("Error previewing cover art: %v", err)
+		}
+	}
+
+	// Remember the frames as they are so that a dry run can report the changes.
+	before := captureFrames(tag)
+
+	// review collects notes about automatic matches shown in interactive mode.
+	var review []string
+
+	// quarantined lists the items whose candidates were queued for review.
+	var quarantined []string
+
+	// Set the default text encoding for added frames.
+	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+
+	// Normalize the comments to ensure compatibility with different tag editors.
+	normalizeComments(tag, opts.lang)
+
+	// sources records how far the frames changed from here on can be trusted,
+	// which is shown to whoever reviews the changes.
+	var sources frameSources
+	var state []frameState
+	if opts.dryRun || opts.interactive || opts.trace != nil {
+		sources = frameSources{}
+		state = captureFrames(tag)
+	}
+
+	// Repair text written in a legacy code page before it is used for lookups.
+	if opts.fixEncoding != "" {
+		enc, err := legacyEncoding(opts.fixEncoding)
+		if err != nil {
+			return embedFailed, nil, err
+		}
+		if n := fixEncoding(tag, enc); n > 0 {
+			review = append(review, fmt.Sprintf("Re-decoded %d frames as %s", n, opts.fixEncoding))
+		}
+		state = sources.track(tag, state, confHigh)
+	}
+
+	// Fill in missing artist and title from files left by download tools and,
+	// for files without any tag, from what else is known about them, so that
+	// automatic lookups have something to search for.
+	// Placeholders such as "Track 01" are replaced the same way, as a lookup
+	// would only find the wrong track.
+	if opts.image == "auto" || opts.lyrics.auto() {
+		placeholders := clearPlaceholders(tag)
+		for _, src := range gatherHints(path, untagged || len(placeholders) > 0, opts) {
+			if n := applyHints(tag, src.hints); n > 0 {
+				review = append(review, fmt.Sprintf("Filled %d missing fields from %s", n, src.name))
+			}
+			state = sources.track(tag, state, src.conf)
+		}
+		if err := restorePlaceholders(tag, placeholders); err != nil {
+			return embedFailed, nil, err
+		}
+		state = sources.track(tag, state, confHuman)
+	}
+
+	// The album artist decides which tracks share the art of an album.
+	if opts.albumArtist != "" && textOf(tag, "TPE2") != opts.albumArtist {
+		tag.AddTextFrame("TPE2", tag.DefaultEncoding(), opts.albumArtist)
+		review = append(review, fmt.Sprintf("Set album artist to %q", opts.albumArtist))
+		state = sources.track(tag, state, confHuman)
+	}
+
+	// Give head units that cannot render the script something to show.
+	if opts.romanize != "" {
+		n, err := applyRomanization(tag, opts.romanize)
+		if err != nil {
+			return embedFailed, nil, err
+		}
+		if n > 0 {
+			review = append(review, fmt.Sprintf("Romanized %d fields", n))
+		}
+		state = sources.track(tag, state, confHigh)
+	}
+
+	// Let players sort "The Beatles" under B and non-Latin names by their
+	// romanization.
+	if opts.sortTags {
+		if n := applySortTags(tag); n > 0 {
+			review = append(review, fmt.Sprintf("Wrote %d sort frames", n))
+		}
+		state = sources.track(tag, state, confHigh)
+	}
+
+	// Resolve legacy numeric genres so that players show names.
+	if opts.normalizeGenre {
+		old := tag.GetTextFrame(tag.CommonID("Genre")).Text
+		changed, err := applyGenreNormalization(tag)
+		if err != nil {
+			return embedFailed, nil, err
+		}
+		if changed {
+			review = append(review, fmt.Sprintf("Normalized genre %q to %q", old, tag.GetTextFrame(tag.CommonID("Genre")).Text))
+		}
+		state = sources.track(tag, state, confHigh)
+	}
+
+	// Process embedding of album art if the image flag is provided, unless
+	// there is good art already and only missing art is to be added.
+	keepArt := opts.image != "" && opts.keepArt && hasGoodArt(tag, opts.pictureType, opts.minArtSize)
+	if keepArt {
+		review = append(review, "Kept the cover art already embedded")
+		debugf("%s: keeping the cover art already embedded", path)
+	}
+	if opts.image != "" && !keepArt {
+		if opts.dryRun && opts.image == "auto" && artProviderName == providerITunes {
+			fmt.Fprintln(opts.output())
+			fmt.Fprintln(opts.output(), "Cover art URL:", coverArtUrl(tag.Artist(), tag.Title()))
+		}
+		if opts.image == "auto" && (opts.quarantine || opts.pick) {
+			c, conf, err := opts.matchCandidate(path, "art", tag, opts.lang)
+			switch {
+			case errors.Is(err, errQuarantined):
+				quarantined = append(quarantined, "cover art")
+			case errors.Is(err, errSkipped):
+				review = append(review, "Cover art skipped")
+			case err != nil:
+				return embedFailed, nil, fmt.Errorf("error fetching album art image: %w", err)
+			default:
+				b, ct, err := candidateArt(c)
+				if err != nil {
+					return embedFailed, nil, fmt.Errorf("error fetching album art image: %w", err)
+				}
+				review = append(review, fmt.Sprintf("Cover art match: %s - %s (%s)", c.Artist, c.Title, c.Album))
+				if err := opts.embedImage(tag, b, ct, &review); err != nil {
+					return embedFailed, nil, err
+				}
+				if opts.artSourceFrame != "" {
+					setUserText(tag, opts.artSourceFrame, c.source())
+				}
+				opts.trace.source("art", "", c.source())
+				state = sources.track(tag, state, conf)
+			}
+		} else {
+			var b []byte
+			var ct string
+			var match *reviewCandidate
+			var shared bool
+			var err error
+			if opts.image == "auto" {
+				b, ct, match, shared, err = opts.albumArt.load(path, tag)
+			} else {
+				b, ct, match, err = loadImageSource(opts.image, tag)
+			}
+			if err != nil {
+				return embedFailed, nil, err
+			}
+			conf, src := confHuman, ""
+			switch {
+			case shared:
+				// The art was found for another track, so only the album can match.
+				review = append(review, fmt.Sprintf("Cover art of album: %s - %s", match.Artist, match.Album))
+				conf = scoreConfidence(matchScore(tag.Artist(), tag.Album(), match.Artist, match.Album))
+				src = match.source()
+			case match != nil:
+				review = append(review, fmt.Sprintf("Cover art match: %s - %s (%s)", match.Artist, match.Title, match.Album))
+				conf = scoreConfidence(match.Score)
+				src = match.source()
+			}
+			if err := opts.embedImage(tag, b, ct, &review); err != nil {
+				return embedFailed, nil, err
+			}
+			if opts.artSourceFrame != "" {
+				setUserText(tag, opts.artSourceFrame, src)
+			}
+			if src == "" {
+				src = opts.image
+			}
+			opts.trace.source("art", "", src)
+			state = sources.track(tag, state, conf)
+		}
+	}
+
+	// Process embedding of lyrics for each lyrics spec.
+	for _, spec := range opts.lyrics {
+		lang := spec.lang
+		if lang == "" {
+			lang = opts.lang
+		}
+		if opts.keepLyrics && hasLyrics(tag, lang, spec.desc) {
+			review = append(review, fmt.Sprintf("Kept the lyrics in %s already embedded", lang))
+			debugf("%s: keeping the lyrics in %s already embedded", path, lang)
+			continue
+		}
+		if spec.source == "auto" && (opts.quarantine || opts.pick) {
+			c, conf, err := opts.matchCandidate(path, "lyrics", tag, lang)
+			switch {
+			case errors.Is(err, errQuarantined):
+				quarantined = append(quarantined, "lyrics")
+			case errors.Is(err, errSkipped):
+				review = append(review, "Lyrics skipped")
+			case err != nil:
+				return embedFailed, nil, err
+			default:
+				review = append(review, fmt.Sprintf("Lyrics match: %s - %s (%s, %s)", c.Artist, c.Title, c.Album,
+					time.Duration(c.Duration*float64(time.Second)).Round(time.Second)))
+				setLyrics(tag, c.Lyrics, lang, spec.desc)
+				if opts.lyricsSourceFrame != "" {
+					setUserText(tag, opts.lyricsSourceFrame, c.source())
+				}
+				opts.trace.source("lyrics", lang, c.source())
+				state = sources.track(tag, state, conf)
+			}
+			continue
+		}
+		lyrics, match, err := loadLyrics(spec.source, path, tag)
+		if err != nil {
+			return embedFailed, nil, err
+		}
+		conf := confHuman
+		if match != nil {
+			review = append(review, fmt.Sprintf("Lyrics match: %s - %s (%s, %s)", match.Artist, match.Title, match.Album,
+				time.Duration(match.Duration*float64(time.Second)).Round(time.Second)))
+			conf = scoreConfidence(match.Score)
+		}
+		setLyrics(tag, lyrics, lang, spec.desc)
+		// Lyrics from a file only replace the record of fetched ones if they
+		// replace the fetched lyrics too, rather than add a translation.
+		if opts.lyricsSourceFrame != "" && (match != nil || spec.lang == "" && spec.desc == "") {
+			src := ""
+			if match != nil {
+				src = match.source()
+			}
+			setUserText(tag, opts.lyricsSourceFrame, src)
+		}
+		if match != nil {
+			opts.trace.source("lyrics", lang, match.source())
+		} else {
+			opts.trace.source("lyrics", lang, spec.source)
+		}
+		state = sources.track(tag, state, conf)
+	}
+
+	// Translate the lyrics now embedded in the language of the tag, unless
+	// they are waiting for review.
+	if opts.translateTo != "" && !slices.Contains(quarantined, "lyrics") &&
+		!(opts.keepLyrics && hasLyrics(tag, opts.translateTo, translationDescriptor)) {
+		lyrics, ok := lyricsToTranslate(tag, opts.lang)
+		if !ok {
+			return embedFailed, nil, fmt.Errorf("no lyrics in %s to translate", opts.lang)
+		}
+		translated, err := opts.translator.translateLyrics(lyrics, opts.lang, opts.translateTo)
+		if err != nil {
+			return embedFailed, nil, fmt.Errorf("error translating lyrics: %w", err)
+		}
+		review = append(review, fmt.Sprintf("Lyrics translated into %s by %s", opts.translateTo, opts.translator.provider))
+		setLyrics(tag, translated, opts.translateTo, translationDescriptor)
+		opts.trace.source("translation", opts.translateTo, opts.translator.provider)
+		state = sources.track(tag, state, confLow)
+	}
+
+	// Strip the tag down last, so that it also applies to what was just added.
+	if opts.webOptimize {
+		opts.optimizeForWeb(tag, &review)
+		sources.track(tag, state, confHigh)
+	}
+
+	result := embedDone
+	if len(quarantined) > 0 {
+		result = embedQueued
+		msg := fmt.Sprintf("No confident match for %s, queued for 'mp3extra review'", strings.Join(quarantined, " and "))
+		if opts.dryRun {
+			msg = fmt.Sprintf("No confident match for %s, would be queued for review", strings.Join(quarantined, " and "))
+		}
+		opts.infof("%s: %s", path, msg)
+		logFileEvent(path, "queued for review", "items", quarantined)
+		if opts.notify && !opts.dryRun {
+			desktopNotify("mp3extra: "+filepath.Base(path)+" needs review", msg)
+		}
+	}
+
+	// Let the user review the changes before anything is written.
+	if opts.interactive && !opts.dryRun {
+		if !confirmChanges(opts.output(), path, tag, before, sources, review) {
+			opts.infof("Skipped %s", path)
+			logFileEvent(path, "declined")
+			return embedSkipped, nil, nil
+		}
+	}
+
+	// Write-protected frames stay as they are, which a dry run should show.
+	if opts.stream != nil {
+		err = opts.stream.keepProtected(opts.output(), tag)
+	} else {
+		err = keepProtectedTo(opts.output(), tag, path)
+	}
+	if err != nil {
+		return embedFailed, nil, fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	if opts.trace != nil {
+		opts.trace.notes = review
+		opts.trace.changes = diffFrames(before, captureFrames(tag))
+		opts.trace.confidence = sources
+	}
+
+	// On a dry run, print what saving would change instead of saving.
+	if opts.dryRun {
+		fmt.Fprintln(opts.output())
+		printFrameDiffSources(opts.output(), diffFrames(before, captureFrames(tag)), sources)
+	}
+	if opts.stream != nil {
+		if opts.dryRun {
+			return result, nil, nil
+		}
+		if err := opts.stream.write(tag, opts.save.padLimit()); err != nil {
+			return embedFailed, nil, fmt.Errorf("error writing MP3 stream: %w", err)
+		}
+		logFileEvent(path, "embedded")
+		return result, nil, nil
+	}
+	reportID3v1(opts.output(), path, tag, opts.save.id3v1, opts.dryRun)
+	if opts.dryRun {
+		return result, nil, nil
+	}
+
+	// The tag is saved by the caller. Its file may be closed by then, as the
+	// frames are all read.
+	w := &tagWrite{path: path, tag: tag, save: opts.save, notify: opts.notify, out: opts.output()}
+	w.save.plan = plan
+	return result, w, nil
+}
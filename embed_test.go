@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestPlanDigestLookupOptions(t *testing.T) {
+	base := func() *embedOptions {
+		return &embedOptions{
+			image:    "auto",
+			lyrics:   lyricsSpecs{{source: "auto"}},
+			lang:     "jpn",
+			hints:    true,
+			albumArt: &albumArtCache{},
+		}
+	}
+	want, err := base().planDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := base().planDigest(); again != want {
+		t.Fatal("planDigest differs for the same options")
+	}
+	tests := []struct {
+		name   string
+		change func(opts *embedOptions)
+	}{
+		{"-fingerprint", func(opts *embedOptions) { opts.fingerprint = true }},
+		{"-hints=false", func(opts *embedOptions) { opts.hints = false }},
+		{"-quarantine", func(opts *embedOptions) { opts.quarantine = true }},
+		{"-pick", func(opts *embedOptions) { opts.pick = true }},
+		{"-album-art=false", func(opts *embedOptions) { opts.albumArt = nil }},
+		{"-lang", func(opts *embedOptions) { opts.lang = "eng" }},
+	}
+	for _, tt := range tests {
+		opts := base()
+		tt.change(opts)
+		got, err := opts.planDigest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == want {
+			t.Errorf("%s does not change the plan, so files would be skipped as up to date", tt.name)
+		}
+	}
+}
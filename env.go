@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables that set flags.
+const envPrefix = "MP3EXTRA_"
+
+// envName returns the name of the environment variable that sets the flag
+// name, as in MP3EXTRA_LOG_FILE for -log-file.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// globalEnvFlags are the flags that MP3EXTRA_<FLAG> sets for every command
+// with such a flag: options of the setup rather than of one run. Any flag of a
+// command is set with MP3EXTRA_<COMMAND>_<FLAG>, as in MP3EXTRA_WATCH_INTERVAL.
+var globalEnvFlags = []string{
+	"lang", "q", "v", "log-file", "log-format", "low-memory", "max-memory",
+	"lyrics-provider", "art-provider", "hooks", "pre-hook", "post-hook",
+	"ca-cert", "client-cert", "client-key", "insecure-skip-verify",
+}
+
+// envCommand returns the name of the command whose flags fs holds, which is
+// embed for the default mode.
+func envCommand(fs *flag.FlagSet) string {
+	if _, ok := commands[fs.Name()]; ok {
+		return fs.Name()
+	}
+	return "embed"
+}
+
+// setFlagsFromEnv sets the flags of fs that were not given on the command line
+// from their environment variables, so that flags take precedence. The
+// variable of the command comes before that of a global option.
+func setFlagsFromEnv(fs *flag.FlagSet) error {
+	warnUnknownEnv()
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	cmd := envCommand(fs)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		name := envName(cmd + "-" + f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok && slices.Contains(globalEnvFlags, f.Name) {
+			name = envName(f.Name)
+			v, ok = os.LookupEnv(name)
+		}
+		if !ok {
+			return
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("invalid %s %q: %v", name, v, e)
+		}
+	})
+	return err
+}
+
+// envWarned is set once the environment was checked by warnUnknownEnv.
+var envWarned bool
+
+// warnUnknownEnv warns once about MP3EXTRA_ variables that set nothing, such
+// as misspelt ones or those of flags that are only set per command.
+func warnUnknownEnv() {
+	if envWarned {
+		return
+	}
+	envWarned = true
+	var unknown []string
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, envPrefix) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return
+	}
+	known := knownEnvNames()
+	for _, name := range unknown {
+		if !known[name] {
+			log.Printf("Warning: ignoring %s, which sets nothing; flags of a command are set with %s<COMMAND>_<FLAG>", name, envPrefix)
+		}
+	}
+}
+
+// knownEnvNames returns the names of all MP3EXTRA_ variables mp3extra reads.
+func knownEnvNames() map[string]bool {
+	known := map[string]bool{envName("proxy"): true}
+	for _, name := range globalEnvFlags {
+		known[envName(name)] = true
+	}
+	for _, p := range credentialProviders {
+		known[credentialEnv(p)] = true
+	}
+	// Hook commands, which may run mp3extra themselves, get these.
+	for _, name := range []string{"hook", "hook-path", "hook-status", "hook-error"} {
+		known[envName(name)] = true
+	}
+	add := func(cmd string, fs *flag.FlagSet) {
+		fs.VisitAll(func(f *flag.Flag) {
+			known[envName(cmd+"-"+f.Name)] = true
+		})
+	}
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	embedFlags(fs, nil)
+	add("embed", fs)
+	for name, c := range commands {
+		add(name, c.flags())
+	}
+	return known
+}
+
+// setProxyFromEnv makes HTTP requests go through the proxy in MP3EXTRA_PROXY,
+// if set, instead of those of HTTP_PROXY and HTTPS_PROXY.
+func setProxyFromEnv() error {
+	v := os.Getenv(envName("proxy"))
+	if v == "" {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: want a URL such as http://proxy:3128", envName("proxy"), v)
+	}
+	http.DefaultTransport.(*http.Transport).Proxy = http.ProxyURL(u)
+	return nil
+}
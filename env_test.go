@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestEnvKeepsArtCacheFlag(t *testing.T) {
+	t.Setenv(envName("lang"), "eng")
+	t.Setenv(envName("no-such-flag"), "1")
+	envWarned = false
+	t.Cleanup(func() { useArtCache = true })
+
+	fs := flag.NewFlagSet("mp3extra", flag.ContinueOnError)
+	run := embedFlags(fs, nil)
+	// -prefetch=-1 stops the run once the flags are applied.
+	if err := fs.Parse([]string{"-art-cache=false", "-prefetch=-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setFlagsFromEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Lookup("lang").Value.String(); got != "eng" {
+		t.Errorf("-lang = %q, want eng from the environment", got)
+	}
+	if err := run(); err == nil {
+		t.Fatal("run with -prefetch=-1 succeeded")
+	}
+	if useArtCache {
+		t.Error("-art-cache=false was reset by reading the environment")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Exit codes, which let scripts tell why a run failed.
+const (
+	exitOK       = 0
+	exitUsage    = 1 // bad flags or arguments, and errors of no other kind
+	exitFile     = 2 // a file was not found or could not be read or written
+	exitNotFound = 3 // no cover art or lyrics were found
+	exitNetwork  = 4 // a lookup failed on the network
+)
+
+// parseFlags parses args with fs and sets the flags not given in args from the
+// environment. Bad flags exit with exitUsage rather than the status 2 of the
+// flag package, which here means a file error.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Init(fs.Name(), flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitUsage)
+	}
+	if err := setFlagsFromEnv(fs); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		os.Exit(exitUsage)
+	}
+}
+
+// batchError is returned by runs over several files when some of them failed.
+type batchError struct {
+	failed, total int
+	code          int // the highest exit code of the files that failed
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("%d of %d files failed", e.failed, e.total)
+}
+
+// fileExitCode returns the exit code for err, returned by embedFile for a
+// single file.
+func fileExitCode(err error) int {
+	switch errorClass(err) {
+	case classNetwork:
+		return exitNetwork
+	case classNotFound, classPlaceholder:
+		return exitNotFound
+	}
+	return exitFile
+}
+
+// exitCode returns the exit code for err, returned by a command.
+func exitCode(err error) int {
+	var be *batchError
+	if errors.As(err, &be) {
+		return be.code
+	}
+	switch errorClass(err) {
+	case classNetwork:
+		return exitNetwork
+	case classNotFound:
+		return exitNotFound
+	case classFile:
+		return exitFile
+	}
+	return exitUsage
+}
+
+// exit logs err and exits with its exit code.
+func exit(err error) {
+	log.Print(err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "extract-art",
+		usage: "Save the cover art embedded in an MP3 file to an image file",
+		run:   runExtractArt,
+	})
+}
+
+// imageExtensions are the file name extensions of image MIME types.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/jpg":  ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+	"image/webp": ".webp",
+}
+
+// imageExtension returns the file name extension for the picture pic, from its
+// MIME type or, if that is unknown, from its content.
+func imageExtension(pic *id3v2.PictureFrame) string {
+	if ext, ok := imageExtensions[strings.ToLower(pic.MimeType)]; ok {
+		return ext
+	}
+	ct, _, _ := strings.Cut(http.DetectContentType(pic.Picture), ";")
+	if ext, ok := imageExtensions[ct]; ok {
+		return ext
+	}
+	return ".bin"
+}
+
+// findPicture returns the picture of type pt in tag, or the cover as chosen
+// by coverPicture if pt is negative.
+func findPicture(tag *id3v2.Tag, pt int) *id3v2.PictureFrame {
+	if pt < 0 {
+		return coverPicture(tag)
+	}
+	for _, f := range tag.GetFrames(tag.CommonID("Attached picture")) {
+		if pic, ok := f.(id3v2.PictureFrame); ok && int(pic.PictureType) == pt {
+			return &pic
+		}
+	}
+	return nil
+}
+
+// runExtractArt implements the extract-art command.
+func runExtractArt(fs *flag.FlagSet) func() error {
+	var out, pictureType string
+	var force bool
+	fs.StringVar(&out, "o", "", "Image file to write, or - for standard output; the extension is added if missing (default: the MP3 file name with the image extension)")
+	fs.StringVar(&pictureType, "picture-type", "", "Picture type to extract: "+strings.Join(pictureTypeNames(), ", ")+" (default: the front cover, or else the first picture)")
+	fs.BoolVar(&force, "force", false, "Overwrite an existing image file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s extract-art file.mp3 [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		// Accept flags after the file name too, as in "extract-art song.mp3 -o cover.jpg".
+		name := fs.Arg(0)
+		parseFlags(fs, fs.Args()[1:])
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		pt := -1
+		if pictureType != "" {
+			t, err := parsePictureType(pictureType)
+			if err != nil {
+				return err
+			}
+			pt = int(t)
+		}
+
+		tag, err := openTag(name)
+		if err != nil {
+			return fmt.Errorf("error opening MP3 file: %w", err)
+		}
+		pic := findPicture(tag, pt)
+		tag.Close()
+		if pic == nil {
+			if pt >= 0 {
+				return fmt.Errorf("%s: no %s picture", name, pictureTypeName(byte(pt)))
+			}
+			return fmt.Errorf("%s: no embedded picture", name)
+		}
+
+		if out == "-" {
+			_, err := os.Stdout.Write(pic.Picture)
+			return err
+		}
+		ext := imageExtension(pic)
+		switch {
+		case out == "":
+			out = strings.TrimSuffix(name, filepath.Ext(name)) + ext
+		case filepath.Ext(out) == "":
+			out += ext
+		case !strings.EqualFold(filepath.Ext(out), ext) && !(ext == ".jpg" && strings.EqualFold(filepath.Ext(out), ".jpeg")):
+			log.Printf("Warning: the picture is %s, but is written to %s", pic.MimeType, out)
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if force {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(out, flags, 0644)
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists; use -force to overwrite it", out)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(pic.Picture)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Extracted %s (%s) to %s\n", pictureTypeName(pic.PictureType), describeImage(pic.Picture), out)
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isMP3 reports whether name has an .mp3 extension.
+func isMP3(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".mp3")
+}
+
+// collectMP3Files expands the command-line arguments into a list of MP3 files.
+// Files are taken as they are, directories are searched recursively for .mp3 files.
+func collectMP3Files(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isMP3(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
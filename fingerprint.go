@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fingerprintMatch is a recording identified by its audio fingerprint.
+type fingerprintMatch struct {
+	IDs    musicBrainzIDs
+	Artist string
+	Title  string
+	Album  string
+	Score  float64
+}
+
+// fpcalcResult represents the JSON output of chromaprint's fpcalc tool.
+type fpcalcResult struct {
+	Duration    float64 `json:"duration"`
+	Fingerprint string  `json:"fingerprint"`
+}
+
+// acoustidResult represents the JSON structure returned by the AcoustID lookup API.
+type acoustidResult struct {
+	Status string `json:"status"`
+	Error  struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Results []struct {
+		ID         string  `json:"id"`
+		Score      float64 `json:"score"`
+		Recordings []struct {
+			ID      string `json:"id"`
+			Title   string `json:"title"`
+			Artists []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"artists"`
+			ReleaseGroups []struct {
+				ID       string `json:"id"`
+				Title    string `json:"title"`
+				Releases []struct {
+					ID string `json:"id"`
+				} `json:"releases"`
+			} `json:"releasegroups"`
+		} `json:"recordings"`
+	} `json:"results"`
+}
+
+// errNoFingerprintMatch is returned when AcoustID does not know the recording.
+var errNoFingerprintMatch = errors.New("no fingerprint match")
+
+// fingerprintFile computes the chromaprint fingerprint of an audio file using fpcalc.
+func fingerprintFile(path string) (*fpcalcResult, error) {
+	out, err := exec.Command("fpcalc", "-json", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("fpcalc: %w", err)
+	}
+	var r fpcalcResult
+	if err := json.Unmarshal(out, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// rawFingerprint computes the uncompressed chromaprint fingerprint of an audio
+// file using fpcalc. Unlike the compressed one sent to AcoustID, it can be
+// compared with fingerprintSimilarity.
+func rawFingerprint(path string) ([]uint32, error) {
+	out, err := exec.Command("fpcalc", "-raw", "-json", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("fpcalc: %w", err)
+	}
+	var r struct {
+		Fingerprint []uint32 `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(out, &r); err != nil {
+		return nil, err
+	}
+	return r.Fingerprint, nil
+}
+
+// maxFingerprintShift is how far, in fingerprint items of about 1/8 second,
+// fingerprintSimilarity moves one recording against the other, for silence of
+// different length at the start.
+const maxFingerprintShift = 16
+
+// fingerprintSimilarity returns the share of equal bits of the raw fingerprints
+// a and b at their best alignment: about 0.5 for unrelated recordings and
+// close to 1 for the same one, even in another encoding.
+func fingerprintSimilarity(a, b []uint32) float64 {
+	best := 0.0
+	for shift := -maxFingerprintShift; shift <= maxFingerprintShift; shift++ {
+		same, n := 0, 0
+		for i := range a {
+			j := i + shift
+			if j < 0 || j >= len(b) {
+				continue
+			}
+			same += 32 - bits.OnesCount32(a[i]^b[j])
+			n += 32
+		}
+		// Require most of the shorter recording to overlap.
+		if n == 0 || n < 32*min(len(a), len(b))/2 {
+			continue
+		}
+		best = max(best, float64(same)/float64(n))
+	}
+	return best
+}
+
+// lookupAcoustID queries the AcoustID service for the recording matching a fingerprint.
+// The API key is the one stored with the auth command or set in the environment.
+func lookupAcoustID(fp *fpcalcResult) (*fingerprintMatch, error) {
+	key, _, err := credential(providerAcoustID)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("client", key)
+	form.Set("meta", "recordings releasegroups releases")
+	form.Set("duration", strconv.Itoa(int(math.Round(fp.Duration))))
+	form.Set("fingerprint", fp.Fingerprint)
+	resp, err := http.PostForm("https://api.acoustid.org/v2/lookup", form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result acoustidResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Status != "ok" {
+		return nil, fmt.Errorf("acoustid: %s", result.Error.Message)
+	}
+
+	// Results are ordered by score, so the first one with a recording wins.
+	for _, r := range result.Results {
+		for _, rec := range r.Recordings {
+			m := &fingerprintMatch{
+				IDs:   musicBrainzIDs{Recording: rec.ID, AcoustID: r.ID},
+				Title: rec.Title,
+				Score: r.Score,
+			}
+			var artists []string
+			for _, a := range rec.Artists {
+				artists = append(artists, a.Name)
+				m.IDs.Artists = append(m.IDs.Artists, a.ID)
+			}
+			m.Artist = strings.Join(artists, ", ")
+			if len(rec.ReleaseGroups) > 0 {
+				rg := rec.ReleaseGroups[0]
+				m.Album = rg.Title
+				m.IDs.ReleaseGroup = rg.ID
+				// The release is only known if the group has just one.
+				if len(rg.Releases) == 1 {
+					m.IDs.Release = rg.Releases[0].ID
+				}
+			}
+			return m, nil
+		}
+	}
+	return nil, errNoFingerprintMatch
+}
+
+// identifyFile fingerprints an audio file and looks it up on AcoustID.
+func identifyFile(path string) (*fingerprintMatch, error) {
+	fp, err := fingerprintFile(path)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	m, err := lookupAcoustID(fp)
+	recordLookup(providerAcoustID, start, err)
+	return m, err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// deleteFramesFunc removes the frames with the given ID for which del returns
+// true, keeping the others in their original order. Duplicate frames are
+// passed to del as the frame they stand for.
+func deleteFramesFunc(tag *id3v2.Tag, id string, del func(i int, f id3v2.Framer) bool) {
+	// DeleteFrames recycles the underlying slice, so copy the frames first.
+	frames := append([]id3v2.Framer(nil), tag.GetFrames(id)...)
+	tag.DeleteFrames(id)
+	for i, f := range frames {
+		if !del(i, plainFrame(f)) {
+			tag.AddFrame(id, f)
+		}
+	}
+}
+
+// deleteFrameAt removes the i-th frame with the given ID.
+func deleteFrameAt(tag *id3v2.Tag, id string, i int) {
+	deleteFramesFunc(tag, id, func(j int, _ id3v2.Framer) bool {
+		return i == j
+	})
+}
+
+// setUserText sets the TXXX frame with the given description to value, or
+// removes it if value is empty.
+func setUserText(tag *id3v2.Tag, desc, value string) {
+	deleteFramesFunc(tag, "TXXX", func(_ int, f id3v2.Framer) bool {
+		udtf, ok := f.(id3v2.UserDefinedTextFrame)
+		return ok && udtf.Description == desc
+	})
+	if value != "" {
+		tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+			Encoding:    tag.DefaultEncoding(),
+			Description: desc,
+			Value:       value,
+		})
+	}
+}
+
+// setComment sets the COMM frame with the given language and description to
+// text, or removes it if text is empty. Languages are compared ignoring case.
+func setComment(tag *id3v2.Tag, lang, desc, text string) {
+	deleteFramesFunc(tag, "COMM", func(_ int, f id3v2.Framer) bool {
+		cf, ok := f.(id3v2.CommentFrame)
+		return ok && strings.EqualFold(cf.Language, lang) && cf.Description == desc
+	})
+	if text != "" {
+		tag.AddCommentFrame(id3v2.CommentFrame{
+			Encoding:    tag.DefaultEncoding(),
+			Language:    lang,
+			Description: desc,
+			Text:        text,
+		})
+	}
+}
+
+// isLanguageCode reports whether s is a 3-letter ISO 639-2 language code, as
+// comments and lyrics are tagged with.
+func isLanguageCode(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return len(s) == 3
+}
+
+// isLatin1 reports whether s can be written in ISO-8859-1.
+func isLatin1(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return r > 0xff }) < 0
+}
+
+// normalizeComments rewrites the comments of tag in ISO-8859-1, which some tag
+// editors handle better than the other encodings, where that loses nothing.
+// Comments without a valid language get lang. Each comment is kept: they differ
+// in language or description.
+func normalizeComments(tag *id3v2.Tag, lang string) {
+	mapFrames(tag, func(f id3v2.Framer) (id3v2.Framer, bool) {
+		cf, ok := f.(id3v2.CommentFrame)
+		if !ok {
+			return f, false
+		}
+		changed := false
+		if !cf.Encoding.Equals(id3v2.EncodingISO) && isLatin1(cf.Description) && isLatin1(cf.Text) {
+			cf.Encoding, changed = id3v2.EncodingISO, true
+		}
+		if !isLanguageCode(cf.Language) {
+			cf.Language, changed = lang, true
+		}
+		return cf, changed
+	})
+}
+
+// mapFrames replaces the frames of tag for which fn reports a change with the
+// frame fn returns, keeping the frames in their original order.
+func mapFrames(tag *id3v2.Tag, fn func(f id3v2.Framer) (id3v2.Framer, bool)) {
+	for id, frames := range tag.AllFrames() {
+		// DeleteFrames recycles the underlying slice, so copy the frames first.
+		frames = append([]id3v2.Framer(nil), frames...)
+		changed := false
+		for i, f := range frames {
+			if g, ok := fn(f); ok {
+				frames[i], changed = g, true
+			}
+		}
+		if !changed {
+			continue
+		}
+		tag.DeleteFrames(id)
+		for _, f := range frames {
+			tag.AddFrame(id, f)
+		}
+	}
+}
+
+// mapText calls fn with the encoding and text fields of f, for frames that have
+// them, and returns the modified frame if fn reports a change.
+func mapText(f id3v2.Framer, fn func(enc *id3v2.Encoding, texts ...*string) bool) (id3v2.Framer, bool) {
+	switch f := f.(type) {
+	case id3v2.TextFrame:
+		return f, fn(&f.Encoding, &f.Text)
+	case id3v2.CommentFrame:
+		return f, fn(&f.Encoding, &f.Description, &f.Text)
+	case id3v2.UnsynchronisedLyricsFrame:
+		return f, fn(&f.Encoding, &f.ContentDescriptor, &f.Lyrics)
+	case id3v2.PictureFrame:
+		return f, fn(&f.Encoding, &f.Description)
+	case id3v2.UserDefinedTextFrame:
+		return f, fn(&f.Encoding, &f.Description, &f.Value)
+	case userURLFrame:
+		return f, fn(&f.Encoding, &f.Description)
+	}
+	return f, false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// genreAliases maps spellings of genres that do not reduce to the same key as
+// their canonical name, see genreKey.
+var genreAliases = map[string]string{
+	"rnb":            "R&B",
+	"randb":          "R&B",
+	"rhythmandblues": "R&B",
+	"dnb":            "Drum & Bass",
+	"drumnbass":      "Drum & Bass",
+	"drumandbass":    "Drum & Bass",
+	"rocknroll":      "Rock & Roll",
+	"rockandroll":    "Rock & Roll",
+	"altrock":        "AlternRock",
+	"hiphoprap":      "Hip-Hop",
+	"acapella":       "A Cappella",
+	"electronica":    "Electronic",
+	"ost":            "Soundtrack",
+	"rx":             "Remix",
+	"cr":             "Cover",
+}
+
+// genreKey reduces a genre name to lower-case letters and digits, so that
+// "Hip Hop", "hip-hop" and "HipHop" compare equal. "&" reduces to nothing.
+func genreKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// genreRefs matches the numeric references of ID3v2.3 genres, e.g. "(17)" or
+// "(RX)", at the start of the text.
+var genreRefs = regexp.MustCompile(`^\((\d+|RX|CR)\)`)
+
+// genresPath returns the location of the user's genre mapping.
+func genresPath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "genres.json"), nil
+}
+
+var (
+	genreMapOnce sync.Once
+	genreMap     map[string]string
+	genreMapErr  error
+)
+
+// userGenres returns the user's genre mapping, keyed by genreKey, which is read
+// once per run. It maps spellings to the name they are replaced with, e.g.
+// {"Hip Hop/Rap": "Hip-Hop"}.
+func userGenres() (map[string]string, error) {
+	genreMapOnce.Do(func() {
+		name, err := genresPath()
+		if err != nil {
+			genreMapErr = err
+			return
+		}
+		b, err := os.ReadFile(name)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				genreMapErr = err
+			}
+			return
+		}
+		var m map[string]string
+		if err := json.Unmarshal(b, &m); err != nil {
+			genreMapErr = fmt.Errorf("%s: %w", name, err)
+			return
+		}
+		genreMap = map[string]string{}
+		for k, v := range m {
+			genreMap[genreKey(k)] = v
+		}
+	})
+	return genreMap, genreMapErr
+}
+
+// canonicalGenre returns the canonical name of a single genre: the name the
+// user's mapping gives it, the ID3v1 name for a genre number or a known
+// spelling, or the trimmed genre itself.
+func canonicalGenre(s string, user map[string]string) string {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		if n >= 0 && n < len(id3v1Genres) {
+			return id3v1Genres[n]
+		}
+		return s
+	}
+	key := genreKey(s)
+	if g, ok := user[key]; ok {
+		return g
+	}
+	if g, ok := genreAliases[key]; ok {
+		return g
+	}
+	for _, g := range id3v1Genres {
+		if genreKey(g) == key {
+			return g
+		}
+	}
+	return s
+}
+
+// normalizeGenres returns the canonical genres of the text of a TCON frame.
+// Numeric references as in "(17)" or "(17)(13)" are resolved, unless they are
+// followed by a refinement, which is used instead as in "(4)Eurodisco".
+func normalizeGenres(text string, user map[string]string) []string {
+	var values []string
+	for _, v := range strings.Split(text, "\x00") {
+		var refs []string
+		for m := genreRefs.FindStringSubmatch(v); m != nil; m = genreRefs.FindStringSubmatch(v) {
+			refs = append(refs, m[1])
+			v = v[len(m[0]):]
+		}
+		if strings.TrimSpace(v) != "" {
+			refs = []string{v}
+		}
+		for _, r := range refs {
+			if g := canonicalGenre(r, user); g != "" && !containsFold(values, g) {
+				values = append(values, g)
+			}
+		}
+	}
+	return values
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyGenreNormalization rewrites the genre of tag with canonical names and
+// reports whether it changed. Several genres are separated as the version of
+// tag expects: by NUL in ID3v2.4 and by "/" in ID3v2.3.
+func applyGenreNormalization(tag *id3v2.Tag) (bool, error) {
+	user, err := userGenres()
+	if err != nil {
+		return false, err
+	}
+	id := tag.CommonID("Genre")
+	old := tag.GetTextFrame(id).Text
+	if old == "" {
+		return false, nil
+	}
+	sep := "/"
+	if tag.Version() == 4 {
+		sep = "\x00"
+	}
+	genre := strings.Join(normalizeGenres(old, user), sep)
+	if genre == old {
+		return false, nil
+	}
+	tag.AddTextFrame(id, tag.DefaultEncoding(), genre)
+	return true, nil
+}
@@ -2,6 +2,11 @@ module github.com/mattn/mp3extra
 
 go 1.24.0
 
-require github.com/bogem/id3v2/v2 v2.1.4
+require (
+	github.com/bogem/id3v2/v2 v2.1.4
+	github.com/fsnotify/fsnotify v1.10.1
+	golang.org/x/term v0.36.0
+	golang.org/x/text v0.3.8
+)
 
-require golang.org/x/text v0.3.8 // indirect
+require golang.org/x/sys v0.37.0 // indirect
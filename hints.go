@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// trackHints are artist, title, album, album artist and track number of a track
+// as found in a companion file left by a download tool or elsewhere.
+type trackHints struct {
+	Artist      string
+	Title       string
+	Album       string
+	AlbumArtist string
+	Track       string
+
+	// MusicBrainz holds the identifiers of the recording if the hints come
+	// from a MusicBrainz match.
+	MusicBrainz *musicBrainzIDs
+}
+
+// hintFiles returns the companion files that may hold hints for the MP3 file at
+// path, in order of preference.
+func hintFiles(path string) []string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	return []string{base + ".info.json", path + ".info.json", base + ".nfo"}
+}
+
+// readHints reads the hints from the first companion file of the MP3 file at
+// path that exists. It returns nil if there is none.
+func readHints(path string) (*trackHints, string, error) {
+	for _, name := range hintFiles(path) {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, name, err
+		}
+		var h *trackHints
+		if strings.HasSuffix(name, ".json") {
+			h, err = parseInfoJSON(b)
+		} else {
+			h = parseNFO(b)
+		}
+		if err != nil {
+			return nil, name, err
+		}
+		return h, name, nil
+	}
+	return nil, "", nil
+}
+
+// parseInfoJSON extracts hints from the .info.json written by yt-dlp and youtube-dl.
+func parseInfoJSON(b []byte) (*trackHints, error) {
+	var info struct {
+		Track       string `json:"track"`
+		Artist      string `json:"artist"`
+		Album       string `json:"album"`
+		AlbumArtist string `json:"album_artist"`
+		Title       string `json:"title"`
+		Creator     string `json:"creator"`
+		Uploader    string `json:"uploader"`
+	}
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+	h := &trackHints{Artist: info.Artist, Title: info.Track, Album: info.Album, AlbumArtist: info.AlbumArtist}
+	if h.Title == "" {
+		// Video titles often read "Artist - Title".
+		h.Title = info.Title
+		if artist, title, ok := strings.Cut(info.Title, " - "); ok && h.Artist == "" {
+			h.Artist, h.Title = artist, title
+		}
+	}
+	if h.Artist == "" {
+		h.Artist = info.Creator
+	}
+	if h.Artist == "" {
+		h.Artist = strings.TrimSuffix(info.Uploader, " - Topic")
+	}
+	return h, nil
+}
+
+// nfoLine matches "Key: value" lines of plain text .nfo files, allowing dots as
+// filler between key and colon as in "Artist.....: value".
+var nfoLine = regexp.MustCompile(`(?i)^\s*(album ?artist|artist|title|track|album)\s*\.*\s*:\s*(.+?)\s*$`)
+
+// parseNFO extracts hints from a .nfo file, which is either Kodi style XML or
+// plain text.
+func parseNFO(b []byte) *trackHints {
+	var x struct {
+		XMLName     xml.Name
+		Title       string `xml:"title"`
+		Artist      string `xml:"artist"`
+		Album       string `xml:"album"`
+		AlbumArtist string `xml:"albumartist"`
+	}
+	if err := xml.Unmarshal(b, &x); err == nil {
+		if x.XMLName.Local == "album" {
+			// The title of an album .nfo is the album's, and its artist the
+			// album artist.
+			return &trackHints{Artist: x.Artist, Album: x.Title, AlbumArtist: x.Artist}
+		}
+		return &trackHints{Artist: x.Artist, Title: x.Title, Album: x.Album, AlbumArtist: x.AlbumArtist}
+	}
+
+	h := &trackHints{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		m := nfoLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		switch strings.ToLower(m[1]) {
+		case "artist":
+			h.Artist = m[2]
+		case "title", "track":
+			h.Title = m[2]
+		case "album":
+			h.Album = m[2]
+		case "album artist", "albumartist":
+			h.AlbumArtist = m[2]
+		}
+	}
+	return h
+}
+
+// applyHints fills the artist, title, album, album artist and track number of
+// tag from h where they are empty and returns the number of fields set.
+// Placeholders in h are ignored. The MusicBrainz identifiers of h are written
+// unless tag already has a recording ID.
+func applyHints(tag *id3v2.Tag, h *trackHints) int {
+	n := 0
+	if tag.Artist() == "" && h.Artist != "" && !isPlaceholder(h.Artist) {
+		tag.SetArtist(h.Artist)
+		n++
+	}
+	if tag.Title() == "" && h.Title != "" && !isPlaceholder(h.Title) {
+		tag.SetTitle(h.Title)
+		n++
+	}
+	if tag.Album() == "" && h.Album != "" && !isPlaceholder(h.Album) {
+		tag.SetAlbum(h.Album)
+		n++
+	}
+	if textOf(tag, "TPE2") == "" && h.AlbumArtist != "" && !isPlaceholder(h.AlbumArtist) {
+		tag.AddTextFrame("TPE2", tag.DefaultEncoding(), h.AlbumArtist)
+		n++
+	}
+	trck := tag.CommonID("Track number/Position in set")
+	if tag.GetTextFrame(trck).Text == "" && h.Track != "" {
+		tag.AddTextFrame(trck, tag.DefaultEncoding(), h.Track)
+		n++
+	}
+	if h.MusicBrainz != nil && musicBrainzRecording(tag) == "" {
+		n += setMusicBrainzIDs(tag, h.MusicBrainz)
+	}
+	return n
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// hookConfig holds the commands run around the processing of each file, as
+// set in hooks.json in the config directory, e.g.
+// {"post_embed": "beet update %f"}. In a command, %f is replaced with the
+// quoted path of the file and %% with %.
+type hookConfig struct {
+	// PreEmbed runs before a file is processed. If it fails, the file is
+	// left alone and counts as failed.
+	PreEmbed string `json:"pre_embed,omitempty"`
+
+	// PostEmbed runs after a file was processed, also if that failed. Its
+	// failure is only reported.
+	PostEmbed string `json:"post_embed,omitempty"`
+}
+
+// hookInput is the JSON a hook reads from standard input: the file as printed
+// with -output json, whose status is "pending" for pre_embed.
+type hookInput struct {
+	Hook string `json:"hook"`
+	*fileOutput
+}
+
+// hooksPath returns the location of the hook configuration.
+func hooksPath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hooks.json"), nil
+}
+
+// loadHookConfig reads hooks.json. A missing file configures no hooks.
+func loadHookConfig() (*hookConfig, error) {
+	name, err := hooksPath()
+	if err != nil {
+		return nil, err
+	}
+	var hc hookConfig
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return &hc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &hc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &hc, nil
+}
+
+// hookFlags defines the -pre-hook, -post-hook and -hooks flags on fs. The
+// returned function reads hooks.json once fs is parsed and returns the hooks to
+// run, with the flags taking precedence.
+func hookFlags(fs *flag.FlagSet) func() (*hookConfig, error) {
+	pre := fs.String("pre-hook", "", "Command to run before each file, with %f for its path (default: pre_embed in hooks.json in the config directory)")
+	post := fs.String("post-hook", "", "Command to run after each file, with %f for its path and the result as JSON on standard input (default: post_embed in hooks.json)")
+	enabled := fs.Bool("hooks", true, "Run the hook commands; -hooks=false runs none")
+	return func() (*hookConfig, error) {
+		if !*enabled {
+			return &hookConfig{}, nil
+		}
+		hc, err := loadHookConfig()
+		if err != nil {
+			return nil, err
+		}
+		if *pre != "" {
+			hc.PreEmbed = *pre
+		}
+		if *post != "" {
+			hc.PostEmbed = *post
+		}
+		return hc, nil
+	}
+}
+
+// pre runs the pre_embed hook for the file at path.
+func (hc *hookConfig) pre(path string, opts *embedOptions) error {
+	if hc.PreEmbed == "" || opts.dryRun {
+		return nil
+	}
+	if err := runHook("pre_embed", hc.PreEmbed, &fileOutput{Path: path, Status: "pending"}, opts.output()); err != nil {
+		return fmt.Errorf("pre_embed hook: %w", err)
+	}
+	return nil
+}
+
+// post runs the post_embed hook for the file at path, which embedFile
+// processed with result and err. For its JSON to list the changes, opts.trace
+// must have been set.
+func (hc *hookConfig) post(path string, opts *embedOptions, result embedResult, err error) {
+	if hc.PostEmbed == "" || opts.dryRun {
+		return
+	}
+	if err := runHook("post_embed", hc.PostEmbed, newFileOutput(path, result, err, opts.trace, false), opts.output()); err != nil {
+		log.Printf("%s: post_embed hook: %v", path, err)
+	}
+}
+
+// runHook runs the hook command of the named hook through the shell for the
+// file out describes. The hook reads out as JSON from standard input and finds
+// the hook name, path, status and error in MP3EXTRA_HOOK, MP3EXTRA_HOOK_PATH,
+// MP3EXTRA_HOOK_STATUS and MP3EXTRA_HOOK_ERROR. Its output goes to stdout, where
+// the messages of the run go, and to standard error.
+func runHook(hook, command string, out *fileOutput, stdout io.Writer) error {
+	in, err := json.Marshal(hookInput{Hook: hook, fileOutput: out})
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		command = strings.NewReplacer("%%", "%", "%f", `"`+out.Path+`"`).Replace(command)
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		command = strings.NewReplacer("%%", "%", "%f", shellQuote(out.Path)).Replace(command)
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = progressWriter{stdout}
+	cmd.Stderr = progressWriter{os.Stderr}
+	cmd.Env = append(os.Environ(),
+		"MP3EXTRA_HOOK="+hook,
+		"MP3EXTRA_HOOK_PATH="+out.Path,
+		"MP3EXTRA_HOOK_STATUS="+out.Status,
+		"MP3EXTRA_HOOK_ERROR="+out.Error,
+	)
+	debugf("running %s hook: %s", hook, command)
+	return cmd.Run()
+}
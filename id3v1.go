@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// id3v1Size is the size of an ID3v1 tag, which occupies the end of the file.
+const id3v1Size = 128
+
+// id3v1Genres are the genres of ID3v1 by number, including the Winamp extensions.
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+	"Club-House", "Hardcore", "Terror", "Indie", "BritPop", "Afro-Punk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "JPop", "Synthpop",
+}
+
+// id3v1Tag holds the fields of an ID3v1.1 tag.
+type id3v1Tag struct {
+	Title   string
+	Artist  string
+	Album   string
+	Year    string
+	Comment string
+	Track   byte // 0 if not set
+	Genre   byte // 255 if not set
+}
+
+// String summarizes the tag for display.
+func (t *id3v1Tag) String() string {
+	s := fmt.Sprintf("%s - %s", t.Artist, t.Title)
+	var extra []string
+	for _, v := range []string{t.Album, t.Year} {
+		if v != "" {
+			extra = append(extra, v)
+		}
+	}
+	if t.Track != 0 {
+		extra = append(extra, "track "+strconv.Itoa(int(t.Track)))
+	}
+	if int(t.Genre) < len(id3v1Genres) {
+		extra = append(extra, id3v1Genres[t.Genre])
+	}
+	if len(extra) > 0 {
+		s += " (" + strings.Join(extra, ", ") + ")"
+	}
+	return s
+}
+
+// latin1 decodes an ID3v1 field, which is ISO-8859-1 padded with NULs or spaces.
+func latin1(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return strings.TrimRight(string(r), " ")
+}
+
+// putLatin1 encodes s into the ID3v1 field b, replacing characters outside of
+// ISO-8859-1 and truncating to the field size.
+func putLatin1(b []byte, s string) {
+	i := 0
+	for _, r := range s {
+		if i == len(b) {
+			break
+		}
+		if r > 0xff {
+			r = '?'
+		}
+		b[i] = byte(r)
+		i++
+	}
+}
+
+// readID3v1 returns the ID3v1 tag of the file at path, or nil if it has none.
+func readID3v1(path string) (*id3v1Tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < id3v1Size {
+		return nil, nil
+	}
+	var b [id3v1Size]byte
+	if _, err := f.ReadAt(b[:], fi.Size()-id3v1Size); err != nil {
+		return nil, err
+	}
+	if string(b[:3]) != "TAG" {
+		return nil, nil
+	}
+	return decodeID3v1(b[:]), nil
+}
+
+// decodeID3v1 parses the 128 bytes of an ID3v1 tag.
+func decodeID3v1(b []byte) *id3v1Tag {
+	t := &id3v1Tag{
+		Title:   latin1(b[3:33]),
+		Artist:  latin1(b[33:63]),
+		Album:   latin1(b[63:93]),
+		Year:    latin1(b[93:97]),
+		Comment: latin1(b[97:127]),
+		Genre:   b[127],
+	}
+	// ID3v1.1 stores the track number in the last byte of the comment.
+	if b[125] == 0 && b[126] != 0 {
+		t.Comment = latin1(b[97:125])
+		t.Track = b[126]
+	}
+	return t
+}
+
+// encode returns the binary form of the tag.
+func (t *id3v1Tag) encode() []byte {
+	b := make([]byte, id3v1Size)
+	copy(b, "TAG")
+	putLatin1(b[3:33], t.Title)
+	putLatin1(b[33:63], t.Artist)
+	putLatin1(b[63:93], t.Album)
+	putLatin1(b[93:97], t.Year)
+	putLatin1(b[97:125], t.Comment)
+	b[126] = t.Track
+	b[127] = t.Genre
+	return b
+}
+
+// writeID3v1 replaces the ID3v1 tag of the file at path with t, adding one if
+// the file has none.
+func writeID3v1(path string, t *id3v1Tag) error {
+	if err := removeID3v1(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(t.encode()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// removeID3v1 removes the ID3v1 tag of the file at path if it has one.
+func removeID3v1(path string) error {
+	t, err := readID3v1(path)
+	if err != nil || t == nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, fi.Size()-id3v1Size)
+}
+
+// genreNumber returns the ID3v1 number of the genre name, or 255 if there is none.
+func genreNumber(name string) byte {
+	for i, g := range id3v1Genres {
+		if strings.EqualFold(g, name) {
+			return byte(i)
+		}
+	}
+	return 255
+}
+
+// id3v1FromTag builds an ID3v1 tag mirroring the main fields of tag.
+func id3v1FromTag(tag *id3v2.Tag) *id3v1Tag {
+	t := &id3v1Tag{
+		Title:  tag.Title(),
+		Artist: tag.Artist(),
+		Album:  tag.Album(),
+		Year:   tag.Year(),
+		Genre:  genreNumber(tag.Genre()),
+	}
+	if len(t.Year) > 4 {
+		t.Year = t.Year[:4]
+	}
+	if comments := tag.GetFrames(tag.CommonID("Comments")); len(comments) > 0 {
+		if c, ok := comments[0].(id3v2.CommentFrame); ok {
+			t.Comment = c.Text
+		}
+	}
+	track, _, _ := strings.Cut(tag.GetTextFrame(tag.CommonID("Track number/Position in set")).Text, "/")
+	if n, err := strconv.Atoi(strings.TrimSpace(track)); err == nil && n > 0 && n < 256 {
+		t.Track = byte(n)
+	}
+	return t
+}
+
+// id3v1Modes are the ways of treating the ID3v1 tag when writing a file.
+var id3v1Modes = []string{"keep", "remove", "sync"}
+
+// checkID3v1Mode returns an error if mode is not one of id3v1Modes.
+func checkID3v1Mode(mode string) error {
+	if !slices.Contains(id3v1Modes, mode) {
+		return fmt.Errorf("invalid ID3v1 mode %q: must be one of %s", mode, strings.Join(id3v1Modes, ", "))
+	}
+	return nil
+}
+
+// applyID3v1 keeps, removes or synchronizes the ID3v1 tag of the file at path
+// according to mode, after tag has been written to it.
+func applyID3v1(path string, tag *id3v2.Tag, mode string) error {
+	switch mode {
+	case "", "keep":
+		return nil
+	case "remove":
+		return removeID3v1(path)
+	case "sync":
+		return writeID3v1(path, id3v1FromTag(tag))
+	}
+	return fmt.Errorf("unknown ID3v1 mode: %s", mode)
+}
+
+// staleID3v1 reports whether the file at path has an ID3v1 tag whose title or
+// artist disagree with tag. Many players prefer such a tag over the ID3v2 one.
+func staleID3v1(path string, tag *id3v2.Tag) (*id3v1Tag, bool) {
+	v1, err := readID3v1(path)
+	if err != nil || v1 == nil {
+		return nil, false
+	}
+	// Compare with what fits into ID3v1, as long titles are always truncated.
+	want := decodeID3v1(id3v1FromTag(tag).encode())
+	return v1, v1.Title != want.Title || v1.Artist != want.Artist
+}
+
+// reportID3v1 tells w about what happens to the ID3v1 tag of the file at path
+// when tag is saved with mode. An ID3v1 tag that is kept although it disagrees
+// with tag is pointed out, as players may show it instead.
+func reportID3v1(w io.Writer, path string, tag *id3v2.Tag, mode string, dryRun bool) {
+	v1, stale := staleID3v1(path, tag)
+	switch {
+	case dryRun && mode == "remove" && v1 != nil:
+		fmt.Fprintf(w, "Would remove ID3v1 tag: %s\n", v1)
+	case dryRun && mode == "sync":
+		fmt.Fprintf(w, "Would write ID3v1 tag: %s\n", decodeID3v1(id3v1FromTag(tag).encode()))
+	case (mode == "" || mode == "keep") && stale:
+		infoTo(w, "%s: stale ID3v1 tag (%s), use -id3v1 remove or sync", path, v1)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func TestID3v1RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  id3v1Tag
+		want id3v1Tag
+	}{
+		{
+			name: "ID3v1.1 with track",
+			tag:  id3v1Tag{Title: "Title", Artist: "Artist", Album: "Album", Year: "1999", Comment: "Comment", Track: 7, Genre: 17},
+			want: id3v1Tag{Title: "Title", Artist: "Artist", Album: "Album", Year: "1999", Comment: "Comment", Track: 7, Genre: 17},
+		},
+		{
+			name: "no track or genre",
+			tag:  id3v1Tag{Title: "Title", Genre: 255},
+			want: id3v1Tag{Title: "Title", Genre: 255},
+		},
+		{
+			name: "last known genre and highest track",
+			tag:  id3v1Tag{Title: "Title", Track: 255, Genre: byte(len(id3v1Genres) - 1)},
+			want: id3v1Tag{Title: "Title", Track: 255, Genre: byte(len(id3v1Genres) - 1)},
+		},
+		{
+			name: "Latin-1 and others",
+			tag:  id3v1Tag{Title: "Café Müller", Artist: "坂本龍一", Genre: 255},
+			want: id3v1Tag{Title: "Café Müller", Artist: "????", Genre: 255},
+		},
+		{
+			name: "truncated fields",
+			tag:  id3v1Tag{Title: strings.Repeat("t", 40), Year: "2001-05-04", Comment: strings.Repeat("c", 30), Track: 1, Genre: 0},
+			want: id3v1Tag{Title: strings.Repeat("t", 30), Year: "2001", Comment: strings.Repeat("c", 28), Track: 1, Genre: 0},
+		},
+	}
+	for _, tt := range tests {
+		b := tt.tag.encode()
+		if len(b) != id3v1Size || string(b[:3]) != "TAG" {
+			t.Errorf("%s: encoded %d bytes starting with %q", tt.name, len(b), b[:3])
+			continue
+		}
+		if got := decodeID3v1(b); *got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestDecodeID3v1(t *testing.T) {
+	// ID3v1.0 has a comment of 30 bytes and no track number.
+	v10 := make([]byte, id3v1Size)
+	copy(v10, "TAG")
+	copy(v10[3:], "Title")
+	copy(v10[97:], strings.Repeat("c", 30))
+	v10[127] = 8
+
+	// Fields padded with spaces rather than NULs, as some taggers write them.
+	spaces := bytes.Repeat([]byte(" "), id3v1Size)
+	copy(spaces, "TAGTitle")
+	spaces[125], spaces[126], spaces[127] = 0, 3, 255
+
+	// A NUL at the end of a comment with nothing after it is no track number.
+	empty := make([]byte, id3v1Size)
+	copy(empty, "TAGTitle")
+	copy(empty[97:], "Short comment")
+
+	tests := []struct {
+		name string
+		b    []byte
+		want id3v1Tag
+	}{
+		{"ID3v1.0", v10, id3v1Tag{Title: "Title", Comment: strings.Repeat("c", 30), Genre: 8}},
+		{"space padding", spaces, id3v1Tag{Title: "Title", Track: 3, Genre: 255}},
+		{"no track", empty, id3v1Tag{Title: "Title", Comment: "Short comment"}},
+		{"Latin-1", append([]byte("TAGCaf\xe9"), make([]byte, id3v1Size-7)...), id3v1Tag{Title: "Café"}},
+	}
+	for _, tt := range tests {
+		if got := decodeID3v1(tt.b); *got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestGenreNumber(t *testing.T) {
+	tests := []struct {
+		name string
+		want byte
+	}{
+		{"Blues", 0},
+		{"rock", 17},
+		{"HIP-HOP", 7},
+		{"Synthpop", byte(len(id3v1Genres) - 1)},
+		{"Vaporwave", 255},
+		{"", 255},
+	}
+	for _, tt := range tests {
+		if got := genreNumber(tt.name); got != tt.want {
+			t.Errorf("genreNumber(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestID3v1FromTag(t *testing.T) {
+	tests := []struct {
+		track, genre, year string
+		wantTrack, genreNo byte
+		wantYear           string
+	}{
+		{"7", "Jazz", "1959", 7, 8, "1959"},
+		{"3/12", "jazz", "2001-05-04", 3, 8, "2001"},
+		{" 12 / 14", "Unknown Genre", "", 12, 255, ""},
+		{"300", "", "", 0, 255, ""},
+		{"0", "", "", 0, 255, ""},
+		{"A1", "", "", 0, 255, ""},
+	}
+	for _, tt := range tests {
+		tag := id3v2.NewEmptyTag()
+		tag.SetTitle("Title")
+		tag.SetGenre(tt.genre)
+		tag.SetYear(tt.year)
+		tag.AddTextFrame(tag.CommonID("Track number/Position in set"), tag.DefaultEncoding(), tt.track)
+		got := id3v1FromTag(tag)
+		if got.Track != tt.wantTrack || got.Genre != tt.genreNo || got.Year != tt.wantYear {
+			t.Errorf("track %q, genre %q, year %q: got track %d, genre %d, year %q; want %d, %d, %q",
+				tt.track, tt.genre, tt.year, got.Track, got.Genre, got.Year, tt.wantTrack, tt.genreNo, tt.wantYear)
+		}
+	}
+}
+
+func TestID3v1File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v1.mp3")
+	audio := []byte("\xff\xfb\x90\x00 audio")
+	if err := os.WriteFile(path, audio, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v1, err := readID3v1(path); err != nil || v1 != nil {
+		t.Fatalf("readID3v1 without a tag = %v, %v", v1, err)
+	}
+
+	tag := id3v2.NewEmptyTag()
+	tag.SetTitle("New Title")
+	tag.SetArtist("Artist")
+	for _, title := range []string{"Old Title", "New Title"} {
+		if err := writeID3v1(path, &id3v1Tag{Title: title, Artist: "Artist", Genre: 255}); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != int64(len(audio)+id3v1Size) {
+			t.Fatalf("size %d after writing ID3v1 tag, want %d", fi.Size(), len(audio)+id3v1Size)
+		}
+		v1, stale := staleID3v1(path, tag)
+		if v1 == nil || v1.Title != title {
+			t.Fatalf("readID3v1 = %+v, want title %q", v1, title)
+		}
+		if stale != (title == "Old Title") {
+			t.Errorf("staleID3v1 with title %q = %v", title, stale)
+		}
+	}
+
+	tag.SetTitle("Synced Title")
+	if err := applyID3v1(path, tag, "sync"); err != nil {
+		t.Fatal(err)
+	}
+	if v1, stale := staleID3v1(path, tag); v1 == nil || v1.Title != "Synced Title" || stale {
+		t.Errorf("after sync: %+v, stale %v", v1, stale)
+	}
+
+	if err := applyID3v1(path, tag, "remove"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, audio) {
+		t.Errorf("after removing the ID3v1 tag: %q, want %q", got, audio)
+	}
+	if err := applyID3v1(path, tag, "remove"); err != nil {
+		t.Errorf("removing a missing ID3v1 tag: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// appDir returns the directory holding mp3extra's persistent state, creating it if needed.
+func appDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "mp3extra")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// journalEntry records a single modification of a file.
+type journalEntry struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"` // "write" or "undo"
+	Path string    `json:"path"`
+
+	// Snapshot is the digest of the raw tag the file had before a write.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Plan is the digest of the operations a write performed, and Size and
+	// ModTime describe the file right after it.
+	Plan    string    `json:"plan,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime,omitempty"`
+}
+
+// journalPath returns the location of the journal file.
+func journalPath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "journal.jsonl"), nil
+}
+
+// journalCache holds the journal entries per path once the journal has been
+// read. journalMu guards it against concurrent requests to serve.
+var (
+	journalCache map[string][]*journalEntry
+	journalMu    sync.Mutex
+)
+
+// appendJournal adds e to the journal. The state lock keeps lines written by
+// concurrent processes from interleaving.
+func appendJournal(e *journalEntry) error {
+	name, err := journalPath()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return withStateLock(func() error {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+		journalMu.Lock()
+		if journalCache != nil {
+			journalCache[e.Path] = append(journalCache[e.Path], e)
+		}
+		journalMu.Unlock()
+		return f.Close()
+	})
+}
+
+// scanJournal calls fn for every entry of the journal, oldest first.
+func scanJournal(fn func(e *journalEntry)) error {
+	name, err := journalPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Skip lines torn by an interrupted write.
+			continue
+		}
+		fn(&e)
+	}
+	return scanner.Err()
+}
+
+// loadJournal reads the whole journal into journalCache.
+func loadJournal() error {
+	cache := map[string][]*journalEntry{}
+	err := scanJournal(func(e *journalEntry) {
+		cache[e.Path] = append(cache[e.Path], e)
+	})
+	if err != nil {
+		return err
+	}
+	journalCache = cache
+	return nil
+}
+
+// readJournal returns all journal entries recorded for path, oldest first.
+// The journal is read only once per run, except in low-memory mode where it is
+// scanned again for every path instead of being kept in memory.
+func readJournal(path string) ([]*journalEntry, error) {
+	if lowMemory {
+		var entries []*journalEntry
+		err := scanJournal(func(e *journalEntry) {
+			if e.Path == path {
+				entries = append(entries, e)
+			}
+		})
+		return entries, err
+	}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if journalCache == nil {
+		if err := loadJournal(); err != nil {
+			return nil, err
+		}
+	}
+	return journalCache[path], nil
+}
+
+// snapshotPath returns where the snapshot with the given digest is stored.
+func snapshotPath(digest string) (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "snapshots", digest+".id3"), nil
+}
+
+// readRawTag returns the raw ID3v2 tag of the file at path, or nil if it has none.
+func readRawTag(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := id3v2TagSize(f)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// takeSnapshot stores the current raw tag of the file at path so that an upcoming
+// modification can be undone, and returns the digest identifying the snapshot.
+func takeSnapshot(path string) (string, error) {
+	raw, err := readRawTag(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	digest := hex.EncodeToString(sum[:])
+	name, err := snapshotPath(digest)
+	if err != nil {
+		return "", err
+	}
+	// Snapshots are content-addressed, so an existing one is already correct.
+	if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(name, raw, 0644); err != nil {
+			return "", err
+		}
+	}
+	return digest, nil
+}
+
+// recordWrite adds a journal entry for a completed write of the file at path.
+func recordWrite(path, snapshot, plan string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	return appendJournal(&journalEntry{
+		Time:     time.Now(),
+		Op:       "write",
+		Path:     abs,
+		Snapshot: snapshot,
+		Plan:     plan,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+	})
+}
+
+// replaceRawTag rewrites the file at path with raw as its ID3v2 tag, keeping the
+// audio data that follows the current tag.
+func replaceRawTag(path string, raw []byte) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	size, err := id3v2TagSize(src)
+	if err != nil {
+		return err
+	}
+	if _, err := src.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	return replaceFile(path, func(w io.Writer) error {
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, src); err != nil {
+			return err
+		}
+		// Windows cannot replace open files.
+		return src.Close()
+	})
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "os"
+
+// tryLockFile is not implemented on this platform, so the state is only
+// protected against concurrent updates within a process.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlockFile is not implemented on this platform.
+func unlockFile(f *os.File) error {
+	return nil
+}
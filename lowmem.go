@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+// Memory ceilings enforced in low-memory mode, which is meant for Raspberry Pi
+// class devices. Files are processed one at a time, so the peak usage is roughly
+// the largest tag plus the new cover art and lyrics.
+const (
+	lowMemoryLimit     = 64 << 20 // soft limit for the Go heap
+	lowMemoryMaxTag    = 16 << 20 // largest existing ID3v2 tag that is loaded
+	lowMemoryMaxImage  = 4 << 20  // largest cover image that is read or fetched
+	lowMemoryMaxLyrics = 1 << 20  // largest lyrics file that is read
+)
+
+// lowMemory is set when running in low-memory mode.
+var lowMemory bool
+
+// The ceilings in effect, in bytes; 0 means no limit. Low-memory mode sets the
+// first three, -max-memory sets maxImageMemory and lowers maxImageBytes to it.
+var (
+	maxTagBytes    int64 // largest existing ID3v2 tag that is loaded
+	maxImageBytes  int64 // largest image that is read or fetched
+	maxLyricsBytes int64 // largest lyrics file that is read
+	maxImageMemory int64 // most memory an image may take when decoded
+)
+
+// errTooLarge is returned when data exceeds a memory ceiling.
+var errTooLarge = errors.New("too large")
+
+// memoryFlags defines the flags that bound memory usage on fs. The returned
+// function applies them once fs is parsed.
+func memoryFlags(fs *flag.FlagSet) func() error {
+	lowMem := fs.Bool("low-memory", false, "Keep memory usage within fixed limits for small devices such as a Raspberry Pi")
+	maxMem := fs.Int64("max-memory", 0, "Fail files whose cover image takes more than this many bytes, as read or fetched and when decoded to be resized (e.g., 50000000)")
+	return func() error {
+		if *maxMem < 0 {
+			return fmt.Errorf("invalid -max-memory %d", *maxMem)
+		}
+		if *lowMem {
+			enableLowMemory()
+		}
+		if *maxMem > 0 {
+			maxImageMemory = *maxMem
+			if maxImageBytes == 0 || *maxMem < maxImageBytes {
+				maxImageBytes = *maxMem
+			}
+		}
+		return nil
+	}
+}
+
+// enableLowMemory switches to low-memory mode. A memory limit given through
+// GOMEMLIMIT takes precedence over lowMemoryLimit.
+func enableLowMemory() {
+	lowMemory = true
+	maxTagBytes, maxImageBytes, maxLyricsBytes = lowMemoryMaxTag, lowMemoryMaxImage, lowMemoryMaxLyrics
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(lowMemoryLimit)
+	}
+	debug.SetGCPercent(50)
+}
+
+// checkSize fails if size exceeds max, unless max is 0.
+func checkSize(what string, size, max int64) error {
+	if max > 0 && size > max {
+		return fmt.Errorf("%s of %d bytes is %w (limit %d)", what, size, errTooLarge, max)
+	}
+	return nil
+}
+
+// readAllLimited is like io.ReadAll, but unless max is 0 it stops reading and
+// fails once more than max bytes have been read.
+func readAllLimited(r io.Reader, what string, max int64) ([]byte, error) {
+	if max == 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSize(what, int64(len(b)), max); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readFileLimited is like os.ReadFile, but unless max is 0 it refuses files
+// larger than max.
+func readFileLimited(name, what string, max int64) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil {
+		if err := checkSize(what, fi.Size(), max); err != nil {
+			return nil, err
+		}
+	}
+	return readAllLimited(f, what, max)
+}
+
+// checkTagLimit fails in low-memory mode if the ID3v2 tag of the file at path is
+// too large to be loaded.
+func checkTagLimit(path string) error {
+	if maxTagBytes == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size, err := id3v2TagSize(f)
+	if err != nil {
+		return err
+	}
+	return checkSize("ID3v2 tag", size, maxTagBytes)
+}
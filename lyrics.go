@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "lyrics",
+		usage: "Print the lyrics embedded in MP3 files",
+		run:   runLyrics,
+	})
+}
+
+// syncedLine is one line of synchronised lyrics.
+type syncedLine struct {
+	Time uint32 // in milliseconds or MPEG frames, see syncedLyrics.Format
+	Text string
+}
+
+// syncedLyrics is the content of a SYLT frame, which the id3v2 library does not
+// parse.
+type syncedLyrics struct {
+	Language   string
+	Descriptor string
+	Format     byte // 1 for MPEG frames, 2 for milliseconds
+	Lines      []syncedLine
+}
+
+// errBadSYLT is returned for SYLT frames that end prematurely.
+var errBadSYLT = errors.New("malformed SYLT frame")
+
+// splitTerminated splits b at the terminator of a string in the ID3v2 text
+// encoding enc. If there is no terminator, all of b is the string.
+func splitTerminated(b []byte, enc byte) (s, rest []byte) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return b[:i], b[i+2:]
+			}
+		}
+		return b, nil
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i], b[i+1:]
+	}
+	return b, nil
+}
+
+// decodeText decodes the string b in the ID3v2 text encoding enc.
+func decodeText(b []byte, enc byte) string {
+	var s []byte
+	var err error
+	switch enc {
+	case 0:
+		s, err = charmap.ISO8859_1.NewDecoder().Bytes(b)
+	case 1:
+		s, err = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Bytes(b)
+	case 2:
+		s, err = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder().Bytes(b)
+	default:
+		s = b
+	}
+	if err != nil {
+		return string(b)
+	}
+	return string(s)
+}
+
+// parseSYLT parses the body of a SYLT frame.
+func parseSYLT(b []byte) (*syncedLyrics, error) {
+	if len(b) < 6 {
+		return nil, errBadSYLT
+	}
+	enc := b[0]
+	sl := &syncedLyrics{Language: string(b[1:4]), Format: b[4]}
+	desc, b := splitTerminated(b[6:], enc)
+	sl.Descriptor = decodeText(desc, enc)
+	for len(b) > 0 {
+		var text []byte
+		text, b = splitTerminated(b, enc)
+		if len(b) < 4 {
+			return nil, errBadSYLT
+		}
+		t := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+		sl.Lines = append(sl.Lines, syncedLine{Time: t, Text: decodeText(text, enc)})
+		b = b[4:]
+	}
+	return sl, nil
+}
+
+// lrc returns the lyrics in LRC format. Times given in MPEG frames cannot be
+// converted without the audio, so they are written as frame numbers.
+func (sl *syncedLyrics) lrc() string {
+	var sb strings.Builder
+	for _, l := range sl.Lines {
+		// Lines often begin with a line feed to mark a new line of the song.
+		text := strings.TrimLeft(l.Text, "\r\n")
+		if sl.Format == 2 {
+			fmt.Fprintf(&sb, "[%02d:%02d.%02d]%s\n", l.Time/60000, l.Time/1000%60, l.Time/10%100, text)
+		} else {
+			fmt.Fprintf(&sb, "[#%d]%s\n", l.Time, text)
+		}
+	}
+	return sb.String()
+}
+
+// lyricsFilter selects lyrics frames by language and descriptor.
+type lyricsFilter struct {
+	lang string // if set, only lyrics in this language
+	desc string // if set, only lyrics with this descriptor
+}
+
+// match reports whether lyrics with the given language and descriptor are
+// selected.
+func (lf *lyricsFilter) match(lang, desc string) bool {
+	return (lf.lang == "" || strings.EqualFold(lang, lf.lang)) && (lf.desc == "" || desc == lf.desc)
+}
+
+// unsyncedLyrics returns the USLT lyrics of tag selected by lf.
+func unsyncedLyrics(tag *id3v2.Tag, lf *lyricsFilter) []string {
+	var texts []string
+	for _, f := range tag.GetFrames(tag.CommonID("Unsynchronised lyrics/text transcription")) {
+		if uslf, ok := f.(id3v2.UnsynchronisedLyricsFrame); ok && lf.match(uslf.Language, uslf.ContentDescriptor) {
+			texts = append(texts, uslf.Lyrics)
+		}
+	}
+	return texts
+}
+
+// syncedLyricsText returns the SYLT lyrics of tag selected by lf in LRC format.
+func syncedLyricsText(tag *id3v2.Tag, lf *lyricsFilter) ([]string, error) {
+	var texts []string
+	for _, f := range tag.GetFrames("SYLT") {
+		uf, ok := f.(id3v2.UnknownFrame)
+		if !ok {
+			continue
+		}
+		sl, err := parseSYLT(uf.Body)
+		if err != nil {
+			return nil, err
+		}
+		if lf.match(sl.Language, sl.Descriptor) {
+			texts = append(texts, sl.lrc())
+		}
+	}
+	return texts, nil
+}
+
+// runLyrics implements the lyrics command.
+func runLyrics(fs *flag.FlagSet) func() error {
+	lf := &lyricsFilter{}
+	var synced bool
+	fs.StringVar(&lf.lang, "lang", "", "Only print lyrics in this language (e.g., jpn, eng)")
+	fs.StringVar(&lf.desc, "desc", "", "Only print lyrics with this content descriptor")
+	fs.BoolVar(&synced, "synced", false, "Print the synchronised lyrics (SYLT) in LRC format instead of the plain ones (USLT)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lyrics [flags] file.mp3...\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Without -synced, synchronised lyrics are printed only for files without plain ones.\n")
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		for i, name := range fs.Args() {
+			tag, err := openTag(name)
+			if err != nil {
+				return fmt.Errorf("error opening MP3 file: %w", err)
+			}
+			var texts []string
+			if !synced {
+				texts = unsyncedLyrics(tag, lf)
+			}
+			if len(texts) == 0 {
+				texts, err = syncedLyricsText(tag, lf)
+			}
+			tag.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if len(texts) == 0 {
+				return fmt.Errorf("%s: no lyrics", name)
+			}
+
+			if fs.NArg() > 1 {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("==> %s <==\n", name)
+			}
+			for j, text := range texts {
+				if j > 0 {
+					fmt.Println()
+				}
+				fmt.Print(text)
+				if !strings.HasSuffix(text, "\n") {
+					fmt.Println()
+				}
+			}
+		}
+		return nil
+	}
+}
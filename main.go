@@ -1,21 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"reflect"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
-
-	"github.com/bogem/id3v2/v2"
 )
 
+// lrclibRecordURL returns the API URL of the lrclib record with the given ID.
+func lrclibRecordURL(id int) string {
+	return "https://lrclib.net/api/get/" + strconv.Itoa(id)
+}
+
 // lrclibResult represents a single result from the LRC lyrics API.
 // It holds various metadata for a track as returned by the API.
 type lrclibResult struct {
@@ -30,254 +35,475 @@ type lrclibResult struct {
 	SyncedLyrics string  `json:"syncedLyrics"`
 }
 
-// downloadLrc fetches synchronized lyrics from the LRC API for a given artist and title.
-// It returns the synced lyrics if a matching record is found.
-func downloadLrc(artist, title string) (string, error) {
-	// Build the API URL with query parameters.
-	resp, err := http.Get("https://lrclib.net/api/search?q=" + url.QueryEscape(artist+" "+title))
+// errLyricsNotFound is returned when the lyrics provider has no lyrics for a track.
+var errLyricsNotFound = errors.New("lyrics not found")
+
+// getJSON gets u and decodes the JSON response into v. Responses with an error
+// status are returned as errors.
+func getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
-		return "", err
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", req.URL.Host, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
 
-	// Decode the JSON response into a slice of lrclibResult.
+// searchLrclib returns the records lrclib finds for a given artist and title.
+func searchLrclib(ctx context.Context, artist, title string) ([]lrclibResult, error) {
 	var results []lrclibResult
-	err = json.NewDecoder(resp.Body).Decode(&results)
-	if err != nil {
-		return "", err
+	if err := getJSON(ctx, "https://lrclib.net/api/search?q="+url.QueryEscape(artist+" "+title), &results); err != nil {
+		return nil, err
 	}
+	return results, nil
+}
 
-	// Iterate through the results and return the synced lyrics for an exact match.
-	for _, r := range results {
-		if r.ArtistName == artist && r.TrackName == title {
-			return r.SyncedLyrics, nil
-		}
+// minLyricsScore is the lowest score, see lyricsScore, of lrclib records that
+// are taken as lyrics of a track.
+const minLyricsScore = 0.7
+
+// lyrics returns the synchronised lyrics of r, or the plain ones if there are
+// none.
+func (r *lrclibResult) lyrics() string {
+	if r.SyncedLyrics != "" {
+		return r.SyncedLyrics
 	}
-	return "", fmt.Errorf("lyrics not found for %s - %s", artist, title)
+	return r.PlainLyrics
 }
 
 // itunesResult represents the JSON structure returned by the iTunes API.
 // It holds the results array containing album art information.
 type itunesResult struct {
-	Results []struct {
-		ArtworkURL100 string `json:"artworkUrl100"`
-	} `json:"results"`
+	Results []itunesTrack `json:"results"`
 }
 
+// itunesTrack is a single track found by the iTunes API.
+type itunesTrack struct {
+	ArtworkURL100  string `json:"artworkUrl100"`
+	ArtistName     string `json:"artistName"`
+	TrackName      string `json:"trackName"`
+	CollectionName string `json:"collectionName"`
+}
+
+// errArtNotFound is returned when the art provider has no album art for a track.
+var errArtNotFound = errors.New("album art not found")
+
 // coverArtUrl constructs the iTunes API URL to search for album art using artist and title.
 func coverArtUrl(artist, title string) string {
-	return "https://itunes.apple.com/search?term=" + url.QueryEscape(artist+" "+title) + "&media=music&limit=1"
+	return itunesSearchURL(artist, title, 1)
 }
 
-// fetchAlbumArtURL retrieves the album art image from the iTunes API.
-// It first queries the API to get the artwork URL, then replaces the size to fetch a higher resolution image.
-// Returns the image data, its content type, or an error.
-func fetchAlbumArtURL(u string) ([]byte, string, error) {
-	// First API call to fetch the artwork URL.
-	resp, err := http.Get(u)
-	if err != nil {
-		return nil, "", err
-	}
-	defer resp.Body.Close()
+// itunesSearchURL constructs the iTunes API URL to search for up to limit tracks.
+func itunesSearchURL(artist, title string, limit int) string {
+	return "https://itunes.apple.com/search?term=" + url.QueryEscape(artist+" "+title) + "&media=music&limit=" + strconv.Itoa(limit)
+}
 
-	// Decode the JSON response from iTunes.
+// searchITunes returns up to limit tracks the iTunes API finds for artist and title.
+func searchITunes(ctx context.Context, artist, title string, limit int) ([]itunesTrack, error) {
 	var result itunesResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, "", err
-	}
-
-	// Check if any result was returned.
-	if len(result.Results) == 0 {
-		return nil, "", fmt.Errorf("album art not found")
+	if err := getJSON(ctx, itunesSearchURL(artist, title, limit), &result); err != nil {
+		return nil, err
 	}
+	return result.Results, nil
+}
 
+// artworkURL returns the URL of the high resolution version of an iTunes artwork,
+// given its 100x100 artwork URL.
+func artworkURL(url100 string) string {
 	// Modify the URL to request a larger image (600x600 instead of 100x100).
-	resp, err = http.Get(strings.Replace(result.Results[0].ArtworkURL100, "100x100", "600x600", 1))
+	return artworkURLSize(url100, 600)
+}
+
+// artworkURLSize returns the URL of an iTunes artwork scaled to size×size pixels.
+func artworkURLSize(url100 string, size int) string {
+	return strings.Replace(url100, "100x100", fmt.Sprintf("%dx%d", size, size), 1)
+}
+
+// fetchArtworkSize downloads the artwork of an iTunes track, given its 100x100
+// artwork URL, scaled to size×size pixels.
+func fetchArtworkSize(ctx context.Context, url100 string, size int) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, artworkURLSize(url100, size), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	// Read the image bytes.
-	b, err := io.ReadAll(resp.Body)
+	b, err := readAllLimited(resp.Body, "album art image", maxImageBytes)
 	if err != nil {
 		return nil, "", err
 	}
 	return b, resp.Header.Get("content-type"), nil
 }
 
-// main is the entry point of the program. It parses command-line flags,
-// opens the MP3 file, and conditionally embeds album art and lyrics based on the provided flags.
-func main() {
-	// Define command-line flags.
-	var embedImage, embedLyrics, embedLang string
-	var dryRun bool
-	flag.StringVar(&embedImage, "image", "", "Path to image file to embed or 'auto' for automatic cover art fetch")
-	flag.StringVar(&embedLyrics, "lyrics", "", "Path to lyrics file to embed or 'auto' for automatic lyrics fetch")
-	flag.StringVar(&embedLang, "lang", "jpn", "Language code for embedded tag (e.g., jpn, eng)")
-	flag.BoolVar(&dryRun, "dryrun", false, "Perform a dry run without modifying the file")
-	flag.Parse()
-
-	// Get the MP3 file from command-line arguments.
-	mp3File := flag.Arg(0)
-	if mp3File == "" {
-		flag.Usage()
-		os.Exit(1)
+// command is a subcommand of mp3extra, selected by the first command-line argument.
+type command struct {
+	name  string
+	usage string
+
+	// run defines the flags of the command on fs and returns the function that
+	// runs it once fs is parsed, so that the flags can be listed without running
+	// the command. Flags are bound to variables of the call only, as defining
+	// them again to list them must not reset package variables set by those of
+	// the running command.
+	run func(fs *flag.FlagSet) func() error
+}
+
+// flags returns the flag set of c, with its flags defined.
+func (c *command) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	c.run(fs)
+	return fs
+}
+
+// exec runs c with the command line args.
+func (c *command) exec(args []string) error {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	run := c.run(fs)
+	parseFlags(fs, args)
+	return run()
+}
+
+// commands holds all registered subcommands keyed by name.
+var commands = map[string]*command{}
+
+// registerCommand makes a subcommand available from the command line.
+func registerCommand(c *command) {
+	commands[c.name] = c
+}
+
+// usage prints the top-level help including the list of subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] file.mp3|dir...\n", os.Args[0])
+	fmt.Fprintf(out, "       %s <command> [flags] [args...]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-12s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nFlags can also be set in the environment, as in %s for -overwrite of the default mode or %s for -interval of watch;\n", envName("embed-overwrite"), envName("watch-interval"))
+	fmt.Fprintf(out, "options shared by the commands, such as -lang, also as in %s for all of them.\n", envName("lang"))
+}
 
-	// Open the MP3 file with ID3v2 tags.
-	tag, err := id3v2.Open(mp3File, id3v2.Options{Parse: true})
-	if err != nil {
-		log.Fatalf("Error opening MP3 file: %v", err)
+func init() {
+	registerCommand(&command{
+		name:  "embed",
+		usage: "Embed cover art and lyrics like the default mode, also into a file streamed from standard input (-) or a URL",
+		run: func(fs *flag.FlagSet) func() error {
+			fs.Usage = func() {
+				fmt.Fprintf(fs.Output(), "Usage: %s embed [flags] file.mp3|dir...\n", os.Args[0])
+				fmt.Fprintf(fs.Output(), "       %s embed [flags] - < in.mp3 > out.mp3\n", os.Args[0])
+				fmt.Fprintf(fs.Output(), "       %s embed [flags] -o out.mp3 https://...\n", os.Args[0])
+				fs.PrintDefaults()
+			}
+			return embedFlags(fs, nil)
+		},
+	})
+}
+
+// main is the entry point of the program. It dispatches to a subcommand if one is given,
+// otherwise it parses command-line flags and embeds album art and lyrics into every
+// MP3 file given as argument based on the provided flags.
+func main() {
+	if err := setProxyFromEnv(); err != nil {
+		exit(err)
 	}
-	defer tag.Close()
-
-	// If dryRun is enabled, print out all current ID3v2 frames for review.
-	if dryRun {
-		frames := tag.AllFrames()
-		var ks []string
-		for k := range frames {
-			ks = append(ks, k)
-		}
-		sort.Strings(ks)
-		for _, k := range ks {
-			for _, v := range frames[k] {
-				var s string
-				// Switch on the type of frame to extract a summary string.
-				switch t := v.(type) {
-				case id3v2.TextFrame:
-					s = t.Text
-				case id3v2.CommentFrame:
-					s = t.Text
-				case id3v2.PictureFrame:
-					s = t.Description
-				default:
-					s = fmt.Sprint(v)
-				}
-				// Truncate output to avoid overly long strings.
-				if len(s) > 70 {
-					s = s[:70] + "..."
-				}
-				s += " [" + reflect.TypeOf(v).Name() + "]"
-				fmt.Printf("%v: %v\n", k, s)
+	if err := setTLSFromEnv(); err != nil {
+		exit(err)
+	}
+	// Dispatch to a subcommand if the first argument names one.
+	if len(os.Args) > 1 {
+		if c, ok := commands[os.Args[1]]; ok {
+			if err := c.exec(os.Args[2:]); err != nil {
+				exit(err)
 			}
+			return
 		}
 	}
 
-	// Set the default text encoding for added frames.
-	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
-
-	// Normalize the comment tag to ensure compatibility with different tag editors.
-	// Some editors do not handle multiple text encodings well.
-	comments := tag.GetFrames(tag.CommonID("Comments"))
-	if len(comments) > 0 {
-		comment := id3v2.CommentFrame{
-			Encoding:    id3v2.EncodingISO,
-			Language:    embedLang,
-			Description: comments[0].(id3v2.CommentFrame).Description,
-			Text:        comments[0].(id3v2.CommentFrame).Text,
-		}
-		tag.DeleteFrames(tag.CommonID("Comments"))
-		tag.AddCommentFrame(comment)
+	flag.Usage = usage
+	if err := runEmbed(flag.CommandLine, os.Args[1:], nil); err != nil {
+		exit(err)
 	}
+}
 
-	// Process embedding of album art if the image flag is provided.
-	if embedImage != "" {
-		// If "auto" is specified, automatically fetch album art via iTunes API.
-		if embedImage == "auto" {
-			u := coverArtUrl(tag.Artist(), tag.Title())
-			if dryRun {
-				fmt.Println()
-				fmt.Println("Cover art URL:", u)
-			} else {
-				b, ct, err := fetchAlbumArtURL(u)
-				if err != nil {
-					log.Fatalf("Error fetching album art image: %v", err)
-				}
-				pic := id3v2.PictureFrame{
-					Encoding:    id3v2.EncodingISO,
-					MimeType:    ct,
-					PictureType: id3v2.PTFrontCover,
-					Description: "Cover Art",
-					Picture:     b,
-				}
-				tag.DeleteFrames(tag.CommonID("Attached picture"))
-				tag.AddAttachedPicture(pic)
+// runEmbed runs the default embed mode with the command line args, defining its
+// flags on fs. If prev is not nil, the run retries files of that report and
+// updates it with the outcome.
+func runEmbed(fs *flag.FlagSet, args []string, prev *runReport) error {
+	run := embedFlags(fs, prev)
+	parseFlags(fs, args)
+	return run()
+}
+
+// embedFlags defines the flags of the default embed mode on fs and returns the
+// function that runs it once fs is parsed. prev is as for runEmbed.
+func embedFlags(fs *flag.FlagSet, prev *runReport) func() error {
+	// Define command-line flags.
+	var opts embedOptions
+	fs.StringVar(&opts.image, "image", "", "Path to image file to embed or 'auto' for automatic cover art fetch")
+	pictureType := fs.String("picture-type", "front", "Picture type to embed the image as: "+strings.Join(pictureTypeNames(), ", "))
+	fs.IntVar(&opts.imageMaxSize, "image-max-size", 0, "Scale images larger than this many pixels in width or height down to fit, re-encoding them as JPEG (e.g., 600)")
+	fs.IntVar(&opts.imageQuality, "image-quality", 0, "Re-encode images as JPEG with this quality from 1 to 100 (90 if only -image-max-size is given)")
+	fs.IntVar(&opts.maxArtBytes, "max-art-bytes", 0, "Recompress images larger than this many bytes as JPEG until they fit, as some players ignore tags with large pictures (e.g., 200000)")
+	fs.BoolVar(&opts.maxArtReject, "max-art-reject", false, "Fail files whose image is larger than -max-art-bytes instead of recompressing it")
+	fs.BoolVar(&opts.webOptimize, "web-optimize", false, "Keep only the main text frames and a small front cover (300 pixels, 32 KB unless -image-max-size or -max-art-bytes say otherwise) for progressive HTTP streaming")
+	fs.BoolVar(&opts.imageAdd, "image-add", false, "Keep pictures of other types and only replace those of the same type")
+	fs.Var(&opts.lyrics, "lyrics", "Lyrics to embed as [lang[:descriptor]=]file|auto, where 'auto' fetches them automatically; replaces only the lyrics in that language and descriptor (may be repeated)")
+	fs.StringVar(&opts.lang, "lang", "jpn", "Language code for embedded tag (e.g., jpn, eng)")
+	fs.StringVar(&opts.translateTo, "translate", "", "Add a translation of the lyrics in the language of -lang into this language (e.g., eng), using the API keys in translate.json in the config directory")
+	translateProvider := fs.String("translate-provider", "", "Translation provider: deepl, google or libretranslate (default: provider in translate.json)")
+	fs.BoolVar(&opts.dryRun, "dryrun", false, "Perform a dry run without modifying the file")
+	fs.BoolVar(&opts.save.backup, "backup", false, "Back up the MP3 file before writing and remove the backup once the write is verified")
+	fs.StringVar(&opts.save.backupDir, "backup-dir", "", "Directory for backups instead of file.mp3.bak (implies -backup)")
+	fs.BoolVar(&opts.save.verifyAudio, "verify-audio", false, "Check after writing that the audio frames are unchanged (always done with -backup)")
+	fs.BoolVar(&opts.save.preserveMtime, "preserve-mtime", false, "Keep the modification time of the MP3 files, for sync tools and players that take a newer file for a changed recording")
+	fs.BoolVar(&opts.save.snapshot, "snapshot", true, "Record the original tags so the write can be reverted with 'mp3extra undo'")
+	fs.BoolVar(&opts.notify, "notify", false, "Show a desktop notification when the file was tagged or needs review")
+	fs.BoolVar(&opts.interactive, "interactive", false, "Review the matched track, lyrics and cover art and confirm before writing each file")
+	fs.StringVar(&opts.lyricsSourceFrame, "lyrics-source-frame", "", "Record the source URL of fetched lyrics in a TXXX frame with this description (e.g., LYRICS_SOURCE)")
+	fs.StringVar(&opts.artSourceFrame, "art-source-frame", "", "Record the URL of fetched cover art in a TXXX frame with this description (e.g., ARTWORK_SOURCE)")
+	fs.StringVar(&opts.albumArtist, "album-artist", "", "Set the album artist (TPE2) of every file, which groups the tracks of an album for cover art lookups")
+	fs.BoolVar(&opts.hints, "hints", true, "Fill in a missing artist, title and album from .info.json or .nfo files next to the MP3 file")
+	fs.BoolVar(&opts.fingerprint, "fingerprint", false, "Identify files without any tag by their audio fingerprint via AcoustID (requires fpcalc and an AcoustID key, see auth)")
+	fs.StringVar(&opts.fixEncoding, "fix-encoding", "", "Re-decode text frames marked as ISO-8859-1 in this legacy encoding (e.g., cp1251, shift_jis, gbk) and rewrite them as Unicode")
+	fs.StringVar(&opts.romanize, "romanize", "", "Write romanized Cyrillic, Greek, kana and Hangul titles, artists and albums into the sort frames ('sort') or TXXX frames ('txxx')")
+	fs.BoolVar(&opts.sortTags, "sort-tags", false, "Write the sort frames (TSOT, TSOP, TSOA, TSO2) of titles, artists and albums that start with 'The' or are in Cyrillic, Greek, kana or Hangul, so that players list them in order")
+	fs.BoolVar(&opts.normalizeGenre, "normalize-genre", false, "Replace numeric genres such as '(17)' and variant spellings such as 'Hip Hop' with canonical genre names, extended by genres.json in the config directory")
+	fs.BoolVar(&opts.quarantine, "quarantine", false, "Queue automatic lookups without an exact match for 'mp3extra review' instead of failing")
+	overwrite := fs.String("overwrite", "all", "Which cover art and lyrics that files already have are replaced: "+strings.Join(overwritePolicies, ", ")+"; the others are only added where missing")
+	onlyMissing := fs.Bool("only-missing", false, "Leave cover art and lyrics alone that files already have, only adding missing ones (same as -overwrite none)")
+	fs.IntVar(&opts.minArtSize, "min-art-size", 0, "Where cover art is kept, still replace art smaller than this many pixels in width or height (e.g., 500)")
+	showProgress := fs.Bool("progress", true, "Show a progress bar with the rate and time left when processing several files and standard output is a terminal")
+	prefetch := fs.Int("prefetch", 4, "Look up this many files ahead while earlier ones are written, so that slow lookups and slow writes overlap; 0 processes one file at a time")
+	artCache := fs.Bool("art-cache", true, "Reuse cover art fetched in earlier runs for the same album or image URL instead of downloading it again")
+	albumArt := fs.Bool("album-art", true, "Fetch cover art once per album and directory and embed it in all tracks of the album")
+	fs.BoolVar(&opts.pick, "pick", false, "List the candidates of automatic lookups with several results and ask which one to use")
+	fs.StringVar(&opts.save.id3v1, "id3v1", "keep", "What to do with the ID3v1 tag at the end of the file: keep, remove, or sync to mirror the main fields for legacy players")
+	manifest := manifestFlag(fs)
+	setupLogs := logFlags(fs)
+	setupProviders := providerFlags(fs)
+	setupTLS := tlsFlags(fs)
+	setupHooks := hookFlags(fs)
+	report := fs.String("report", "", "Write a JSON report of the run to this file, for use with 'mp3extra retry'")
+	fs.BoolVar(&opts.force, "force", false, "Process files even if the same operations were already applied to them")
+	output := fs.String("output", "text", "Output format: text, or json to print one JSON object per file to standard output, with messages going to standard error")
+	setupMemory := memoryFlags(fs)
+	outFile := fs.String("o", "", "Write the file given as a URL, remote file or - to this file or remote file (e.g., s3://bucket/key.mp3) instead of standard output or back to the remote file")
+	return func() error {
+		useArtCache = *artCache
+		if err := setupMemory(); err != nil {
+			return err
+		}
+		if err := setupLogs(); err != nil {
+			return err
+		}
+		if err := setupProviders(); err != nil {
+			return err
+		}
+		if err := setupTLS(); err != nil {
+			return err
+		}
+		hooks, err := setupHooks()
+		if err != nil {
+			return err
+		}
+		if opts.save.backupDir != "" {
+			opts.save.backup = true
+		}
+		opts.save.noPadding = opts.webOptimize
+		if err := checkID3v1Mode(opts.save.id3v1); err != nil {
+			return err
+		}
+		if opts.imageMaxSize < 0 {
+			return fmt.Errorf("invalid image size: %d", opts.imageMaxSize)
+		}
+		if *onlyMissing {
+			if *overwrite != "all" && *overwrite != "none" {
+				return errors.New("-only-missing and -overwrite cannot be combined")
 			}
-		} else {
-			// If a specific file path is provided, read and embed that image.
-			if dryRun {
-				fmt.Println()
-				fmt.Println("Cover art from file:", embedImage)
-			} else {
-				b, err := os.ReadFile(embedImage)
-				if err != nil {
-					log.Fatalf("Error reading album art image: %v", err)
-				}
-				ct := http.DetectContentType(b)
-				pic := id3v2.PictureFrame{
-					Encoding:    id3v2.EncodingISO,
-					MimeType:    ct,
-					PictureType: id3v2.PTFrontCover,
-					Description: "Cover Art",
-					Picture:     b,
-				}
-				tag.DeleteFrames(tag.CommonID("Attached picture"))
-				tag.AddAttachedPicture(pic)
+			*overwrite = "none"
+		}
+		if err := opts.setOverwrite(*overwrite); err != nil {
+			return err
+		}
+		if opts.minArtSize < 0 {
+			return fmt.Errorf("invalid art size: %d", opts.minArtSize)
+		}
+		if *prefetch < 0 {
+			return fmt.Errorf("invalid -prefetch: %d", *prefetch)
+		}
+		if opts.maxArtBytes < 0 {
+			return fmt.Errorf("invalid art size limit: %d", opts.maxArtBytes)
+		}
+		if opts.imageQuality < 0 || opts.imageQuality > 100 {
+			return fmt.Errorf("invalid image quality: %d", opts.imageQuality)
+		}
+		if opts.webOptimize && (len(opts.lyrics) > 0 || opts.translateTo != "") {
+			return errors.New("-web-optimize removes lyrics and cannot be combined with -lyrics or -translate")
+		}
+		if opts.pick && opts.quarantine {
+			return errors.New("-pick and -quarantine cannot be combined")
+		}
+		if opts.fingerprint {
+			if _, _, err := credential(providerAcoustID); err != nil {
+				return fmt.Errorf("-fingerprint: %w", err)
 			}
 		}
-	}
-
-	// Process embedding of lyrics if the lyrics flag is provided.
-	if embedLyrics != "" {
-		// If "auto" is specified, automatically fetch lyrics using the LRC API.
-		if embedLyrics == "auto" {
-			lyrics, err := downloadLrc(tag.Artist(), tag.Title())
+		if opts.translateTo != "" {
+			if _, err := translationLanguage(opts.translateTo); err != nil {
+				return err
+			}
+			opts.translateTo = strings.ToLower(opts.translateTo)
+			t, err := newTranslator(*translateProvider)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
-			if dryRun {
-				fmt.Println()
-				fmt.Println(lyrics)
-			} else {
-				uslt := id3v2.UnsynchronisedLyricsFrame{
-					Encoding:          id3v2.EncodingUTF8,
-					Language:          embedLang,
-					ContentDescriptor: "Lyrics",
-					Lyrics:            lyrics,
+			opts.translator = t
+		}
+		pt, err := parsePictureType(*pictureType)
+		if err != nil {
+			return err
+		}
+		opts.pictureType = pt
+		if opts.fixEncoding != "" {
+			if _, err := legacyEncoding(opts.fixEncoding); err != nil {
+				return err
+			}
+		}
+		if opts.romanize != "" && !slices.Contains(romanizeModes, opts.romanize) {
+			return fmt.Errorf("unknown romanization mode: %s", opts.romanize)
+		}
+		if opts.normalizeGenre {
+			if _, err := userGenres(); err != nil {
+				return err
+			}
+		}
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if slices.ContainsFunc(fs.Args(), isStreamSource) {
+			return embedStream(fs, &opts, *outFile)
+		}
+		if *outFile != "" {
+			return errors.New("-o is only for a URL, remote file or - given as the file")
+		}
+
+		if *output != "text" && *output != "json" {
+			return fmt.Errorf("unknown output format: %s", *output)
+		}
+		// Everything else printed goes to standard error, so that standard output
+		// only holds the JSON objects.
+		var enc *json.Encoder
+		if *output == "json" {
+			enc = json.NewEncoder(os.Stdout)
+			opts.out = os.Stderr
+		}
+		if opts.image == "auto" && *albumArt {
+			opts.albumArt = &albumArtCache{}
+		}
+
+		// Process every MP3 file given on the command line, descending into directories.
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		rep := prev
+		if rep == nil {
+			rep = newRunReport(fs)
+		}
+		failed, code := 0, exitOK
+		var done []string
+		summary := &runSummary{}
+		// No bar is shown when something else needs the terminal, such as prompts
+		// and dry run listings, or logs that bypass the log package.
+		if *showProgress && len(files) > 1 && enc == nil && isTerminal(os.Stdout) && verbosity == levelNormal &&
+			!opts.dryRun && !opts.interactive && !opts.pick && !logsToStderr {
+			startProgress(len(files))
+		}
+		// Dry runs write nothing to overlap with, and prompts must not interleave
+		// with the output of earlier files.
+		ahead := *prefetch
+		if opts.dryRun || opts.interactive || opts.pick {
+			ahead = 0
+		}
+		next, stop := prepareFiles(files, &opts, hooks, enc != nil || hooks.PostEmbed != "", ahead)
+		defer stop()
+		for i, name := range files {
+			if opts.dryRun && len(files) > 1 {
+				if i > 0 {
+					fmt.Fprintln(opts.output())
 				}
-				tag.DeleteFrames(tag.CommonID("Unsynchronised lyrics/text transcription"))
-				tag.AddUnsynchronisedLyricsFrame(uslt)
+				fmt.Fprintf(opts.output(), "==> %s <==\n", name)
 			}
-		} else {
-			// If a specific lyrics file path is provided, read and embed those lyrics.
-			if dryRun {
-				fmt.Println()
-				fmt.Println("Lyrics text from file:", embedLyrics)
-			} else {
-				b, err := os.ReadFile(embedLyrics)
-				if err != nil {
-					log.Fatalf("Error reading lyrics file: %v", err)
+			p := next()
+			result, err := p.finish(hooks)
+			summary.add(result, err)
+			if enc != nil {
+				if err := enc.Encode(newFileOutput(name, result, err, p.opts.trace, opts.dryRun)); err != nil {
+					return err
 				}
-				uslt := id3v2.UnsynchronisedLyricsFrame{
-					Encoding:          id3v2.EncodingUTF8,
-					Language:          embedLang,
-					ContentDescriptor: "Lyrics",
-					Lyrics:            string(b),
+			}
+			if err != nil {
+				logFileError(name, err)
+				if opts.notify {
+					desktopNotify("mp3extra: "+filepath.Base(name)+" needs review", err.Error())
 				}
-				tag.DeleteFrames(tag.CommonID("Unsynchronised lyrics/text transcription"))
-				tag.AddUnsynchronisedLyricsFrame(uslt)
+				failed++
+				code = max(code, fileExitCode(err))
+			} else {
+				done = append(done, name)
+			}
+			if progress != nil {
+				progress.add(err)
+			}
+			if err := rep.add(name, err); err != nil {
+				return err
 			}
 		}
-	}
-
-	// If not a dry run, save the modified tags back to the MP3 file.
-	if !dryRun {
-		err = tag.Save()
-		if err != nil {
-			log.Fatalf("Error saving MP3 file: %v", err)
-			return
+		if progress != nil {
+			progress.finish()
+		}
+		if !opts.dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		rep.Summary = summary
+		if *report != "" && !opts.dryRun {
+			if err := writeJSONFile(*report, rep); err != nil {
+				return fmt.Errorf("error writing report: %w", err)
+			}
+		}
+		if len(files) > 1 && verbosity >= levelNormal {
+			written := *report
+			if opts.dryRun {
+				written = ""
+			}
+			summary.print(opts.output(), written)
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: code}
 		}
-		fmt.Println("Embedded successfully in", mp3File)
+		return nil
 	}
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "manifest",
+		usage: "Write SHA-256 manifests of the audio data of MP3 files, which tag changes leave alone",
+		run:   runManifest,
+	})
+}
+
+// manifestName is the name of the manifests written to each directory.
+const manifestName = "audio.sha256"
+
+// manifestHeader starts every manifest. The hashes are not those of the whole
+// files, so sha256sum cannot check them.
+const manifestHeader = "# SHA-256 of the audio frames without tags, written by mp3extra; check with 'mp3extra audio-hash -c'"
+
+// manifestFlag defines the -manifest flag of batch commands on fs.
+func manifestFlag(fs *flag.FlagSet) *string {
+	return fs.String("manifest", "", "Record the SHA-256 of the audio data of the processed files in "+manifestName+" in each directory ('dir') or in one manifest file with this path")
+}
+
+// readManifest returns the hashes recorded in the manifest at name by the
+// file names they were recorded for. A missing manifest is empty.
+func readManifest(name string) (map[string]string, error) {
+	sums := map[string]string{}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return sums, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, file, ok := strings.Cut(line, "  ")
+		if !ok || len(sum) != 64 {
+			return nil, fmt.Errorf("%s:%d: malformed line", name, n)
+		}
+		sums[file] = sum
+	}
+	return sums, scanner.Err()
+}
+
+// writeManifest replaces the manifest at name with sums, sorted by file name.
+func writeManifest(name string, sums map[string]string) error {
+	files := make([]string, 0, len(sums))
+	for file := range sums {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	var sb strings.Builder
+	sb.WriteString(manifestHeader + "\n")
+	for _, file := range files {
+		fmt.Fprintf(&sb, "%s  %s\n", sums[file], file)
+	}
+	return writeFileAtomic(name, []byte(sb.String()))
+}
+
+// manifestPath returns the manifest that records the MP3 file at path for the
+// given -manifest value, and the name path is recorded under, relative to the
+// manifest with forward slashes.
+func manifestPath(mode, path string) (string, string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+	name := filepath.Join(filepath.Dir(abs), manifestName)
+	if mode != "dir" {
+		if name, err = filepath.Abs(mode); err != nil {
+			return "", "", err
+		}
+	}
+	rel, err := filepath.Rel(filepath.Dir(name), abs)
+	if err != nil {
+		return "", "", err
+	}
+	return name, filepath.ToSlash(rel), nil
+}
+
+// updateManifests records the audio hashes of files in the manifests selected
+// by mode, keeping the entries of other files. Files that cannot be hashed are
+// logged and left out, and their number is returned. Nothing is done if mode
+// is empty.
+func updateManifests(mode string, files []string) (int, error) {
+	if mode == "" || len(files) == 0 {
+		return 0, nil
+	}
+	failed := 0
+	updates := map[string]map[string]string{}
+	for _, path := range files {
+		name, rel, err := manifestPath(mode, path)
+		if err != nil {
+			return failed, err
+		}
+		sum, err := audioDigest(path)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			failed++
+			continue
+		}
+		if updates[name] == nil {
+			updates[name] = map[string]string{}
+		}
+		updates[name][rel] = sum
+	}
+	for name, update := range updates {
+		sums, err := readManifest(name)
+		if err != nil {
+			return failed, err
+		}
+		for rel, sum := range update {
+			sums[rel] = sum
+		}
+		if err := writeManifest(name, sums); err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// runManifest implements the manifest command.
+func runManifest(fs *flag.FlagSet) func() error {
+	mode := fs.String("manifest", "dir", "Write "+manifestName+" to each directory ('dir') or one manifest file with this path")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s manifest [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 || *mode == "" {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		failed, err := updateManifests(*mode, files)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Recorded %d files\n", len(files)-failed)
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
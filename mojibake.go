@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bogem/id3v2/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// legacyEncoding returns the legacy encoding with the given name, such as
+// cp1251, shift_jis or gbk.
+func legacyEncoding(name string) (encoding.Encoding, error) {
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding: %s", name)
+	}
+	return enc, nil
+}
+
+// redecode re-interprets s, which was decoded as ISO-8859-1 although it was
+// written in enc. ok is false if s is plain ASCII, which reads the same in
+// every legacy encoding, or is not valid in enc.
+func redecode(s string, enc encoding.Encoding) (string, bool) {
+	b := make([]byte, 0, len(s))
+	ascii := true
+	for _, r := range s {
+		if r > 0xff {
+			// Not ISO-8859-1, so not mis-decoded.
+			return s, false
+		}
+		if r >= 0x80 {
+			ascii = false
+		}
+		b = append(b, byte(r))
+	}
+	if ascii {
+		return s, false
+	}
+	d, err := enc.NewDecoder().Bytes(b)
+	if err != nil || !utf8.Valid(d) || strings.ContainsRune(string(d), utf8.RuneError) {
+		return s, false
+	}
+	return string(d), true
+}
+
+// fixEncoding re-decodes the text of the ISO-8859-1 frames of tag in enc and
+// rewrites them in the default encoding of tag. Tag editors on Windows often
+// wrote text in the system code page while marking it as ISO-8859-1. It returns
+// the number of frames fixed.
+func fixEncoding(tag *id3v2.Tag, enc encoding.Encoding) int {
+	n := 0
+	mapFrames(tag, func(f id3v2.Framer) (id3v2.Framer, bool) {
+		return mapText(f, func(e *id3v2.Encoding, texts ...*string) bool {
+			if !e.Equals(id3v2.EncodingISO) {
+				return false
+			}
+			fixed := make([]string, len(texts))
+			changed := false
+			for i, t := range texts {
+				s, ok := redecode(*t, enc)
+				if !ok && s != "" && !isASCII(s) {
+					// Leave frames alone that are not all valid in enc.
+					return false
+				}
+				fixed[i] = s
+				changed = changed || ok
+			}
+			if !changed {
+				return false
+			}
+			for i, t := range texts {
+				*t = fixed[i]
+			}
+			*e = tag.DefaultEncoding()
+			n++
+			return true
+		})
+	})
+	return n
+}
+
+// isASCII reports whether s consists of ASCII characters only.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
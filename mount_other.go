@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// deviceID is not implemented on this platform. Unmounted shares are noticed
+// only when their directory disappears.
+func deviceID(path string) (uint64, bool) {
+	return 0, false
+}
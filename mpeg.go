@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// MPEG audio versions as encoded in the frame header.
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+)
+
+// mpegBitrates holds the bitrate tables in kbps indexed by [version is MPEG1][layer-1][index].
+var mpegBitrates = [2][3][16]int{
+	// MPEG2 / MPEG2.5
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+	// MPEG1
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+}
+
+// mpegSampleRates holds the sample rates in Hz indexed by [version][index].
+var mpegSampleRates = [4][3]int{
+	mpeg25: {11025, 12000, 8000},
+	mpeg2:  {22050, 24000, 16000},
+	mpeg1:  {44100, 48000, 32000},
+}
+
+// mpegFrame describes a single MPEG audio frame found in a file.
+type mpegFrame struct {
+	Offset     int64 // position of the frame header in the file
+	Size       int   // frame length in bytes including the header
+	Version    int
+	Layer      int
+	Bitrate    int // kbps
+	SampleRate int
+	Samples    int // samples per channel
+	Channels   int
+
+	// Level is the largest number of encoded bits used by a single granule of a
+	// layer III frame. Digital silence encodes to zero. It is -1 for other layers.
+	Level int
+
+	// Xing is set if the frame carries a Xing/Info/VBRI header instead of audio.
+	Xing bool
+
+	// data holds the bytes of the frame. It is only valid during the callback
+	// of scanMPEGFrames.
+	data []byte
+}
+
+// Duration returns the playing time of the frame.
+func (f *mpegFrame) Duration() time.Duration {
+	return time.Duration(f.Samples) * time.Second / time.Duration(f.SampleRate)
+}
+
+// parseMPEGHeader decodes the 4-byte frame header in b. It returns false if b
+// does not start with a valid header.
+func parseMPEGHeader(b []byte) (mpegFrame, bool) {
+	var f mpegFrame
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return f, false
+	}
+	f.Version = int(b[1]>>3) & 3
+	layerBits := int(b[1]>>1) & 3
+	bitrateIndex := int(b[2] >> 4)
+	sampleRateIndex := int(b[2]>>2) & 3
+	padding := int(b[2]>>1) & 1
+	if f.Version == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return f, false
+	}
+	f.Layer = 4 - layerBits
+	v1 := 0
+	if f.Version == mpeg1 {
+		v1 = 1
+	}
+	f.Bitrate = mpegBitrates[v1][f.Layer-1][bitrateIndex]
+	f.SampleRate = mpegSampleRates[f.Version][sampleRateIndex]
+	f.Channels = 2
+	if b[3]>>6 == 3 {
+		f.Channels = 1
+	}
+	switch {
+	case f.Layer == 1:
+		f.Samples = 384
+		f.Size = (12*f.Bitrate*1000/f.SampleRate + padding) * 4
+	case f.Layer == 3 && f.Version != mpeg1:
+		f.Samples = 576
+		f.Size = 72*f.Bitrate*1000/f.SampleRate + padding
+	default:
+		f.Samples = 1152
+		f.Size = 144*f.Bitrate*1000/f.SampleRate + padding
+	}
+	f.Level = -1
+	return f, true
+}
+
+// sideInfoSize returns the size of the layer III side information of f.
+func (f *mpegFrame) sideInfoSize() int {
+	if f.Version == mpeg1 {
+		if f.Channels == 1 {
+			return 17
+		}
+		return 32
+	}
+	if f.Channels == 1 {
+		return 9
+	}
+	return 17
+}
+
+// bitReader reads big-endian bit fields from a byte slice.
+type bitReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		if r.pos/8 >= len(r.b) {
+			return v
+		}
+		v = v<<1 | int(r.b[r.pos/8]>>(7-r.pos%8))&1
+		r.pos++
+	}
+	return v
+}
+
+// analyzeLayer3 fills in Level and Xing from the frame body in b.
+func (f *mpegFrame) analyzeLayer3(b []byte) {
+	off := 4
+	if b[1]&1 == 0 {
+		off += 2 // CRC
+	}
+	side := f.sideInfoSize()
+	if len(b) < off+side {
+		return
+	}
+	if len(b) >= off+side+4 {
+		switch string(b[off+side : off+side+4]) {
+		case "Xing", "Info":
+			f.Xing = true
+		}
+	}
+	if len(b) >= 36+4 && string(b[36:40]) == "VBRI" {
+		f.Xing = true
+	}
+
+	br := &bitReader{b: b[off : off+side]}
+	granules := 1
+	if f.Version == mpeg1 {
+		granules = 2
+		br.read(9) // main_data_begin
+		if f.Channels == 1 {
+			br.read(5)
+		} else {
+			br.read(3)
+		}
+		br.read(4 * f.Channels) // scfsi
+	} else {
+		br.read(8) // main_data_begin
+		br.read(f.Channels)
+	}
+	f.Level = 0
+	for gr := 0; gr < granules; gr++ {
+		for ch := 0; ch < f.Channels; ch++ {
+			part23 := br.read(12)
+			if part23 > f.Level {
+				f.Level = part23
+			}
+			// Skip the rest of the granule info, whose length does not
+			// depend on its contents.
+			if f.Version == mpeg1 {
+				br.read(59 - 12)
+			} else {
+				br.read(63 - 12)
+			}
+		}
+	}
+}
+
+// audioRange returns the byte range of f that holds MPEG audio, excluding a
+// leading ID3v2 tag and a trailing ID3v1 tag.
+func audioRange(f *os.File) (start, end int64, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	start, err = id3v2TagSize(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	end = fi.Size()
+	if end-start >= 128 {
+		var b [3]byte
+		if _, err := f.ReadAt(b[:], end-128); err != nil {
+			return 0, 0, err
+		}
+		if string(b[:]) == "TAG" {
+			end -= 128
+		}
+	}
+	return start, end, nil
+}
+
+// id3v2TagSize returns the number of bytes occupied by the ID3v2 tag at the
+// start of r including its header and footer, or 0 if there is none.
+func id3v2TagSize(r io.ReaderAt) (int64, error) {
+	var h [10]byte
+	if _, err := r.ReadAt(h[:], 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if string(h[:3]) != "ID3" {
+		return 0, nil
+	}
+	size := int64(h[6]&0x7f)<<21 | int64(h[7]&0x7f)<<14 | int64(h[8]&0x7f)<<7 | int64(h[9]&0x7f)
+	size += 10
+	if h[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return size, nil
+}
+
+// scanMPEGFrames walks the MPEG audio frames of r between start and end and
+// calls fn for each of them. Garbage between frames is skipped.
+func scanMPEGFrames(r io.ReaderAt, start, end int64, fn func(f *mpegFrame) error) error {
+	br := bufio.NewReaderSize(io.NewSectionReader(r, start, end-start), 64*1024)
+	pos := start
+	for {
+		h, err := br.Peek(4)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		f, ok := parseMPEGHeader(h)
+		if !ok {
+			br.Discard(1)
+			pos++
+			continue
+		}
+		b, err := br.Peek(f.Size)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// Truncated last frame.
+				return nil
+			}
+			return err
+		}
+		f.Offset = pos
+		f.data = b
+		if f.Layer == 3 {
+			f.analyzeLayer3(b)
+		}
+		if err := fn(&f); err != nil {
+			return err
+		}
+		br.Discard(f.Size)
+		pos += int64(f.Size)
+	}
+}
+
+// audioDigest returns the hex-encoded SHA-256 of the MPEG audio frames of the
+// file at path. Tags, a Xing/Info header and anything else between the frames
+// are left out, so it does not change when only the metadata of a file is
+// rewritten.
+func audioDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	start, end, err := audioRange(f)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	frames := 0
+	err = scanMPEGFrames(f, start, end, func(fr *mpegFrame) error {
+		if !fr.Xing {
+			h.Write(fr.data)
+			frames++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if frames == 0 {
+		return "", errNoAudio
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// errNoAudio is returned by audioDigest for files without MPEG audio frames.
+var errNoAudio = errors.New("no MPEG audio frames found")
+
+// audioDuration returns the playing time of the MPEG audio frames of the file
+// at path.
+func audioDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	start, end, err := audioRange(f)
+	if err != nil {
+		return 0, err
+	}
+	var total time.Duration
+	frames := 0
+	err = scanMPEGFrames(f, start, end, func(fr *mpegFrame) error {
+		if !fr.Xing {
+			total += fr.Duration()
+			frames++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if frames == 0 {
+		return 0, errNoAudio
+	}
+	return total, nil
+}
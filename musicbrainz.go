@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// musicBrainzOwner is the owner of the UFID frame holding the MusicBrainz
+// recording ID.
+const musicBrainzOwner = "http://musicbrainz.org"
+
+// musicBrainzIDs are the MusicBrainz identifiers (MBIDs) of a recording and
+// the AcoustID track it was matched by. Empty IDs are unknown.
+type musicBrainzIDs struct {
+	Recording    string
+	Artists      []string
+	Release      string
+	ReleaseGroup string
+	AcoustID     string
+}
+
+// setMusicBrainzIDs writes ids into tag the way MusicBrainz Picard does, so
+// that Picard, beets and other tools recognize the match: the recording ID
+// into the UFID frame of musicBrainzOwner and the others into TXXX frames.
+// Several artist IDs are separated by slashes. It returns the number of
+// frames written.
+func setMusicBrainzIDs(tag *id3v2.Tag, ids *musicBrainzIDs) int {
+	n := 0
+	if ids.Recording != "" {
+		tag.AddUFIDFrame(id3v2.UFIDFrame{OwnerIdentifier: musicBrainzOwner, Identifier: []byte(ids.Recording)})
+		n++
+	}
+	for _, t := range [][2]string{
+		{"MusicBrainz Artist Id", strings.Join(ids.Artists, "/")},
+		{"MusicBrainz Album Id", ids.Release},
+		{"MusicBrainz Release Group Id", ids.ReleaseGroup},
+		{"Acoustid Id", ids.AcoustID},
+	} {
+		if t[1] != "" {
+			setUserText(tag, t[0], t[1])
+			n++
+		}
+	}
+	return n
+}
+
+// musicBrainzRecording returns the MusicBrainz recording ID of tag, or "".
+func musicBrainzRecording(tag *id3v2.Tag) string {
+	for _, f := range tag.GetFrames("UFID") {
+		if ufid, ok := f.(id3v2.UFIDFrame); ok && ufid.OwnerIdentifier == musicBrainzOwner {
+			return string(ufid.Identifier)
+		}
+	}
+	return ""
+}
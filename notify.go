@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// desktopNotify shows a desktop notification using whatever mechanism the
+// platform offers: notify-send or D-Bus on Linux and BSD, osascript on macOS
+// and a toast through PowerShell on Windows.
+func desktopNotify(title, message string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + strconv.Quote(message) + " with title " + strconv.Quote(title)
+		return exec.Command("osascript", "-e", script).Run()
+	case "windows":
+		quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+		script := `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode(` + quote(title) + `)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode(` + quote(message) + `)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('mp3extra').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+		return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Run()
+	}
+
+	if _, err := exec.LookPath("notify-send"); err == nil {
+		return exec.Command("notify-send", "-a", "mp3extra", title, message).Run()
+	}
+	if _, err := exec.LookPath("gdbus"); err == nil {
+		return exec.Command("gdbus", "call", "--session",
+			"--dest", "org.freedesktop.Notifications",
+			"--object-path", "/org/freedesktop/Notifications",
+			"--method", "org.freedesktop.Notifications.Notify",
+			"mp3extra", "0", "", title, message, "[]", "{}", "-1").Run()
+	}
+	return errors.New("no notification mechanism available")
+}
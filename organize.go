@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "organize",
+		usage: "Move or copy MP3 files into a library laid out after their tags",
+		run:   runOrganize,
+	})
+}
+
+// defaultLibraryTemplate is the layout organize uses unless told otherwise.
+const defaultLibraryTemplate = "{albumartist}/{album}/{track:02} {title}.mp3"
+
+// moveFile moves the file at src to dst, copying it if it cannot be renamed,
+// e.g. because dst is on another file system.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyWithTimes(src, dst); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyWithTimes copies src to dst like copyFile and gives dst the modification
+// time of src.
+func copyWithTimes(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// runOrganize implements the organize command.
+func runOrganize(fs *flag.FlagSet) func() error {
+	var template, collision string
+	var copyFiles, dryRun bool
+	fs.StringVar(&template, "template", defaultLibraryTemplate, "Layout of the library, with the fields of the rename command")
+	fs.StringVar(&collision, "collision", "number", "What to do when a different file has the name: skip the file, or number it as in \"Title (2).mp3\"")
+	fs.BoolVar(&copyFiles, "copy", false, "Copy the files instead of moving them")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show where the files would go without moving them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s organize [flags] file.mp3|dir... library\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if collision != "skip" && collision != "number" {
+			return fmt.Errorf("invalid -collision: %s (want skip or number)", collision)
+		}
+		tmpl, err := parseNameTemplate(template)
+		if err != nil {
+			return err
+		}
+		library := fs.Arg(fs.NArg() - 1)
+
+		files, err := collectMP3Files(fs.Args()[:fs.NArg()-1])
+		if err != nil {
+			return err
+		}
+		verb := "Moved"
+		if copyFiles {
+			verb = "Copied"
+		}
+		claimed := map[string]string{}
+		failed, done, dupes := 0, 0, 0
+		for _, path := range files {
+			name, err := tagFileName(path, tmpl)
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			target := filepath.Join(library, name)
+			if target == filepath.Clean(path) {
+				continue
+			}
+			target, err = renameTarget(path, target, collision == "number", true, claimed)
+			if errors.Is(err, errDuplicate) {
+				fmt.Printf("%s: already in the library as %s\n", path, target)
+				dupes++
+				continue
+			}
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			claimed[strings.ToLower(target)] = path
+			if target == filepath.Clean(path) {
+				continue
+			}
+			if dryRun {
+				fmt.Printf("%s -> %s\n", path, target)
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			if copyFiles {
+				err = copyWithTimes(path, target)
+			} else {
+				err = moveFile(path, target)
+			}
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s %s to %s\n", verb, path, target)
+			done++
+		}
+		if !dryRun {
+			fmt.Printf("%s %d files, left %d duplicates in place\n", verb, done, dupes)
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
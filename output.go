@@ -0,0 +1,101 @@
+package main
+
+// String returns the name of r used in JSON output.
+func (r embedResult) String() string {
+	switch r {
+	case embedDone:
+		return "done"
+	case embedSkipped:
+		return "skipped"
+	case embedQueued:
+		return "queued"
+	}
+	return "failed"
+}
+
+// embedTrace collects what embedFile did to a file for the JSON output.
+type embedTrace struct {
+	notes      []string
+	sources    []sourceOutput
+	changes    []frameChange
+	confidence frameSources
+}
+
+// source records where an item embedded in the file came from. A nil trace
+// records nothing.
+func (t *embedTrace) source(item, lang, src string) {
+	if t != nil {
+		t.sources = append(t.sources, sourceOutput{Item: item, Lang: lang, Source: src})
+	}
+}
+
+// fileOutput is the JSON object printed for each file with -output json.
+type fileOutput struct {
+	Path    string         `json:"path"`
+	Status  string         `json:"status"` // done, skipped, queued or failed
+	DryRun  bool           `json:"dry_run,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	Class   string         `json:"class,omitempty"` // see errorClasses
+	Notes   []string       `json:"notes,omitempty"` // matches and conversions
+	Sources []sourceOutput `json:"sources,omitempty"`
+	Frames  []frameOutput  `json:"frames,omitempty"` // changed frames
+}
+
+// sourceOutput is where cover art, lyrics or a translation came from: a file
+// or the URL of the record found by a lookup.
+type sourceOutput struct {
+	Item   string `json:"item"` // art, lyrics or translation
+	Lang   string `json:"lang,omitempty"`
+	Source string `json:"source"`
+}
+
+// frameOutput is a frame that was added, deleted or replaced.
+type frameOutput struct {
+	Op         string `json:"op"` // added, deleted or replaced
+	ID         string `json:"id"`
+	Summary    string `json:"summary"`
+	Size       int    `json:"size"` // of the body, before deletion for deleted frames
+	SizeBefore int    `json:"size_before,omitempty"`
+
+	// Confidence is "auto" for frames fetched for an exact match or derived
+	// by a fixed rule and "check" for loose matches and guesses, as marked in
+	// dry runs.
+	Confidence string `json:"confidence,omitempty"`
+}
+
+// newFileOutput returns the JSON output for the file at path, which embedFile
+// processed with result and err, recording into t.
+func newFileOutput(path string, result embedResult, err error, t *embedTrace, dryRun bool) *fileOutput {
+	out := &fileOutput{Path: path, Status: result.String(), DryRun: dryRun}
+	if err != nil {
+		out.Status = embedFailed.String()
+		out.Error, out.Class = err.Error(), errorClass(err)
+	}
+	// Nothing was written to files that failed.
+	if t == nil || err != nil {
+		return out
+	}
+	out.Notes, out.Sources = t.notes, t.sources
+	for _, c := range t.changes {
+		f := frameOutput{}
+		switch c.Op {
+		case '+':
+			f.Op, f.ID, f.Summary, f.Size = "added", c.After.ID, c.After.Summary, len(c.After.Data)
+		case '-':
+			f.Op, f.ID, f.Summary, f.Size = "deleted", c.Before.ID, c.Before.Summary, len(c.Before.Data)
+		default:
+			f.Op, f.ID, f.Summary, f.Size = "replaced", c.After.ID, c.After.Summary, len(c.After.Data)
+			f.SizeBefore = len(c.Before.Data)
+		}
+		if c.After != nil {
+			switch t.confidence[c.After.Key] {
+			case confHigh:
+				f.Confidence = "auto"
+			case confLow:
+				f.Confidence = "check"
+			}
+		}
+		out.Frames = append(out.Frames, f)
+	}
+	return out
+}
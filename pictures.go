@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// pictureTypes are the picture types of APIC frames, indexed by their value,
+// with the name used by -picture-type and the description written with them.
+// Descriptions must differ, as ID3v2 allows only one picture per description.
+var pictureTypes = []struct {
+	name, desc string
+}{
+	{"other", "Picture"},
+	{"icon", "File Icon"},
+	{"other-icon", "Other File Icon"},
+	{"front", "Cover Art"},
+	{"back", "Back Cover"},
+	{"leaflet", "Leaflet Page"},
+	{"media", "Media"},
+	{"lead-artist", "Lead Artist"},
+	{"artist", "Artist"},
+	{"conductor", "Conductor"},
+	{"band", "Band"},
+	{"composer", "Composer"},
+	{"lyricist", "Lyricist"},
+	{"location", "Recording Location"},
+	{"recording", "During Recording"},
+	{"performance", "During Performance"},
+	{"video", "Video Capture"},
+	{"fish", "A Bright Coloured Fish"},
+	{"illustration", "Illustration"},
+	{"band-logo", "Band Logo"},
+	{"publisher-logo", "Publisher Logo"},
+}
+
+// pictureTypeNames returns the names accepted by parsePictureType.
+func pictureTypeNames() []string {
+	names := make([]string, len(pictureTypes))
+	for i, t := range pictureTypes {
+		names[i] = t.name
+	}
+	return names
+}
+
+// parsePictureType returns the APIC picture type named s, which may also be
+// given as its number.
+func parsePictureType(s string) (byte, error) {
+	for i, t := range pictureTypes {
+		if strings.EqualFold(t.name, s) {
+			return byte(i), nil
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(pictureTypes) {
+		return byte(n), nil
+	}
+	return 0, fmt.Errorf("unknown picture type: %s", s)
+}
+
+// pictureTypeName returns the name of the APIC picture type pt.
+func pictureTypeName(pt byte) string {
+	if int(pt) < len(pictureTypes) {
+		return pictureTypes[pt].name
+	}
+	return strconv.Itoa(int(pt))
+}
+
+// placePicture adds b as a picture of type pt to tag. If add is set, only
+// pictures of the same type are replaced and the others are kept; otherwise
+// all pictures are replaced.
+func placePicture(tag *id3v2.Tag, b []byte, ct string, pt byte, add bool) {
+	id := tag.CommonID("Attached picture")
+	if add {
+		deleteFramesFunc(tag, id, func(_ int, f id3v2.Framer) bool {
+			pic, ok := f.(id3v2.PictureFrame)
+			return ok && pic.PictureType == pt
+		})
+	} else {
+		tag.DeleteFrames(id)
+	}
+	desc := "Picture"
+	if int(pt) < len(pictureTypes) {
+		desc = pictureTypes[pt].desc
+	}
+	tag.AddAttachedPicture(id3v2.PictureFrame{
+		Encoding:    id3v2.EncodingISO,
+		MimeType:    ct,
+		PictureType: pt,
+		Description: desc,
+		Picture:     b,
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+// preparedFile is a file of a batch run whose lookups are done and whose tag
+// waits to be written.
+type preparedFile struct {
+	path   string
+	opts   *embedOptions // the options it was prepared with, with its own trace
+	result embedResult
+	write  *tagWrite // nil if there is nothing to write
+	err    error
+}
+
+// prepare runs the pre_embed hook and the lookups of the file at path with a
+// copy of opts, which gets a trace of its own if trace is set.
+func prepare(path string, opts *embedOptions, hooks *hookConfig, trace bool) *preparedFile {
+	fo := *opts
+	if trace {
+		fo.trace = &embedTrace{}
+	}
+	p := &preparedFile{path: path, opts: &fo, result: embedFailed}
+	if p.err = hooks.pre(path, &fo); p.err == nil {
+		p.result, p.write, p.err = prepareEmbed(path, &fo)
+	}
+	return p
+}
+
+// finish writes the tag of p, if any, and runs the post_embed hook. It returns
+// the outcome for the file.
+func (p *preparedFile) finish(hooks *hookConfig) (embedResult, error) {
+	if p.err == nil && p.write != nil {
+		if err := p.write.commit(); err != nil {
+			p.result, p.err = embedFailed, err
+		}
+	}
+	hooks.post(p.path, p.opts, p.result, p.err)
+	return p.result, p.err
+}
+
+// prepareFiles returns a function that returns the files in turn, prepared
+// for finish. If ahead is positive, the files are prepared in a goroutine of
+// their own while the caller writes the ones before, so that slow lookups and
+// slow writes overlap instead of adding up; at most ahead prepared files wait
+// to be written, which bounds the memory their tags take. The goroutine ends
+// once all files were returned or stop is called.
+func prepareFiles(files []string, opts *embedOptions, hooks *hookConfig, trace bool, ahead int) (next func() *preparedFile, stop func()) {
+	if ahead <= 0 {
+		i := 0
+		return func() *preparedFile {
+			i++
+			return prepare(files[i-1], opts, hooks, trace)
+		}, func() {}
+	}
+	ch := make(chan *preparedFile, ahead-1)
+	done := make(chan struct{})
+	go func() {
+		defer close(ch)
+		for _, path := range files {
+			select {
+			case ch <- prepare(path, opts, hooks, trace):
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() *preparedFile { return <-ch }, func() { close(done) }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// placeholderPatterns match the values that rippers, phones and recorders put
+// into tags when they know nothing about a track.
+var placeholderPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^(unknown|untitled|no name|none|null|n/?a|\?+|-+)$`),
+	regexp.MustCompile(`(?i)^(unknown|untitled|no) (artist|title|album|track)s?$`),
+	regexp.MustCompile(`(?i)^(artist|title|album|track)$`),
+	regexp.MustCompile(`(?i)^(audio )?track[\s_-]*\d+$`),
+	regexp.MustCompile(`(?i)^(aud|ptt|rec|voice|audio|recording|new recording|voice memo|memo)[\s_-]*\d[\d\s_-]*(wa\d+)?$`),
+}
+
+// isPlaceholder reports whether s is a placeholder such as "Track 01",
+// "Unknown Artist" or "AUD_0001" rather than a real value.
+func isPlaceholder(s string) bool {
+	s = strings.TrimSpace(s)
+	for _, re := range placeholderPatterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholderFields are the fields used by automatic lookups that are checked
+// for placeholders.
+var placeholderFields = []struct {
+	name, id string
+}{
+	{"artist", "TPE1"},
+	{"title", "TIT2"},
+	{"album", "TALB"},
+}
+
+// errPlaceholder is returned when a file cannot be looked up because its tag
+// holds placeholders and nothing better was found.
+var errPlaceholder = errors.New("tag holds placeholder values")
+
+// clearPlaceholders removes the fields of tag that hold placeholders, so that
+// hints can fill them in, and returns the original frames by ID.
+func clearPlaceholders(tag *id3v2.Tag) map[string]id3v2.TextFrame {
+	cleared := map[string]id3v2.TextFrame{}
+	for _, f := range placeholderFields {
+		if tf := tag.GetTextFrame(f.id); tf.Text != "" && isPlaceholder(tf.Text) {
+			cleared[f.id] = tf
+			tag.DeleteFrames(f.id)
+		}
+	}
+	return cleared
+}
+
+// restorePlaceholders puts back the placeholders cleared by clearPlaceholders
+// that no hint replaced. It returns an error wrapping errPlaceholder if the
+// artist or title is still a placeholder, as a lookup would only find the
+// wrong track.
+func restorePlaceholders(tag *id3v2.Tag, cleared map[string]id3v2.TextFrame) error {
+	var left []string
+	for _, f := range placeholderFields {
+		tf, ok := cleared[f.id]
+		if !ok || tag.GetTextFrame(f.id).Text != "" {
+			continue
+		}
+		tag.AddFrame(f.id, tf)
+		if f.id != "TALB" {
+			left = append(left, fmt.Sprintf("%s %q", f.name, tf.Text))
+		}
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("%w (%s), not looking it up; set the tags or use -fingerprint", errPlaceholder, strings.Join(left, ", "))
+	}
+	return nil
+}
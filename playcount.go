@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// playCounterFrame is a PCNT frame, which counts how often the file has been
+// played. The id3v2 library does not read it.
+type playCounterFrame struct {
+	Count uint64
+}
+
+// errBadPlayCounter is returned for PCNT frames that are not 4 to 8 bytes long.
+var errBadPlayCounter = errors.New("invalid PCNT frame")
+
+// parsePlayCounter parses the body of a PCNT frame: a big-endian counter of at
+// least 4 bytes. Counters beyond 64 bits are not supported.
+func parsePlayCounter(body []byte) (playCounterFrame, error) {
+	if len(body) < 4 || len(body) > 8 {
+		return playCounterFrame{}, errBadPlayCounter
+	}
+	var n uint64
+	for _, b := range body {
+		n = n<<8 | uint64(b)
+	}
+	return playCounterFrame{Count: n}, nil
+}
+
+// body returns the encoded body of pc: 4 bytes, or 8 if the count needs them.
+func (pc playCounterFrame) body() []byte {
+	if pc.Count <= 0xffffffff {
+		return binary.BigEndian.AppendUint32(nil, uint32(pc.Count))
+	}
+	return binary.BigEndian.AppendUint64(nil, pc.Count)
+}
+
+func (pc playCounterFrame) Size() int                { return len(pc.body()) }
+func (pc playCounterFrame) UniqueIdentifier() string { return "" }
+func (pc playCounterFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(pc.body())
+	return int64(n), err
+}
+
+// parsePlayCount parses a play count given on the command line.
+func parsePlayCount(s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid play count %q", s)
+	}
+	return n, nil
+}
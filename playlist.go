@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "playlist",
+		usage: "Write an extended M3U playlist of MP3 files, optionally only those matching tags",
+		run:   runPlaylist,
+	})
+}
+
+// playlistEntry is a track of a playlist.
+type playlistEntry struct {
+	path        string
+	disc, track int
+	duration    time.Duration
+	display     string // "Artist - Title" for #EXTINF
+}
+
+// playlistMatch is a condition on a field of the rename templates: the field
+// must contain text, ignoring case.
+type playlistMatch struct {
+	field, text string
+}
+
+// parsePlaylistMatch parses a -match value of the form field=text.
+func parsePlaylistMatch(s string) (playlistMatch, error) {
+	field, text, ok := strings.Cut(s, "=")
+	if !ok || nameFields[field] == nil {
+		return playlistMatch{}, fmt.Errorf("invalid -match %q: want field=text with a field of the rename command", s)
+	}
+	return playlistMatch{field, strings.ToLower(text)}, nil
+}
+
+// readPlaylistEntry reads the entry for the MP3 file at path. It returns nil if
+// the file does not satisfy all of matches.
+func readPlaylistEntry(path string, matches []playlistMatch) (*playlistEntry, error) {
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	for _, m := range matches {
+		if !strings.Contains(strings.ToLower(nameFields[m.field](tag)), m.text) {
+			return nil, nil
+		}
+	}
+	d, err := audioDuration(path)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &playlistEntry{path: path, duration: d}
+	e.disc, _, _ = parsePosition(textOf(tag, "TPOS"))
+	e.track, _, _ = parsePosition(textOf(tag, "TRCK"))
+	artist, title := strings.TrimSpace(tag.Artist()), strings.TrimSpace(tag.Title())
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	e.display = title
+	if artist != "" {
+		e.display = artist + " - " + title
+	}
+	return e, nil
+}
+
+// sortPlaylist puts entries in album order: by directory, then by disc and
+// track number, then by file name.
+func sortPlaylist(entries []*playlistEntry) {
+	slices.SortStableFunc(entries, func(a, b *playlistEntry) int {
+		return cmp.Or(
+			strings.Compare(filepath.Dir(a.path), filepath.Dir(b.path)),
+			cmp.Compare(a.disc, b.disc),
+			cmp.Compare(a.track, b.track),
+			strings.Compare(filepath.Base(a.path), filepath.Base(b.path)),
+		)
+	})
+}
+
+// formatPlaylist returns entries as an extended M3U playlist. If dir is not
+// empty, the paths are made relative to it where possible, so that the
+// playlist can be moved along with the files.
+func formatPlaylist(entries []*playlistEntry, dir string) string {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	for _, e := range entries {
+		path := e.path
+		if dir != "" {
+			if abs, err := filepath.Abs(path); err == nil {
+				if rel, err := filepath.Rel(dir, abs); err == nil {
+					path = rel
+				}
+			}
+		}
+		// Line breaks would end the entry early.
+		display := strings.NewReplacer("\r", " ", "\n", " ").Replace(e.display)
+		fmt.Fprintf(&sb, "#EXTINF:%d,%s\n%s\n", int(e.duration.Round(time.Second)/time.Second), display, path)
+	}
+	return sb.String()
+}
+
+// runPlaylist implements the playlist command.
+func runPlaylist(fs *flag.FlagSet) func() error {
+	var out, order string
+	var matchArgs stringList
+	fs.StringVar(&out, "o", "", "Write the playlist to this .m3u8 file instead of standard output; paths are relative to it")
+	fs.StringVar(&order, "sort", "album", "Order of the tracks: album (by directory, disc and track number) or path")
+	fs.Var(&matchArgs, "match", "Only list files whose field contains the text, ignoring case, as in genre=jazz; fields are those of the rename command (may be repeated)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s playlist [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if order != "album" && order != "path" {
+			return fmt.Errorf("invalid -sort: %s (want album or path)", order)
+		}
+		var matches []playlistMatch
+		for _, s := range matchArgs {
+			m, err := parsePlaylistMatch(s)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, m)
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		var entries []*playlistEntry
+		failed := 0
+		for _, path := range files {
+			e, err := readPlaylistEntry(path, matches)
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			if e != nil {
+				entries = append(entries, e)
+			}
+		}
+		if order == "album" {
+			sortPlaylist(entries)
+		} else {
+			slices.SortFunc(entries, func(a, b *playlistEntry) int { return strings.Compare(a.path, b.path) })
+		}
+
+		if out == "" {
+			fmt.Print(formatPlaylist(entries, ""))
+		} else {
+			abs, err := filepath.Abs(out)
+			if err != nil {
+				return err
+			}
+			if err := writeFileAtomic(out, []byte(formatPlaylist(entries, filepath.Dir(abs)))); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %d tracks to %s\n", len(entries), out)
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
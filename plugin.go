@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Plugins are providers in external programs named mp3extra-provider-<name>,
+// found in the providers directory of the config directory or in PATH. Each
+// search or fetch runs the program once, with a pluginRequest as JSON on its
+// standard input, and reads a pluginResponse as JSON from its standard output.
+
+// pluginPrefix is the prefix of the program names of plugins.
+const pluginPrefix = "mp3extra-provider-"
+
+// pluginTimeout bounds how long a plugin may take to answer a request.
+const pluginTimeout = time.Minute
+
+// pluginRequest is what a plugin is asked to do: "search" for the records of
+// Query, or "fetch" the lyrics or image of Candidate. Kind is "lyrics" or "art".
+type pluginRequest struct {
+	Op        string           `json:"op"`
+	Kind      string           `json:"kind"`
+	Query     *pluginQuery     `json:"query,omitempty"`
+	Candidate *reviewCandidate `json:"candidate,omitempty"`
+}
+
+// pluginQuery is a trackQuery as sent to plugins.
+type pluginQuery struct {
+	Artist   string  `json:"artist"`
+	Title    string  `json:"title"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // in seconds
+}
+
+// pluginResponse is the answer of a plugin. Error reports a failure; with
+// NotFound set it means that nothing was found.
+type pluginResponse struct {
+	Candidates  []reviewCandidate `json:"candidates"`
+	Lyrics      string            `json:"lyrics"`
+	Image       []byte            `json:"image"` // base64 in JSON
+	ContentType string            `json:"content_type"`
+	Error       string            `json:"error"`
+	NotFound    bool              `json:"not_found"`
+}
+
+// plugin is an external provider program.
+type plugin struct {
+	name, path string
+}
+
+// findPlugin returns the plugin called name, preferring the providers
+// directory of the config directory over PATH.
+func findPlugin(name string) (*plugin, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid provider name: %q", name)
+	}
+	prog := pluginPrefix + name
+	if dir, err := appDir(); err == nil {
+		path := filepath.Join(dir, "providers", prog)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return &plugin{name: name, path: path}, nil
+		}
+	}
+	path, err := exec.LookPath(prog)
+	if err != nil {
+		return nil, fmt.Errorf("unknown provider %q: neither built in (%s, %s) nor a plugin %s", name, providerLrclib, providerITunes, prog)
+	}
+	return &plugin{name: name, path: path}, nil
+}
+
+// call runs the plugin with req and returns its response. Responses reporting
+// that nothing was found are returned as errors wrapping notFound.
+func (p *plugin) call(ctx context.Context, req *pluginRequest, notFound error) (*pluginResponse, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Stdin = bytes.NewReader(in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("provider %s: %v: %s", p.name, err, msg)
+		}
+		return nil, fmt.Errorf("provider %s: %w", p.name, err)
+	}
+	var resp pluginResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("provider %s: bad response: %w", p.name, err)
+	}
+	switch {
+	case resp.NotFound && resp.Error != "":
+		return nil, fmt.Errorf("provider %s: %w: %s", p.name, notFound, resp.Error)
+	case resp.NotFound:
+		return nil, fmt.Errorf("provider %s: %w", p.name, notFound)
+	case resp.Error != "":
+		return nil, fmt.Errorf("provider %s: %s", p.name, resp.Error)
+	}
+	return &resp, nil
+}
+
+// search asks the plugin for the records of kind found for q.
+func (p *plugin) search(ctx context.Context, kind string, q trackQuery, notFound error) ([]reviewCandidate, error) {
+	resp, err := p.call(ctx, &pluginRequest{Op: "search", Kind: kind, Query: &pluginQuery{
+		Artist:   q.Artist,
+		Title:    q.Title,
+		Album:    q.Album,
+		Duration: q.Duration.Seconds(),
+	}}, notFound)
+	if errors.Is(err, notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Candidates, nil
+}
+
+// pluginLyrics is a plugin used as a lyricsProvider.
+type pluginLyrics struct{ *plugin }
+
+func (p pluginLyrics) Search(ctx context.Context, q trackQuery) ([]reviewCandidate, error) {
+	return p.search(ctx, "lyrics", q, errLyricsNotFound)
+}
+
+func (p pluginLyrics) Fetch(ctx context.Context, c *reviewCandidate) (string, error) {
+	resp, err := p.call(ctx, &pluginRequest{Op: "fetch", Kind: "lyrics", Candidate: c}, errLyricsNotFound)
+	if err != nil {
+		return "", err
+	}
+	return resp.Lyrics, nil
+}
+
+// pluginArt is a plugin used as an artProvider.
+type pluginArt struct{ *plugin }
+
+func (p pluginArt) Search(ctx context.Context, q trackQuery) ([]reviewCandidate, error) {
+	return p.search(ctx, "art", q, errArtNotFound)
+}
+
+func (p pluginArt) Fetch(ctx context.Context, c *reviewCandidate) ([]byte, string, error) {
+	resp, err := p.call(ctx, &pluginRequest{Op: "fetch", Kind: "art", Candidate: c}, errArtNotFound)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(resp.Image) == 0 {
+		return nil, "", fmt.Errorf("provider %s: %w", p.name, errArtNotFound)
+	}
+	ct := resp.ContentType
+	if ct == "" {
+		ct = http.DetectContentType(resp.Image)
+	}
+	return resp.Image, ct, nil
+}
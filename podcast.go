@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "podcast",
+		usage: "Write the frames podcast apps expect to an episode, from flags or a YAML file",
+		run:   runPodcast,
+	})
+}
+
+// podcastFields are the fields of an episode, as named by the flags of the
+// podcast command and the keys of episode YAML files, with their usage.
+var podcastFields = [][2]string{
+	{"title", "Title of the episode (TIT2)"},
+	{"show", "Name of the podcast (TALB)"},
+	{"author", "Author of the podcast (TPE1)"},
+	{"description", "Description of the episode (COMM and TDES)"},
+	{"subtitle", "Short summary of the episode (TIT3)"},
+	{"date", "Release date, as YYYY-MM-DD (TDRL and the year)"},
+	{"episode", "Episode number (TRCK)"},
+	{"category", "Category of the podcast, such as Technology (TCAT)"},
+	{"keywords", "Comma-separated keywords (TKWD)"},
+	{"feed", "URL of the podcast feed (WFED)"},
+	{"guid", "GUID of the episode in the feed (TGID)"},
+	{"art", "Image file with the episode art"},
+	{"chapters", "Chapter file with a start time and title per line, as in \"12:30 Interview\""},
+}
+
+// podcastChapter is a chapter of an episode.
+type podcastChapter struct {
+	Start time.Duration
+	Title string
+	Image string
+}
+
+// podcastMeta is what the podcast command writes to an episode.
+type podcastMeta struct {
+	Fields   map[string]string
+	Chapters []podcastChapter
+}
+
+// yamlScalar returns the value of a YAML scalar, without the quotes it may be
+// enclosed in.
+func yamlScalar(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// indentOf returns the number of leading spaces of s.
+func indentOf(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " "))
+}
+
+// parseEpisodeYAML parses an episode file: a small subset of YAML with a
+// mapping of podcastFields, where the description may be a | or > block, and
+// chapters a list of mappings with start, title and image. Relative paths are
+// taken relative to dir.
+func parseEpisodeYAML(b []byte, dir string) (*podcastMeta, error) {
+	meta := &podcastMeta{Fields: map[string]string{}}
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), " \t\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	path := func(p string) string {
+		if p != "" && !filepath.IsAbs(p) {
+			return filepath.Join(dir, p)
+		}
+		return p
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if indentOf(line) > 0 {
+			return nil, fmt.Errorf("line %d: unexpected indentation", i+1)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key: value", i+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !podcastField(key) {
+			return nil, fmt.Errorf("line %d: unknown key %q", i+1, key)
+		}
+
+		// Collect the indented lines that belong to the key.
+		var block []string
+		for i+1 < len(lines) && (lines[i+1] == "" || indentOf(lines[i+1]) > 0) {
+			i++
+			block = append(block, lines[i])
+		}
+		for len(block) > 0 && strings.TrimSpace(block[len(block)-1]) == "" {
+			block = block[:len(block)-1]
+		}
+
+		switch {
+		case key == "chapters" && value == "":
+			var c *podcastChapter
+			for _, l := range block {
+				t := strings.TrimSpace(l)
+				if t == "" || strings.HasPrefix(t, "#") {
+					continue
+				}
+				if rest, ok := strings.CutPrefix(t, "- "); ok {
+					meta.Chapters = append(meta.Chapters, podcastChapter{Start: -1})
+					c = &meta.Chapters[len(meta.Chapters)-1]
+					t = rest
+				}
+				k, v, ok := strings.Cut(t, ":")
+				if c == nil || !ok {
+					return nil, fmt.Errorf("chapters: want a list of start, title and image")
+				}
+				v = yamlScalar(v)
+				switch strings.TrimSpace(k) {
+				case "start":
+					d, err := parseClock(v)
+					if err != nil {
+						return nil, fmt.Errorf("chapters: %w", err)
+					}
+					c.Start = d
+				case "title":
+					c.Title = v
+				case "image":
+					c.Image = path(v)
+				default:
+					return nil, fmt.Errorf("chapters: unknown key %q", strings.TrimSpace(k))
+				}
+			}
+			for _, c := range meta.Chapters {
+				if c.Start < 0 {
+					return nil, fmt.Errorf("chapters: chapter %q has no start", c.Title)
+				}
+			}
+		case value == "|" || value == ">":
+			indent := -1
+			var text []string
+			for _, l := range block {
+				if indent < 0 && strings.TrimSpace(l) != "" {
+					indent = indentOf(l)
+				}
+				text = append(text, strings.TrimPrefix(l, strings.Repeat(" ", max(indent, 0))))
+			}
+			sep := "\n"
+			if value == ">" {
+				sep = " "
+			}
+			meta.Fields[key] = strings.Join(text, sep)
+		case len(block) > 0:
+			return nil, fmt.Errorf("line %d: %s: use | for text on several lines", i+1, key)
+		case key == "art" || key == "chapters":
+			meta.Fields[key] = path(yamlScalar(value))
+		default:
+			meta.Fields[key] = yamlScalar(value)
+		}
+	}
+	return meta, nil
+}
+
+// podcastField reports whether name is one of podcastFields.
+func podcastField(name string) bool {
+	for _, f := range podcastFields {
+		if f[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readChapterList reads a chapter file with a start time and a title per line.
+func readChapterList(name string) ([]podcastChapter, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var chapters []podcastChapter
+	for n, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		start, title, _ := strings.Cut(line, " ")
+		d, err := parseClock(start)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n+1, err)
+		}
+		chapters = append(chapters, podcastChapter{Start: d, Title: strings.TrimSpace(title)})
+	}
+	return chapters, nil
+}
+
+// podcastChapters turns the chapters of an episode of length total into
+// chapter frames, each ending where the next one starts. Text is written in
+// enc.
+func podcastChapters(chapters []podcastChapter, total time.Duration, enc id3v2.Encoding) ([]chapterFrame, error) {
+	var frames []chapterFrame
+	for i, c := range chapters {
+		if i > 0 && c.Start <= chapters[i-1].Start {
+			return nil, fmt.Errorf("chapter %q does not start after the one before", c.Title)
+		}
+		end := total
+		if i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		cf := chapterFrame{
+			ElementID:   fmt.Sprintf("ch%d", i+1),
+			Start:       c.Start,
+			End:         end,
+			StartOffset: id3v2.IgnoredOffset,
+			EndOffset:   id3v2.IgnoredOffset,
+		}
+		e := &chapterEdit{title: &c.Title}
+		if c.Image != "" {
+			e.image = &c.Image
+		}
+		if err := e.apply(&cf, enc); err != nil {
+			return nil, err
+		}
+		frames = append(frames, cf)
+	}
+	if err := checkChapters(frames, total); err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+// applyPodcast writes meta to tag, the tag of the MP3 file at path.
+func applyPodcast(tag *id3v2.Tag, path string, meta *podcastMeta) error {
+	enc := tag.DefaultEncoding()
+	f := meta.Fields
+	text := map[string]string{
+		"title":    "TIT2",
+		"show":     "TALB",
+		"author":   "TPE1",
+		"subtitle": "TIT3",
+		"episode":  "TRCK",
+		"category": "TCAT",
+		"keywords": "TKWD",
+		"guid":     "TGID",
+		"feed":     "WFED",
+	}
+	for name, id := range text {
+		if v, ok := f[name]; ok {
+			tag.DeleteFrames(id)
+			if v != "" {
+				// iTunes writes WFED like a text frame.
+				tag.AddFrame(id, id3v2.TextFrame{Encoding: enc, Text: v})
+			}
+		}
+	}
+	if v, ok := f["description"]; ok {
+		tag.DeleteFrames("TDES")
+		tag.DeleteFrames(tag.CommonID("Comments"))
+		if v != "" {
+			tag.AddTextFrame("TDES", enc, v)
+			tag.AddCommentFrame(id3v2.CommentFrame{Encoding: enc, Language: "eng", Text: v})
+		}
+	}
+	if v := f["date"]; v != "" {
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			return fmt.Errorf("invalid date %q: want YYYY-MM-DD", v)
+		}
+		tag.SetYear(v[:4])
+		if tag.Version() == 4 {
+			tag.AddTextFrame("TDRL", enc, v)
+		}
+	}
+	if v := f["art"]; v != "" {
+		b, ct, err := loadImage(v, tag)
+		if err != nil {
+			return err
+		}
+		setCover(tag, b, ct)
+	}
+	// The iTunes podcast marker and the genre most apps sort podcasts by.
+	tag.DeleteFrames("PCST")
+	tag.AddFrame("PCST", id3v2.UnknownFrame{Body: []byte{0, 0, 0, 0}})
+	tag.SetGenre("Podcast")
+
+	chapters := meta.Chapters
+	if name := f["chapters"]; name != "" {
+		var err error
+		if chapters, err = readChapterList(name); err != nil {
+			return err
+		}
+	}
+	if len(chapters) > 0 {
+		total, err := audioDuration(path)
+		if err != nil {
+			return err
+		}
+		frames, err := podcastChapters(chapters, total, enc)
+		if err != nil {
+			return err
+		}
+		if err := writeChapters(tag, frames); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPodcast implements the podcast command.
+func runPodcast(fs *flag.FlagSet) func() error {
+	var metaFile string
+	var dryRun bool
+	fs.StringVar(&metaFile, "meta", "", "YAML file with the fields of the episode (defaults to the .yaml or .yml file of the same name next to the MP3 file, if any)")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the file")
+	for _, f := range podcastFields {
+		fs.String(f[0], "", f[1])
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s podcast [flags] episode.mp3\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		path := fs.Arg(0)
+
+		if metaFile == "" {
+			base := strings.TrimSuffix(path, filepath.Ext(path))
+			for _, ext := range []string{".yaml", ".yml"} {
+				if _, err := os.Stat(base + ext); err == nil {
+					metaFile = base + ext
+					break
+				}
+			}
+		}
+		meta := &podcastMeta{Fields: map[string]string{}}
+		if metaFile != "" {
+			b, err := os.ReadFile(metaFile)
+			if err != nil {
+				return err
+			}
+			if meta, err = parseEpisodeYAML(b, filepath.Dir(metaFile)); err != nil {
+				return fmt.Errorf("%s: %w", metaFile, err)
+			}
+		}
+		// Flags take precedence over the YAML file.
+		fs.Visit(func(f *flag.Flag) {
+			if podcastField(f.Name) {
+				meta.Fields[f.Name] = f.Value.String()
+			}
+			if f.Name == "chapters" {
+				meta.Chapters = nil
+			}
+		})
+
+		tag, err := openTag(path)
+		if err != nil {
+			return fmt.Errorf("error opening MP3 file: %w", err)
+		}
+		defer tag.Close()
+		tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+
+		before := captureFrames(tag)
+		if err := applyPodcast(tag, path, meta); err != nil {
+			return err
+		}
+		if err := keepProtected(tag, path); err != nil {
+			return fmt.Errorf("error checking write-protected frames: %w", err)
+		}
+		changes := diffFrames(before, captureFrames(tag))
+		if dryRun {
+			fmt.Printf("==> %s <==\n", path)
+			printFrameDiff(os.Stdout, changes)
+			return nil
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+			return fmt.Errorf("error saving MP3 file: %w", err)
+		}
+		fmt.Printf("Wrote podcast frames to %s\n", path)
+		return nil
+	}
+}
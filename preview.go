@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// previewCols is the width in terminal cells of the cover preview.
+const previewCols = 40
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// coverPicture returns the front cover of tag, or its first picture if there is
+// no front cover. It returns nil if the tag has no pictures at all.
+func coverPicture(tag *id3v2.Tag) *id3v2.PictureFrame {
+	var first *id3v2.PictureFrame
+	for _, f := range tag.GetFrames(tag.CommonID("Attached picture")) {
+		pic, ok := f.(id3v2.PictureFrame)
+		if !ok {
+			continue
+		}
+		if pic.PictureType == id3v2.PTFrontCover {
+			return &pic
+		}
+		if first == nil {
+			first = &pic
+		}
+	}
+	return first
+}
+
+// detectGraphics guesses which inline image protocol the terminal speaks.
+func detectGraphics() string {
+	term := os.Getenv("TERM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty":
+		return "kitty"
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return "iterm"
+	case strings.Contains(term, "sixel") || term == "foot" || term == "mlterm":
+		return "sixel"
+	}
+	return "ascii"
+}
+
+// previewArt renders the cover of tag to w using the given mode, which is one of
+// auto, kitty, iterm, sixel, ascii or none. In auto mode nothing is printed unless
+// w is a terminal.
+func previewArt(w io.Writer, tag *id3v2.Tag, mode string) error {
+	if mode == "none" {
+		return nil
+	}
+	if mode == "auto" {
+		if f, ok := w.(*os.File); !ok || !isTerminal(f) {
+			return nil
+		}
+		mode = detectGraphics()
+	}
+	pic := coverPicture(tag)
+	if pic == nil {
+		return nil
+	}
+	return previewImage(w, pic.Picture, mode)
+}
+
+// previewImage renders the encoded image b to w using the given mode, which is
+// one of kitty, iterm, sixel or ascii.
+func previewImage(w io.Writer, b []byte, mode string) error {
+	fmt.Fprintln(w)
+	switch mode {
+	case "iterm":
+		// iTerm2 decodes the image itself, so the original bytes can be sent as is.
+		fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;width=%d;preserveAspectRatio=1:%s\a\n",
+			len(b), previewCols, base64.StdEncoding.EncodeToString(b))
+		return nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if err := checkDecodedSize(cfg); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case "kitty":
+		return writeKitty(w, img)
+	case "sixel":
+		return writeSixel(w, scaleImage(img, previewCols*8, 0))
+	case "ascii":
+		return writeASCII(w, img)
+	}
+	return fmt.Errorf("unknown preview mode: %s", mode)
+}
+
+// scaleImage resizes img to the given width using nearest-neighbour sampling.
+// If height is 0 it is derived from the aspect ratio.
+func scaleImage(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	if height == 0 {
+		height = b.Dy() * width / b.Dx()
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*b.Dx()/width, b.Min.Y+y*b.Dy()/height))
+		}
+	}
+	return dst
+}
+
+// writeKitty sends img using the kitty graphics protocol, which requires PNG data
+// transmitted in base64 chunks of at most 4096 bytes.
+func writeKitty(w io.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	for first := true; len(data) > 0; first = false {
+		n := min(len(data), 4096)
+		chunk := data[:n]
+		data = data[n:]
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,c=%d,m=%d;%s\x1b\\", previewCols, more, chunk)
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// writeSixel encodes img as DEC sixel graphics using a fixed 6x6x6 color cube.
+func writeSixel(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	index := func(x, y int) int {
+		r, g, bl, _ := img.At(x, y).RGBA()
+		return int(r>>8)*6/256*36 + int(g>>8)*6/256*6 + int(bl>>8)*6/256
+	}
+
+	var out strings.Builder
+	out.WriteString("\x1bPq")
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+	for y0 := b.Min.Y; y0 < b.Max.Y; y0 += 6 {
+		// Collect the six-pixel columns of every color used in this band.
+		bands := map[int][]byte{}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			for dy := 0; dy < 6 && y0+dy < b.Max.Y; dy++ {
+				c := index(x, y0+dy)
+				if bands[c] == nil {
+					bands[c] = make([]byte, b.Dx())
+				}
+				bands[c][x-b.Min.X] |= 1 << dy
+			}
+		}
+		for c, bits := range bands {
+			fmt.Fprintf(&out, "#%d", c)
+			for i := 0; i < len(bits); {
+				j := i
+				for j < len(bits) && bits[j] == bits[i] {
+					j++
+				}
+				if j-i > 3 {
+					fmt.Fprintf(&out, "!%d%c", j-i, 63+bits[i])
+				} else {
+					out.WriteString(strings.Repeat(string(rune(63+bits[i])), j-i))
+				}
+				i = j
+			}
+			out.WriteByte('$')
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\\n")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeASCII draws img with characters of increasing density, for terminals
+// without graphics support. Cells are about twice as high as they are wide.
+func writeASCII(w io.Writer, img image.Image) error {
+	const ramp = " .:-=+*#%@"
+	b := img.Bounds()
+	img = scaleImage(img, previewCols, b.Dy()*previewCols/b.Dx()/2)
+	b = img.Bounds()
+	var out strings.Builder
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			lum := (299*r + 587*g + 114*bl) / 1000
+			out.WriteByte(ramp[int(lum)*len(ramp)/0x10000])
+		}
+		out.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
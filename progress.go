@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressWidth is the width in terminal cells of the bar itself.
+const progressWidth = 30
+
+// progressBar shows how far a batch run has got on the last line of the
+// terminal, with the counts of files that succeeded and failed.
+type progressBar struct {
+	total      int
+	ok, failed int
+	start      time.Time
+}
+
+// progress is the bar of the run in progress, or nil if none is shown. Lines
+// printed by infof and the log package go above it.
+var progress *progressBar
+
+// startProgress shows a progress bar for a run over total files.
+func startProgress(total int) {
+	progress = &progressBar{total: total, start: time.Now()}
+	log.SetOutput(progressWriter{os.Stderr})
+	progress.draw()
+}
+
+// add counts a file that was processed with err and redraws the bar.
+func (p *progressBar) add(err error) {
+	if err != nil {
+		p.failed++
+	} else {
+		p.ok++
+	}
+	p.draw()
+}
+
+// finish removes the bar once the run is over.
+func (p *progressBar) finish() {
+	p.clear()
+	progress = nil
+	log.SetOutput(os.Stderr)
+}
+
+// draw prints the bar over the current line.
+func (p *progressBar) draw() {
+	done := p.ok + p.failed
+	filled := progressWidth * done / max(p.total, 1)
+	bar := strings.Repeat("=", filled)
+	if filled < progressWidth {
+		bar += ">" + strings.Repeat(" ", progressWidth-filled-1)
+	}
+	rate, eta := "", "ETA --"
+	if elapsed := time.Since(p.start).Seconds(); done > 0 && elapsed > 0 {
+		perSec := float64(done) / elapsed
+		rate = fmt.Sprintf("  %.1f files/s", perSec)
+		left := time.Duration(float64(p.total-done) / perSec * float64(time.Second))
+		eta = "ETA " + left.Round(time.Second).String()
+	}
+	fmt.Printf("\r\x1b[K[%s] %d/%d  %d ok  %d failed%s  %s", bar, done, p.total, p.ok, p.failed, rate, eta)
+}
+
+// clear erases the bar, leaving the cursor at the start of its line.
+func (p *progressBar) clear() {
+	fmt.Print("\r\x1b[K")
+}
+
+// printf prints a line above the bar.
+func (p *progressBar) printf(format string, args ...any) {
+	p.clear()
+	fmt.Printf(format+"\n", args...)
+	p.draw()
+}
+
+// progressWriter writes to w above the progress bar, if one is shown.
+type progressWriter struct {
+	w io.Writer
+}
+
+func (pw progressWriter) Write(b []byte) (int, error) {
+	if progress == nil {
+		return pw.w.Write(b)
+	}
+	progress.clear()
+	n, err := pw.w.Write(b)
+	progress.draw()
+	return n, err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+	"golang.org/x/term"
+)
+
+// stdin is shared by all prompts so that buffered input is not lost between them.
+var stdin = bufio.NewReader(os.Stdin)
+
+// ask prints prompt and returns the line typed by the user without surrounding
+// spaces. It returns io.EOF once standard input has ended.
+func ask(prompt string) (string, error) {
+	return askTo(os.Stdout, prompt)
+}
+
+// askTo is ask printing the prompt to w.
+func askTo(w io.Writer, prompt string) (string, error) {
+	fmt.Fprint(w, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// askSecret is ask for keys and passwords: in a terminal, what is typed is not
+// echoed, so it does not end up on the screen or in the scrollback.
+func askSecret(prompt string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return ask(prompt)
+	}
+	fmt.Print(prompt)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// confirm asks a yes/no question. Anything but an explicit yes, including the
+// end of input, counts as no.
+func confirm(prompt string) bool {
+	return confirmTo(os.Stdout, prompt)
+}
+
+// confirmTo is confirm printing the question to w.
+func confirmTo(w io.Writer, prompt string) bool {
+	answer, _ := askTo(w, prompt+" [y/N] ")
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// lyricsPreviewLines is the number of lyrics lines shown when asking for confirmation.
+const lyricsPreviewLines = 6
+
+// confirmChanges shows on w what is about to be written to path, including notes
+// about automatic matches, a lyrics excerpt and the cover dimensions, and asks the
+// user whether to go ahead. Frames are marked by how far sources trusts them.
+func confirmChanges(w io.Writer, path string, tag *id3v2.Tag, before []frameState, sources frameSources, notes []string) bool {
+	fmt.Fprintf(w, "\n==> %s <==\n", path)
+	fmt.Fprintf(w, "Track: %s - %s\n", tag.Artist(), tag.Title())
+	for _, note := range notes {
+		fmt.Fprintln(w, note)
+	}
+
+	changes := diffFrames(before, captureFrames(tag))
+	printFrameDiffSources(w, changes, sources)
+	if len(changes) == 0 {
+		return false
+	}
+	for _, c := range changes {
+		if c.After == nil {
+			continue
+		}
+		switch c.After.ID {
+		case tag.CommonID("Unsynchronised lyrics/text transcription"):
+			for _, f := range tag.GetFrames(c.After.ID) {
+				uslt, ok := f.(id3v2.UnsynchronisedLyricsFrame)
+				if !ok {
+					continue
+				}
+				lines := strings.Split(strings.TrimSpace(uslt.Lyrics), "\n")
+				if len(lines) > lyricsPreviewLines {
+					lines = append(lines[:lyricsPreviewLines], "...")
+				}
+				fmt.Fprintln(w)
+				for _, line := range lines {
+					fmt.Fprintln(w, "  "+line)
+				}
+			}
+		case tag.CommonID("Attached picture"):
+			pic := coverPicture(tag)
+			if pic == nil {
+				continue
+			}
+			fmt.Fprintln(w)
+			if cfg, format, err := image.DecodeConfig(bytes.NewReader(pic.Picture)); err == nil {
+				fmt.Fprintf(w, "Cover art: %dx%d %s, %d KB\n", cfg.Width, cfg.Height, format, len(pic.Picture)/1024)
+			} else {
+				fmt.Fprintf(w, "Cover art: %s, %d KB (%v)\n", pic.MimeType, len(pic.Picture)/1024, err)
+			}
+			previewArt(w, tag, "auto")
+		}
+	}
+	fmt.Fprintln(w)
+	return confirmTo(w, fmt.Sprintf("Write changes to %s?", path))
+}
+
+// errSkipped is returned by pickCandidate when the user picks none of the
+// candidates.
+var errSkipped = errors.New("skipped")
+
+// pickCandidate looks up the lyrics or art (kind) for the file at path and, if
+// there are several candidates, lists them and asks the user which one to use.
+// A single candidate is used as it is. The list and question go to w.
+func pickCandidate(w io.Writer, path, kind string, tag *id3v2.Tag) (*reviewCandidate, error) {
+	cands, err := findCandidates(path, kind, tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(cands) == 1 {
+		return &cands[0], nil
+	}
+	what := "Lyrics"
+	if kind == "art" {
+		what = "Cover art"
+	}
+	fmt.Fprintf(w, "\n==> %s <==\n", path)
+	fmt.Fprintf(w, "Track: %s - %s\n", tag.Artist(), tag.Title())
+	fmt.Fprintf(w, "%s candidates:\n\n", what)
+	for i, c := range cands {
+		fmt.Fprintf(w, "  %d) %3.0f%%  %s\n", i+1, c.Score*100, c.describe())
+	}
+	fmt.Fprintln(w)
+	for {
+		answer, err := askTo(w, fmt.Sprintf("Use which one? [1-%d, s to skip] (1) ", len(cands)))
+		if err != nil {
+			return nil, err
+		}
+		switch answer {
+		case "":
+			return &cands[0], nil
+		case "s", "S":
+			return nil, errSkipped
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(cands) {
+			return &cands[n-1], nil
+		}
+		fmt.Fprintln(w, "Invalid choice:", answer)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// protectedRule selects frames that mp3extra must never modify or delete,
+// typically because another tool owns them. It is written as a frame ID,
+// optionally followed by a colon and a description that may end in "*" to
+// match all descriptions with that prefix, e.g. "POPM" or "TXXX:MusicBrainz *".
+type protectedRule struct {
+	spec   string
+	id     string
+	desc   string
+	prefix bool
+}
+
+// parseProtectedRule parses a rule of the protected frames config.
+func parseProtectedRule(s string) (protectedRule, error) {
+	id, desc, hasDesc := strings.Cut(s, ":")
+	if len(id) != 4 {
+		return protectedRule{}, fmt.Errorf("invalid frame ID in %q", s)
+	}
+	r := protectedRule{spec: s, id: strings.ToUpper(id)}
+	if hasDesc {
+		r.desc, r.prefix = strings.CutSuffix(desc, "*")
+	}
+	return r, nil
+}
+
+// match reports whether f, a frame with the ID of r, is selected by r.
+func (r protectedRule) match(f id3v2.Framer) bool {
+	if r.desc == "" && !r.prefix {
+		return true
+	}
+	desc, _, ok := frameDescription(f)
+	if !ok {
+		return false
+	}
+	if r.prefix {
+		return strings.HasPrefix(desc, r.desc)
+	}
+	return desc == r.desc
+}
+
+// protectedPath returns the location of the protected frames config.
+func protectedPath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "protected.json"), nil
+}
+
+var (
+	protectedOnce  sync.Once
+	protectedCache []protectedRule
+	protectedErr   error
+)
+
+// protectedRules returns the rules of the protected frames config, which is
+// read once per run. There are none if the config does not exist.
+func protectedRules() ([]protectedRule, error) {
+	protectedOnce.Do(func() {
+		protectedCache, protectedErr = loadProtectedRules()
+	})
+	return protectedCache, protectedErr
+}
+
+// loadProtectedRules reads the protected frames config.
+func loadProtectedRules() ([]protectedRule, error) {
+	name, err := protectedPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var config struct {
+		Frames []string `json:"frames"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	var rules []protectedRule
+	for _, s := range config.Frames {
+		r, err := parseProtectedRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// protectedFrames returns the frames of tag selected by r, serialized.
+func protectedFrames(tag *id3v2.Tag, r protectedRule) [][]byte {
+	var frames [][]byte
+	for _, f := range tag.GetFrames(r.id) {
+		if r.match(f) {
+			var buf bytes.Buffer
+			f.WriteTo(&buf)
+			frames = append(frames, buf.Bytes())
+		}
+	}
+	return frames
+}
+
+// restoreProtected makes the frames of tag selected by rules the same as in
+// orig, undoing any change to them. It returns the rules whose frames were
+// restored.
+func restoreProtected(tag, orig *id3v2.Tag, rules []protectedRule) []string {
+	var restored []string
+	for _, r := range rules {
+		want, got := protectedFrames(orig, r), protectedFrames(tag, r)
+		if slices.EqualFunc(want, got, bytes.Equal) {
+			continue
+		}
+		deleteFramesFunc(tag, r.id, func(_ int, f id3v2.Framer) bool {
+			return r.match(f)
+		})
+		for _, f := range orig.GetFrames(r.id) {
+			if r.match(f) {
+				tag.AddFrame(r.id, f)
+			}
+		}
+		restored = append(restored, r.spec)
+	}
+	return restored
+}
+
+// keepProtected undoes any change to the protected frames of tag compared to
+// the tag stored in the file at path and tells the user about it. Every write
+// goes through it, so that no command touches frames owned by other tools.
+func keepProtected(tag *id3v2.Tag, path string) error {
+	return keepProtectedTo(os.Stdout, tag, path)
+}
+
+// keepProtectedTo is keepProtected telling w about the frames left unchanged.
+func keepProtectedTo(w io.Writer, tag *id3v2.Tag, path string) error {
+	rules, err := protectedRules()
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	orig, err := openTag(path)
+	if err != nil {
+		return err
+	}
+	defer orig.Close()
+	if restored := restoreProtected(tag, orig, rules); len(restored) > 0 {
+		fmt.Fprintf(w, "%s: leaving write-protected %s unchanged\n", path, strings.Join(restored, ", "))
+	}
+	return nil
+}
+
+// hasProtected reports whether the file at path has frames selected by the
+// protected frames config.
+func hasProtected(path string) (bool, error) {
+	rules, err := protectedRules()
+	if err != nil || len(rules) == 0 {
+		return false, err
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return false, err
+	}
+	defer tag.Close()
+	for _, r := range rules {
+		if len(protectedFrames(tag, r)) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// replaceRawTagProtected is replaceRawTag for bringing back an earlier tag: the
+// write-protected frames keep the state they have now.
+func replaceRawTagProtected(path string, raw []byte) error {
+	rules, err := protectedRules()
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return replaceRawTag(path, raw)
+	}
+	cur, err := openTag(path)
+	if err != nil {
+		return err
+	}
+	cur.Close()
+	if err := replaceRawTag(path, raw); err != nil {
+		return err
+	}
+
+	tag, err := openTag(path)
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+	restored := restoreProtected(tag, cur, rules)
+	if len(restored) == 0 {
+		return nil
+	}
+	fmt.Printf("%s: leaving write-protected %s unchanged\n", path, strings.Join(restored, ", "))
+	return saveTagFile(tag, path, nil)
+}
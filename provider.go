@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// trackQuery is the track a provider is asked to find lyrics or art for.
+type trackQuery struct {
+	Artist   string
+	Title    string
+	Album    string
+	Duration time.Duration // 0 if unknown
+}
+
+// lyricsProvider is a source of lyrics for automatic lookups.
+type lyricsProvider interface {
+	// Search returns the records found for q in the provider's ranking. Their
+	// Lyrics may be left empty for Fetch to fill in; Score is set by the
+	// caller.
+	Search(ctx context.Context, q trackQuery) ([]reviewCandidate, error)
+
+	// Fetch returns the lyrics of a candidate returned by Search.
+	Fetch(ctx context.Context, c *reviewCandidate) (string, error)
+}
+
+// artProvider is a source of cover art for automatic lookups.
+type artProvider interface {
+	// Search returns the records with art found for q in the provider's
+	// ranking. Score is set by the caller.
+	Search(ctx context.Context, q trackQuery) ([]reviewCandidate, error)
+
+	// Fetch returns the image of a candidate returned by Search and its
+	// content type.
+	Fetch(ctx context.Context, c *reviewCandidate) ([]byte, string, error)
+}
+
+// lyricsProviders and artProviders hold the built-in providers by name. Other
+// providers are external plugins, see plugin.go.
+var (
+	lyricsProviders = map[string]lyricsProvider{}
+	artProviders    = map[string]artProvider{}
+)
+
+// registerLyricsProvider makes a lyrics provider available to -lyrics-provider.
+func registerLyricsProvider(name string, p lyricsProvider) {
+	lyricsProviders[name] = p
+}
+
+// registerArtProvider makes an art provider available to -art-provider.
+func registerArtProvider(name string, p artProvider) {
+	artProviders[name] = p
+}
+
+func init() {
+	registerLyricsProvider(providerLrclib, lrclibProvider{})
+	registerArtProvider(providerITunes, itunesProvider{})
+}
+
+// lyricsProviderName and artProviderName name the providers of automatic
+// lookups, set by -lyrics-provider and -art-provider.
+var (
+	lyricsProviderName = providerLrclib
+	artProviderName    = providerITunes
+)
+
+// lyricsProviderNamed returns the lyrics provider called name: a built-in one
+// or else a plugin.
+func lyricsProviderNamed(name string) (lyricsProvider, error) {
+	if p, ok := lyricsProviders[name]; ok {
+		return p, nil
+	}
+	p, err := findPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	return pluginLyrics{p}, nil
+}
+
+// artProviderNamed returns the art provider called name: a built-in one or
+// else a plugin.
+func artProviderNamed(name string) (artProvider, error) {
+	if p, ok := artProviders[name]; ok {
+		return p, nil
+	}
+	p, err := findPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	return pluginArt{p}, nil
+}
+
+// providerFlags defines the -lyrics-provider and -art-provider flags on fs.
+// The returned function checks and selects the providers once fs is parsed.
+func providerFlags(fs *flag.FlagSet) func() error {
+	lyrics := fs.String("lyrics-provider", providerLrclib, "Provider of automatic lyrics lookups: lrclib or the name of a plugin")
+	art := fs.String("art-provider", providerITunes, "Provider of automatic cover art lookups: itunes or the name of a plugin")
+	return func() error {
+		if _, err := lyricsProviderNamed(*lyrics); err != nil {
+			return err
+		}
+		if _, err := artProviderNamed(*art); err != nil {
+			return err
+		}
+		lyricsProviderName, artProviderName = *lyrics, *art
+		return nil
+	}
+}
+
+// candidateScore returns the score of a candidate of kind for q: how well its
+// artist and title match and, for lyrics, its duration.
+func candidateScore(kind string, q trackQuery, c *reviewCandidate) float64 {
+	score := matchScore(q.Artist, q.Title, c.Artist, c.Title)
+	if kind == "lyrics" {
+		score *= durationScore(q.Duration, c.Duration)
+	}
+	return score
+}
+
+// kindProvider returns the name of the provider of automatic lookups of kind.
+func kindProvider(kind string) string {
+	if kind == "art" {
+		return artProviderName
+	}
+	return lyricsProviderName
+}
+
+// searchProvider searches the provider of automatic lookups of kind for q and
+// returns its candidates, scored, in the provider's ranking.
+func searchProvider(kind string, q trackQuery) ([]reviewCandidate, error) {
+	name := kindProvider(kind)
+	var cands []reviewCandidate
+	var err error
+	switch kind {
+	case "lyrics":
+		var p lyricsProvider
+		if p, err = lyricsProviderNamed(name); err == nil {
+			cands, err = p.Search(context.Background(), q)
+		}
+	case "art":
+		var p artProvider
+		if p, err = artProviderNamed(name); err == nil {
+			cands, err = p.Search(context.Background(), q)
+		}
+	default:
+		return nil, fmt.Errorf("unknown item: %s", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range cands {
+		cands[i].Provider = name
+		cands[i].Score = candidateScore(kind, q, &cands[i])
+	}
+	return cands, nil
+}
+
+// candidateLyrics returns the lyrics of c, fetching them from its provider if
+// the search left them out.
+func candidateLyrics(c *reviewCandidate) (string, error) {
+	if c.Lyrics != "" {
+		return c.Lyrics, nil
+	}
+	p, err := lyricsProviderNamed(c.Provider)
+	if err != nil {
+		return "", err
+	}
+	return p.Fetch(context.Background(), c)
+}
+
+// candidateArt returns the image of c, fetched from its provider unless it is
+// in the artwork cache, and its content type.
+func candidateArt(c *reviewCandidate) ([]byte, string, error) {
+	// Candidates queued before providers were recorded come from iTunes.
+	name := c.Provider
+	if name == "" {
+		name = providerITunes
+	}
+	p, err := artProviderNamed(name)
+	if err != nil {
+		return nil, "", err
+	}
+	key := urlCacheKey(c)
+	if b, ct, _, ok := cachedArt(key); ok {
+		debugf("reusing the cached cover art of %s", c.source())
+		return b, ct, nil
+	}
+	b, ct, err := p.Fetch(context.Background(), c)
+	if err != nil {
+		return nil, "", err
+	}
+	// Plugins are not bound by the ceiling while fetching.
+	if err := checkSize("album art image", int64(len(b)), maxImageBytes); err != nil {
+		return nil, "", err
+	}
+	cacheArt(b, ct, c, key)
+	return b, ct, nil
+}
+
+// source returns where the lyrics or art of c come from: the URL of the
+// record or image at the provider.
+func (c *reviewCandidate) source() string {
+	switch {
+	case c.URL != "":
+		return c.URL
+	case c.Provider == providerLrclib:
+		return lrclibRecordURL(c.RecordID)
+	case c.ArtworkURL != "":
+		return artworkURL(c.ArtworkURL)
+	}
+	return c.Provider
+}
+
+// lookupLyrics finds the lyrics for artist, title and, if it is not 0, the
+// duration of a track with the provider of automatic lookups. It returns the
+// best scored candidate with its lyrics filled in, unless its score is below
+// minLyricsScore. The lookup is recorded in the statistics.
+func lookupLyrics(artist, title string, duration time.Duration) (*reviewCandidate, error) {
+	start := time.Now()
+	c, err := bestLyrics(trackQuery{Artist: artist, Title: title, Duration: duration})
+	recordLookup(lyricsProviderName, start, err)
+	return c, err
+}
+
+// bestLyrics implements lookupLyrics.
+func bestLyrics(q trackQuery) (*reviewCandidate, error) {
+	artist, title := q.Artist, q.Title
+	cands, err := searchProvider("lyrics", q)
+	if err != nil {
+		return nil, err
+	}
+	var best *reviewCandidate
+	for i := range cands {
+		c := &cands[i]
+		// The first of equally scored records wins, keeping the provider's ranking.
+		debugf("%s record %s - %s (%s, %.0fs) scores %.0f%%", c.Provider, c.Artist, c.Title, c.Album, c.Duration, c.Score*100)
+		if best == nil || c.Score > best.Score {
+			best = c
+		}
+	}
+	if best == nil || best.Score < minLyricsScore {
+		debugf("no %s record for %s - %s scores %.0f%% or more", lyricsProviderName, artist, title, minLyricsScore*100)
+		return nil, fmt.Errorf("%w for %s - %s", errLyricsNotFound, artist, title)
+	}
+	if best.Lyrics, err = candidateLyrics(best); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(best.Lyrics) == "" {
+		return nil, fmt.Errorf("%w for %s - %s", errLyricsNotFound, artist, title)
+	}
+	debugf("picked %s record %s for %s - %s", best.Provider, best.source(), artist, title)
+	return best, nil
+}
+
+// lookupArt finds the cover art for artist and title with the provider of
+// automatic lookups, taking the first candidate in the provider's ranking. It
+// returns the image, its content type and the candidate. The lookup is recorded
+// in the statistics.
+func lookupArt(artist, title string) ([]byte, string, *reviewCandidate, error) {
+	start := time.Now()
+	cands, err := searchProvider("art", trackQuery{Artist: artist, Title: title})
+	if err == nil && len(cands) == 0 {
+		err = errArtNotFound
+	}
+	var b []byte
+	var ct string
+	if err == nil {
+		b, ct, err = candidateArt(&cands[0])
+	}
+	recordLookup(artProviderName, start, err)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	c := &cands[0]
+	debugf("took the cover art of the first %s match for %s - %s: %s - %s (%s)", c.Provider, artist, title, c.Artist, c.Title, c.Album)
+	return b, ct, c, nil
+}
+
+// lrclibProvider looks up lyrics on lrclib.net.
+type lrclibProvider struct{}
+
+func (lrclibProvider) Search(ctx context.Context, q trackQuery) ([]reviewCandidate, error) {
+	results, err := searchLrclib(ctx, q.Artist, q.Title)
+	if err != nil {
+		return nil, err
+	}
+	var cands []reviewCandidate
+	for _, r := range results {
+		lyrics := r.lyrics()
+		if lyrics == "" {
+			continue
+		}
+		cands = append(cands, reviewCandidate{
+			Artist:   r.ArtistName,
+			Title:    r.TrackName,
+			Album:    r.AlbumName,
+			Duration: r.Duration,
+			Lyrics:   lyrics,
+			Synced:   r.SyncedLyrics != "",
+			RecordID: r.ID,
+		})
+	}
+	return cands, nil
+}
+
+func (lrclibProvider) Fetch(ctx context.Context, c *reviewCandidate) (string, error) {
+	var r lrclibResult
+	if err := getJSON(ctx, lrclibRecordURL(c.RecordID), &r); err != nil {
+		return "", err
+	}
+	return r.lyrics(), nil
+}
+
+// itunesProvider looks up cover art in the iTunes Store.
+type itunesProvider struct{}
+
+func (itunesProvider) Search(ctx context.Context, q trackQuery) ([]reviewCandidate, error) {
+	tracks, err := searchITunes(ctx, q.Artist, q.Title, quarantineCandidates)
+	if err != nil {
+		return nil, err
+	}
+	return itunesCandidates(q.Artist, q.Title, tracks), nil
+}
+
+func (itunesProvider) Fetch(ctx context.Context, c *reviewCandidate) ([]byte, string, error) {
+	return fetchArtworkSize(ctx, c.ArtworkURL, 600)
+}
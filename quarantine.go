@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// quarantineCandidates is the number of candidates kept for a quarantined match.
+const quarantineCandidates = 5
+
+// reviewCandidate is a possible match for a quarantined file.
+type reviewCandidate struct {
+	Provider string  `json:"provider"`
+	Artist   string  `json:"artist"`
+	Title    string  `json:"title"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	Score    float64 `json:"score"`
+
+	Lyrics     string `json:"lyrics,omitempty"`      // for lyrics candidates
+	Synced     bool   `json:"synced,omitempty"`      // whether Lyrics are in LRC format
+	RecordID   int    `json:"record_id,omitempty"`   // lrclib ID of lyrics candidates
+	ArtworkURL string `json:"artwork_url,omitempty"` // iTunes artwork of art candidates
+
+	// ID and URL identify the records of plugins: ID is passed back to fetch
+	// the lyrics or art, and URL is recorded as their source.
+	ID  string `json:"id,omitempty"`
+	URL string `json:"url,omitempty"`
+}
+
+// describe returns the track of c, with its album and duration and, for lyrics,
+// whether they are synchronised.
+func (c *reviewCandidate) describe() string {
+	info := []string{c.Album}
+	if c.Duration > 0 {
+		info = append(info, time.Duration(c.Duration*float64(time.Second)).Round(time.Second).String())
+	}
+	if c.Lyrics != "" {
+		if c.Synced {
+			info = append(info, "synced")
+		} else {
+			info = append(info, "plain")
+		}
+	}
+	return fmt.Sprintf("%s - %s (%s)", c.Artist, c.Title, strings.Join(info, ", "))
+}
+
+// quarantineEntry is an automatic lookup for a file that found no confident
+// match and waits for the user to pick a candidate.
+type quarantineEntry struct {
+	Time       time.Time         `json:"time"`
+	Path       string            `json:"path"`
+	Kind       string            `json:"kind"` // "lyrics" or "art"
+	Artist     string            `json:"artist"`
+	Title      string            `json:"title"`
+	Lang       string            `json:"lang,omitempty"`
+	Candidates []reviewCandidate `json:"candidates"`
+}
+
+// quarantinePath returns the location of the quarantine file.
+func quarantinePath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "quarantine.json"), nil
+}
+
+// loadQuarantine reads the quarantined entries, oldest first.
+func loadQuarantine() ([]*quarantineEntry, error) {
+	name, err := quarantinePath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []*quarantineEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return entries, nil
+}
+
+// saveQuarantine replaces the quarantined entries.
+func saveQuarantine(entries []*quarantineEntry) error {
+	name, err := quarantinePath()
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []*quarantineEntry{}
+	}
+	return writeJSONFile(name, entries)
+}
+
+// quarantine adds e to the quarantine, replacing an earlier entry for the same
+// file and kind.
+func quarantine(e *quarantineEntry) error {
+	abs, err := filepath.Abs(e.Path)
+	if err != nil {
+		return err
+	}
+	e.Path = abs
+	e.Time = time.Now()
+	return withStateLock(func() error {
+		entries, err := loadQuarantine()
+		if err != nil {
+			return err
+		}
+		kept := entries[:0]
+		for _, old := range entries {
+			if old.Path != e.Path || old.Kind != e.Kind {
+				kept = append(kept, old)
+			}
+		}
+		return saveQuarantine(append(kept, e))
+	})
+}
+
+// unquarantine removes e from the quarantine. The quarantine is read again, so
+// that entries added by other processes in the meantime are kept.
+func unquarantine(e *quarantineEntry) error {
+	return withStateLock(func() error {
+		entries, err := loadQuarantine()
+		if err != nil {
+			return err
+		}
+		kept := entries[:0]
+		for _, old := range entries {
+			if old.Path != e.Path || old.Kind != e.Kind || !old.Time.Equal(e.Time) {
+				kept = append(kept, old)
+			}
+		}
+		return saveQuarantine(kept)
+	})
+}
+
+// matchWords splits s into lower-case words, ignoring punctuation.
+func matchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// wordSimilarity returns the share of words a and b have in common, from 0 to 1.
+func wordSimilarity(a, b string) float64 {
+	wa, wb := matchWords(a), matchWords(b)
+	if len(wa) == 0 && len(wb) == 0 {
+		return 1
+	}
+	set := map[string]bool{}
+	for _, w := range wa {
+		set[w] = true
+	}
+	common := 0
+	union := len(set)
+	seen := map[string]bool{}
+	for _, w := range wb {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		if set[w] {
+			common++
+		} else {
+			union++
+		}
+	}
+	return float64(common) / float64(union)
+}
+
+// matchScore rates how well a found track matches the artist and title a file is
+// tagged with, from 0 to 1. Differences in case and punctuation are ignored.
+func matchScore(artist, title, foundArtist, foundTitle string) float64 {
+	return (wordSimilarity(artist, foundArtist) + wordSimilarity(title, foundTitle)) / 2
+}
+
+// durationTolerance is how far the duration of a found track may differ from
+// that of the file without lowering its score. Beyond it, the score drops to 0
+// at a difference of durationTolerance plus durationSpread, which tells
+// studio and live versions or radio edits apart.
+const (
+	durationTolerance = 3 * time.Second
+	durationSpread    = 30 * time.Second
+)
+
+// durationScore rates how well the duration of a found track in seconds matches
+// the duration of a file, from 0 to 1. Unknown durations, 0, match any.
+func durationScore(duration time.Duration, found float64) float64 {
+	if duration == 0 || found == 0 {
+		return 1
+	}
+	diff := (duration - time.Duration(found*float64(time.Second))).Abs()
+	if diff <= durationTolerance {
+		return 1
+	}
+	return max(0, 1-float64(diff-durationTolerance)/float64(durationSpread))
+}
+
+// lyricsCandidates searches the provider of automatic lookups and returns the
+// best scored candidates for artist, title and, if it is not 0, duration, with
+// their lyrics.
+func lyricsCandidates(artist, title string, duration time.Duration) ([]reviewCandidate, error) {
+	cands, err := searchProvider("lyrics", trackQuery{Artist: artist, Title: title, Duration: duration})
+	if err != nil {
+		return nil, err
+	}
+	cands = bestCandidates(cands)
+	for i := range cands {
+		if cands[i].Lyrics, err = candidateLyrics(&cands[i]); err != nil {
+			return nil, err
+		}
+	}
+	return cands, nil
+}
+
+// artCandidates searches the provider of automatic lookups and returns the best
+// scored candidates for artist and title.
+func artCandidates(artist, title string) ([]reviewCandidate, error) {
+	cands, err := searchProvider("art", trackQuery{Artist: artist, Title: title})
+	if err != nil {
+		return nil, err
+	}
+	return bestCandidates(cands), nil
+}
+
+// itunesCandidates returns the tracks with artwork iTunes found for artist and
+// title as scored candidates, in the order of iTunes' ranking.
+func itunesCandidates(artist, title string, tracks []itunesTrack) []reviewCandidate {
+	var cands []reviewCandidate
+	for _, t := range tracks {
+		if t.ArtworkURL100 == "" {
+			continue
+		}
+		cands = append(cands, reviewCandidate{
+			Provider:   providerITunes,
+			Artist:     t.ArtistName,
+			Title:      t.TrackName,
+			Album:      t.CollectionName,
+			Score:      matchScore(artist, title, t.ArtistName, t.TrackName),
+			ArtworkURL: t.ArtworkURL100,
+		})
+	}
+	return cands
+}
+
+// bestCandidates sorts cands by descending score and keeps the best of them.
+func bestCandidates(cands []reviewCandidate) []reviewCandidate {
+	// Stable, so that ties keep the order of the provider's ranking.
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].Score > cands[j].Score
+	})
+	if len(cands) > quarantineCandidates {
+		cands = cands[:quarantineCandidates]
+	}
+	return cands
+}
+
+// errQuarantined is returned when a lookup found no confident match and its
+// candidates were queued for review.
+var errQuarantined = errors.New("no confident match, queued for review")
+
+// findCandidates looks up the lyrics or art (kind) for the file at path with
+// tag and returns the best scored candidates, of which there is at least one.
+func findCandidates(path, kind string, tag *id3v2.Tag) ([]reviewCandidate, error) {
+	var cands []reviewCandidate
+	var err error
+	// Files whose audio cannot be read match records of any duration.
+	duration, _ := audioDuration(path)
+	start := time.Now()
+	switch kind {
+	case "lyrics":
+		cands, err = lyricsCandidates(tag.Artist(), tag.Title(), duration)
+		if err == nil && len(cands) == 0 {
+			err = fmt.Errorf("%w for %s - %s", errLyricsNotFound, tag.Artist(), tag.Title())
+		}
+	case "art":
+		cands, err = artCandidates(tag.Artist(), tag.Title())
+		if err == nil && len(cands) == 0 {
+			err = errArtNotFound
+		}
+	default:
+		return nil, fmt.Errorf("unknown item: %s", kind)
+	}
+	recordLookup(kindProvider(kind), start, err)
+	return cands, err
+}
+
+// confidentMatch looks up the lyrics or art (kind) for the file at path and
+// returns the best candidate if it matches the tag exactly, ignoring case and
+// punctuation, and for lyrics also the duration of the file. Otherwise the
+// candidates are quarantined, unless dryRun is set, and errQuarantined is
+// returned.
+func confidentMatch(path, kind string, tag *id3v2.Tag, lang string, dryRun bool) (*reviewCandidate, error) {
+	cands, err := findCandidates(path, kind, tag)
+	if err != nil {
+		return nil, err
+	}
+	if cands[0].Score == 1 {
+		debugf("%s: %s match %s is exact", path, kind, cands[0].describe())
+		return &cands[0], nil
+	}
+	debugf("%s: best %s match %s scores only %.0f%%", path, kind, cands[0].describe(), cands[0].Score*100)
+	if !dryRun {
+		err = quarantine(&quarantineEntry{
+			Path:       path,
+			Kind:       kind,
+			Artist:     tag.Artist(),
+			Title:      tag.Title(),
+			Lang:       lang,
+			Candidates: cands,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errQuarantined
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// defaultRatingEmail is the email identifier of the POPM frames written for
+// ratings. Windows Media Player's is read by most players and by Windows
+// itself.
+const defaultRatingEmail = "Windows Media Player 9 Series"
+
+// ratingBytes maps star ratings to the POPM rating byte Windows Media Player
+// and most players following it use. 0 stars means unrated.
+var ratingBytes = [6]uint8{0, 1, 64, 128, 196, 255}
+
+// parseRating parses a star rating from 0 (unrated) to 5.
+func parseRating(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 5 {
+		return 0, fmt.Errorf("invalid rating %q: want 1 to 5, or 0 to remove it", s)
+	}
+	return n, nil
+}
+
+// ratingStars returns the star rating of a POPM rating byte, or 0 if b is 0
+// (unrated). Bytes between those of ratingBytes count as the nearest rating.
+func ratingStars(b uint8) int {
+	switch {
+	case b == 0:
+		return 0
+	case b < 32:
+		return 1
+	case b < 96:
+		return 2
+	case b < 160:
+		return 3
+	case b < 224:
+		return 4
+	}
+	return 5
+}
+
+// setRating sets the rating of the POPM frame of tag with the given email to
+// stars, keeping its play counter. 0 stars removes the rating, and the frame
+// along with it unless it counts plays.
+func setRating(tag *id3v2.Tag, stars int, email string) {
+	counter := new(big.Int)
+	deleteFramesFunc(tag, "POPM", func(_ int, f id3v2.Framer) bool {
+		pf, ok := f.(id3v2.PopularimeterFrame)
+		if ok && pf.Email == email {
+			if pf.Counter != nil {
+				counter = pf.Counter
+			}
+			return true
+		}
+		return false
+	})
+	if stars == 0 && counter.Sign() == 0 {
+		return
+	}
+	tag.AddFrame("POPM", id3v2.PopularimeterFrame{Email: email, Rating: ratingBytes[stars], Counter: counter})
+}
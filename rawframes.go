@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// rawFrame is a frame as stored in an ID3v2.3 or ID3v2.4 tag.
+type rawFrame struct {
+	ID    string
+	Flags [2]byte
+	Body  []byte
+}
+
+// errEncryptedFrame is returned for frames whose body is encrypted, which can
+// be neither read nor written back as they are.
+var errEncryptedFrame = errors.New("encrypted frame")
+
+// validFrameID reports whether id consists of capital letters and digits only.
+func validFrameID(id string) bool {
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; !('A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return len(id) == 4
+}
+
+// readRawFrames returns the version and frames of the ID3v2 tag at the start of
+// the file at path, reading it the way the id3v2 library does. It returns no
+// frames for files without a tag, and for ID3v2.2 tags and tags with an
+// extended header or unsynchronisation, which the library does not read either.
+func readRawFrames(path string) (byte, []rawFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	return parseRawFrames(f)
+}
+
+// parseRawFrames is like readRawFrames, but reads the tag from r.
+func parseRawFrames(r io.Reader) (byte, []rawFrame, error) {
+	var h [10]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, nil, nil
+		}
+		return 0, nil, err
+	}
+	version := h[3]
+	if string(h[:3]) != "ID3" || (version != 3 && version != 4) || h[5]&0xc0 != 0 {
+		return version, nil, nil
+	}
+	size := int(h[6]&0x7f)<<21 | int(h[7]&0x7f)<<14 | int(h[8]&0x7f)<<7 | int(h[9]&0x7f)
+	b := make([]byte, size)
+	n, err := io.ReadFull(r, b)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, nil, err
+	}
+	b = b[:n]
+
+	var frames []rawFrame
+	for len(b) >= 10 {
+		id := string(b[:4])
+		var n int
+		if version == 4 {
+			n = int(b[4]&0x7f)<<21 | int(b[5]&0x7f)<<14 | int(b[6]&0x7f)<<7 | int(b[7]&0x7f)
+		} else {
+			n = int(b[4])<<24 | int(b[5])<<16 | int(b[6])<<8 | int(b[7])
+		}
+		if !validFrameID(id) || n == 0 || n > len(b)-10 {
+			break
+		}
+		frames = append(frames, rawFrame{ID: id, Flags: [2]byte{b[8], b[9]}, Body: b[10 : 10+n]})
+		b = b[10+n:]
+	}
+	return version, frames, nil
+}
+
+// plainBody returns the body of f with the grouping, compression and
+// unsynchronisation given by its format flags undone, as the id3v2 library
+// writes frames without any of them.
+func (f *rawFrame) plainBody(version byte) ([]byte, error) {
+	body, format := f.Body, f.Flags[1]
+	var grouped, encrypted, compressed, unsynced bool
+	skip := 0
+	if version == 4 {
+		grouped, encrypted, compressed, unsynced = format&0x40 != 0, format&0x04 != 0, format&0x08 != 0, format&0x02 != 0
+		if format&0x01 != 0 {
+			skip += 4 // data length indicator
+		}
+	} else {
+		grouped, encrypted, compressed = format&0x20 != 0, format&0x40 != 0, format&0x80 != 0
+		if compressed {
+			skip += 4 // decompressed size
+		}
+	}
+	if encrypted {
+		return nil, errEncryptedFrame
+	}
+	if grouped {
+		skip++
+	}
+	if skip > len(body) {
+		return nil, fmt.Errorf("%s frame too short for its flags", f.ID)
+	}
+	body = body[skip:]
+	if unsynced {
+		body = bytes.ReplaceAll(body, []byte{0xff, 0x00}, []byte{0xff})
+	}
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%s frame: %w", f.ID, err)
+		}
+		defer zr.Close()
+		if body, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("%s frame: %w", f.ID, err)
+		}
+	}
+	return body, nil
+}
+
+// parseRawFrame parses the frame with the given ID and plain body on its own
+// with the id3v2 library. If the library cannot make sense of it, the body is
+// kept as an unknown frame, so that it is written back unchanged.
+func parseRawFrame(id string, body []byte, version byte) id3v2.Framer {
+	var b bytes.Buffer
+	b.WriteString("ID3")
+	b.Write([]byte{version, 0, 0})
+	var size [4]byte
+	putSize(size[:], 10+len(body), true)
+	b.Write(size[:])
+	b.WriteString(id)
+	putSize(size[:], len(body), version == 4)
+	b.Write(size[:])
+	b.Write([]byte{0, 0})
+	b.Write(body)
+
+	tag, err := id3v2.ParseReader(&b, id3v2.Options{Parse: true})
+	if err == nil {
+		if frames := tag.AllFrames()[id]; len(frames) == 1 {
+			return frames[0]
+		}
+	}
+	return id3v2.UnknownFrame{Body: body}
+}
+
+// openTag opens the tag of the MP3 file at path like id3v2.Open, but without
+// losing frames the library cannot handle. The library gives up on the rest of
+// the tag at the first frame it fails to parse, ignores frame flags and loses
+// its place after CHAP frames with subframes other than TIT2 and TIT3, so it is
+// only left to check the frame sizes and the frames are parsed here one by one:
+// frames the library cannot parse are kept as opaque unknown frames, and
+// compressed or unsynchronised frames are decoded first. Encrypted frames cannot
+// be written back and are dropped with a warning. CHAP and CTOC frames are read
+// as chapterFrame and tocFrame, PCNT frames as playCounterFrame and URL frames
+// as urlFrame and userURLFrame. Frames the library would merge with an earlier
+// one are kept as duplicateFrame.
+func openTag(path string) (*id3v2.Tag, error) {
+	// No frame has a blank ID, so the library skips the body of every frame.
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true, ParseFrames: []string{""}})
+	if err != nil {
+		return nil, err
+	}
+	version, raw, err := readRawFrames(path)
+	if err != nil {
+		tag.Close()
+		return nil, err
+	}
+	setRawFrames(tag, path, version, raw)
+	return tag, nil
+}
+
+// setRawFrames replaces the frames of tag, read from the file at path, with
+// raw, as described for openTag. Without raw frames, tag is left alone.
+func setRawFrames(tag *id3v2.Tag, path string, version byte, raw []rawFrame) {
+	if len(raw) == 0 {
+		return
+	}
+	tag.DeleteAllFrames()
+	for i, f := range raw {
+		body, err := f.plainBody(version)
+		if err != nil {
+			log.Printf("%s: cannot keep %s frame: %v", path, f.ID, err)
+			continue
+		}
+		addRawFrame(tag, path, f.ID, i, parseFrameBody(path, f.ID, body, version))
+	}
+}
+
+// parseFrameBody parses the plain body of a frame with the given ID into the
+// type openTag reads it as.
+func parseFrameBody(path, id string, body []byte, version byte) id3v2.Framer {
+	if cf, ok, err := parseChapterOrTOC(id, body, version); ok {
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			return id3v2.UnknownFrame{Body: body}
+		}
+		return cf
+	}
+	if uf, ok, err := parseURLFrame(id, body, version); ok && err == nil {
+		return uf
+	}
+	if id == "PCNT" {
+		if pc, err := parsePlayCounter(body); err == nil {
+			return pc
+		}
+	}
+	return parseRawFrame(id, body, version)
+}
+
+// addRawFrame adds f, the i-th frame of the tag of the file at path, to tag.
+// The id3v2 library replaces frames with the same ID and identity, such as two
+// comments with the same language and description, so such a frame is added
+// as a duplicateFrame instead. Frames of which the library keeps only one, such
+// as text frames, cannot be kept twice: the first one is kept with a warning.
+func addRawFrame(tag *id3v2.Tag, path, id string, i int, f id3v2.Framer) {
+	if _, ok := f.(id3v2.UnknownFrame); !ok {
+		for _, g := range tag.GetFrames(id) {
+			if g.UniqueIdentifier() != f.UniqueIdentifier() {
+				continue
+			}
+			if !sequenceFrame(id) {
+				log.Printf("%s: dropping another %s frame, which ID3v2 allows only once", path, id)
+				return
+			}
+			f = duplicateFrame{Framer: f, index: i}
+			break
+		}
+	}
+	tag.AddFrame(id, f)
+}
+
+// sequenceFrame reports whether the id3v2 library keeps several frames with
+// the given ID, as it does for all but text frames and a few others.
+func sequenceFrame(id string) bool {
+	if id != "TXXX" && strings.HasPrefix(id, "T") {
+		return false
+	}
+	switch id {
+	case "MCDI", "ETCO", "SYTC", "RVRB", "MLLT", "PCNT", "RBUF", "POSS", "OWNE", "SEEK", "ASPI", "IPLS", "RVAD":
+		return false
+	}
+	return true
+}
+
+// duplicateFrame is a frame with the same ID and identity as an earlier frame
+// of its tag, e.g. a second front cover with the same description. It is
+// written back like the frame it wraps, but not merged with the earlier one.
+type duplicateFrame struct {
+	id3v2.Framer
+	index int // position in the tag as read, which tells duplicates apart
+}
+
+func (df duplicateFrame) UniqueIdentifier() string {
+	return "duplicate#" + strconv.Itoa(df.index)
+}
+
+// plainFrame returns the frame f stands for: the wrapped frame of a
+// duplicateFrame, or else f itself.
+func plainFrame(f id3v2.Framer) id3v2.Framer {
+	if df, ok := f.(duplicateFrame); ok {
+		return df.Framer
+	}
+	return f
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// rawTag returns an ID3v2 tag of the given version and header flags holding
+// frames, with frame sizes stored as the version does.
+func rawTag(version, flags byte, frames ...rawFrame) []byte {
+	var body bytes.Buffer
+	for _, f := range frames {
+		var size [4]byte
+		putSize(size[:], len(f.Body), version == 4)
+		body.WriteString(f.ID)
+		body.Write(size[:])
+		body.Write(f.Flags[:])
+		body.Write(f.Body)
+	}
+	b := []byte{'I', 'D', '3', version, 0, flags, 0, 0, 0, 0}
+	putSize(b[6:10], body.Len(), true)
+	return append(b, body.Bytes()...)
+}
+
+// textBody returns the body of an ISO-8859-1 text frame.
+func textBody(s string) []byte {
+	return append([]byte{0}, s...)
+}
+
+func TestParseRawFrames(t *testing.T) {
+	// A body longer than 127 bytes has a size that differs when synchsafe.
+	long := textBody(string(bytes.Repeat([]byte("x"), 300)))
+	frames := []rawFrame{
+		{ID: "TIT2", Body: textBody("Title")},
+		{ID: "TXXX", Body: long},
+	}
+	padded := append(rawTag(4, 0, frames[0]), make([]byte, 32)...)
+	putSize(padded[6:10], len(padded)-10, true)
+	tests := []struct {
+		name string
+		tag  []byte
+		want []rawFrame
+	}{
+		{"v3", rawTag(3, 0, frames...), frames},
+		{"v4", rawTag(4, 0, frames...), frames},
+		{"padding", padded, frames[:1]},
+		{"unsynchronised tag", rawTag(3, 0x80, frames...), nil},
+		{"extended header", rawTag(4, 0x40, frames...), nil},
+		{"v2.2", rawTag(2, 0, frames...), nil},
+		{"no tag", []byte("\xff\xfb\x90\x00 audio"), nil},
+		{"empty file", nil, nil},
+		{"truncated tag", rawTag(4, 0, frames...)[:40], frames[:1]},
+	}
+	for _, tt := range tests {
+		_, got, err := parseRawFrames(bytes.NewReader(tt.tag))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d frames, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, f := range got {
+			if f.ID != tt.want[i].ID || !bytes.Equal(f.Body, tt.want[i].Body) {
+				t.Errorf("%s: frame %d = %s %q, want %s %q", tt.name, i, f.ID, f.Body, tt.want[i].ID, tt.want[i].Body)
+			}
+		}
+	}
+}
+
+func TestPlainBody(t *testing.T) {
+	plain := []byte("\x00\xff\xe0 sync-like bytes \xff")
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(plain)
+	zw.Close()
+	unsynced := bytes.ReplaceAll(plain, []byte{0xff}, []byte{0xff, 0x00})
+	length := []byte{0, 0, 0, byte(len(plain))}
+
+	tests := []struct {
+		name    string
+		version byte
+		format  byte
+		body    []byte
+		err     bool
+	}{
+		{"v4 plain", 4, 0, plain, false},
+		{"v4 unsynchronised", 4, 0x02, unsynced, false},
+		{"v4 unsynchronised with data length", 4, 0x03, append(length, unsynced...), false},
+		{"v4 compressed", 4, 0x09, append(length, compressed.Bytes()...), false},
+		{"v4 grouped", 4, 0x40, append([]byte{7}, plain...), false},
+		{"v4 encrypted", 4, 0x04, append([]byte{1}, plain...), true},
+		{"v4 data length cut short", 4, 0x01, []byte{0, 0}, true},
+		{"v3 plain", 3, 0, plain, false},
+		{"v3 compressed", 3, 0x80, append(length, compressed.Bytes()...), false},
+		{"v3 grouped", 3, 0x20, append([]byte{7}, plain...), false},
+		{"v3 encrypted", 3, 0x40, append([]byte{1}, plain...), true},
+		{"v3 bad compressed data", 3, 0x80, append(length, plain...), true},
+	}
+	for _, tt := range tests {
+		f := rawFrame{ID: "PRIV", Flags: [2]byte{0, tt.format}, Body: tt.body}
+		got, err := f.plainBody(tt.version)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%s: %q, %v; want %q", tt.name, got, err, plain)
+		}
+	}
+	f := rawFrame{ID: "PRIV", Flags: [2]byte{0, 0x04}, Body: []byte{1, 2}}
+	if _, err := f.plainBody(4); !errors.Is(err, errEncryptedFrame) {
+		t.Errorf("encrypted frame: %v, want errEncryptedFrame", err)
+	}
+}
+
+// writeRawMP3 writes a file of the tag followed by audio and returns its path.
+func writeRawMP3(t *testing.T, tag []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "raw.mp3")
+	if err := os.WriteFile(path, append(tag, "\xff\xfb\x90\x00 audio"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenTagKeepsFrames(t *testing.T) {
+	comment := func(text string) []byte {
+		return append([]byte("\x00eng\x00"), text...)
+	}
+	unknown := []byte("\x01\x02 opaque data the library does not model")
+	plain := textBody("Unsynchronised \xff\xe0 title")
+	for _, version := range []byte{3, 4} {
+		title := rawFrame{ID: "TIT2", Body: plain}
+		if version == 4 {
+			title.Flags[1] = 0x02
+			title.Body = bytes.ReplaceAll(plain, []byte{0xff}, []byte{0xff, 0x00})
+		}
+		path := writeRawMP3(t, rawTag(version, 0,
+			title,
+			rawFrame{ID: "XQZW", Body: unknown},
+			rawFrame{ID: "COMM", Body: comment("first")},
+			rawFrame{ID: "COMM", Body: comment("second")},
+			rawFrame{ID: "TIT2", Body: textBody("Another title")},
+			rawFrame{ID: "PRIV", Flags: [2]byte{0, map[byte]byte{3: 0x40, 4: 0x04}[version]}, Body: []byte{1, 2, 3}},
+		))
+
+		check := func(step string) {
+			tag, err := openTag(path)
+			if err != nil {
+				t.Fatalf("v%d %s: %v", version, step, err)
+			}
+			defer tag.Close()
+			if got := tag.Title(); got != "Unsynchronised ÿà title" {
+				t.Errorf("v%d %s: title %q", version, step, got)
+			}
+			if n := len(tag.GetFrames("TIT2")); n != 1 {
+				t.Errorf("v%d %s: %d TIT2 frames, want the first one only", version, step, n)
+			}
+			var texts []string
+			for _, f := range tag.GetFrames("COMM") {
+				if cf, ok := plainFrame(f).(id3v2.CommentFrame); ok {
+					texts = append(texts, cf.Text)
+				}
+			}
+			if len(texts) != 2 || texts[0] != "first" || texts[1] != "second" {
+				t.Errorf("v%d %s: comments %q, want both", version, step, texts)
+			}
+			fs := tag.GetFrames("XQZW")
+			if len(fs) != 1 {
+				t.Fatalf("v%d %s: %d XQZW frames, want 1", version, step, len(fs))
+			}
+			if uf, ok := fs[0].(id3v2.UnknownFrame); !ok || !bytes.Equal(uf.Body, unknown) {
+				t.Errorf("v%d %s: XQZW frame %+v", version, step, fs[0])
+			}
+			if n := len(tag.GetFrames("PRIV")); n != 0 {
+				t.Errorf("v%d %s: encrypted frame kept", version, step)
+			}
+		}
+		check("read")
+
+		tag, err := openTag(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = saveTagFile(tag, path, nil)
+		tag.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("saved")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "rename",
+		usage: "Rename MP3 files after their tags using a template",
+		run:   runRename,
+	})
+}
+
+// nameFields are the fields a rename template can refer to, by name.
+var nameFields = map[string]func(tag *id3v2.Tag) string{
+	"artist": func(tag *id3v2.Tag) string { return textOf(tag, "TPE1") },
+	"albumartist": func(tag *id3v2.Tag) string {
+		if s := strings.TrimSpace(textOf(tag, "TPE2")); s != "" {
+			return s
+		}
+		return textOf(tag, "TPE1")
+	},
+	"album": func(tag *id3v2.Tag) string { return textOf(tag, "TALB") },
+	"title": func(tag *id3v2.Tag) string { return textOf(tag, "TIT2") },
+	"genre": func(tag *id3v2.Tag) string { return textOf(tag, "TCON") },
+	"year":  tagYear,
+	"track": func(tag *id3v2.Tag) string { return textOf(tag, "TRCK") },
+	"disc":  func(tag *id3v2.Tag) string { return textOf(tag, "TPOS") },
+}
+
+// numberFields are the nameFields that hold a position and take a width, as in
+// {track:02}.
+var numberFields = []string{"track", "disc"}
+
+// maxNameBytes is the longest file or directory name most file systems allow.
+const maxNameBytes = 255
+
+// namePart is a piece of a rename template: literal text, or a field padded
+// with zeros to width digits.
+type namePart struct {
+	literal string
+	field   string
+	width   int
+}
+
+// parseNameTemplate parses a rename template such as
+// "{artist} - {album}/{track:02} {title}.mp3".
+func parseNameTemplate(s string) ([]namePart, error) {
+	var parts []namePart
+	for s != "" {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			parts = append(parts, namePart{literal: s})
+			break
+		}
+		if i > 0 {
+			parts = append(parts, namePart{literal: s[:i]})
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("unterminated field in template: %s", s[i:])
+		}
+		name, spec, hasSpec := strings.Cut(s[i+1:i+j], ":")
+		if nameFields[name] == nil {
+			return nil, fmt.Errorf("unknown field in template: {%s}", name)
+		}
+		p := namePart{field: name}
+		if hasSpec {
+			if !slices.Contains(numberFields, name) {
+				return nil, fmt.Errorf("{%s:%s}: only %s take a width", name, spec, strings.Join(numberFields, " and "))
+			}
+			w, err := strconv.Atoi(spec)
+			if err != nil || w < 1 || w > 9 {
+				return nil, fmt.Errorf("{%s:%s}: invalid width", name, spec)
+			}
+			p.width = w
+		}
+		parts = append(parts, p)
+		s = s[i+j+1:]
+	}
+	return parts, nil
+}
+
+// sanitizeName replaces the characters of s that are not allowed in file names
+// on common file systems, including path separators, with underscores.
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// cleanSegment makes s usable as a file or directory name: surrounding spaces
+// and trailing dots, which Windows drops, are removed, and long names are cut
+// to maxNameBytes, keeping the extension ext.
+func cleanSegment(s, ext string) string {
+	s = strings.TrimRight(strings.TrimSpace(strings.TrimSuffix(s, ext)), ". ")
+	if len(s)+len(ext) > maxNameBytes {
+		s = s[:maxNameBytes-len(ext)]
+		for !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+		s = strings.TrimRight(s, ". ")
+	}
+	if s == "" {
+		s = "_"
+	}
+	return s + ext
+}
+
+// tagFileName returns the path relative to the target directory that tmpl
+// gives the MP3 file at path. The extension of path is added if the template
+// does not end in .mp3.
+func tagFileName(path string, tmpl []namePart) (string, error) {
+	tag, err := openTag(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+
+	var sb strings.Builder
+	for _, p := range tmpl {
+		if p.field == "" {
+			sb.WriteString(p.literal)
+			continue
+		}
+		v := strings.TrimSpace(nameFields[p.field](tag))
+		if v == "" {
+			return "", fmt.Errorf("no %s for {%s}", p.field, p.field)
+		}
+		if slices.Contains(numberFields, p.field) {
+			n, _, err := parsePosition(v)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", p.field, err)
+			}
+			v = fmt.Sprintf("%0*d", p.width, n)
+		}
+		sb.WriteString(sanitizeName(v))
+	}
+	name := sb.String()
+	if !isMP3(name) {
+		name += filepath.Ext(path)
+	}
+
+	segments := strings.Split(filepath.ToSlash(name), "/")
+	for i, seg := range segments {
+		ext := ""
+		if i == len(segments)-1 {
+			ext = filepath.Ext(seg)
+		}
+		segments[i] = cleanSegment(seg, ext)
+	}
+	return filepath.Join(segments...), nil
+}
+
+// nameTaken reports whether target is in use by a file other than path, or
+// was claimed for another file of this run. claimed holds the files of the run
+// by the lower-cased names they were given.
+func nameTaken(path, target string, claimed map[string]string) (bool, error) {
+	if claimed[strings.ToLower(target)] != "" {
+		return true, nil
+	}
+	fi, err := os.Stat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	src, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	// A name that differs only in case refers to the file itself on
+	// case-insensitive file systems.
+	return !os.SameFile(fi, src), nil
+}
+
+// errDuplicate is returned when the file an MP3 file would be moved onto has
+// the same audio.
+var errDuplicate = errors.New("same audio already exists")
+
+// sameAudio reports whether the MP3 files at a and b have the same audio data.
+func sameAudio(a, b string) (bool, error) {
+	sa, err := audioDigest(a)
+	if err != nil {
+		return false, err
+	}
+	sb, err := audioDigest(b)
+	if err != nil {
+		// The other file may not be an MP3 file at all.
+		return false, nil
+	}
+	return sa == sb, nil
+}
+
+// renameTarget returns the name to rename the file at path to instead of
+// target if that is taken: with number set, the first free one of
+// "name (2).mp3", "name (3).mp3" and so on, or else an error. With dupes set,
+// finding a file with the same audio as path under one of these names returns
+// that name and errDuplicate.
+func renameTarget(path, target string, number, dupes bool, claimed map[string]string) (string, error) {
+	ext := filepath.Ext(target)
+	base := strings.TrimSuffix(target, ext)
+	for n := 1; ; n++ {
+		name := target
+		if n > 1 {
+			name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+		}
+		taken, err := nameTaken(path, name, claimed)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+		if dupes {
+			other := name
+			if _, err := os.Stat(name); err != nil && claimed[strings.ToLower(name)] != "" {
+				// The file is not there yet in a dry run.
+				other = claimed[strings.ToLower(name)]
+			}
+			same, err := sameAudio(path, other)
+			if err != nil {
+				return "", err
+			}
+			if same {
+				return name, errDuplicate
+			}
+		}
+		if !number {
+			return "", fmt.Errorf("%s already exists", name)
+		}
+	}
+}
+
+// runRename implements the rename command.
+func runRename(fs *flag.FlagSet) func() error {
+	var template, outDir, collision string
+	var dryRun bool
+	fs.StringVar(&template, "template", "", "Template of the new names, e.g. \"{artist} - {album}/{track:02} {title}.mp3\"; fields: artist, albumartist, album, title, track, disc, year, genre")
+	fs.StringVar(&outDir, "o", "", "Directory the new names are relative to (defaults to the directory of each file)")
+	fs.StringVar(&collision, "collision", "skip", "What to do when the new name is taken: skip the file, or number it as in \"Title (2).mp3\"")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the new names without renaming the files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s rename -template template [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 || template == "" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if collision != "skip" && collision != "number" {
+			return fmt.Errorf("invalid -collision: %s (want skip or number)", collision)
+		}
+		tmpl, err := parseNameTemplate(template)
+		if err != nil {
+			return err
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		claimed := map[string]string{}
+		failed, renamed := 0, 0
+		for _, path := range files {
+			name, err := tagFileName(path, tmpl)
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			dir := outDir
+			if dir == "" {
+				dir = filepath.Dir(path)
+			}
+			target := filepath.Join(dir, name)
+			if target == filepath.Clean(path) {
+				continue
+			}
+			target, err = renameTarget(path, target, collision == "number", false, claimed)
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			claimed[strings.ToLower(target)] = path
+			if target == filepath.Clean(path) {
+				// Numbered already by an earlier run.
+				continue
+			}
+			if dryRun {
+				fmt.Printf("%s -> %s\n", path, target)
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			if err := os.Rename(path, target); err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+				continue
+			}
+			fmt.Printf("Renamed %s to %s\n", path, target)
+			renamed++
+		}
+		if !dryRun {
+			fmt.Printf("Renamed %d files\n", renamed)
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "renumber",
+		usage: "Number the tracks of each directory in the order of their file names",
+		run:   runRenumber,
+	})
+}
+
+// parsePosition parses a track or disc number in the form "n" or "n/total" as
+// used by the TRCK and TPOS frames. total is 0 if it is not given.
+func parsePosition(s string) (n, total int, err error) {
+	num, tot, hasTotal := strings.Cut(strings.TrimSpace(s), "/")
+	if n, err = strconv.Atoi(num); err != nil || n < 1 {
+		return 0, 0, fmt.Errorf("invalid number %q: want n or n/total", s)
+	}
+	if hasTotal {
+		if total, err = strconv.Atoi(tot); err != nil || total < n {
+			return 0, 0, fmt.Errorf("invalid total in %q: want n/total with total >= n", s)
+		}
+	}
+	return n, total, nil
+}
+
+// formatPosition formats a track or disc number, with its total if not 0.
+func formatPosition(n, total int) string {
+	if total == 0 {
+		return strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%d/%d", n, total)
+}
+
+// errNoPosition is returned when a total is set on a file without a number.
+var errNoPosition = errors.New("no number to add a total to")
+
+// setPositionTotal sets the total of the track or disc number in the frame id
+// of tag, keeping the number. An empty total removes it.
+func setPositionTotal(tag *id3v2.Tag, id, total string) error {
+	cur := tag.GetTextFrame(id).Text
+	if cur == "" {
+		return fmt.Errorf("%s: %w", id, errNoPosition)
+	}
+	n, _, err := parsePosition(cur)
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	t := 0
+	if total != "" {
+		if t, err = strconv.Atoi(total); err != nil || t < n {
+			return fmt.Errorf("%s: invalid total %q for number %d", id, total, n)
+		}
+	}
+	tag.AddTextFrame(id, tag.DefaultEncoding(), formatPosition(n, t))
+	return nil
+}
+
+// runRenumber implements the renumber command.
+func runRenumber(fs *flag.FlagSet) func() error {
+	var start int
+	var total, dryRun bool
+	fs.IntVar(&start, "start", 1, "Number of the first track of each directory")
+	fs.BoolVar(&total, "total", true, "Write the number of tracks of the directory as well, as in 3/12")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the files")
+	manifest := manifestFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s renumber [flags] dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if start < 1 {
+			return fmt.Errorf("invalid start: %d", start)
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		// Number each directory on its own, in the order of the file names.
+		dirs := map[string][]string{}
+		var order []string
+		for _, name := range files {
+			dir := filepath.Dir(name)
+			if _, ok := dirs[dir]; !ok {
+				order = append(order, dir)
+			}
+			dirs[dir] = append(dirs[dir], name)
+		}
+		failed := 0
+		var done []string
+		for _, dir := range order {
+			names := dirs[dir]
+			slices.SortFunc(names, func(a, b string) int {
+				return strings.Compare(filepath.Base(a), filepath.Base(b))
+			})
+			t := 0
+			if total {
+				t = start + len(names) - 1
+			}
+			for i, name := range names {
+				if err := renumberFile(name, formatPosition(start+i, t), dryRun); err != nil {
+					log.Printf("%s: %v", name, err)
+					failed++
+					continue
+				}
+				done = append(done, name)
+			}
+		}
+		if !dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
+
+// renumberFile sets the track number of the MP3 file at path to trck.
+func renumberFile(path, trck string, dryRun bool) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+
+	before := captureFrames(tag)
+	tag.AddTextFrame(tag.CommonID("Track number/Position in set"), tag.DefaultEncoding(), trck)
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	changes := diffFrames(before, captureFrames(tag))
+	if dryRun {
+		fmt.Printf("==> %s <==\n", path)
+		printFrameDiff(os.Stdout, changes)
+		return nil
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	fmt.Printf("Numbered %s as %s\n", path, trck)
+	return nil
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "replaygain",
+		usage: "Measure the loudness of tracks and albums (EBU R128) and write ReplayGain tags",
+		run:   runReplayGain,
+	})
+}
+
+// replayGainReference is the loudness in LUFS that ReplayGain 2.0 adjusts
+// tracks to.
+const replayGainReference = -18.0
+
+// The TXXX descriptions of the ReplayGain frames.
+const (
+	replayGainTrackGain = "REPLAYGAIN_TRACK_GAIN"
+	replayGainTrackPeak = "REPLAYGAIN_TRACK_PEAK"
+	replayGainAlbumGain = "REPLAYGAIN_ALBUM_GAIN"
+	replayGainAlbumPeak = "REPLAYGAIN_ALBUM_PEAK"
+)
+
+// errSilent is returned for audio without any blocks above the absolute gate
+// of -70 LUFS, which has no loudness to adjust.
+var errSilent = errors.New("audio is silent")
+
+// biquad is a second-order IIR filter in transposed direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the two filters of the K-weighting of ITU-R BS.1770, a
+// high shelf modelling the head followed by a high pass, for the sample rate.
+// The coefficients are derived for any rate as libebur128 does.
+func kWeighting(rate int) [2]biquad {
+	fs := float64(rate)
+
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / fs)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / fs)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return [2]biquad{shelf, highPass}
+}
+
+// loudnessMeter measures the loudness of interleaved samples as EBU R128 does:
+// the K-weighted power of 400 ms blocks overlapping by 75%. MP3 files have at
+// most two channels, which are weighted equally. Mono counts as dual mono, as
+// it plays on both speakers.
+type loudnessMeter struct {
+	filters [][2]biquad // per channel
+	weight  float64     // of each channel
+	step    int         // samples per channel in 100 ms
+
+	sub    float64    // power summed over the current 100 ms
+	n      int        // samples per channel in sub
+	recent [4]float64 // the last four 100 ms sums, the newest last
+	seen   int        // number of 100 ms sums so far
+
+	blocks []float64 // mean square of each 400 ms block
+	peak   float64   // largest absolute sample value
+}
+
+func newLoudnessMeter(rate, channels int) *loudnessMeter {
+	m := &loudnessMeter{filters: make([][2]biquad, channels), weight: 1, step: rate / 10}
+	if channels == 1 {
+		m.weight = 2
+	}
+	for i := range m.filters {
+		m.filters[i] = kWeighting(rate)
+	}
+	return m
+}
+
+// write adds the interleaved samples to the measurement.
+func (m *loudnessMeter) write(samples []float32) {
+	channels := len(m.filters)
+	for i := 0; i+channels <= len(samples); i += channels {
+		for c := range channels {
+			x := float64(samples[i+c])
+			m.peak = max(m.peak, math.Abs(x))
+			y := m.filters[c][0].filter(x)
+			y = m.filters[c][1].filter(y)
+			m.sub += m.weight * y * y
+		}
+		if m.n++; m.n < m.step {
+			continue
+		}
+		copy(m.recent[:], m.recent[1:])
+		m.recent[3] = m.sub
+		m.sub, m.n = 0, 0
+		if m.seen++; m.seen >= 4 {
+			sum := m.recent[0] + m.recent[1] + m.recent[2] + m.recent[3]
+			m.blocks = append(m.blocks, sum/float64(4*m.step))
+		}
+	}
+}
+
+// blockLoudness returns the loudness in LUFS of a block with mean square z.
+func blockLoudness(z float64) float64 {
+	return -0.691 + 10*math.Log10(z)
+}
+
+// gatedLoudness returns the integrated loudness in LUFS of the blocks, leaving
+// out those below the absolute gate of -70 LUFS and then those more than 10 LU
+// below the loudness of the rest. Passing the blocks of several tracks gives
+// their loudness as an album.
+func gatedLoudness(blocks []float64) (float64, error) {
+	mean := func(threshold float64) (float64, int) {
+		sum, n := 0.0, 0
+		for _, z := range blocks {
+			if z > 0 && blockLoudness(z) > threshold {
+				sum += z
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, 0
+		}
+		return sum / float64(n), n
+	}
+	z, n := mean(-70)
+	if n == 0 {
+		return 0, errSilent
+	}
+	z, n = mean(max(-70, blockLoudness(z)-10))
+	if n == 0 {
+		return 0, errSilent
+	}
+	return blockLoudness(z), nil
+}
+
+// audioFormat returns the sample rate and number of channels of the first MPEG
+// audio frame of the file at path.
+func audioFormat(path string) (rate, channels int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	start, end, err := audioRange(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	errFound := errors.New("found")
+	err = scanMPEGFrames(f, start, end, func(fr *mpegFrame) error {
+		if fr.Xing {
+			return nil
+		}
+		rate, channels = fr.SampleRate, fr.Channels
+		return errFound
+	})
+	if err == nil {
+		return 0, 0, errNoAudio
+	}
+	if err != errFound {
+		return 0, 0, err
+	}
+	return rate, channels, nil
+}
+
+// trackLoudness is the measurement of a track.
+type trackLoudness struct {
+	blocks []float64
+	peak   float64
+	lufs   float64
+}
+
+// measureLoudness decodes the MP3 file at path with ffmpeg and measures its
+// loudness. The samples are streamed, so that long files do not need to fit in
+// memory.
+func measureLoudness(path string) (*trackLoudness, error) {
+	rate, channels, err := audioFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("ffmpeg", "-nostdin", "-v", "error", "-i", path,
+		"-f", "f32le", "-acodec", "pcm_f32le", "-ar", strconv.Itoa(rate), "-ac", strconv.Itoa(channels), "-")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+
+	m := newLoudnessMeter(rate, channels)
+	br := bufio.NewReaderSize(out, 64*1024)
+	buf := make([]byte, 64*1024)
+	samples := make([]float32, len(buf)/4)
+	var readErr error
+	for {
+		n, err := io.ReadFull(br, buf)
+		n -= n % 4
+		for i := 0; i < n; i += 4 {
+			samples[i/4] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))
+		}
+		m.write(samples[:n/4])
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				readErr = err
+			}
+			break
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ffmpeg: %s", msg)
+		}
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	lufs, err := gatedLoudness(m.blocks)
+	if err != nil {
+		return nil, err
+	}
+	return &trackLoudness{blocks: m.blocks, peak: m.peak, lufs: lufs}, nil
+}
+
+// formatGain formats a gain for a ReplayGain frame, as in "-6.52 dB".
+func formatGain(lufs float64) string {
+	return fmt.Sprintf("%+.2f dB", replayGainReference-lufs)
+}
+
+// formatPeak formats a peak for a ReplayGain frame, as in "0.988312".
+func formatPeak(peak float64) string {
+	return fmt.Sprintf("%.6f", peak)
+}
+
+// setReplayGain sets the ReplayGain frame with the given description to value,
+// removing frames other programs wrote under the description in lower case.
+func setReplayGain(tag *id3v2.Tag, desc, value string) {
+	deleteFramesFunc(tag, "TXXX", func(_ int, f id3v2.Framer) bool {
+		udtf, ok := f.(id3v2.UserDefinedTextFrame)
+		return ok && udtf.Description != desc && strings.EqualFold(udtf.Description, desc)
+	})
+	setUserText(tag, desc, value)
+}
+
+// hasReplayGain reports whether the MP3 file at path has a track gain and, if
+// album is set, an album gain.
+func hasReplayGain(path string, album bool) bool {
+	tag, err := openTag(path)
+	if err != nil {
+		return false
+	}
+	defer tag.Close()
+	has := func(desc string) bool {
+		for _, f := range tag.GetFrames("TXXX") {
+			if udtf, ok := f.(id3v2.UserDefinedTextFrame); ok && strings.EqualFold(udtf.Description, desc) {
+				return true
+			}
+		}
+		return false
+	}
+	return has(replayGainTrackGain) && (!album || has(replayGainAlbumGain))
+}
+
+// replayGainAlbums groups the files into albums: the files of a folder with the
+// same album name. Files without an album name get no album gain and are
+// returned in a group of their own each, under an empty album name.
+func replayGainAlbums(files []string) ([][]string, []string) {
+	var groups [][]string
+	var names []string
+	index := map[[2]string]int{}
+	for _, path := range files {
+		name := ""
+		if tag, err := openTag(path); err == nil {
+			name = strings.TrimSpace(textOf(tag, "TALB"))
+			tag.Close()
+		}
+		if name == "" {
+			groups = append(groups, []string{path})
+			names = append(names, "")
+			continue
+		}
+		key := [2]string{filepath.Dir(path), name}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+			names = append(names, name)
+		}
+		groups[i] = append(groups[i], path)
+	}
+	return groups, names
+}
+
+// writeReplayGain writes the ReplayGain frames of a track to the MP3 file at
+// path. album is nil if the file gets no album gain.
+func writeReplayGain(path string, track, album *trackLoudness, dryRun bool) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+
+	before := captureFrames(tag)
+	setReplayGain(tag, replayGainTrackGain, formatGain(track.lufs))
+	setReplayGain(tag, replayGainTrackPeak, formatPeak(track.peak))
+	if album != nil {
+		setReplayGain(tag, replayGainAlbumGain, formatGain(album.lufs))
+		setReplayGain(tag, replayGainAlbumPeak, formatPeak(album.peak))
+	}
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	changes := diffFrames(before, captureFrames(tag))
+	if dryRun {
+		fmt.Printf("==> %s <==\n", path)
+		printFrameDiff(os.Stdout, changes)
+		return nil
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	return nil
+}
+
+// runReplayGain implements the replaygain command.
+func runReplayGain(fs *flag.FlagSet) func() error {
+	var trackOnly, force, dryRun bool
+	fs.BoolVar(&trackOnly, "track-only", false, "Only write the track gain and peak, not those of the album")
+	fs.BoolVar(&force, "force", false, "Measure files that already have ReplayGain tags again")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replaygain [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		groups, names := replayGainAlbums(files)
+		failed := 0
+		for i, group := range groups {
+			album := names[i] != "" && !trackOnly
+			if !force && !slices.ContainsFunc(group, func(path string) bool { return !hasReplayGain(path, album) }) {
+				continue
+			}
+
+			// The album gain is only known once all of its tracks are measured, and
+			// would be wrong without any of them, so the album is skipped as a whole
+			// if a track fails.
+			tracks := make([]*trackLoudness, len(group))
+			ok := true
+			for j, path := range group {
+				if tracks[j], err = measureLoudness(path); err != nil {
+					log.Printf("%s: %v", path, err)
+					failed++
+					ok = false
+				}
+			}
+			var albumLoudness *trackLoudness
+			if album && ok {
+				a := &trackLoudness{}
+				for _, t := range tracks {
+					a.blocks = append(a.blocks, t.blocks...)
+					a.peak = max(a.peak, t.peak)
+				}
+				if a.lufs, err = gatedLoudness(a.blocks); err != nil {
+					log.Printf("%s: %v", names[i], err)
+					failed += len(group)
+					continue
+				}
+				albumLoudness = a
+			}
+			for j, path := range group {
+				if tracks[j] == nil || album && albumLoudness == nil {
+					continue
+				}
+				if err := writeReplayGain(path, tracks[j], albumLoudness, dryRun); err != nil {
+					log.Printf("%s: %v", path, err)
+					failed++
+					continue
+				}
+				if !dryRun {
+					fmt.Printf("%s: %.1f LUFS, track gain %s, peak %s\n", path, tracks[j].lufs, formatGain(tracks[j].lufs), formatPeak(tracks[j].peak))
+				}
+			}
+			if albumLoudness != nil && !dryRun {
+				fmt.Printf("%s: %.1f LUFS, album gain %s, peak %s\n", names[i], albumLoudness.lufs, formatGain(albumLoudness.lufs), formatPeak(albumLoudness.peak))
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "retry",
+		usage: "Retry the files that failed in a run written with -report",
+		run:   runRetry,
+	})
+}
+
+// Classes of errors recorded in a run report.
+const (
+	classNetwork     = "network"
+	classNotFound    = "not-found"
+	classTooLarge    = "too-large"
+	classFile        = "file"
+	classPlaceholder = "placeholder"
+	classOther       = "other"
+)
+
+// errorClasses lists the error classes in the order they are documented.
+var errorClasses = []string{classNetwork, classNotFound, classTooLarge, classFile, classPlaceholder, classOther}
+
+// errorClass returns the class of an error returned by embedFile.
+func errorClass(err error) string {
+	// HTTP requests fail with a *url.Error, while file system errors such as
+	// syscall.Errno would also pass for a net.Error.
+	var urlErr *url.Error
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &urlErr):
+		return classNetwork
+	case isNotFound(err):
+		return classNotFound
+	case errors.Is(err, errTooLarge), errors.Is(err, errArtTooLarge):
+		return classTooLarge
+	case errors.As(err, &pathErr):
+		return classFile
+	case errors.Is(err, errPlaceholder):
+		return classPlaceholder
+	}
+	return classOther
+}
+
+// runReport records the outcome of a run of the default embed mode, so that the
+// files that failed can be retried later without scanning the library again.
+type runReport struct {
+	Time    time.Time     `json:"time"`
+	Args    []string      `json:"args"` // flags of the run, without the files
+	Files   []*reportFile `json:"files"`
+	Summary *runSummary   `json:"summary,omitempty"` // of the last run
+
+	index map[string]*reportFile // Files by path
+}
+
+// reportFile is the outcome of a run for a single file.
+type reportFile struct {
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+	Class string `json:"class,omitempty"`
+}
+
+// runSummary counts the outcomes of the files of a run.
+type runSummary struct {
+	Succeeded      int `json:"succeeded"`
+	Skipped        int `json:"skipped"` // already up to date or declined
+	Queued         int `json:"queued"`  // with lookups queued for review
+	ArtNotFound    int `json:"art_not_found"`
+	LyricsNotFound int `json:"lyrics_not_found"`
+	Errors         int `json:"errors"` // other failures
+}
+
+// add counts the outcome of a file.
+func (s *runSummary) add(result embedResult, err error) {
+	switch {
+	case errors.Is(err, errArtNotFound):
+		s.ArtNotFound++
+	case errors.Is(err, errLyricsNotFound):
+		s.LyricsNotFound++
+	case err != nil:
+		s.Errors++
+	case result == embedSkipped:
+		s.Skipped++
+	case result == embedQueued:
+		s.Queued++
+	default:
+		s.Succeeded++
+	}
+}
+
+// failed returns the number of files that failed.
+func (s *runSummary) failed() int {
+	return s.ArtNotFound + s.LyricsNotFound + s.Errors
+}
+
+// print prints a table of s. If files failed, it tells how to retry them with
+// the report written to report, if any.
+func (s *runSummary) print(w io.Writer, report string) {
+	total := s.Succeeded + s.Skipped + s.Queued + s.failed()
+	fmt.Fprintf(w, "\nSummary of %d files:\n", total)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range []struct {
+		label string
+		n     int
+	}{
+		{"succeeded", s.Succeeded},
+		{"skipped", s.Skipped},
+		{"queued for review", s.Queued},
+		{"art not found", s.ArtNotFound},
+		{"lyrics not found", s.LyricsNotFound},
+		{"errors", s.Errors},
+	} {
+		fmt.Fprintf(tw, "  %s\t%d\n", row.label, row.n)
+	}
+	tw.Flush()
+	switch {
+	case s.failed() == 0:
+	case report != "":
+		fmt.Fprintf(w, "Retry the failed files with: %s retry -report %s\n", os.Args[0], report)
+	default:
+		fmt.Fprintln(w, "Run with -report to record the failed files for 'mp3extra retry'.")
+	}
+}
+
+// reportPathFlags are the flags naming files, which are recorded as absolute
+// paths so that a retry works from any directory.
+var reportPathFlags = []string{"image", "lyrics", "backup-dir"}
+
+// newRunReport starts a report for a run with the flags set on fs.
+func newRunReport(fs *flag.FlagSet) *runReport {
+	r := &runReport{Time: time.Now()}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "report" {
+			return
+		}
+		v := f.Value.String()
+		if slices.Contains(reportPathFlags, f.Name) && v != "" && v != "auto" {
+			if abs, err := filepath.Abs(v); err == nil {
+				v = abs
+			}
+		}
+		r.Args = append(r.Args, "-"+f.Name+"="+v)
+	})
+	return r
+}
+
+// add records the outcome of processing the file at path, replacing an earlier
+// outcome for it.
+func (r *runReport) add(path string, err error) error {
+	abs, aerr := filepath.Abs(path)
+	if aerr != nil {
+		return aerr
+	}
+	if r.index == nil {
+		r.index = map[string]*reportFile{}
+		for _, f := range r.Files {
+			r.index[f.Path] = f
+		}
+	}
+	f := r.index[abs]
+	if f == nil {
+		f = &reportFile{Path: abs}
+		r.Files = append(r.Files, f)
+		r.index[abs] = f
+	}
+	f.Error, f.Class = "", ""
+	if err != nil {
+		f.Error, f.Class = err.Error(), errorClass(err)
+	}
+	return nil
+}
+
+// failed returns the files that failed with one of the given error classes, or
+// with any error if classes is empty.
+func (r *runReport) failed(classes []string) []string {
+	var files []string
+	for _, f := range r.Files {
+		if f.Error != "" && (len(classes) == 0 || slices.Contains(classes, f.Class)) {
+			files = append(files, f.Path)
+		}
+	}
+	return files
+}
+
+// readRunReport reads the report written to name.
+func readRunReport(name string) (*runReport, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var r runReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &r, nil
+}
+
+// runRetry implements the retry command.
+func runRetry(fs *flag.FlagSet) func() error {
+	var report, class string
+	var dryRun bool
+	fs.StringVar(&report, "report", "", "Report written by an earlier run with -report; it is updated with the outcome")
+	fs.StringVar(&class, "class", "", "Only retry files that failed with these error classes, separated by commas: "+strings.Join(errorClasses, ", "))
+	fs.BoolVar(&dryRun, "dryrun", false, "List the files that would be retried")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s retry -report out.json [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if report == "" || fs.NArg() > 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		var classes []string
+		if class != "" {
+			for _, c := range strings.Split(class, ",") {
+				c = strings.TrimSpace(c)
+				if !slices.Contains(errorClasses, c) {
+					return fmt.Errorf("unknown error class: %s", c)
+				}
+				classes = append(classes, c)
+			}
+		}
+
+		rep, err := readRunReport(report)
+		if err != nil {
+			return err
+		}
+		var files []string
+		for _, name := range rep.failed(classes) {
+			if _, err := os.Stat(name); err != nil {
+				log.Printf("%s: skipping: %v", name, err)
+				continue
+			}
+			files = append(files, name)
+		}
+		if len(files) == 0 {
+			fmt.Println("No failed files to retry")
+			return nil
+		}
+		if dryRun {
+			for _, name := range files {
+				fmt.Println(name)
+			}
+			return nil
+		}
+
+		embedArgs := slices.Concat(rep.Args, []string{"-report", report}, files)
+		return runEmbed(flag.NewFlagSet(os.Args[0], flag.ExitOnError), embedArgs, rep)
+	}
+}
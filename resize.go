@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+)
+
+// defaultImageQuality is the JPEG quality of resized images if no quality was
+// given.
+const defaultImageQuality = 90
+
+// fitImage prepares the image b of content type ct for embedding. If it is
+// larger than maxSize pixels in either direction, it is scaled down to fit, and
+// if it is scaled or quality is set, it is re-encoded as JPEG with that quality.
+// Otherwise b is returned as it is. A maxSize or quality of 0 means no limit or
+// no re-encoding.
+func fitImage(b []byte, ct string, maxSize, quality int) ([]byte, string, error) {
+	if maxSize == 0 && quality == 0 {
+		return b, ct, nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	scale := maxSize > 0 && max(cfg.Width, cfg.Height) > maxSize
+	if !scale && quality == 0 {
+		return b, ct, nil
+	}
+	if err := checkDecodedSize(cfg); err != nil {
+		return nil, "", err
+	}
+	src, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	img := flatten(src)
+	if scale {
+		w, h := maxSize, cfg.Height*maxSize/cfg.Width
+		if cfg.Height > cfg.Width {
+			w, h = cfg.Width*maxSize/cfg.Height, maxSize
+		}
+		img = downscale(img, max(w, 1), max(h, 1))
+	}
+	if quality == 0 {
+		quality = defaultImageQuality
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/jpeg", nil
+}
+
+// errArtTooLarge is returned when cover art is larger than -max-art-bytes and
+// may not or cannot be made smaller.
+var errArtTooLarge = errors.New("album art image too large")
+
+// shrinkQualities are the JPEG qualities tried in turn by shrinkImage at each
+// size.
+var shrinkQualities = []int{85, 70, 55, 40}
+
+// minShrinkSize is the smallest width or height shrinkImage scales down to.
+const minShrinkSize = 64
+
+// shrinkImage re-encodes the image b as JPEG with decreasing quality and, if
+// that is not enough, at decreasing sizes until it takes at most limit bytes.
+func shrinkImage(b []byte, limit int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecodedSize(cfg); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	img := flatten(src)
+	for {
+		for _, q := range shrinkQualities {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q}); err != nil {
+				return nil, err
+			}
+			if buf.Len() <= limit {
+				return buf.Bytes(), nil
+			}
+		}
+		w, h := img.Bounds().Dx()*3/4, img.Bounds().Dy()*3/4
+		if min(w, h) < minShrinkSize {
+			return nil, fmt.Errorf("%w: cannot fit %d bytes", errArtTooLarge, limit)
+		}
+		img = downscale(img, w, h)
+	}
+}
+
+// checkDecodedSize fails if decoding an image of the size cfg describes would
+// take more than -max-memory. Decoding takes up to 4 bytes per pixel, and
+// flatten makes another copy of as much.
+func checkDecodedSize(cfg image.Config) error {
+	return checkSize("decoded image", 8*int64(cfg.Width)*int64(cfg.Height), maxImageMemory)
+}
+
+// flatten draws img onto a white background, as JPEG has no transparency.
+func flatten(img image.Image) *image.RGBA {
+	r := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Over)
+	return dst
+}
+
+// downscale scales src down to w×h pixels, averaging the source pixels that
+// fall into each destination pixel.
+func downscale(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := range sum {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := y*dst.Stride + x*4
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// describeImage returns the dimensions, format and size of the image b.
+func describeImage(b []byte) string {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Sprintf("%d KB", len(b)/1024)
+	}
+	return fmt.Sprintf("%dx%d %s, %d KB", cfg.Width, cfg.Height, format, len(b)/1024)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "review",
+		usage: "Review quarantined lookups and pick the right match",
+		run:   runReview,
+	})
+}
+
+// reviewer walks through the quarantine.
+type reviewer struct {
+	art string // preview mode for artwork
+
+	// artCache holds downloaded candidate artwork by source.
+	artCache map[string][]byte
+}
+
+// artwork downloads the artwork of candidate c, reusing earlier downloads.
+func (rv *reviewer) artwork(c *reviewCandidate) ([]byte, error) {
+	if b, ok := rv.artCache[c.source()]; ok {
+		return b, nil
+	}
+	b, _, err := candidateArt(c)
+	if err != nil {
+		return nil, err
+	}
+	rv.artCache[c.source()] = b
+	return b, nil
+}
+
+// show prints entry e with its candidates and previews the selected one.
+func (rv *reviewer) show(e *quarantineEntry, n, total, sel int) {
+	if isTerminal(os.Stdout) {
+		fmt.Print("\x1b[H\x1b[2J")
+	}
+	what := "Lyrics"
+	if e.Kind == "art" {
+		what = "Cover art"
+	}
+	fmt.Printf("[%d/%d] %s\n", n, total, e.Path)
+	fmt.Printf("Track: %s - %s\n", e.Artist, e.Title)
+	fmt.Printf("%s candidates:\n\n", what)
+	for i, c := range e.Candidates {
+		mark := " "
+		if i == sel {
+			mark = ">"
+		}
+		fmt.Printf("%s %d) %3.0f%%  %s\n", mark, i+1, c.Score*100, c.describe())
+	}
+
+	c := &e.Candidates[sel]
+	fmt.Println()
+	switch e.Kind {
+	case "lyrics":
+		lines := strings.Split(strings.TrimSpace(c.Lyrics), "\n")
+		if len(lines) > lyricsPreviewLines {
+			lines = append(lines[:lyricsPreviewLines], "...")
+		}
+		for _, line := range lines {
+			fmt.Println("  " + line)
+		}
+	case "art":
+		b, err := rv.artwork(c)
+		if err != nil {
+			fmt.Println("Error fetching album art image:", err)
+			break
+		}
+		if cfg, format, err := image.DecodeConfig(bytes.NewReader(b)); err == nil {
+			fmt.Printf("Cover art: %dx%d %s, %d KB\n", cfg.Width, cfg.Height, format, len(b)/1024)
+		}
+		mode := rv.art
+		if mode == "auto" {
+			mode = "none"
+			if isTerminal(os.Stdout) {
+				mode = detectGraphics()
+			}
+		}
+		if mode != "none" {
+			if err := previewImage(os.Stdout, b, mode); err != nil {
+				fmt.Println("Error previewing cover art:", err)
+			}
+		}
+	}
+	fmt.Println()
+}
+
+// apply writes candidate c of entry e to the file.
+func (rv *reviewer) apply(e *quarantineEntry, c *reviewCandidate) error {
+	tag, err := openTag(e.Path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+	switch e.Kind {
+	case "lyrics":
+		setLyrics(tag, c.Lyrics, e.Lang, "")
+	case "art":
+		b, err := rv.artwork(c)
+		if err != nil {
+			return fmt.Errorf("error fetching album art image: %w", err)
+		}
+		setCover(tag, b, http.DetectContentType(b))
+	}
+	if err := saveTag(tag, e.Path, &saveOptions{snapshot: true}); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	return nil
+}
+
+// decide shows entry e until the user approves a candidate, skips or drops the
+// entry, or quits. It reports whether e is settled and whether to quit.
+func (rv *reviewer) decide(e *quarantineEntry, n, total int) (settled, quit bool) {
+	sel := 0
+	msg := ""
+	for {
+		rv.show(e, n, total, sel)
+		if msg != "" {
+			fmt.Println(msg)
+			fmt.Println()
+			msg = ""
+		}
+		line, err := ask("a approve  <n> select candidate  s skip  d drop  q quit > ")
+		if err != nil {
+			// Without input, nothing more can be decided.
+			return false, true
+		}
+		if i, err := strconv.Atoi(line); err == nil {
+			if i < 1 || i > len(e.Candidates) {
+				msg = "No such candidate."
+				continue
+			}
+			sel = i - 1
+			continue
+		}
+		switch line {
+		case "a":
+			if err := rv.apply(e, &e.Candidates[sel]); err != nil {
+				msg = err.Error()
+				continue
+			}
+			fmt.Println("Embedded successfully in", e.Path)
+			return true, false
+		case "s", "":
+			return false, false
+		case "d":
+			return true, false
+		case "q":
+			return false, true
+		default:
+			msg = "Unknown command: " + line
+		}
+	}
+}
+
+// runReview implements the review command.
+func runReview(fs *flag.FlagSet) func() error {
+	rv := &reviewer{artCache: map[string][]byte{}}
+	fs.StringVar(&rv.art, "art", "auto", "Artwork preview: auto, kitty, iterm, sixel, ascii or none")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s review [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+
+		entries, err := loadQuarantine()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("Nothing to review.")
+			return nil
+		}
+
+		left := len(entries)
+		for i, e := range entries {
+			if len(e.Candidates) == 0 {
+				continue
+			}
+			settled, quit := rv.decide(e, i+1, len(entries))
+			if quit {
+				break
+			}
+			if settled {
+				if err := unquarantine(e); err != nil {
+					return err
+				}
+				left--
+			}
+		}
+		fmt.Printf("%d left to review.\n", left)
+		return nil
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// cyrillicLatin romanizes the Cyrillic letters of Russian, Ukrainian,
+// Belarusian and the South Slavic languages.
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u", 'ђ': "dj", 'ј': "j",
+	'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz", 'ѓ': "gj", 'ќ': "kj", 'ѕ': "dz",
+}
+
+// greekLatin romanizes Greek letters.
+var greekLatin = map[rune]string{
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th",
+	'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+	'ω': "o", 'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ό': "o", 'ύ': "y", 'ώ': "o",
+	'ϊ': "i", 'ϋ': "y", 'ΐ': "i", 'ΰ': "y",
+}
+
+// kanaLatin romanizes hiragana in Hepburn. Katakana is mapped to hiragana first.
+var kanaLatin = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+}
+
+// smallKana are the small kana that modify the sound of the preceding kana.
+var smallKana = map[rune]string{
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+}
+
+// cjkPunctuation maps CJK punctuation to its ASCII counterpart.
+var cjkPunctuation = map[rune]string{
+	'　': " ", '・': " ", '、': ",", '。': ".", '「': "\"", '」': "\"", '『': "\"", '』': "\"",
+	'【': "[", '】': "]", '〜': "~",
+}
+
+// Hangul syllables are romanized by decomposing them into their initial,
+// medial and final jamo, following the Revised Romanization of Korean.
+var (
+	hangulInitial = []string{"g", "kk", "n", "d", "tt", "r", "m", "b", "pp", "s", "ss", "", "j", "jj", "ch", "k", "t", "p", "h"}
+	hangulMedial  = []string{"a", "ae", "ya", "yae", "eo", "e", "yeo", "ye", "o", "wa", "wae", "oe", "yo", "u", "wo", "we", "wi", "yu", "eu", "ui", "i"}
+	hangulFinal   = []string{"", "k", "k", "k", "n", "n", "n", "t", "l", "k", "m", "l", "l", "l", "p", "l", "m", "p", "p", "t", "t", "ng", "t", "t", "k", "t", "p", "t"}
+)
+
+// romanizeRune returns the romanization of a single letter of a caseless
+// script. ok is false for runes of other scripts.
+func romanizeRune(r rune) (string, bool) {
+	switch {
+	case r >= 0xac00 && r <= 0xd7a3:
+		i := int(r - 0xac00)
+		return hangulInitial[i/588] + hangulMedial[i%588/28] + hangulFinal[i%28], true
+	case r >= 0x30a1 && r <= 0x30f6:
+		// Katakana to hiragana.
+		r -= 0x60
+	}
+	s, ok := kanaLatin[r]
+	return s, ok
+}
+
+// romanize transliterates the Cyrillic, Greek, kana and Hangul letters of s to
+// Latin letters. ok is false if s has no such letters, or has letters of other
+// scripts such as kanji, which cannot be romanized without a dictionary.
+func romanize(s string) (string, bool) {
+	var b strings.Builder
+	changed := false
+	wordStart := true
+	double := false // a small tsu doubles the next consonant
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		lower := unicode.ToLower(r)
+		var out string
+		var ok bool
+		if out, ok = cyrillicLatin[lower]; !ok {
+			out, ok = greekLatin[lower]
+		}
+		if ok {
+			// Keep the case of cased scripts.
+			if r != lower && out != "" {
+				if i+1 < len(rs) && unicode.IsUpper(rs[i+1]) {
+					out = strings.ToUpper(out)
+				} else {
+					out = strings.ToUpper(out[:1]) + out[1:]
+				}
+			}
+		} else if out, ok = romanizeRune(r); ok {
+			// Combine with a following small kana, as in きゃ "kya" or ファ "fa".
+			if i+1 < len(rs) {
+				next := rs[i+1]
+				if next >= 0x30a1 && next <= 0x30f6 {
+					next -= 0x60
+				}
+				if small, isSmall := smallKana[next]; isSmall {
+					out = combineKana(out, small)
+					i++
+				}
+			}
+			if double && out != "" {
+				if strings.HasPrefix(out, "ch") {
+					out = "t" + out
+				} else {
+					out = out[:1] + out
+				}
+			}
+			double = false
+			if wordStart && out != "" {
+				out = strings.ToUpper(out[:1]) + out[1:]
+			}
+		} else if r == 'っ' || r == 'ッ' {
+			double, changed = true, true
+			continue
+		} else if r == 'ー' {
+			// The long vowel mark is dropped, as in "ramen".
+			changed = true
+			continue
+		} else if p, isPunct := cjkPunctuation[r]; isPunct {
+			out = p
+		} else if r >= 0xff01 && r <= 0xff5e {
+			// Full-width ASCII.
+			out = string(r - 0xfee0)
+		} else if unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+			return s, false
+		} else {
+			out = string(r)
+		}
+		if out != string(r) {
+			changed = true
+		}
+		if out != "" {
+			wordStart = !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}
+		b.WriteString(out)
+	}
+	return b.String(), changed
+}
+
+// combineKana joins a kana with a following small kana.
+func combineKana(base, small string) string {
+	switch small {
+	case "ya", "yu", "yo":
+		// し+ゃ is "sha" rather than "shya".
+		if strings.HasSuffix(base, "hi") || base == "ji" {
+			return base[:len(base)-1] + small[1:]
+		}
+		return strings.TrimSuffix(base, "i") + small
+	}
+	if base == "u" {
+		// ウィ is "wi".
+		return "w" + small
+	}
+	return strings.TrimRight(base, "aiueo") + small
+}
+
+// romanizeFields pairs the fields that are romanized with their sort frame
+// and the TXXX description used instead of it.
+var romanizeFields = []struct {
+	sortID, desc string
+	get          func(*id3v2.Tag) string
+}{
+	{"TSOT", "ROMANIZED_TITLE", (*id3v2.Tag).Title},
+	{"TSOP", "ROMANIZED_ARTIST", (*id3v2.Tag).Artist},
+	{"TSOA", "ROMANIZED_ALBUM", (*id3v2.Tag).Album},
+}
+
+// romanizeModes are the values of the -romanize flag.
+var romanizeModes = []string{"sort", "txxx"}
+
+// applyRomanization writes romanized versions of the title, artist and album of
+// tag into the sort frames if mode is "sort", or into TXXX frames if it is
+// "txxx". Fields that are already there are left alone. It returns the number
+// of fields written.
+func applyRomanization(tag *id3v2.Tag, mode string) (int, error) {
+	n := 0
+	for _, f := range romanizeFields {
+		r, ok := romanize(f.get(tag))
+		if !ok {
+			continue
+		}
+		switch mode {
+		case "sort":
+			if tag.GetTextFrame(f.sortID).Text != "" {
+				continue
+			}
+			tag.AddTextFrame(f.sortID, tag.DefaultEncoding(), r)
+		case "txxx":
+			if userText(tag, f.desc) != "" {
+				continue
+			}
+			setUserText(tag, f.desc, r)
+		default:
+			return n, fmt.Errorf("unknown romanization mode: %s", mode)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// userText returns the value of the TXXX frame with the given description.
+func userText(tag *id3v2.Tag, desc string) string {
+	for _, f := range tag.GetFrames("TXXX") {
+		if udtf, ok := f.(id3v2.UserDefinedTextFrame); ok && udtf.Description == desc {
+			return udtf.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// saveOptions controls how a modified tag is written back to its file.
+type saveOptions struct {
+	// backup makes a copy of the original file before it is rewritten.
+	backup bool
+
+	// backupDir is the directory receiving the backup. If empty, the backup is
+	// written next to the original file with a ".bak" suffix.
+	backupDir string
+
+	// snapshot records the original tag in the journal so that the write can be
+	// reverted with the undo command.
+	snapshot bool
+
+	// id3v1 keeps, removes or synchronizes the ID3v1 tag; see applyID3v1.
+	id3v1 string
+
+	// plan is the digest of the operations being saved, recorded in the journal.
+	plan string
+
+	// verifyAudio checks after writing that the audio frames are unchanged,
+	// which backups do anyway.
+	verifyAudio bool
+
+	// preserveMtime restores the modification time the file had before it was
+	// saved, for sync tools and smart playlists that take a newer file for a
+	// changed recording.
+	preserveMtime bool
+
+	// noPadding writes the tag without padding, as -web-optimize promises, so
+	// it is only written in place over a tag of exactly its size.
+	noPadding bool
+}
+
+// padLimit returns the most padding a tag saved with o may be left with when
+// it is written in place. o may be nil.
+func (o *saveOptions) padLimit() int {
+	if o != nil && o.noPadding {
+		return 0
+	}
+	return maxPadding
+}
+
+// backupPath returns where the backup of path is stored. In backupDir, the
+// name has a digest of the directory of path added, so that files of the same
+// name in different directories, such as the 01.mp3 of every album, do not
+// overwrite each other's backups.
+func (o *saveOptions) backupPath(path string) string {
+	if o.backupDir != "" {
+		dir, err := filepath.Abs(filepath.Dir(path))
+		if err != nil {
+			dir = filepath.Dir(path)
+		}
+		sum := sha256.Sum256([]byte(dir))
+		return filepath.Join(o.backupDir, fmt.Sprintf("%s.%x.bak", filepath.Base(path), sum[:4]))
+	}
+	return path + ".bak"
+}
+
+// keptBackups holds the backups kept in this run because a write failed, which
+// hold the only copy of an original file. keptMu guards it.
+var (
+	keptBackups = map[string]bool{}
+	keptMu      sync.Mutex
+)
+
+// keepBackup records that the backup bak is kept and returns err.
+func keepBackup(bak string, err error) error {
+	keptMu.Lock()
+	keptBackups[bak] = true
+	keptMu.Unlock()
+	return err
+}
+
+// checkBackup returns an error if bak is a backup kept earlier in this run,
+// which must not be overwritten.
+func checkBackup(bak string) error {
+	keptMu.Lock()
+	defer keptMu.Unlock()
+	if keptBackups[bak] {
+		return fmt.Errorf("backup %s of a failed write would be overwritten", bak)
+	}
+	return nil
+}
+
+// copyFile copies the contents and permissions of src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// replaceFile replaces the file at path with what write writes, keeping its
+// permissions. The new contents go to a temporary file in the same directory,
+// which is synced to disk and then renamed over the original, so a crash or
+// power loss at any point leaves either the old or the new file, never a
+// truncated one.
+func replaceFile(path string, write func(w io.Writer) error) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// maxPadding is the most padding a tag written in place may be left with. A
+// tag that shrinks by more, as when a large picture is removed, is rewritten
+// so that the file shrinks as well.
+const maxPadding = 64 << 10
+
+// saveTagFile writes tag to the MP3 file at path in place of the tag the file
+// has. If tag fits into the space of that tag, it is written over it with the
+// rest as padding, which leaves the audio untouched and is much faster on large
+// files and network shares. Otherwise the file is rewritten through
+// replaceFile, once there is room for the copy; tag.Save is not used, as it
+// rewrites the file without syncing it. The file of tag may be closed, but its
+// frames remain usable and it can be saved again. opts may be nil.
+//
+// Writing in place trades the atomicity of replaceFile for speed: a crash or
+// power loss halfway through the tag leaves a damaged tag, though never
+// damaged audio or a truncated file. The original tag is then still in the
+// backup, if one was made.
+func saveTagFile(tag *id3v2.Tag, path string, opts *saveOptions) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	size, err := id3v2TagSize(src)
+	if err != nil {
+		return err
+	}
+	if ok, err := patchTag(tag, src, size, opts.padLimit()); err != nil || ok {
+		return err
+	}
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if err := ensureSpace(filepath.Dir(path), fi.Size()+int64(tag.Size())); err != nil {
+		return err
+	}
+	return replaceFile(path, func(w io.Writer) error {
+		if _, err := tag.WriteTo(w); err != nil {
+			return err
+		}
+		if _, err := src.Seek(size, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, src); err != nil {
+			return err
+		}
+		// Windows cannot replace open files. The tag may have been closed
+		// already.
+		src.Close()
+		tag.Close()
+		return nil
+	})
+}
+
+// patchTag overwrites the tag of the file src, which takes size bytes, with
+// tag padded to the same size. It reports false if there is no tag to
+// overwrite, tag does not fit or would leave more than limit bytes of padding,
+// or the file cannot be written to but possibly replaced. A tag without frames
+// is never written in place, as the file is meant to have no tag at all.
+func patchTag(tag *id3v2.Tag, src *os.File, size int64, limit int) (bool, error) {
+	if size == 0 || tag.Count() == 0 {
+		return false, nil
+	}
+	fi, err := src.Stat()
+	if err != nil || size > fi.Size() {
+		return false, err
+	}
+	b, ok, err := paddedTag(tag, int(size), limit)
+	if err != nil || !ok {
+		return false, err
+	}
+	f, err := os.OpenFile(src.Name(), os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrPermission) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.WriteAt(b, 0); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return false, err
+	}
+	return true, f.Close()
+}
+
+// saveTag writes tag back to the file at path according to opts.
+//
+// With backups enabled, the original file is copied away first. Once the saved
+// file is verified to have a readable tag and an unchanged audio stream, the
+// backup is removed again; otherwise it is kept and its location is reported.
+func saveTag(tag *id3v2.Tag, path string, opts *saveOptions) error {
+	if opts == nil {
+		opts = &saveOptions{}
+	}
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	var mtime time.Time
+	if opts.preserveMtime {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		mtime = fi.ModTime()
+	}
+	var snapshot string
+	if opts.snapshot {
+		var err error
+		snapshot, err = takeSnapshot(path)
+		if err != nil {
+			return fmt.Errorf("error taking snapshot: %w", err)
+		}
+	}
+	if err := writeTag(tag, path, opts); err != nil {
+		return err
+	}
+	if err := applyID3v1(path, tag, opts.id3v1); err != nil {
+		return fmt.Errorf("error writing ID3v1 tag: %w", err)
+	}
+	if opts.preserveMtime {
+		// The access time is left as the write set it.
+		if err := os.Chtimes(path, time.Time{}, mtime); err != nil {
+			return fmt.Errorf("error restoring modification time: %w", err)
+		}
+	}
+	if opts.snapshot || opts.plan != "" {
+		return recordWrite(path, snapshot, opts.plan)
+	}
+	return nil
+}
+
+// errFreeSpaceUnknown is returned by freeSpace on platforms where it cannot be determined.
+var errFreeSpaceUnknown = errors.New("free space unknown")
+
+// ensureSpace checks that dir has room for need more bytes. Rewriting a tag
+// creates a complete copy of the file before the original is replaced, which
+// for multi-gigabyte mixes and audiobooks can easily exhaust a disk.
+func ensureSpace(dir string, need int64) error {
+	avail, err := freeSpace(dir)
+	if err != nil {
+		if errors.Is(err, errFreeSpaceUnknown) {
+			return nil
+		}
+		return err
+	}
+	if avail < need {
+		return fmt.Errorf("not enough free space in %s: need %d MB, have %d MB", dir, need>>20, avail>>20)
+	}
+	return nil
+}
+
+// checkBackupSpace makes sure that backing up the file at path to bak will
+// not run out of disk space halfway through. Room for rewriting the file is
+// checked by saveTagFile, as a tag written in place needs none.
+func checkBackupSpace(path, bak string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ensureSpace(filepath.Dir(bak), fi.Size())
+}
+
+// writeTag saves tag, keeping a verified backup if requested.
+func writeTag(tag *id3v2.Tag, path string, opts *saveOptions) error {
+	if opts.backup && opts.backupDir != "" {
+		if err := os.MkdirAll(opts.backupDir, 0755); err != nil {
+			return err
+		}
+	}
+	if !opts.backup {
+		if !opts.verifyAudio {
+			return saveTagFile(tag, path, opts)
+		}
+		before, err := audioDigest(path)
+		if err != nil {
+			return err
+		}
+		if err := saveTagFile(tag, path, opts); err != nil {
+			return err
+		}
+		if err := verifySaved(path, before); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+		return nil
+	}
+
+	bak := opts.backupPath(path)
+	if err := checkBackup(bak); err != nil {
+		return err
+	}
+	if err := checkBackupSpace(path, bak); err != nil {
+		return err
+	}
+	if err := copyFile(path, bak); err != nil {
+		return fmt.Errorf("error creating backup: %w", err)
+	}
+	before, err := audioDigest(bak)
+	if err != nil {
+		return err
+	}
+
+	if err := saveTagFile(tag, path, opts); err != nil {
+		return keepBackup(bak, fmt.Errorf("%w (original kept in %s)", err, bak))
+	}
+	if err := verifySaved(path, before); err != nil {
+		return keepBackup(bak, fmt.Errorf("verification failed: %w (original kept in %s)", err, bak))
+	}
+	return os.Remove(bak)
+}
+
+// verifySaved checks that the file at path has a parseable tag and that its
+// audio stream still has the digest it had before saving.
+func verifySaved(path, digest string) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return err
+	}
+	tag.Close()
+
+	after, err := audioDigest(path)
+	if err != nil {
+		return err
+	}
+	if after != digest {
+		return fmt.Errorf("audio data of %s changed", path)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// largeFileSize is the size of the sparse files of the large file tests, just
+// beyond what 32-bit signed offsets can address.
+const largeFileSize = 1<<31 + 1<<20
+
+// largeMarkers are written into the audio of the large test files, at offsets
+// relative to the end of the tag, to tell whether the audio was moved intact.
+var largeMarkers = []struct {
+	off  int64
+	data string
+}{
+	{0, "first audio bytes"},
+	{1<<31 - 4096, "audio across the 2 GB boundary"},
+	{1<<31 + 12345, "audio beyond 2 GB"},
+}
+
+// writeLargeMP3 creates a sparse file of largeFileSize bytes with an ID3v2
+// tag of the given title followed by padding bytes, then zeros with
+// largeMarkers, and an ID3v1 tag at the end. It returns the path and the size
+// of the ID3v2 tag.
+func writeLargeMP3(t *testing.T, title string, padding int) (string, int64) {
+	t.Helper()
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(4)
+	tag.SetTitle(title)
+	b, ok, err := paddedTag(tag, tag.Size()+padding, padding)
+	if err != nil || !ok {
+		t.Fatalf("paddedTag: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "large.mp3")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(largeFileSize); err != nil {
+		t.Skipf("cannot create a sparse file of %d bytes: %v", largeFileSize, err)
+	}
+	if _, err := f.WriteAt(b, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range largeMarkers {
+		if _, err := f.WriteAt([]byte(m.data), int64(len(b))+m.off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.WriteAt([]byte("TAG"), largeFileSize-128); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, int64(len(b))
+}
+
+// audioSum returns the SHA-256 digest of the file at path from offset start.
+func audioSum(t *testing.T, path string, start int64) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, start, 1<<62)); err != nil {
+		t.Fatal(err)
+	}
+	return h.Sum(nil)
+}
+
+// checkLargeAudio fails t unless the file at path has a tag of tagSize bytes
+// followed by the markers and audio with the digest sum.
+func checkLargeAudio(t *testing.T, path string, tagSize, audioSize int64, sum []byte) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Size(); got != tagSize+audioSize {
+		t.Fatalf("size = %d, want %d", got, tagSize+audioSize)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, m := range largeMarkers {
+		b := make([]byte, len(m.data))
+		if _, err := f.ReadAt(b, tagSize+m.off); err != nil || string(b) != m.data {
+			t.Errorf("at %d: %q, %v; want %q", tagSize+m.off, b, err, m.data)
+		}
+	}
+	if !testing.Short() && !bytes.Equal(audioSum(t, path, tagSize), sum) {
+		t.Error("audio changed")
+	}
+}
+
+// saveLargeTitle sets the title of the file at path to title with openTag and
+// saveTagFile, as saveTag does without backup and snapshot.
+func saveLargeTitle(t *testing.T, path, title string) {
+	t.Helper()
+	tag, err := openTag(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tag.Close()
+	tag.SetTitle(title)
+	if err := saveTagFile(tag, path, nil); err != nil {
+		t.Fatalf("saveTagFile: %v", err)
+	}
+}
+
+func TestLargeFileOffsets(t *testing.T) {
+	path, tagSize := writeLargeMP3(t, "Mix", 1024)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, err := id3v2TagSize(f); err != nil || got != tagSize {
+		t.Errorf("id3v2TagSize = %d, %v; want %d", got, err, tagSize)
+	}
+	start, end, err := audioRange(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != tagSize || end != largeFileSize-128 {
+		t.Errorf("audioRange = %d, %d; want %d, %d", start, end, tagSize, int64(largeFileSize-128))
+	}
+}
+
+func TestLargeFileSaveInPlace(t *testing.T) {
+	path, tagSize := writeLargeMP3(t, "Mix", 1024)
+	var sum []byte
+	if !testing.Short() {
+		sum = audioSum(t, path, tagSize)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saveLargeTitle(t, path, "A longer title that still fits into the padding")
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("file was replaced instead of patched in place")
+	}
+	checkLargeAudio(t, path, tagSize, largeFileSize-tagSize, sum)
+	tag, err := openTag(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tag.Close()
+	if got := tag.Title(); got != "A longer title that still fits into the padding" {
+		t.Errorf("title = %q", got)
+	}
+}
+
+func TestLargeFileRewrite(t *testing.T) {
+	path, tagSize := writeLargeMP3(t, "Mix", 0)
+	if err := ensureSpace(filepath.Dir(path), 2*largeFileSize); err != nil {
+		t.Skip(err)
+	}
+	var sum []byte
+	if !testing.Short() {
+		sum = audioSum(t, path, tagSize)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without padding, a longer title forces the file to be rewritten.
+	saveLargeTitle(t, path, "A title too long for the tag without padding")
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("file was patched although the tag grew")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSize, err := id3v2TagSize(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSize <= tagSize {
+		t.Fatalf("tag size = %d, want more than %d", newSize, tagSize)
+	}
+	checkLargeAudio(t, path, newSize, largeFileSize-tagSize, sum)
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) > 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
+
+func TestPaddedTagLimit(t *testing.T) {
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(4)
+	tag.SetTitle("Mix")
+	n := tag.Size()
+	tests := []struct {
+		size, limit int
+		ok          bool
+	}{
+		{n, 0, true},
+		{n - 1, maxPadding, false},
+		{n + 100, 100, true},
+		{n + 101, 100, false},
+		{n + 1, 0, false},
+	}
+	for _, tt := range tests {
+		b, ok, err := paddedTag(tag, tt.size, tt.limit)
+		if err != nil || ok != tt.ok {
+			t.Errorf("paddedTag(%d, %d) = %v, %v; want %v", tt.size, tt.limit, ok, err, tt.ok)
+			continue
+		}
+		if ok && len(b) != tt.size {
+			t.Errorf("paddedTag(%d, %d) wrote %d bytes", tt.size, tt.limit, len(b))
+		}
+	}
+}
+
+func TestSaveWithoutPadding(t *testing.T) {
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(4)
+	tag.SetTitle("A title that leaves room once it is shortened")
+	b, _, err := paddedTag(tag, tag.Size()+1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "web.mp3")
+	if err := os.WriteFile(path, append(b, "audio"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tag.SetTitle("Short")
+	if err := saveTagFile(tag, path, &saveOptions{noPadding: true}); err != nil {
+		t.Fatalf("saveTagFile: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := tag.Size() + len("audio"); len(got) != want {
+		t.Errorf("size = %d, want %d without padding", len(got), want)
+	}
+	if !bytes.HasSuffix(got, []byte("audio")) {
+		t.Error("audio changed")
+	}
+}
+
+func TestBackupPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		opts saveOptions
+		a, b string
+		same bool
+	}{
+		{saveOptions{}, "a/01.mp3", "b/01.mp3", false},
+		{saveOptions{backupDir: dir}, "a/01.mp3", "b/01.mp3", false},
+		{saveOptions{backupDir: dir}, "a/01.mp3", "a/../a/01.mp3", true},
+		{saveOptions{backupDir: dir}, "a/01.mp3", "a/02.mp3", false},
+	}
+	for _, tt := range tests {
+		a, b := tt.opts.backupPath(tt.a), tt.opts.backupPath(tt.b)
+		if (a == b) != tt.same {
+			t.Errorf("backupPath(%q) = %q, backupPath(%q) = %q; want same = %v", tt.a, a, tt.b, b, tt.same)
+		}
+		if tt.opts.backupDir != "" && filepath.Dir(a) != dir {
+			t.Errorf("backupPath(%q) = %q, not in %s", tt.a, a, dir)
+		}
+	}
+}
+
+func TestKeptBackupNotOverwritten(t *testing.T) {
+	bak := filepath.Join(t.TempDir(), "01.mp3.bak")
+	if err := checkBackup(bak); err != nil {
+		t.Fatalf("checkBackup before keeping: %v", err)
+	}
+	keepBackup(bak, nil)
+	if err := checkBackup(bak); err == nil {
+		t.Error("checkBackup allowed overwriting a kept backup")
+	}
+}
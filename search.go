@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "search",
+		usage: "Print the lyrics or cover art candidates the providers find, without writing",
+		run:   runSearch,
+	})
+}
+
+// searchResult is a candidate printed by the search command. Auto marks the
+// one 'auto' would embed.
+type searchResult struct {
+	reviewCandidate
+	Auto bool `json:"auto,omitempty"`
+}
+
+// searchCandidates looks up the lyrics or art (kind) for artist, title and, for
+// lyrics, duration, and returns the best scored candidates.
+func searchCandidates(kind, artist, title string, duration time.Duration) ([]searchResult, error) {
+	var cands []reviewCandidate
+	auto := -1
+	start := time.Now()
+	switch kind {
+	case "lyrics":
+		var err error
+		cands, err = lyricsCandidates(artist, title, duration)
+		if err == nil && len(cands) == 0 {
+			recordLookup(lyricsProviderName, start, errLyricsNotFound)
+		} else {
+			recordLookup(lyricsProviderName, start, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Like lookupLyrics, 'auto' takes the best record unless it is too poor.
+		if len(cands) > 0 && cands[0].Score >= minLyricsScore {
+			auto = 0
+		}
+	case "art":
+		found, err := searchProvider("art", trackQuery{Artist: artist, Title: title})
+		if err != nil {
+			recordLookup(artProviderName, start, err)
+			return nil, err
+		}
+		var first string
+		if len(found) > 0 {
+			first = found[0].source()
+		}
+		cands = bestCandidates(found)
+		if len(cands) == 0 {
+			recordLookup(artProviderName, start, errArtNotFound)
+		} else {
+			recordLookup(artProviderName, start, nil)
+		}
+		// 'auto' takes the art of the first record the provider finds.
+		for i, c := range cands {
+			if first != "" && c.source() == first {
+				auto = i
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown item: %s", kind)
+	}
+	results := make([]searchResult, len(cands))
+	for i, c := range cands {
+		results[i] = searchResult{reviewCandidate: c, Auto: i == auto}
+	}
+	return results, nil
+}
+
+// printSearchResults prints a table of the candidates of kind.
+func printSearchResults(kind string, results []searchResult) error {
+	if len(results) == 0 {
+		fmt.Println("No candidates found.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	last := "LYRICS"
+	if kind == "art" {
+		last = "ARTWORK"
+	}
+	fmt.Fprintf(tw, " \t#\tSCORE\tARTIST\tTITLE\tALBUM\tDURATION\t%s\n", last)
+	for i, r := range results {
+		mark := ""
+		if r.Auto {
+			mark = "*"
+		}
+		duration := ""
+		if r.Duration > 0 {
+			duration = time.Duration(r.Duration * float64(time.Second)).Round(time.Second).String()
+		}
+		info := r.source()
+		if kind == "lyrics" {
+			info = "plain"
+			if r.Synced {
+				info = "synced"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.0f%%\t%s\t%s\t%s\t%s\t%s\n", mark, i+1, r.Score*100, r.Artist, r.Title, r.Album, duration, info)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Auto {
+			return nil
+		}
+	}
+	if kind == "lyrics" {
+		fmt.Println("\n'auto' would embed none of them, as they match too poorly.")
+	}
+	return nil
+}
+
+// runSearch implements the search command.
+func runSearch(fs *flag.FlagSet) func() error {
+	asJSON := fs.Bool("json", false, "Print the candidates as JSON, including the lyrics")
+	duration := fs.Duration("duration", 0, "Duration of the track (e.g., 3m25s), which lyrics are matched against like the audio of a file")
+	setupProviders := providerFlags(fs)
+	setupTLS := tlsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s search [flags] lyrics|art artist title\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "The candidate marked with * is the one 'auto' would embed.\n")
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 3 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		kind, artist, title := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+		if kind != "lyrics" && kind != "art" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if err := setupProviders(); err != nil {
+			return err
+		}
+		if err := setupTLS(); err != nil {
+			return err
+		}
+
+		results, err := searchCandidates(kind, artist, title, *duration)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if results == nil {
+				results = []searchResult{}
+			}
+			return enc.Encode(results)
+		}
+		return printSearchResults(kind, results)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "serve",
+		usage: "Serve a web interface and JSON API for a library",
+		run:   runServe,
+	})
+}
+
+// libraryFile is the state of a single file as shown in the web interface.
+type libraryFile struct {
+	Path        string
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	HasArt      bool
+	HasLyrics   bool
+}
+
+// Complete reports whether the file has all the tags mp3extra cares about.
+func (f *libraryFile) Complete() bool {
+	return f.Title != "" && f.Artist != "" && f.Album != "" && f.HasArt && f.HasLyrics
+}
+
+// libraryAlbum groups the files of one album, told apart from others of the
+// same name by the album artist.
+type libraryAlbum struct {
+	Name   string
+	Artist string
+	Files  []*libraryFile
+}
+
+// library is the set of files served by the web interface.
+type library struct {
+	mu     sync.Mutex
+	roots  []string // absolute, with symbolic links resolved
+	lang   string
+	files  map[string]*libraryFile
+	albums []*libraryAlbum
+	locks  map[string]*fileLock // of the files being updated
+}
+
+// fileLock serializes the updates of one file.
+type fileLock struct {
+	sync.Mutex
+	users int // updates holding or waiting for the lock
+}
+
+// lock waits until no other update of the file at path runs and returns the
+// function that ends this one. Paths are told apart with symbolic links
+// resolved, so that one file reached through a link is locked as well.
+func (l *library) lock(path string) (unlock func()) {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*fileLock{}
+	}
+	fl := l.locks[path]
+	if fl == nil {
+		fl = &fileLock{}
+		l.locks[path] = fl
+	}
+	fl.users++
+	l.mu.Unlock()
+
+	fl.Lock()
+	return func() {
+		fl.Unlock()
+		l.mu.Lock()
+		if fl.users--; fl.users == 0 {
+			delete(l.locks, path)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// readLibraryFile reads the tag state of the file at path.
+func readLibraryFile(path string) (*libraryFile, error) {
+	if err := checkTagLimit(path); err != nil {
+		return nil, err
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, err
+	}
+	defer tag.Close()
+	return &libraryFile{
+		Path:        path,
+		Title:       tag.Title(),
+		Artist:      tag.Artist(),
+		Album:       tag.Album(),
+		AlbumArtist: textOf(tag, "TPE2"),
+		HasArt:      len(tag.GetFrames(tag.CommonID("Attached picture"))) > 0,
+		HasLyrics:   len(tag.GetFrames(tag.CommonID("Unsynchronised lyrics/text transcription"))) > 0,
+	}, nil
+}
+
+// scan rereads all files below the library roots.
+func (l *library) scan() error {
+	paths, err := collectMP3Files(l.roots)
+	if err != nil {
+		return err
+	}
+	files := map[string]*libraryFile{}
+	byAlbum := map[string]*libraryAlbum{}
+	var albums []*libraryAlbum
+	for _, path := range paths {
+		f, err := readLibraryFile(path)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			continue
+		}
+		files[path] = f
+		key := f.AlbumArtist + "\x00" + f.Album
+		a := byAlbum[key]
+		if a == nil {
+			a = &libraryAlbum{Name: f.Album, Artist: f.AlbumArtist}
+			byAlbum[key] = a
+			albums = append(albums, a)
+		}
+		a.Files = append(a.Files, f)
+	}
+	sort.Slice(albums, func(i, j int) bool {
+		if albums[i].Name != albums[j].Name {
+			return albums[i].Name < albums[j].Name
+		}
+		return albums[i].Artist < albums[j].Artist
+	})
+
+	l.mu.Lock()
+	l.files, l.albums = files, albums
+	l.mu.Unlock()
+	return nil
+}
+
+// update applies modify to the tag of the file at path and saves it. Updates
+// of the same file run one after the other, so that none is lost.
+func (l *library) update(path string, modify func(tag *id3v2.Tag) error) error {
+	defer l.lock(path)()
+	if err := checkTagLimit(path); err != nil {
+		return err
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+	if err := modify(tag); err != nil {
+		return err
+	}
+	if err := saveTag(tag, path, &saveOptions{snapshot: true}); err != nil {
+		return err
+	}
+
+	f, err := readLibraryFile(path)
+	if err != nil {
+		return err
+	}
+	// Files added since the last scan show up in the web interface after a rescan.
+	l.mu.Lock()
+	if lf := l.files[path]; lf != nil {
+		*lf = *f
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// fetch embeds automatically fetched art or lyrics into the given files. Cover art
+// is fetched once and shared by all files, since they belong to the same album.
+func (l *library) fetch(paths []string, what string) error {
+	var art []byte
+	var ct string
+	for _, path := range paths {
+		err := l.update(path, func(tag *id3v2.Tag) error {
+			switch what {
+			case "art":
+				if art == nil {
+					var err error
+					if art, ct, err = loadImage("auto", tag); err != nil {
+						return err
+					}
+				}
+				setCover(tag, art, ct)
+			case "lyrics":
+				lyrics, _, err := loadLyrics("auto", path, tag)
+				if err != nil {
+					return err
+				}
+				setLyrics(tag, lyrics, l.lang, "")
+			default:
+				return fmt.Errorf("unknown item: %s", what)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// known reports whether path belongs to the library. Requests may only touch such files.
+func (l *library) known(path string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.files[path]
+	return ok
+}
+
+var libraryTemplate = template.Must(template.New("library").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mp3extra</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: middle; }
+.missing { color: #c00; }
+.ok { color: #080; }
+img { width: 48px; height: 48px; object-fit: cover; }
+form { display: inline; }
+.error { background: #fdd; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>mp3extra</h1>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+<form method="post" action="/rescan"><button>Rescan</button></form>
+{{range .Albums}}
+<h2>{{if .Name}}{{.Name}}{{else}}(no album){{end}}{{with .Artist}} &ndash; {{.}}{{end}}</h2>
+<form method="post" action="/fetch">{{range .Files}}<input type="hidden" name="path" value="{{.Path}}">{{end}}<input type="hidden" name="what" value="art"><button>Fetch art for album</button></form>
+<form method="post" action="/fetch">{{range .Files}}<input type="hidden" name="path" value="{{.Path}}">{{end}}<input type="hidden" name="what" value="lyrics"><button>Fetch lyrics for album</button></form>
+<table>
+<tr><th></th><th></th><th>Title</th><th>Artist</th><th>File</th><th>Art</th><th>Lyrics</th><th></th></tr>
+{{range .Files}}
+<tr>
+<td>{{if .Complete}}<span class="ok">&#10003;</span>{{else}}<span class="missing">&#10007;</span>{{end}}</td>
+<td>{{if .HasArt}}<img src="/art?path={{.Path}}">{{end}}</td>
+<td{{if not .Title}} class="missing"{{end}}>{{or .Title "missing"}}</td>
+<td{{if not .Artist}} class="missing"{{end}}>{{or .Artist "missing"}}</td>
+<td>{{.Path}}</td>
+<td>{{if .HasArt}}<span class="ok">yes</span>{{else}}<span class="missing">no</span>{{end}}</td>
+<td>{{if .HasLyrics}}<span class="ok">yes</span>{{else}}<span class="missing">no</span>{{end}}</td>
+<td>
+<form method="post" action="/fetch"><input type="hidden" name="path" value="{{.Path}}"><input type="hidden" name="what" value="art"><button>Fetch art</button></form>
+<form method="post" action="/fetch"><input type="hidden" name="path" value="{{.Path}}"><input type="hidden" name="what" value="lyrics"><button>Fetch lyrics</button></form>
+<form method="post" action="/upload" enctype="multipart/form-data"><input type="hidden" name="path" value="{{.Path}}"><input type="file" name="image" accept="image/*" required><button>Replace art</button></form>
+</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+// handleIndex renders the library page.
+func (l *library) handleIndex(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := libraryTemplate.Execute(w, map[string]any{
+		"Albums": l.albums,
+		"Error":  r.URL.Query().Get("error"),
+	})
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// redirect sends the browser back to the library page, showing err if not nil.
+func redirect(w http.ResponseWriter, r *http.Request, err error) {
+	u := "/"
+	if err != nil {
+		log.Print(err)
+		u += "?error=" + template.URLQueryEscaper(err.Error())
+	}
+	http.Redirect(w, r, u, http.StatusSeeOther)
+}
+
+// handleFetch fetches art or lyrics for one or more files.
+func (l *library) handleFetch(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	paths := r.Form["path"]
+	for _, path := range paths {
+		if !l.known(path) {
+			http.Error(w, "unknown file", http.StatusBadRequest)
+			return
+		}
+	}
+	redirect(w, r, l.fetch(paths, r.FormValue("what")))
+}
+
+// handleUpload replaces the cover of a file with an uploaded image.
+func (l *library) handleUpload(w http.ResponseWriter, r *http.Request) {
+	limit := int64(maxRequestBytes)
+	if maxImageBytes > 0 {
+		limit = maxImageBytes + 64<<10
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if lowMemory {
+		// Buffer the upload on disk rather than in memory.
+		if err := r.ParseMultipartForm(64 << 10); err != nil {
+			redirect(w, r, err)
+			return
+		}
+	}
+	path := r.FormValue("path")
+	if !l.known(path) {
+		http.Error(w, "unknown file", http.StatusBadRequest)
+		return
+	}
+	f, _, err := r.FormFile("image")
+	if err != nil {
+		redirect(w, r, err)
+		return
+	}
+	defer f.Close()
+	b, err := readAllLimited(f, "album art image", maxImageBytes)
+	if err != nil {
+		redirect(w, r, err)
+		return
+	}
+	redirect(w, r, l.update(path, func(tag *id3v2.Tag) error {
+		setCover(tag, b, http.DetectContentType(b))
+		return nil
+	}))
+}
+
+// handleArt serves the cover of a file.
+func (l *library) handleArt(w http.ResponseWriter, r *http.Request) {
+	path := r.FormValue("path")
+	if !l.known(path) {
+		http.NotFound(w, r)
+		return
+	}
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true, ParseFrames: []string{"Attached picture"}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tag.Close()
+	pic := coverPicture(tag)
+	if pic == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", pic.MimeType)
+	w.Write(pic.Picture)
+}
+
+// handleRescan rereads the library from disk.
+func (l *library) handleRescan(w http.ResponseWriter, r *http.Request) {
+	redirect(w, r, l.scan())
+}
+
+// sameOrigin rejects form posts from other sites, so that web pages opened in
+// the same browser cannot modify the library.
+func sameOrigin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); r.Method == http.MethodPost && origin != "" && origin != "http://"+r.Host {
+			http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serialize handles one request at a time using mu, so that concurrent requests cannot
+// add up beyond the low-memory ceilings.
+func serialize(mu *sync.Mutex, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// runServe implements the serve command.
+func runServe(fs *flag.FlagSet) func() error {
+	var webAddr, apiAddr, lang string
+	fs.StringVar(&webAddr, "web", "localhost:8080", "Address to serve the web interface on, or empty to disable it")
+	fs.StringVar(&apiAddr, "api", "", "Address to serve the JSON API on, e.g. localhost:8081")
+	fs.StringVar(&lang, "lang", "jpn", "Language code for fetched lyrics (e.g., jpn, eng)")
+	setupMemory := memoryFlags(fs)
+	setupTLS := tlsFlags(fs)
+	setupProviders := providerFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s serve [flags] [dir...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if webAddr == "" && apiAddr == "" {
+			return fmt.Errorf("nothing to serve: both -web and -api are empty")
+		}
+		if err := setupMemory(); err != nil {
+			return err
+		}
+		if err := setupTLS(); err != nil {
+			return err
+		}
+		if err := setupProviders(); err != nil {
+			return err
+		}
+
+		roots := fs.Args()
+		if len(roots) == 0 {
+			roots = []string{"."}
+		}
+		for i, root := range roots {
+			abs, err := filepath.Abs(root)
+			if err != nil {
+				return err
+			}
+			// The API checks paths with links resolved against the roots.
+			if roots[i], err = filepath.EvalSymlinks(abs); err != nil {
+				return err
+			}
+		}
+
+		l := &library{roots: roots, lang: lang}
+		if err := l.scan(); err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /{$}", l.handleIndex)
+		mux.HandleFunc("GET /art", l.handleArt)
+		mux.HandleFunc("POST /fetch", l.handleFetch)
+		mux.HandleFunc("POST /upload", l.handleUpload)
+		mux.HandleFunc("POST /rescan", l.handleRescan)
+
+		// In low-memory mode both servers share a single worker.
+		wrap := func(h http.Handler) http.Handler { return h }
+		if lowMemory {
+			var mu sync.Mutex
+			wrap = func(h http.Handler) http.Handler { return serialize(&mu, h) }
+		}
+
+		errc := make(chan error, 2)
+		if webAddr != "" {
+			log.Printf("Serving %d files on http://%s/", len(l.files), webAddr)
+			go func() { errc <- http.ListenAndServe(webAddr, wrap(sameOrigin(mux))) }()
+		}
+		if apiAddr != "" {
+			log.Printf("Serving the JSON API on http://%s/", apiAddr)
+			go func() { errc <- http.ListenAndServe(apiAddr, wrap(l.apiHandler())) }()
+		}
+		return <-errc
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "set",
+		usage: "Set text tags such as title, artist and album",
+		run:   runSet,
+	})
+}
+
+// setFlags maps the flags of the set command to the labels of editFields.
+var setFlags = [][2]string{
+	{"title", "Title"},
+	{"artist", "Artist"},
+	{"album", "Album"},
+	{"album-artist", "Album Artist"},
+	{"year", "Year"},
+	{"genre", "Genre"},
+	{"track", "Track"},
+	{"disc", "Disc"},
+}
+
+// totalFlags maps the flags of the set command that set the total of a number
+// to the labels of editFields.
+var totalFlags = [][2]string{
+	{"track-total", "Track"},
+	{"disc-total", "Disc"},
+}
+
+// setTextFields sets the text fields of tag given by label. An empty value
+// removes the field.
+func setTextFields(tag *id3v2.Tag, values map[string]string) {
+	for _, f := range editFields(tag) {
+		v, ok := values[f[1]]
+		if !ok {
+			continue
+		}
+		if v == "" {
+			tag.DeleteFrames(f[0])
+			continue
+		}
+		tag.AddTextFrame(f[0], tag.DefaultEncoding(), v)
+	}
+}
+
+// setTotals sets the totals of the track and disc numbers of tag given by label,
+// keeping the numbers. An empty total removes it.
+func setTotals(tag *id3v2.Tag, totals map[string]string) error {
+	for _, f := range editFields(tag) {
+		if t, ok := totals[f[1]]; ok {
+			if err := setPositionTotal(tag, f[0], t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// urlFlags maps the flags of the set command that set URL frames to the IDs
+// of the frames.
+var urlFlags = [][2]string{
+	{"artist-url", "WOAR"},
+	{"file-url", "WOAF"},
+	{"source-url", "WOAS"},
+}
+
+// setChanges are the changes the set command makes to each file.
+type setChanges struct {
+	values map[string]string // text fields by label
+	totals map[string]string // number totals by label
+
+	stars       int     // rating, or -1 to leave it unchanged
+	ratingEmail string  // of the POPM frame holding the rating
+	count       *uint64 // play count, unchanged if nil
+
+	// userText holds TXXX descriptions and their new values, in the order
+	// given; an empty value removes the frame.
+	userText [][2]string
+
+	urls     map[string]string // URLs by frame ID; an empty URL removes the frames
+	userURLs [][2]string       // WXXX descriptions and URLs, like userText
+
+	// comment is the new text of the COMM frame with commentLang and
+	// commentDesc, unchanged if nil; an empty text removes the frame.
+	comment                  *string
+	commentLang, commentDesc string
+}
+
+// runSet implements the set command.
+func runSet(fs *flag.FlagSet) func() error {
+	flags := map[string]*string{}
+	for _, f := range setFlags {
+		flags[f[0]] = fs.String(f[0], "", "Set the "+f[1]+" field; an empty value removes it")
+	}
+	for _, f := range totalFlags {
+		flags[f[0]] = fs.String(f[0], "", "Set the total of the "+f[1]+" field, as in 3/12, keeping the number; an empty value removes it")
+	}
+	urlUsage := map[string]string{
+		"WOAR": "Set the official artist webpage (WOAR), such as the Bandcamp page of the artist; an empty value removes it",
+		"WOAF": "Set the official webpage of the file (WOAF), such as the page of the track; an empty value removes it",
+		"WOAS": "Set the official webpage of the audio source (WOAS), such as the page of the release; an empty value removes it",
+	}
+	for _, f := range urlFlags {
+		flags[f[0]] = fs.String(f[0], "", urlUsage[f[1]])
+	}
+	c := &setChanges{values: map[string]string{}, totals: map[string]string{}, stars: -1, urls: map[string]string{}}
+	var rating, plays, comment string
+	fs.StringVar(&comment, "comment", "", "Set the comment (COMM) with the language and description of -comment-lang and -comment-desc, replacing only that one; an empty value removes it")
+	fs.StringVar(&c.commentDesc, "comment-desc", "", "Description of the comment set by -comment")
+	fs.StringVar(&c.commentLang, "comment-lang", "eng", "Language of the comment set by -comment, as a 3-letter ISO 639-2 code")
+	var userText, userURLs stringList
+	fs.StringVar(&rating, "rating", "", "Set the star rating from 1 to 5 in a POPM frame; 0 removes it")
+	fs.StringVar(&c.ratingEmail, "rating-email", defaultRatingEmail, "Email identifier of the POPM frame holding the rating; players only read the ratings under their own")
+	fs.StringVar(&plays, "play-count", "", "Set the play count in the PCNT frame; 0 resets it")
+	fs.Var(&userText, "txxx", "Set the user-defined text frame (TXXX) with a description, as in \"MusicBrainz Album Id=...\"; an empty value removes it (may be repeated)")
+	fs.Var(&userURLs, "wxxx", "Set the user-defined URL frame (WXXX) with a description, as in \"Bandcamp=https://...\"; an empty URL removes it (may be repeated)")
+	var dryRun bool
+	save := &saveOptions{snapshot: true}
+	fs.BoolVar(&dryRun, "dryrun", false, "Show the changes without modifying the files")
+	manifest := manifestFlag(fs)
+	fs.StringVar(&save.id3v1, "id3v1", "keep", "What to do with the ID3v1 tag: keep, remove, or sync to mirror the new fields")
+	fs.BoolVar(&save.preserveMtime, "preserve-mtime", false, "Keep the modification time of the files, for sync tools and players that take a newer file for a changed recording")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s set [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+
+		// Only the flags given on the command line are applied.
+		ratingGiven, playsGiven := false, false
+		fs.Visit(func(f *flag.Flag) {
+			ratingGiven = ratingGiven || f.Name == "rating"
+			playsGiven = playsGiven || f.Name == "play-count"
+			if f.Name == "comment" {
+				c.comment = &comment
+			}
+			for _, sf := range setFlags {
+				if f.Name == sf[0] {
+					c.values[sf[1]] = *flags[sf[0]]
+				}
+			}
+			for _, tf := range totalFlags {
+				if f.Name == tf[0] {
+					c.totals[tf[1]] = *flags[tf[0]]
+				}
+			}
+			for _, uf := range urlFlags {
+				if f.Name == uf[0] {
+					c.urls[uf[1]] = *flags[uf[0]]
+				}
+			}
+		})
+		if len(c.values)+len(c.totals)+len(userText)+len(c.urls)+len(userURLs) == 0 && !ratingGiven && !playsGiven && c.comment == nil || fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if ratingGiven {
+			var err error
+			if c.stars, err = parseRating(rating); err != nil {
+				return err
+			}
+		}
+		if playsGiven {
+			n, err := parsePlayCount(plays)
+			if err != nil {
+				return err
+			}
+			c.count = &n
+		}
+		for _, s := range userText {
+			desc, value, ok := strings.Cut(s, "=")
+			if !ok || desc == "" {
+				return fmt.Errorf("invalid -txxx %q: want DESCRIPTION=VALUE", s)
+			}
+			c.userText = append(c.userText, [2]string{desc, value})
+		}
+		if c.comment != nil && !isLanguageCode(c.commentLang) {
+			return fmt.Errorf("invalid -comment-lang %q: want a 3-letter code such as eng", c.commentLang)
+		}
+		for _, url := range c.urls {
+			if err := checkURL(url); err != nil {
+				return err
+			}
+		}
+		for _, s := range userURLs {
+			desc, url, ok := strings.Cut(s, "=")
+			if !ok {
+				return fmt.Errorf("invalid -wxxx %q: want DESCRIPTION=URL", s)
+			}
+			if err := checkURL(url); err != nil {
+				return err
+			}
+			c.userURLs = append(c.userURLs, [2]string{desc, url})
+		}
+		// Track and disc numbers are "n" or "n/total".
+		for _, label := range []string{"Track", "Disc"} {
+			if v := c.values[label]; v != "" {
+				if _, _, err := parsePosition(v); err != nil {
+					return fmt.Errorf("%s: %w", label, err)
+				}
+			}
+			if t := c.totals[label]; t != "" {
+				if n, err := strconv.Atoi(t); err != nil || n < 1 {
+					return fmt.Errorf("%s: invalid total %q", label, t)
+				}
+			}
+		}
+		if err := checkID3v1Mode(save.id3v1); err != nil {
+			return err
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		failed := 0
+		var done []string
+		for _, name := range files {
+			if err := setFile(name, c, save, dryRun); err != nil {
+				log.Printf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			done = append(done, name)
+		}
+		if !dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
+
+// setFile makes the changes c to the MP3 file at path and saves it with save.
+func setFile(path string, c *setChanges, save *saveOptions, dryRun bool) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return fmt.Errorf("error opening MP3 file: %w", err)
+	}
+	defer tag.Close()
+	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+
+	before := captureFrames(tag)
+	setTextFields(tag, c.values)
+	if err := setTotals(tag, c.totals); err != nil {
+		return err
+	}
+	if c.stars >= 0 {
+		setRating(tag, c.stars, c.ratingEmail)
+	}
+	if c.count != nil {
+		tag.DeleteFrames("PCNT")
+		tag.AddFrame("PCNT", playCounterFrame{Count: *c.count})
+	}
+	for _, t := range c.userText {
+		setUserText(tag, t[0], t[1])
+	}
+	if c.comment != nil {
+		setComment(tag, strings.ToLower(c.commentLang), c.commentDesc, *c.comment)
+	}
+	for id, url := range c.urls {
+		setURL(tag, id, url)
+	}
+	for _, u := range c.userURLs {
+		setUserURL(tag, u[0], u[1])
+	}
+	if err := keepProtected(tag, path); err != nil {
+		return fmt.Errorf("error checking write-protected frames: %w", err)
+	}
+	if dryRun {
+		fmt.Printf("==> %s <==\n", path)
+		printFrameDiff(os.Stdout, diffFrames(before, captureFrames(tag)))
+		reportID3v1(os.Stdout, path, tag, save.id3v1, true)
+		return nil
+	}
+	reportID3v1(os.Stdout, path, tag, save.id3v1, false)
+	if err := saveTag(tag, path, save); err != nil {
+		return fmt.Errorf("error saving MP3 file: %w", err)
+	}
+	fmt.Println("Updated", path)
+	return nil
+}
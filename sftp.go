@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sftpTarget is a file on an SSH server, addressed as
+// sftp://[user@]host[:port]/path, where a path starting with /~/ is relative to
+// the home directory. Files are read and written with the SFTP protocol over
+// the sftp subsystem of the ssh program, so its configuration, keys and agent
+// apply, and accounts limited to SFTP work as well.
+type sftpTarget struct {
+	host string // with the user, if given
+	port string
+	path string
+}
+
+// parseSFTPURL returns the file the sftp URL name addresses. ok is false for
+// anything else.
+func parseSFTPURL(name string) (t *sftpTarget, ok bool) {
+	if !strings.HasPrefix(name, "sftp://") {
+		return nil, false
+	}
+	u, err := url.Parse(name)
+	if err != nil || u.Hostname() == "" || u.Path == "" || u.Path == "/" {
+		return nil, false
+	}
+	t = &sftpTarget{host: u.Hostname(), port: u.Port(), path: u.Path}
+	if u.User != nil {
+		t.host = u.User.Username() + "@" + t.host
+	}
+	// SFTP servers resolve relative paths against the home directory.
+	if rest, ok := strings.CutPrefix(t.path, "/~/"); ok {
+		t.path = rest
+	}
+	return t, true
+}
+
+// isSFTPURL reports whether name addresses a file on an SSH server.
+func isSFTPURL(name string) bool {
+	_, ok := parseSFTPURL(name)
+	return ok
+}
+
+// SFTP version 3 packet types, open flags, attribute flags and status codes,
+// from draft-ietf-secsh-filexfer-02.
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpRemove   = 13
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpAttrs    = 105
+	sftpExtended = 200
+
+	sftpOpenRead  = 0x01
+	sftpOpenWrite = 0x02
+	sftpOpenCreat = 0x08
+	sftpOpenTrunc = 0x10
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+
+	sftpOK               = 0
+	sftpEOF              = 1
+	sftpNoSuchFile       = 2
+	sftpPermissionDenied = 3
+)
+
+const (
+	sftpChunk     = 32 << 10 // bytes per read or write request
+	sftpWindow    = 16       // requests in flight while reading or writing
+	sftpMaxPacket = 1 << 20  // largest packet accepted from the server
+)
+
+// sftpConn is an SFTP session with a server, over the ssh program.
+type sftpConn struct {
+	host    string
+	cmd     *exec.Cmd
+	w       io.WriteCloser
+	r       *bufio.Reader
+	stderr  bytes.Buffer
+	next    uint32 // id of the next request
+	exts    map[string]string
+	replies map[uint32]sftpReply // replies received before they were waited for
+	closed  bool
+}
+
+// sftpReply is a packet from the server without its request id.
+type sftpReply struct {
+	typ  byte
+	data []byte
+}
+
+// dial starts an SFTP session with the server of t.
+func (t *sftpTarget) dial() (*sftpConn, error) {
+	args := []string{"-s"}
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	args = append(args, "--", t.host, "sftp")
+	c := &sftpConn{
+		host:    t.host,
+		cmd:     exec.Command("ssh", args...),
+		exts:    map[string]string{},
+		replies: map[uint32]sftpReply{},
+	}
+	c.cmd.Stderr = &c.stderr
+	w, err := c.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.w, c.r = w, bufio.NewReaderSize(r, 64<<10)
+	if err := c.cmd.Start(); err != nil {
+		return nil, err
+	}
+	if err := c.send(sftpInit, sftpPacket(nil).u32(3)); err != nil {
+		return nil, c.fail(err)
+	}
+	typ, data, err := c.readPacket()
+	if err != nil {
+		return nil, c.fail(err)
+	}
+	if typ != sftpVersion {
+		c.Close()
+		return nil, fmt.Errorf("ssh %s: not an SFTP server", c.host)
+	}
+	d := &sftpBuffer{b: data}
+	d.u32()
+	for len(d.b) > 0 && !d.short {
+		name, value := d.bytes(), d.bytes()
+		c.exts[string(name)] = string(value)
+	}
+	return c, nil
+}
+
+// Close ends the session, discarding replies not waited for.
+func (c *sftpConn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.w.Close()
+	io.Copy(io.Discard, c.r)
+	return c.cmd.Wait()
+}
+
+// fail ends the session after err broke it, and returns the error with what
+// ssh reported.
+func (c *sftpConn) fail(err error) error {
+	c.Close()
+	if msg := bytes.TrimSpace(c.stderr.Bytes()); len(msg) > 0 {
+		return fmt.Errorf("ssh %s: %s", c.host, msg)
+	}
+	return fmt.Errorf("ssh %s: %v", c.host, err)
+}
+
+// sftpPacket is the payload of a packet being built.
+type sftpPacket []byte
+
+func (p sftpPacket) u32(v uint32) sftpPacket { return binary.BigEndian.AppendUint32(p, v) }
+func (p sftpPacket) u64(v uint64) sftpPacket { return binary.BigEndian.AppendUint64(p, v) }
+
+func (p sftpPacket) bytes(b []byte) sftpPacket { return append(p.u32(uint32(len(b))), b...) }
+func (p sftpPacket) str(s string) sftpPacket   { return append(p.u32(uint32(len(s))), s...) }
+
+// sftpBuffer reads the fields of a packet from the server. short is set once
+// a field is cut off, and the fields read from then on are zero.
+type sftpBuffer struct {
+	b     []byte
+	short bool
+}
+
+func (d *sftpBuffer) u32() uint32 {
+	if len(d.b) < 4 {
+		d.short = true
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *sftpBuffer) skip(n int) {
+	if len(d.b) < n {
+		d.short = true
+		return
+	}
+	d.b = d.b[n:]
+}
+
+func (d *sftpBuffer) bytes() []byte {
+	n := d.u32()
+	if d.short || uint64(n) > uint64(len(d.b)) {
+		d.short = true
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+// send writes a packet of type typ with payload p.
+func (c *sftpConn) send(typ byte, p sftpPacket) error {
+	b := make([]byte, 4, 5+len(p))
+	b = append(b, typ)
+	b = append(b, p...)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_, err := c.w.Write(b)
+	return err
+}
+
+// readPacket reads the next packet from the server.
+func (c *sftpConn) readPacket() (byte, []byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(c.r, n[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size == 0 || size > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("invalid SFTP packet of %d bytes", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return 0, nil, err
+	}
+	return b[0], b[1:], nil
+}
+
+// request sends a request of type typ with payload p and returns its id.
+func (c *sftpConn) request(typ byte, p sftpPacket) (uint32, error) {
+	id := c.next
+	c.next++
+	if err := c.send(typ, append(sftpPacket(nil).u32(id), p...)); err != nil {
+		return 0, c.fail(err)
+	}
+	return id, nil
+}
+
+// reply waits for the reply to the request id. Replies to other requests that
+// arrive first are kept for later.
+func (c *sftpConn) reply(id uint32) (byte, []byte, error) {
+	if r, ok := c.replies[id]; ok {
+		delete(c.replies, id)
+		return r.typ, r.data, nil
+	}
+	for {
+		typ, data, err := c.readPacket()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		d := &sftpBuffer{b: data}
+		rid := d.u32()
+		if d.short {
+			return 0, nil, c.fail(errors.New("SFTP reply without id"))
+		}
+		if rid == id {
+			return typ, d.b, nil
+		}
+		c.replies[rid] = sftpReply{typ, d.b}
+	}
+}
+
+// call sends a request and waits for its reply.
+func (c *sftpConn) call(typ byte, p sftpPacket) (byte, []byte, error) {
+	id, err := c.request(typ, p)
+	if err != nil {
+		return 0, nil, err
+	}
+	return c.reply(id)
+}
+
+// status returns the error of a status reply to the operation op on path, nil
+// for success and io.EOF for the end of a file.
+func (c *sftpConn) status(data []byte, op, path string) error {
+	d := &sftpBuffer{b: data}
+	code := d.u32()
+	msg := string(d.bytes())
+	var err error
+	switch code {
+	case sftpOK:
+		return nil
+	case sftpEOF:
+		return io.EOF
+	case sftpNoSuchFile:
+		err = os.ErrNotExist
+	case sftpPermissionDenied:
+		err = os.ErrPermission
+	default:
+		if msg == "" {
+			msg = fmt.Sprintf("SFTP status %d", code)
+		}
+		err = errors.New(msg)
+	}
+	return &os.PathError{Op: op, Path: c.host + ":" + path, Err: err}
+}
+
+// check returns the error of a reply that should be a status.
+func (c *sftpConn) check(typ byte, data []byte, err error, op, path string) error {
+	if err != nil {
+		return err
+	}
+	if typ != sftpStatus {
+		return fmt.Errorf("%s %s:%s: unexpected SFTP reply %d", op, c.host, path, typ)
+	}
+	return c.status(data, op, path)
+}
+
+// open opens the file at path with the open flags pflags and returns its
+// handle. A file it creates gets the permissions perm.
+func (c *sftpConn) open(path string, pflags, perm uint32) (string, error) {
+	p := sftpPacket(nil).str(path).u32(pflags)
+	if pflags&sftpOpenCreat != 0 {
+		p = p.u32(sftpAttrPermissions).u32(perm)
+	} else {
+		p = p.u32(0)
+	}
+	typ, data, err := c.call(sftpOpen, p)
+	if err == nil && typ == sftpHandle {
+		d := &sftpBuffer{b: data}
+		if h := d.bytes(); !d.short {
+			return string(h), nil
+		}
+	}
+	if err := c.check(typ, data, err, "open", path); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("open %s:%s: no handle in the SFTP reply", c.host, path)
+}
+
+// closeHandle closes the file handle h of the file at path.
+func (c *sftpConn) closeHandle(h, path string) error {
+	typ, data, err := c.call(sftpClose, sftpPacket(nil).str(h))
+	return c.check(typ, data, err, "close", path)
+}
+
+// permissions returns the permission bits of the file at path.
+func (c *sftpConn) permissions(path string) (uint32, error) {
+	typ, data, err := c.call(sftpStat, sftpPacket(nil).str(path))
+	if err == nil && typ == sftpAttrs {
+		d := &sftpBuffer{b: data}
+		flags := d.u32()
+		if flags&sftpAttrSize != 0 {
+			d.skip(8)
+		}
+		if flags&sftpAttrUIDGID != 0 {
+			d.skip(8)
+		}
+		if perm := d.u32(); flags&sftpAttrPermissions != 0 && !d.short {
+			return perm & 0o7777, nil
+		}
+		return 0, fmt.Errorf("stat %s:%s: no permissions in the SFTP reply", c.host, path)
+	}
+	if err := c.check(typ, data, err, "stat", path); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("stat %s:%s: no attributes in the SFTP reply", c.host, path)
+}
+
+// remove removes the file at path.
+func (c *sftpConn) remove(path string) error {
+	typ, data, err := c.call(sftpRemove, sftpPacket(nil).str(path))
+	return c.check(typ, data, err, "remove", path)
+}
+
+// rename renames the file at from to to, replacing to. Servers without the
+// posix-rename extension refuse to replace a file, so it is removed first.
+func (c *sftpConn) rename(from, to string) error {
+	if _, ok := c.exts["posix-rename@openssh.com"]; ok {
+		typ, data, err := c.call(sftpExtended, sftpPacket(nil).str("posix-rename@openssh.com").str(from).str(to))
+		return c.check(typ, data, err, "rename", from)
+	}
+	if err := c.remove(to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	typ, data, err := c.call(sftpRename, sftpPacket(nil).str(from).str(to))
+	return c.check(typ, data, err, "rename", from)
+}
+
+// write writes r to the file handle h of the file at path from offset off,
+// with up to sftpWindow requests in flight.
+func (c *sftpConn) write(h, path string, off int64, r io.Reader) error {
+	buf := make([]byte, sftpChunk)
+	var pending []uint32
+	for done := false; !done || len(pending) > 0; {
+		if !done && len(pending) < sftpWindow {
+			n, err := io.ReadFull(r, buf)
+			if n > 0 {
+				id, err := c.request(sftpWrite, sftpPacket(nil).str(h).u64(uint64(off)).bytes(buf[:n]))
+				if err != nil {
+					return err
+				}
+				pending = append(pending, id)
+				off += int64(n)
+			}
+			switch {
+			case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+				done = true
+			case err != nil:
+				return err
+			}
+			continue
+		}
+		typ, data, err := c.reply(pending[0])
+		if err := c.check(typ, data, err, "write", path); err != nil {
+			return err
+		}
+		pending = pending[1:]
+	}
+	return nil
+}
+
+// openSFTP starts downloading the file at the sftp URL name.
+func openSFTP(name string) (io.ReadCloser, error) {
+	t, ok := parseSFTPURL(name)
+	if !ok {
+		return nil, fmt.Errorf("invalid sftp URL: %s", name)
+	}
+	debugf("downloading %s", name)
+	c, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	h, err := c.open(t.path, sftpOpenRead, 0)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &sftpReader{c: c, handle: h, path: t.path}, nil
+}
+
+// sftpReader reads a file from an SFTP server, with up to sftpWindow read
+// requests in flight.
+type sftpReader struct {
+	c       *sftpConn
+	handle  string
+	path    string
+	off     int64 // offset of the next read request
+	pending []sftpReadRequest
+	buf     []byte // data read but not returned yet
+	err     error
+}
+
+// sftpReadRequest is a read request in flight.
+type sftpReadRequest struct {
+	id  uint32
+	off int64
+}
+
+// Read reads from the file.
+func (r *sftpReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill sends read requests up to the window and waits for the oldest one.
+func (r *sftpReader) fill() {
+	for len(r.pending) < sftpWindow {
+		id, err := r.c.request(sftpRead, sftpPacket(nil).str(r.handle).u64(uint64(r.off)).u32(sftpChunk))
+		if err != nil {
+			r.err = err
+			return
+		}
+		r.pending = append(r.pending, sftpReadRequest{id, r.off})
+		r.off += sftpChunk
+	}
+	req := r.pending[0]
+	r.pending = r.pending[1:]
+	typ, data, err := r.c.reply(req.id)
+	if err == nil && typ == sftpData {
+		d := &sftpBuffer{b: data}
+		b := d.bytes()
+		switch {
+		case d.short:
+			r.err = fmt.Errorf("read %s:%s: invalid SFTP reply", r.c.host, r.path)
+		case len(b) == 0:
+			r.err = io.ErrUnexpectedEOF
+		case len(b) < sftpChunk:
+			// A short read leaves a gap before the requests in flight, so
+			// they are dropped and reading resumes after it.
+			for _, q := range r.pending {
+				r.c.reply(q.id)
+			}
+			r.pending = nil
+			r.off = req.off + int64(len(b))
+		}
+		r.buf = b
+		return
+	}
+	r.err = r.c.check(typ, data, err, "read", r.path)
+	if r.err == nil {
+		r.err = fmt.Errorf("read %s:%s: unexpected SFTP reply", r.c.host, r.path)
+	}
+}
+
+// Close stops the download, which need not have been read to its end.
+func (r *sftpReader) Close() error {
+	r.c.Close()
+	return nil
+}
+
+// uploadSFTP uploads the file at path to the sftp URL name. The file on the
+// server is only replaced once the upload is complete, and keeps its
+// permissions.
+func uploadSFTP(name, path string) error {
+	t, ok := parseSFTPURL(name)
+	if !ok {
+		return fmt.Errorf("invalid sftp URL: %s", name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	debugf("uploading %s", name)
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	perm, err := c.permissions(t.path)
+	if err != nil {
+		perm = 0o644
+	}
+	tmp := t.path + ".mp3extra.tmp"
+	h, err := c.open(tmp, sftpOpenWrite|sftpOpenCreat|sftpOpenTrunc, perm)
+	if err != nil {
+		return err
+	}
+	err = c.write(h, tmp, 0, f)
+	if cerr := c.closeHandle(h, tmp); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = c.rename(tmp, t.path)
+	}
+	if err != nil && !c.closed {
+		c.remove(tmp)
+	}
+	return err
+}
+
+// patchSFTP overwrites the start of the file at the sftp URL name with b,
+// leaving the rest of the file as it is.
+func patchSFTP(name string, b []byte) error {
+	t, ok := parseSFTPURL(name)
+	if !ok {
+		return fmt.Errorf("invalid sftp URL: %s", name)
+	}
+	debugf("rewriting the first %d bytes of %s", len(b), name)
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	h, err := c.open(t.path, sftpOpenWrite, 0)
+	if err != nil {
+		return err
+	}
+	err = c.write(h, t.path, 0, bytes.NewReader(b))
+	if cerr := c.closeHandle(h, t.path); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "show",
+		usage: "Print the ID3v2 frames and cover art of MP3 files",
+		run:   runShow,
+	})
+}
+
+// frameSummary returns a short human-readable description of a frame, including its type.
+func frameSummary(v id3v2.Framer) string {
+	if df, ok := v.(duplicateFrame); ok {
+		return "duplicate: " + frameSummary(df.Framer)
+	}
+	var s string
+	// Switch on the type of frame to extract a summary string.
+	switch t := v.(type) {
+	case id3v2.TextFrame:
+		s = t.Text
+	case id3v2.CommentFrame:
+		s = t.Language + " " + t.Description + ": " + t.Text
+	case id3v2.PictureFrame:
+		s = t.Description + " (" + pictureTypeName(t.PictureType) + ")"
+	case id3v2.UserDefinedTextFrame:
+		s = t.Description + ": " + t.Value
+	case id3v2.UFIDFrame:
+		s = t.OwnerIdentifier + ": " + string(t.Identifier)
+	case id3v2.UnsynchronisedLyricsFrame:
+		s = t.Language + " " + t.ContentDescriptor + ": " + t.Lyrics
+	case id3v2.PopularimeterFrame:
+		s = fmt.Sprintf("%s: %d stars (%d)", t.Email, ratingStars(t.Rating), t.Rating)
+		if t.Counter != nil && t.Counter.Sign() > 0 {
+			s += fmt.Sprintf(", played %s times", t.Counter)
+		}
+	case urlFrame:
+		s = t.URL
+	case userURLFrame:
+		s = t.Description + ": " + t.URL
+	case playCounterFrame:
+		s = fmt.Sprintf("played %d times", t.Count)
+	case chapterFrame:
+		s = fmt.Sprintf("%s %s-%s", t.ElementID, formatClock(t.Start), formatClock(t.End))
+		if title := t.title(); title != "" {
+			s += ": " + title
+		}
+		if t.picture() != nil {
+			s += " (with picture)"
+		}
+	case tocFrame:
+		s = t.ElementID + ": " + strings.Join(t.Children, " ")
+	case id3v2.UnknownFrame:
+		s = fmt.Sprintf("unknown frame (preserved), %d bytes", len(t.Body))
+	default:
+		s = fmt.Sprint(v)
+	}
+	// Keep the summary on a single line.
+	s = strings.Join(strings.Fields(s), " ")
+	// Truncate output to avoid overly long strings.
+	if r := []rune(s); len(r) > 70 {
+		s = string(r[:70]) + "..."
+	}
+	return s + " [" + reflect.TypeOf(v).Name() + "]"
+}
+
+// printUserText prints the TXXX frames of tag as DESCRIPTION=VALUE lines, in
+// the order of the tag.
+func printUserText(tag *id3v2.Tag) {
+	for _, f := range tag.GetFrames("TXXX") {
+		if udtf, ok := f.(id3v2.UserDefinedTextFrame); ok {
+			fmt.Printf("%s=%s\n", udtf.Description, udtf.Value)
+		}
+	}
+}
+
+// printFrames prints a one-line summary of every frame in tag, sorted by frame ID.
+func printFrames(w io.Writer, tag *id3v2.Tag) {
+	frames := tag.AllFrames()
+	var ks []string
+	for k := range frames {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		for _, v := range frames[k] {
+			fmt.Fprintf(w, "%v: %v\n", k, frameSummary(v))
+		}
+	}
+}
+
+// runShow implements the show command.
+func runShow(fs *flag.FlagSet) func() error {
+	var art string
+	var userText bool
+	fs.StringVar(&art, "art", "auto", "Cover preview: auto, kitty, iterm, sixel, ascii or none")
+	fs.BoolVar(&userText, "txxx", false, "Only list the user-defined text frames (TXXX), in full, one DESCRIPTION=VALUE per line")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s show [flags] file.mp3...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		for i, name := range fs.Args() {
+			if fs.NArg() > 1 {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("==> %s <==\n", name)
+			}
+			tag, err := openTag(name)
+			if err != nil {
+				return fmt.Errorf("error opening MP3 file: %w", err)
+			}
+			if userText {
+				printUserText(tag)
+				tag.Close()
+				continue
+			}
+			printFrames(os.Stdout, tag)
+			if v1, err := readID3v1(name); err == nil && v1 != nil {
+				fmt.Printf("ID3v1: %s\n", v1)
+			}
+			err = previewArt(os.Stdout, tag, art)
+			tag.Close()
+			if err != nil {
+				return fmt.Errorf("error previewing cover art: %w", err)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// sortFields pairs the fields players sort by with their sort frames. TSO2,
+// the sort order of the album artist, is an iTunes extension that most
+// players read as well.
+var sortFields = []struct {
+	sortID string
+	get    func(*id3v2.Tag) string
+}{
+	{"TSOT", (*id3v2.Tag).Title},
+	{"TSOP", (*id3v2.Tag).Artist},
+	{"TSOA", (*id3v2.Tag).Album},
+	{"TSO2", func(tag *id3v2.Tag) string { return textOf(tag, "TPE2") }},
+}
+
+// sortName returns how s is sorted: romanized if it is in a script romanize
+// handles, and with a leading "The" moved to the end, as in "Beatles, The".
+// ok is false if s sorts as it is.
+func sortName(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	name, _ := romanize(s)
+	if len(name) > 4 && strings.EqualFold(name[:4], "the ") {
+		if rest := strings.TrimSpace(name[4:]); rest != "" {
+			name = rest + ", " + name[:3]
+		}
+	}
+	return name, name != s && name != ""
+}
+
+// applySortTags writes the sort frames of the title, artist, album and album
+// artist of tag where they sort differently than they read. Sort frames that
+// are already there are left alone. It returns the number of frames written.
+func applySortTags(tag *id3v2.Tag) int {
+	n := 0
+	for _, f := range sortFields {
+		if tag.GetTextFrame(f.sortID).Text != "" {
+			continue
+		}
+		if name, ok := sortName(f.get(tag)); ok {
+			tag.AddTextFrame(f.sortID, tag.DefaultEncoding(), name)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "split",
+		usage: "Split a stream rip into tagged tracks at chapter markers or silence",
+		run:   runSplit,
+	})
+}
+
+// splitFrame is the per-frame information needed to find split points.
+type splitFrame struct {
+	offset int64
+	start  time.Duration
+	level  int
+}
+
+// splitSegment is one track cut out of a stream rip.
+type splitSegment struct {
+	start, end     int64
+	startAt, endAt time.Duration
+	title          string
+	artist, album  string
+	musicBrainz    *musicBrainzIDs // set once the track is identified
+}
+
+// findSilenceCuts returns the indexes of frames at which a new track starts.
+// A cut is placed in the middle of every run of silent frames that lasts at least minSilence.
+func findSilenceCuts(frames []splitFrame, level int, minSilence time.Duration, total time.Duration) []int {
+	var cuts []int
+	runStart := -1
+	endRun := func(i int) {
+		if runStart < 0 {
+			return
+		}
+		endAt := total
+		if i < len(frames) {
+			endAt = frames[i].start
+		}
+		// Silence at the very beginning or end of the rip is not a track boundary.
+		if endAt-frames[runStart].start >= minSilence && runStart > 0 && i < len(frames) {
+			cuts = append(cuts, (runStart+i)/2)
+		}
+		runStart = -1
+	}
+	for i, f := range frames {
+		if f.level >= 0 && f.level <= level {
+			if runStart < 0 {
+				runStart = i
+			}
+			continue
+		}
+		endRun(i)
+	}
+	endRun(len(frames))
+	return cuts
+}
+
+// findChapterCuts returns the indexes of frames at which chapters start, along
+// with the chapters that start at them.
+func findChapterCuts(frames []splitFrame, chapters []chapterFrame) ([]int, []chapterFrame) {
+	chapters = slices.Clone(chapters)
+	sort.Slice(chapters, func(i, j int) bool {
+		return chapters[i].Start < chapters[j].Start
+	})
+
+	var cuts []int
+	var kept []chapterFrame
+	for _, cf := range chapters {
+		i := sort.Search(len(frames), func(i int) bool {
+			return frames[i].start >= cf.Start
+		})
+		if i >= len(frames) {
+			continue
+		}
+		if len(cuts) > 0 && cuts[len(cuts)-1] == i {
+			continue
+		}
+		cuts = append(cuts, i)
+		kept = append(kept, cf)
+	}
+	return cuts, kept
+}
+
+// writeSegment writes the audio between seg.start and seg.end of src to name,
+// preceded by a fresh ID3v2 tag.
+func writeSegment(src *os.File, name string, seg *splitSegment, track, total int) error {
+	tag := id3v2.NewEmptyTag()
+	tag.SetDefaultEncoding(id3v2.EncodingUTF16)
+	if seg.title != "" {
+		tag.SetTitle(seg.title)
+	}
+	if seg.artist != "" {
+		tag.SetArtist(seg.artist)
+	}
+	if seg.album != "" {
+		tag.SetAlbum(seg.album)
+	}
+	tag.AddTextFrame(tag.CommonID("Track number/Position in set"), tag.DefaultEncoding(), fmt.Sprintf("%d/%d", track, total))
+	if seg.musicBrainz != nil {
+		setMusicBrainzIDs(tag, seg.musicBrainz)
+	}
+
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := tag.WriteTo(out); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(src, seg.start, seg.end-seg.start)); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// runSplit implements the split command. It cuts a long recording into separate
+// tracks, either at the CHAP frames of its tag or at detected silences, and
+// optionally identifies every track by its audio fingerprint.
+func runSplit(fs *flag.FlagSet) func() error {
+	var outDir, cueFile string
+	var minSilence, minTrack time.Duration
+	var level int
+	var useMarkers, fingerprint, dryRun bool
+	fs.StringVar(&outDir, "o", "", "Directory to write the tracks to (defaults to the directory of the input file)")
+	fs.DurationVar(&minSilence, "min-silence", 2*time.Second, "Minimum length of silence that separates two tracks")
+	fs.DurationVar(&minTrack, "min-track", 30*time.Second, "Minimum length of a track; shorter segments are merged into the previous one")
+	fs.IntVar(&level, "silence-level", 16, "Maximum encoded bits per granule for a frame to count as silent")
+	fs.BoolVar(&useMarkers, "markers", true, "Split at the tracks of a cue sheet or at CHAP frames when the file has them instead of detecting silence")
+	fs.StringVar(&cueFile, "cue", "", "Cue sheet to take the tracks from (defaults to the .cue file of the same name next to the stream)")
+	fs.BoolVar(&fingerprint, "fingerprint", false, "Identify each track via AcoustID (requires fpcalc and an AcoustID key, see auth)")
+	fs.BoolVar(&dryRun, "dryrun", false, "Print the detected tracks without writing files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s split [flags] stream.mp3\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+
+		mp3File := fs.Arg(0)
+		if mp3File == "" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if fingerprint {
+			if _, _, err := credential(providerAcoustID); err != nil {
+				return fmt.Errorf("-fingerprint: %w", err)
+			}
+		}
+
+		tag, err := openTag(mp3File)
+		if err != nil {
+			return fmt.Errorf("error opening MP3 file: %w", err)
+		}
+		defer tag.Close()
+
+		var sheet *cueSheet
+		if cueFile == "" && useMarkers {
+			cueFile = findCueSheet(mp3File)
+		}
+		if cueFile != "" {
+			if sheet, err = readCueSheet(cueFile); err != nil {
+				return err
+			}
+		}
+
+		src, err := os.Open(mp3File)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		start, end, err := audioRange(src)
+		if err != nil {
+			return err
+		}
+
+		// Collect the offset, start time and loudness of every audio frame.
+		var frames []splitFrame
+		var total time.Duration
+		err = scanMPEGFrames(src, start, end, func(f *mpegFrame) error {
+			if f.Xing {
+				return nil
+			}
+			frames = append(frames, splitFrame{offset: f.Offset, start: total, level: f.Level})
+			total += f.Duration()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(frames) == 0 {
+			return errNoAudio
+		}
+
+		var cuts []int
+		var titles, artists []string
+		if useMarkers || sheet != nil {
+			chapters, _, err := readChapters(tag)
+			if err != nil {
+				return err
+			}
+			if sheet != nil {
+				chapters = sheet.chapters(total, id3v2.EncodingUTF8)
+			}
+			var kept []chapterFrame
+			cuts, kept = findChapterCuts(frames, chapters)
+			for _, cf := range kept {
+				title, artist := cf.title(), ""
+				if sheet != nil {
+					if t := sheet.track(cf.ElementID); t != nil {
+						artist = t.Performer
+					}
+				}
+				titles = append(titles, title)
+				artists = append(artists, artist)
+			}
+		}
+		if len(cuts) == 0 {
+			cuts = findSilenceCuts(frames, level, minSilence, total)
+			titles, artists = nil, nil
+		}
+		artist, album := tag.Artist(), tag.Album()
+		if sheet != nil && sheet.Performer != "" {
+			artist = sheet.Performer
+		}
+		if sheet != nil && sheet.Title != "" {
+			album = sheet.Title
+		}
+
+		// Turn the cut points into segments, merging those that are too short.
+		// Chapter segments are kept as they are since their boundaries are explicit.
+		bounds := cuts
+		if len(bounds) == 0 || bounds[0] != 0 {
+			bounds = append([]int{0}, bounds...)
+			if titles != nil {
+				titles = append([]string{""}, titles...)
+				artists = append([]string{""}, artists...)
+			}
+		}
+		var segments []*splitSegment
+		for i, b := range bounds {
+			seg := &splitSegment{
+				start:   frames[b].offset,
+				startAt: frames[b].start,
+				artist:  artist,
+				album:   album,
+			}
+			if titles != nil {
+				seg.title = titles[i]
+				if artists[i] != "" {
+					seg.artist = artists[i]
+				}
+			}
+			if i+1 < len(bounds) {
+				seg.end = frames[bounds[i+1]].offset
+				seg.endAt = frames[bounds[i+1]].start
+			} else {
+				seg.end = end
+				seg.endAt = total
+			}
+			if len(segments) > 0 && titles == nil && seg.endAt-seg.startAt < minTrack {
+				prev := segments[len(segments)-1]
+				prev.end, prev.endAt = seg.end, seg.endAt
+				continue
+			}
+			segments = append(segments, seg)
+		}
+
+		if outDir == "" {
+			outDir = filepath.Dir(mp3File)
+		}
+		base := strings.TrimSuffix(filepath.Base(mp3File), filepath.Ext(mp3File))
+		for i, seg := range segments {
+			if seg.title == "" {
+				seg.title = fmt.Sprintf("Track %02d", i+1)
+			}
+			name := filepath.Join(outDir, fmt.Sprintf("%s-%02d.mp3", base, i+1))
+			if dryRun {
+				fmt.Printf("%s: %v - %v %s\n", name, seg.startAt.Round(time.Second), seg.endAt.Round(time.Second), seg.title)
+				continue
+			}
+			if err := writeSegment(src, name, seg, i+1, len(segments)); err != nil {
+				return fmt.Errorf("error writing %s: %w", name, err)
+			}
+
+			// Replace the placeholder tags with the identified recording.
+			if fingerprint {
+				m, err := identifyFile(name)
+				if err != nil {
+					log.Printf("Error identifying %s: %v", name, err)
+				} else {
+					seg.title, seg.artist, seg.musicBrainz = m.Title, m.Artist, &m.IDs
+					if m.Album != "" {
+						seg.album = m.Album
+					}
+					if err := writeSegment(src, name, seg, i+1, len(segments)); err != nil {
+						return fmt.Errorf("error writing %s: %w", name, err)
+					}
+				}
+			}
+			fmt.Println("Wrote", name)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "export-state",
+		usage: "Write the journal, snapshots, statistics, review queue and settings to an archive",
+		run:   runExportState,
+	})
+	registerCommand(&command{
+		name:  "import-state",
+		usage: "Restore the state written by export-state, e.g. on another machine",
+		run:   runImportState,
+	})
+}
+
+// stateFiles are the files in appDir that make up the state, besides the
+// snapshots.
+var stateFiles = []string{"journal.jsonl", "stats.json", "quarantine.json", "protected.json", "genres.json"}
+
+// isStateFile reports whether name, relative to appDir with forward slashes,
+// belongs to the state.
+func isStateFile(name string) bool {
+	for _, f := range stateFiles {
+		if name == f {
+			return true
+		}
+	}
+	dir, file := path.Split(name)
+	return dir == "snapshots/" && strings.HasSuffix(file, ".id3") && !strings.ContainsAny(file, `/\`)
+}
+
+// runExportState implements the export-state command.
+func runExportState(fs *flag.FlagSet) func() error {
+	snapshots := fs.Bool("snapshots", true, "Include the snapshots needed by 'mp3extra undo'")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export-state [flags] state.tar.gz\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		dir, err := appDir()
+		if err != nil {
+			return err
+		}
+
+		out, err := os.Create(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		n := 0
+		err = withStateLock(func() error {
+			gz := gzip.NewWriter(out)
+			tw := tar.NewWriter(gz)
+			add := func(name string) error {
+				b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), ModTime: time.Now()}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				n++
+				_, err = tw.Write(b)
+				return err
+			}
+			for _, name := range stateFiles {
+				if err := add(name); err != nil {
+					return err
+				}
+			}
+			if *snapshots {
+				entries, err := os.ReadDir(filepath.Join(dir, "snapshots"))
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+				for _, e := range entries {
+					if name := "snapshots/" + e.Name(); isStateFile(name) {
+						if err := add(name); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		})
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(fs.Arg(0))
+			return err
+		}
+		fmt.Printf("Exported %d files to %s\n", n, fs.Arg(0))
+		return nil
+	}
+}
+
+// pathRewrite replaces the prefix From of file paths recorded in the state
+// with To, for moving a library to another location.
+type pathRewrite struct {
+	From, To string
+}
+
+// apply returns p with its prefix rewritten, if it has it.
+func (r *pathRewrite) apply(p string) string {
+	if r == nil {
+		return p
+	}
+	if p == r.From || strings.HasPrefix(p, strings.TrimSuffix(r.From, string(filepath.Separator))+string(filepath.Separator)) {
+		return r.To + p[len(r.From):]
+	}
+	return p
+}
+
+// rewriteStateFile rewrites the file paths recorded in the state file name
+// with r.
+func rewriteStateFile(name string, b []byte, r *pathRewrite) ([]byte, error) {
+	if r == nil {
+		return b, nil
+	}
+	switch name {
+	case "journal.jsonl":
+		var out bytes.Buffer
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			var e journalEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				// Skip lines torn by an interrupted write.
+				continue
+			}
+			e.Path = r.apply(e.Path)
+			line, err := json.Marshal(&e)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(append(line, '\n'))
+		}
+		return out.Bytes(), scanner.Err()
+	case "quarantine.json":
+		var entries []*quarantineEntry
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, e := range entries {
+			e.Path = r.apply(e.Path)
+		}
+		return json.MarshalIndent(entries, "", "  ")
+	}
+	return b, nil
+}
+
+// runImportState implements the import-state command.
+func runImportState(fs *flag.FlagSet) func() error {
+	var force bool
+	var rewrite string
+	fs.BoolVar(&force, "force", false, "Replace the existing state instead of refusing to overwrite it")
+	fs.StringVar(&rewrite, "rewrite", "", "Rewrite recorded file paths starting with one directory to another, as in /home/me/Music=/Users/me/Music")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import-state [flags] state.tar.gz\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		var r *pathRewrite
+		if rewrite != "" {
+			from, to, ok := strings.Cut(rewrite, "=")
+			if !ok || from == "" || to == "" {
+				return fmt.Errorf("invalid rewrite %q: want old=new", rewrite)
+			}
+			r = &pathRewrite{From: filepath.Clean(from), To: filepath.Clean(to)}
+		}
+		dir, err := appDir()
+		if err != nil {
+			return err
+		}
+
+		// Read the whole archive before touching anything, so that a damaged
+		// archive leaves the state as it was.
+		files, err := readStateArchive(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if !force {
+			for _, name := range stateFiles {
+				if _, ok := files[name]; !ok {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					return fmt.Errorf("%s already exists; use -force to replace the state", name)
+				}
+			}
+		}
+		for name, b := range files {
+			if files[name], err = rewriteStateFile(name, b, r); err != nil {
+				return err
+			}
+		}
+
+		err = withStateLock(func() error {
+			if err := os.MkdirAll(filepath.Join(dir, "snapshots"), 0755); err != nil {
+				return err
+			}
+			for name, b := range files {
+				dst := filepath.Join(dir, filepath.FromSlash(name))
+				if strings.HasPrefix(name, "snapshots/") {
+					// Snapshots are named by their digest, so existing ones are the same.
+					if _, err := os.Stat(dst); err == nil {
+						continue
+					}
+					if err := os.WriteFile(dst, b, 0644); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := writeFileAtomic(dst, b); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d files into %s\n", len(files), dir)
+		return nil
+	}
+}
+
+// readStateArchive returns the state files in the archive written by
+// export-state at name, by their name relative to appDir.
+func readStateArchive(name string) (map[string][]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !isStateFile(hdr.Name) {
+			return nil, fmt.Errorf("%s: unexpected entry %q", name, hdr.Name)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files[hdr.Name] = b
+	}
+	return files, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateMu serializes updates of the state in appDir within this process, such
+// as those of concurrent requests to serve.
+var stateMu sync.Mutex
+
+// stateLockTimeout is how long to wait for another process, e.g. a running
+// watch or serve, to finish updating the state.
+const stateLockTimeout = 30 * time.Second
+
+// errStateBusy is returned when the state stayed locked by another process for
+// longer than stateLockTimeout.
+var errStateBusy = errors.New("state is locked by another mp3extra process")
+
+// withStateLock calls fn while holding the lock on the state in appDir, so that
+// read-modify-write updates of the journal, statistics and quarantine by other
+// goroutines and processes do not interleave with fn. It retries while another
+// process holds the lock.
+func withStateLock(fn func() error) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	dir, err := appDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "state.lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	deadline := time.Now().Add(stateLockTimeout)
+	wait := 10 * time.Millisecond
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			return fmt.Errorf("error locking state: %w", err)
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errStateBusy
+		}
+		time.Sleep(wait)
+		wait = min(wait*2, 250*time.Millisecond)
+	}
+	defer unlockFile(f)
+	return fn()
+}
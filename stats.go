@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "stats",
+		usage: "Show statistics, e.g. 'stats providers' for lookup hit rates",
+		run:   runStats,
+	})
+}
+
+// Providers used for automatic lookups.
+const (
+	providerITunes   = "itunes"
+	providerLrclib   = "lrclib"
+	providerAcoustID = "acoustid"
+)
+
+// providerStats counts the outcomes of the lookups made with one provider.
+type providerStats struct {
+	Hits      int           `json:"hits"`     // found a result
+	Misses    int           `json:"misses"`   // answered, but knew nothing
+	Failures  int           `json:"failures"` // network, API or decoding errors
+	TotalTime time.Duration `json:"total_time"`
+	LastUsed  time.Time     `json:"last_used"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+// Lookups returns the number of lookups made.
+func (s *providerStats) Lookups() int {
+	return s.Hits + s.Misses + s.Failures
+}
+
+// HitRate returns the share of lookups that found a result.
+func (s *providerStats) HitRate() float64 {
+	if s.Lookups() == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Lookups())
+}
+
+// statsPath returns the location of the provider statistics file.
+func statsPath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stats.json"), nil
+}
+
+// loadProviderStats reads the persisted provider statistics.
+func loadProviderStats() (map[string]*providerStats, error) {
+	name, err := statsPath()
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]*providerStats{}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return stats, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &stats); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return stats, nil
+}
+
+// isNotFound reports whether err means that a provider had no result, as
+// opposed to the lookup failing.
+func isNotFound(err error) bool {
+	return errors.Is(err, errArtNotFound) || errors.Is(err, errLyricsNotFound) || errors.Is(err, errNoFingerprintMatch)
+}
+
+// recordLookup adds the outcome of a lookup with provider, started at start, to
+// the persisted statistics. Statistics are best effort, so errors are only logged.
+func recordLookup(provider string, start time.Time, lookupErr error) {
+	if err := withStateLock(func() error {
+		return updateProviderStats(provider, start, lookupErr)
+	}); err != nil {
+		log.Printf("Error recording provider statistics: %v", err)
+	}
+}
+
+// updateProviderStats adds the outcome of a lookup to the persisted statistics.
+func updateProviderStats(provider string, start time.Time, lookupErr error) error {
+	stats, err := loadProviderStats()
+	if err != nil {
+		return err
+	}
+	s := stats[provider]
+	if s == nil {
+		s = &providerStats{}
+		stats[provider] = s
+	}
+	switch {
+	case lookupErr == nil:
+		s.Hits++
+	case isNotFound(lookupErr):
+		s.Misses++
+	default:
+		s.Failures++
+		s.LastError = lookupErr.Error()
+	}
+	s.TotalTime += time.Since(start)
+	s.LastUsed = time.Now()
+
+	name, err := statsPath()
+	if err != nil {
+		return err
+	}
+	return writeJSONFile(name, stats)
+}
+
+// writeJSONFile replaces the file at name with v encoded as JSON, see
+// writeFileAtomic.
+func writeJSONFile(name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name, append(b, '\n'))
+}
+
+// writeFileAtomic replaces the file at name with b by way of a temporary file,
+// so readers never see a partial file.
+func writeFileAtomic(name string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// printProviderStats prints a table of the provider statistics.
+func printProviderStats() error {
+	stats, err := loadProviderStats()
+	if err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		fmt.Println("No lookups recorded yet.")
+		return nil
+	}
+	var names []string
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROVIDER\tLOOKUPS\tHITS\tMISSES\tFAILURES\tHIT RATE\tAVG TIME\tLAST USED")
+	for _, name := range names {
+		s := stats[name]
+		var avg time.Duration
+		if s.Lookups() > 0 {
+			avg = s.TotalTime / time.Duration(s.Lookups())
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f%%\t%v\t%s\n", name, s.Lookups(), s.Hits, s.Misses, s.Failures,
+			s.HitRate()*100, avg.Round(time.Millisecond), s.LastUsed.Format(time.DateTime))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if s := stats[name]; s.LastError != "" {
+			fmt.Printf("\nLast %s failure: %s\n", name, s.LastError)
+		}
+	}
+	return nil
+}
+
+// runStats implements the stats command.
+func runStats(fs *flag.FlagSet) func() error {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s stats providers\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() != 1 || fs.Arg(0) != "providers" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		return printProviderStats()
+	}
+}
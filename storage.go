@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// objectStore is a cloud object storage service whose objects can be tagged
+// like files, addressed as scheme://bucket/key.
+type objectStore interface {
+	// get starts downloading the object key of bucket.
+	get(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+
+	// put uploads size bytes from r as the object key of bucket.
+	put(ctx context.Context, bucket, key string, r io.Reader, size int64) error
+}
+
+// objectStores holds the object storage services by URL scheme.
+var objectStores = map[string]objectStore{
+	"s3": s3Store{},
+	"gs": gcsStore{},
+}
+
+// parseObjectURL splits an object URL such as s3://bucket/key.mp3 into its
+// store, bucket and key. ok is false for anything else.
+func parseObjectURL(name string) (store objectStore, bucket, key string, ok bool) {
+	scheme, rest, found := strings.Cut(name, "://")
+	if !found {
+		return nil, "", "", false
+	}
+	store, ok = objectStores[scheme]
+	if !ok {
+		return nil, "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	return store, bucket, key, bucket != "" && key != ""
+}
+
+// isObjectURL reports whether name addresses an object in cloud storage.
+func isObjectURL(name string) bool {
+	_, _, _, ok := parseObjectURL(name)
+	return ok
+}
+
+// openObject starts downloading the object at the object URL name.
+func openObject(name string) (io.ReadCloser, error) {
+	store, bucket, key, ok := parseObjectURL(name)
+	if !ok {
+		return nil, fmt.Errorf("invalid object URL: %s", name)
+	}
+	debugf("downloading %s", name)
+	return store.get(context.Background(), bucket, key)
+}
+
+// uploadObject uploads the file at path to the object URL name.
+func uploadObject(name, path string) error {
+	store, bucket, key, ok := parseObjectURL(name)
+	if !ok {
+		return fmt.Errorf("invalid object URL: %s", name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	debugf("uploading %s (%d bytes)", name, fi.Size())
+	return store.put(context.Background(), bucket, key, f, fi.Size())
+}
+
+// objectResponse checks the response to a request for the object at name. A
+// missing object is reported like a missing file, and other failures like
+// failed requests.
+func objectResponse(resp *http.Response, op, name string) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	msg := resp.Status
+	// S3 explains errors in XML and GCS in JSON; either is short.
+	if b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096)); len(b) > 0 {
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(b, &s3Err) == nil && s3Err.Code != "" {
+			msg += ": " + s3Err.Code + ": " + s3Err.Message
+		} else {
+			msg += ": " + strings.TrimSpace(string(b))
+		}
+	}
+	return &url.Error{Op: op, URL: name, Err: errors.New(msg)}
+}
+
+// s3Store is Amazon S3 or a compatible service. Credentials, the region and
+// a custom endpoint such as a MinIO server are taken from the usual AWS
+// environment variables; without credentials requests are anonymous.
+type s3Store struct{}
+
+// s3Region returns the region of S3 requests.
+func s3Region() string {
+	for _, v := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if r := os.Getenv(v); r != "" {
+			return r
+		}
+	}
+	return "us-east-1"
+}
+
+// objectURL returns the HTTPS URL of key in bucket. Custom endpoints are
+// addressed in path style, AWS itself in virtual-hosted style.
+func (s3Store) objectURL(bucket, key string) string {
+	for _, v := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+		if ep := os.Getenv(v); ep != "" {
+			return strings.TrimSuffix(ep, "/") + "/" + bucket + "/" + awsEscape(key)
+		}
+	}
+	return "https://" + bucket + ".s3." + s3Region() + ".amazonaws.com/" + awsEscape(key)
+}
+
+func (s s3Store) get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	signS3(req, time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := objectResponse(resp, "get", "s3://"+bucket+"/"+key); err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s s3Store) put(ctx context.Context, bucket, key string, r io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(bucket, key), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "audio/mpeg")
+	signS3(req, time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	if err := objectResponse(resp, "put", "s3://"+bucket+"/"+key); err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// signS3 signs req at time t with AWS Signature Version 4. Unless the hash of
+// the payload was set, the payload is left unsigned so that it can be
+// streamed. Without AWS_ACCESS_KEY_ID the request is left anonymous.
+func signS3(req *http.Request, t time.Time) {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return
+	}
+	region := s3Region()
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = "UNSIGNED-PAYLOAD"
+		req.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	// The canonical request lists the host and every header set so far.
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonHeaders, "%s:%s\n", k, headers[k])
+	}
+	signed := strings.Join(names, ";")
+	query := req.URL.Query()
+	var params []string
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(params)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{req.Method, path, strings.Join(params, "&"), canonHeaders.String(), signed, payload}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", id, scope, signed, sig))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes s as AWS signatures require, leaving only
+// unreserved characters and slashes as they are.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// gcsStore is Google Cloud Storage. Requests are authorized with the access
+// token in GOOGLE_OAUTH_ACCESS_TOKEN or else the one gcloud prints; without
+// either they are anonymous.
+type gcsStore struct{}
+
+// gcsToken returns the OAuth access token for GCS requests, or "".
+func gcsToken() string {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token
+	}
+	if !hasCommand("gcloud") {
+		return ""
+	}
+	out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// gcsEndpoint returns the base URL of the GCS API, which STORAGE_EMULATOR_HOST
+// overrides as for the Google client libraries.
+func gcsEndpoint() string {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return strings.TrimSuffix(host, "/")
+	}
+	return "https://storage.googleapis.com"
+}
+
+// do sends req with the access token, if there is one.
+func (gcsStore) do(req *http.Request) (*http.Response, error) {
+	if token := gcsToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (s gcsStore) get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	u := gcsEndpoint() + "/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(key) + "?alt=media"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := objectResponse(resp, "get", "gs://"+bucket+"/"+key); err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s gcsStore) put(ctx context.Context, bucket, key string, r io.Reader, size int64) error {
+	u := gcsEndpoint() + "/upload/storage/v1/b/" + url.PathEscape(bucket) + "/o?uploadType=media&name=" + url.QueryEscape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "audio/mpeg")
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	if err := objectResponse(resp, "put", "gs://"+bucket+"/"+key); err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
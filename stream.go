@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// stdinPath is the file argument that reads an MP3 file from standard input
+// and writes it with the new tag to standard output.
+const stdinPath = "-"
+
+// isURL reports whether the file argument name is an HTTP or HTTPS URL, which
+// is downloaded as a stream.
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// isStreamSource reports whether the file argument name is streamed rather
+// than a file on disk.
+func isStreamSource(name string) bool {
+	return name == stdinPath || isURL(name) || isRemote(name)
+}
+
+// isRemote reports whether name addresses a file in object storage or on an
+// SSH server, which is written back to where it was read from.
+func isRemote(name string) bool {
+	return isObjectURL(name) || isSFTPURL(name)
+}
+
+// streamIncompatible lists the flags of the embed mode that need a file on
+// disk or the terminal, which a stream has neither of.
+var streamIncompatible = []string{"backup", "backup-dir", "verify-audio", "id3v1", "preserve-mtime", "fingerprint", "quarantine", "pick", "interactive", "manifest", "report", "output"}
+
+// tagStream is an MP3 file passed through from in to out, with only its tag
+// rewritten. The audio is copied as it arrives, so no more than the tag is
+// held in memory and nothing is written to disk.
+type tagStream struct {
+	name string // stdinPath or the URL, for messages
+	in   *bufio.Reader
+	out  io.Writer
+
+	// raw is the ID3v2 tag read from in, or nil if the stream has none.
+	raw []byte
+
+	// patch, if not nil, overwrites the start of the file the stream was read
+	// from with a tag of the size of raw. Tags that fit are written this way,
+	// leaving the audio alone, and patched is set.
+	patch   func(b []byte) error
+	patched bool
+}
+
+// newTagStream returns the stream called name from in to out.
+func newTagStream(name string, in io.Reader, out io.Writer) *tagStream {
+	return &tagStream{name: name, in: bufio.NewReader(in), out: out}
+}
+
+// readTag reads the ID3v2 tag at the start of the stream, leaving the audio
+// to be copied by write.
+func (s *tagStream) readTag() (*id3v2.Tag, error) {
+	h, err := s.in.Peek(10)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(h) == 10 && string(h[:3]) == "ID3" {
+		size, _ := id3v2TagSize(bytes.NewReader(h))
+		if err := checkSize("ID3v2 tag", size, maxTagBytes); err != nil {
+			return nil, err
+		}
+		s.raw = make([]byte, size)
+		if _, err := io.ReadFull(s.in, s.raw); err != nil {
+			return nil, fmt.Errorf("error reading the ID3v2 tag: %w", err)
+		}
+	}
+	return s.parse()
+}
+
+// parse returns the tag read from the stream, parsed like openTag does.
+func (s *tagStream) parse() (*id3v2.Tag, error) {
+	if s.raw == nil {
+		return id3v2.NewEmptyTag(), nil
+	}
+	tag, err := id3v2.ParseReader(bytes.NewReader(s.raw), id3v2.Options{Parse: true, ParseFrames: []string{""}})
+	if err != nil {
+		return nil, err
+	}
+	version, raw, err := parseRawFrames(bytes.NewReader(s.raw))
+	if err != nil {
+		return nil, err
+	}
+	setRawFrames(tag, s.name, version, raw)
+	return tag, nil
+}
+
+// keepProtected is keepProtectedTo for the tag read from the stream.
+func (s *tagStream) keepProtected(w io.Writer, tag *id3v2.Tag) error {
+	rules, err := protectedRules()
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	orig, err := s.parse()
+	if err != nil {
+		return err
+	}
+	if restored := restoreProtected(tag, orig, rules); len(restored) > 0 {
+		fmt.Fprintf(w, "%s: leaving write-protected %s unchanged\n", s.name, strings.Join(restored, ", "))
+	}
+	return nil
+}
+
+// embedStream runs the embed mode with opts on the MP3 file streamed from
+// standard input or downloaded from a URL or object storage, which fs was given
+// as its only file. The result is written to the file or object out, or else
+// back to the object or to standard output.
+func embedStream(fs *flag.FlagSet, opts *embedOptions, out string) error {
+	src := fs.Arg(0)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s cannot be combined with other files", src)
+	}
+	var bad []string
+	fs.Visit(func(f *flag.Flag) {
+		if slices.Contains(streamIncompatible, f.Name) && f.Value.String() != f.DefValue {
+			bad = append(bad, "-"+f.Name)
+		}
+	})
+	if len(bad) > 0 {
+		return fmt.Errorf("%s cannot be used with %s", strings.Join(bad, ", "), src)
+	}
+
+	var in io.Reader = os.Stdin
+	if src == stdinPath {
+		if isTerminal(os.Stdin) {
+			return errors.New("standard input is a terminal, not an MP3 file")
+		}
+	} else {
+		body, err := openSource(src)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		in = body
+	}
+
+	// Remote files are tagged in place unless -o says otherwise.
+	if out == "" && isRemote(src) {
+		out = src
+	}
+	var w io.Writer = os.Stdout
+	var tmp *os.File
+	switch {
+	case opts.dryRun:
+		w = io.Discard
+	case out != "":
+		// The file is only replaced once the whole stream was written. Remote
+		// files are uploaded from a temporary file, as their size must be known.
+		dir, pattern := filepath.Dir(out), filepath.Base(out)+".tmp*"
+		if isRemote(out) {
+			dir, pattern = "", "mp3extra-*.mp3"
+		}
+		var err error
+		tmp, err = os.CreateTemp(dir, pattern)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		w = tmp
+	case isTerminal(os.Stdout):
+		return errors.New("standard output is a terminal: redirect it or write to a file with -o")
+	default:
+		// Messages go to standard error, as standard output carries the file.
+		opts.out = os.Stderr
+	}
+	opts.stream = newTagStream(src, in, w)
+	// A tag that fits in the space of the old one is rewritten on the SSH
+	// server without transferring the audio.
+	if out == src && isSFTPURL(src) {
+		opts.stream.patch = func(b []byte) error { return patchSFTP(src, b) }
+	}
+	_, err := embedFile(src, opts)
+	if tmp == nil {
+		return err
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case opts.stream.patched:
+	case isObjectURL(out):
+		err = uploadObject(out, tmp.Name())
+	case isSFTPURL(out):
+		err = uploadSFTP(out, tmp.Name())
+	default:
+		err = os.Rename(tmp.Name(), out)
+	}
+	if err != nil {
+		return err
+	}
+	opts.infof("Embedded successfully in %s", out)
+	return nil
+}
+
+// openSource starts downloading the file at the URL, object or sftp URL name.
+func openSource(name string) (io.ReadCloser, error) {
+	switch {
+	case isObjectURL(name):
+		return openObject(name)
+	case isSFTPURL(name):
+		return openSFTP(name)
+	}
+	return openURL(name)
+}
+
+// openURL starts downloading the file at u.
+func openURL(u string) (io.ReadCloser, error) {
+	debugf("downloading %s", u)
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &url.Error{Op: "Get", URL: u, Err: errors.New(resp.Status)}
+	}
+	return resp.Body, nil
+}
+
+// write writes tag followed by the rest of the stream to out, or patches the
+// tag in place if it fits with at most limit bytes of padding.
+func (s *tagStream) write(tag *id3v2.Tag, limit int) error {
+	if s.patch != nil && s.raw != nil {
+		b, ok, err := paddedTag(tag, len(s.raw), limit)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.patched = true
+			return s.patch(b)
+		}
+	}
+	if _, err := tag.WriteTo(s.out); err != nil {
+		return err
+	}
+	_, err := io.Copy(s.out, s.in)
+	return err
+}
+
+// paddedTag returns tag written with padding to size bytes, or false if it is
+// larger than that or would be left with more than limit bytes of padding.
+func paddedTag(tag *id3v2.Tag, size, limit int) ([]byte, bool, error) {
+	var b bytes.Buffer
+	if _, err := tag.WriteTo(&b); err != nil {
+		return nil, false, err
+	}
+	// A tag without frames is written as nothing at all.
+	if b.Len() == 0 {
+		b.Write([]byte{'I', 'D', '3', tag.Version(), 0, 0, 0, 0, 0, 0})
+	}
+	if b.Len() > size || size-b.Len() > limit {
+		return nil, false, nil
+	}
+	padded := make([]byte, size)
+	copy(padded, b.Bytes())
+	putSize(padded[6:10], size-10, true)
+	return padded, true, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "strip",
+		usage: "Remove all tags from MP3 files, leaving the audio untouched",
+		run:   runStrip,
+	})
+}
+
+// runStrip implements the strip command.
+func runStrip(fs *flag.FlagSet) func() error {
+	var v1, dryRun bool
+	fs.BoolVar(&v1, "v1", false, "Also remove the ID3v1 tag at the end of the file")
+	fs.BoolVar(&dryRun, "dryrun", false, "Show what would be removed without modifying the files")
+	manifest := manifestFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s strip [flags] file.mp3|dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		files, err := collectMP3Files(fs.Args())
+		if err != nil {
+			return err
+		}
+		failed := 0
+		var done []string
+		for _, name := range files {
+			if err := stripFile(name, v1, dryRun); err != nil {
+				log.Printf("%s: %v", name, err)
+				failed++
+				continue
+			}
+			done = append(done, name)
+		}
+		if !dryRun {
+			if _, err := updateManifests(*manifest, done); err != nil {
+				return fmt.Errorf("error updating manifest: %w", err)
+			}
+		}
+		if failed > 0 {
+			return &batchError{failed: failed, total: len(files), code: exitFile}
+		}
+		return nil
+	}
+}
+
+// stripFile removes the ID3v2 tag, and the ID3v1 tag if v1 is set, from the MP3
+// file at path. The ID3v2 tag is snapshotted first, so that it can be restored
+// with undo.
+func stripFile(path string, v1, dryRun bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	tagSize, err := id3v2TagSize(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, end, err := audioRange(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fi, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	hasV1 := v1 && end < fi.Size()
+
+	if tagSize == 0 && !hasV1 {
+		fmt.Println("No tags in", path)
+		return nil
+	}
+
+	// Write-protected frames must survive, so a tag holding any is emptied
+	// instead of removed.
+	protected := false
+	if tagSize > 0 {
+		if protected, err = hasProtected(path); err != nil {
+			return fmt.Errorf("error checking write-protected frames: %w", err)
+		}
+	}
+
+	if dryRun {
+		switch {
+		case protected:
+			fmt.Printf("%s: would remove all but the write-protected ID3v2 frames\n", path)
+		case tagSize > 0:
+			fmt.Printf("%s: would remove ID3v2 tag (%d bytes)\n", path, tagSize)
+		}
+		if hasV1 {
+			fmt.Printf("%s: would remove ID3v1 tag (128 bytes)\n", path)
+		}
+		return nil
+	}
+
+	if hasV1 {
+		if err := removeID3v1(path); err != nil {
+			return fmt.Errorf("error removing ID3v1 tag: %w", err)
+		}
+	}
+	if protected {
+		if err := stripUnprotected(path); err != nil {
+			return fmt.Errorf("error removing ID3v2 frames: %w", err)
+		}
+	} else {
+		var snapshot string
+		if tagSize > 0 {
+			if snapshot, err = takeSnapshot(path); err != nil {
+				return fmt.Errorf("error taking snapshot: %w", err)
+			}
+			if err := replaceRawTag(path, nil); err != nil {
+				return fmt.Errorf("error removing ID3v2 tag: %w", err)
+			}
+		}
+		if err := recordWrite(path, snapshot, ""); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Stripped", path)
+	return nil
+}
+
+// stripUnprotected removes all frames but the write-protected ones from the MP3
+// file at path.
+func stripUnprotected(path string) error {
+	tag, err := openTag(path)
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+	tag.DeleteAllFrames()
+	return saveTag(tag, path, &saveOptions{snapshot: true})
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// syncDir flushes the directory entries of dir to disk, so that a file renamed
+// into it survives a power loss.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
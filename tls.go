@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// tlsSettings configure the TLS connections to lookup providers, translation
+// services and storage, for traffic through inspecting proxies and private
+// mirrors.
+type tlsSettings struct {
+	caCert     string // PEM file with CA certificates trusted besides the system ones
+	clientCert string // PEM file with a client certificate
+	clientKey  string // PEM file with its key, if not in clientCert
+	insecure   bool   // skip verifying server certificates
+}
+
+// tlsFlags defines the TLS flags on fs. The returned function applies them
+// once fs is parsed. Like all flags, they can be set through the environment,
+// which setTLSFromEnv applies to every command.
+func tlsFlags(fs *flag.FlagSet) func() error {
+	var s tlsSettings
+	fs.StringVar(&s.caCert, "ca-cert", "", "PEM file with CA certificates to trust besides the system ones, e.g. that of an inspecting proxy or a private mirror")
+	fs.StringVar(&s.clientCert, "client-cert", "", "PEM file with a client certificate to present to servers that require one")
+	fs.StringVar(&s.clientKey, "client-key", "", "PEM file with the key of -client-cert, if it is not in that file")
+	fs.BoolVar(&s.insecure, "insecure-skip-verify", false, "Do not verify the certificates of servers (dangerous: anyone on the network can tamper with the lookups)")
+	return func() error {
+		return s.apply()
+	}
+}
+
+// setTLSFromEnv applies the TLS settings of MP3EXTRA_CA_CERT,
+// MP3EXTRA_CLIENT_CERT, MP3EXTRA_CLIENT_KEY and MP3EXTRA_INSECURE_SKIP_VERIFY,
+// so that they also cover commands without the TLS flags.
+func setTLSFromEnv() error {
+	s := tlsSettings{
+		caCert:     os.Getenv(envName("ca-cert")),
+		clientCert: os.Getenv(envName("client-cert")),
+		clientKey:  os.Getenv(envName("client-key")),
+	}
+	if v := os.Getenv(envName("insecure-skip-verify")); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: want true or false", envName("insecure-skip-verify"), v)
+		}
+		s.insecure = insecure
+	}
+	if err := s.apply(); err != nil {
+		return fmt.Errorf("TLS settings from the environment: %w", err)
+	}
+	return nil
+}
+
+// apply makes HTTP requests use s. Settings that are not given are left
+// alone.
+func (s *tlsSettings) apply() error {
+	if *s == (tlsSettings{}) {
+		return nil
+	}
+	t := http.DefaultTransport.(*http.Transport)
+	conf := t.TLSClientConfig
+	if conf == nil {
+		conf = &tls.Config{}
+	}
+	if s.caCert != "" {
+		b, err := os.ReadFile(s.caCert)
+		if err != nil {
+			return fmt.Errorf("error reading CA certificates: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no PEM certificates in %s", s.caCert)
+		}
+		conf.RootCAs = pool
+	}
+	if s.clientKey != "" && s.clientCert == "" {
+		return errors.New("-client-key needs -client-cert")
+	}
+	if s.clientCert != "" {
+		key := s.clientKey
+		if key == "" {
+			key = s.clientCert
+		}
+		cert, err := tls.LoadX509KeyPair(s.clientCert, key)
+		if err != nil {
+			return fmt.Errorf("error loading client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if s.insecure && !conf.InsecureSkipVerify {
+		log.Printf("WARNING: TLS certificate verification is disabled. Lookups, downloads and API keys can be read and tampered with by anyone on the network path. Use -ca-cert instead if possible.")
+		conf.InsecureSkipVerify = true
+	}
+	t.TLSClientConfig = conf
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// Translation providers, which are recorded in the statistics like lookups.
+const (
+	providerDeepL          = "deepl"
+	providerGoogle         = "google"
+	providerLibreTranslate = "libretranslate"
+)
+
+// translationDescriptor is the content descriptor of translated lyrics, which
+// keeps them apart from lyrics embedded in the same language.
+const translationDescriptor = "Translation"
+
+// translateConfig is the content of translate.json in the config directory,
+// which holds the settings of the translation providers. Their API keys may
+// also be stored with the auth command, which takes precedence.
+type translateConfig struct {
+	// Provider is used unless -translate-provider names another one.
+	Provider string `json:"provider"`
+
+	DeepLKey  string `json:"deepl_key"`
+	GoogleKey string `json:"google_key"`
+
+	// LibreTranslateURL is the address of a LibreTranslate server, which may
+	// not need a key.
+	LibreTranslateURL string `json:"libretranslate_url"`
+	LibreTranslateKey string `json:"libretranslate_key"`
+}
+
+// key returns the API key of provider in c, if any.
+func (c *translateConfig) key(provider string) string {
+	switch provider {
+	case providerDeepL:
+		return c.DeepLKey
+	case providerGoogle:
+		return c.GoogleKey
+	case providerLibreTranslate:
+		return c.LibreTranslateKey
+	}
+	return ""
+}
+
+// translatePath returns the location of the translation settings.
+func translatePath() (string, error) {
+	dir, err := appDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "translate.json"), nil
+}
+
+// loadTranslateConfig reads the translation settings, which are empty if there
+// is no translate.json.
+func loadTranslateConfig() (*translateConfig, error) {
+	name, err := translatePath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return &translateConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c translateConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &c, nil
+}
+
+// translator translates lines of text into another language.
+type translator struct {
+	provider string
+	key      string // may be empty for LibreTranslate
+	config   *translateConfig
+}
+
+// newTranslator returns a translator using the given provider, or the one of
+// the settings if provider is empty.
+func newTranslator(provider string) (*translator, error) {
+	c, err := loadTranslateConfig()
+	if err != nil {
+		return nil, err
+	}
+	if provider == "" {
+		provider = c.Provider
+	}
+	switch provider {
+	case providerDeepL, providerGoogle:
+	case providerLibreTranslate:
+		if c.LibreTranslateURL == "" {
+			return nil, errors.New("libretranslate needs libretranslate_url in translate.json")
+		}
+	case "":
+		return nil, errors.New("no translation provider: set provider in translate.json or use -translate-provider")
+	default:
+		return nil, fmt.Errorf("unknown translation provider %q: want deepl, google or libretranslate", provider)
+	}
+	key := c.key(provider)
+	stored, _, err := credential(provider)
+	switch {
+	case err == nil:
+		key = stored
+	case !errors.Is(err, errNoCredential):
+		return nil, err
+	case key == "" && provider != providerLibreTranslate:
+		return nil, err
+	}
+	return &translator{provider: provider, key: key, config: c}, nil
+}
+
+// iso6391 maps ISO 639-2 language codes, as used in ID3v2 frames, to the ISO
+// 639-1 codes translation services use.
+var iso6391 = map[string]string{
+	"ara": "ar", "bul": "bg", "ces": "cs", "cze": "cs", "dan": "da", "deu": "de",
+	"ger": "de", "ell": "el", "gre": "el", "eng": "en", "spa": "es", "est": "et",
+	"fas": "fa", "per": "fa", "fin": "fi", "fra": "fr", "fre": "fr", "heb": "he",
+	"hin": "hi", "hun": "hu", "ind": "id", "ita": "it", "jpn": "ja", "kor": "ko",
+	"lit": "lt", "lav": "lv", "nob": "nb", "nor": "nb", "nld": "nl", "dut": "nl",
+	"pol": "pl", "por": "pt", "ron": "ro", "rum": "ro", "rus": "ru", "slk": "sk",
+	"slo": "sk", "slv": "sl", "swe": "sv", "tha": "th", "tur": "tr", "ukr": "uk",
+	"vie": "vi", "zho": "zh", "chi": "zh",
+}
+
+// translationLanguage returns the ISO 639-1 code of an ISO 639-2 code.
+func translationLanguage(lang string) (string, error) {
+	code, ok := iso6391[strings.ToLower(lang)]
+	if !ok {
+		return "", fmt.Errorf("unsupported translation language %q", lang)
+	}
+	return code, nil
+}
+
+// translate translates lines from the language from into the language to,
+// both ISO 639-2 codes. The source language is detected if from is not known.
+func (t *translator) translate(lines []string, from, to string) ([]string, error) {
+	target, err := translationLanguage(to)
+	if err != nil {
+		return nil, err
+	}
+	source, _ := translationLanguage(from)
+	start := time.Now()
+	var out []string
+	switch t.provider {
+	case providerDeepL:
+		out, err = t.deepL(lines, source, target)
+	case providerGoogle:
+		out, err = t.google(lines, source, target)
+	case providerLibreTranslate:
+		out, err = t.libreTranslate(lines, source, target)
+	}
+	if err == nil && len(out) != len(lines) {
+		err = fmt.Errorf("%s: got %d translations for %d lines", t.provider, len(out), len(lines))
+	}
+	recordLookup(t.provider, start, err)
+	return out, err
+}
+
+// postJSON posts the JSON encoding of body to u and decodes the response into
+// v. Responses with an error status are returned as errors.
+func postJSON(u string, header http.Header, body, v any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// deepL translates lines with the DeepL API. Keys of free accounts end in
+// ":fx" and have their own endpoint.
+func (t *translator) deepL(lines []string, source, target string) ([]string, error) {
+	endpoint := "https://api.deepl.com/v2/translate"
+	if strings.HasSuffix(t.key, ":fx") {
+		endpoint = "https://api-free.deepl.com/v2/translate"
+	}
+	// DeepL wants a variant for English and Portuguese targets.
+	switch target {
+	case "en":
+		target = "en-US"
+	case "pt":
+		target = "pt-PT"
+	}
+	body := map[string]any{"text": lines, "target_lang": strings.ToUpper(target)}
+	if source != "" {
+		body["source_lang"] = strings.ToUpper(source)
+	}
+	var result struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	header := http.Header{"Authorization": {"DeepL-Auth-Key " + t.key}}
+	if err := postJSON(endpoint, header, body, &result); err != nil {
+		return nil, fmt.Errorf("deepl: %w", err)
+	}
+	var out []string
+	for _, tr := range result.Translations {
+		out = append(out, tr.Text)
+	}
+	return out, nil
+}
+
+// google translates lines with the Google Cloud Translation API.
+func (t *translator) google(lines []string, source, target string) ([]string, error) {
+	body := map[string]any{"q": lines, "target": target, "format": "text"}
+	if source != "" {
+		body["source"] = source
+	}
+	var result struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText string `json:"translatedText"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	u := "https://translation.googleapis.com/language/translate/v2?key=" + url.QueryEscape(t.key)
+	if err := postJSON(u, nil, body, &result); err != nil {
+		return nil, fmt.Errorf("google: %w", err)
+	}
+	var out []string
+	for _, tr := range result.Data.Translations {
+		out = append(out, tr.TranslatedText)
+	}
+	return out, nil
+}
+
+// libreTranslate translates lines with a LibreTranslate server.
+func (t *translator) libreTranslate(lines []string, source, target string) ([]string, error) {
+	if source == "" {
+		source = "auto"
+	}
+	body := map[string]any{"q": lines, "source": source, "target": target, "format": "text"}
+	if t.key != "" {
+		body["api_key"] = t.key
+	}
+	var result struct {
+		TranslatedText []string `json:"translatedText"`
+	}
+	u := strings.TrimSuffix(t.config.LibreTranslateURL, "/") + "/translate"
+	if err := postJSON(u, nil, body, &result); err != nil {
+		return nil, fmt.Errorf("libretranslate: %w", err)
+	}
+	return result.TranslatedText, nil
+}
+
+// lrcPrefix matches the time tags at the start of a line of LRC lyrics, and
+// lrcTag lines such as "[ar:Artist]" that hold no lyrics.
+var (
+	lrcPrefix = regexp.MustCompile(`^(\[\d+:\d+(?:[.:]\d+)?\])+`)
+	lrcTag    = regexp.MustCompile(`^\[[a-z]+:.*\]$`)
+)
+
+// translateLyrics translates plain or LRC lyrics line by line, keeping the
+// time tags of LRC lyrics so that the translation stays synchronised.
+func (t *translator) translateLyrics(lyrics, from, to string) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(lyrics, "\r\n", "\n"), "\n")
+	var text []string
+	var index []int
+	for i, l := range lines {
+		l = strings.TrimSpace(lrcPrefix.ReplaceAllString(l, ""))
+		if l == "" || lrcTag.MatchString(l) {
+			continue
+		}
+		text = append(text, l)
+		index = append(index, i)
+	}
+	if len(text) == 0 {
+		return "", errors.New("no lyrics to translate")
+	}
+	out, err := t.translate(text, from, to)
+	if err != nil {
+		return "", err
+	}
+	for n, i := range index {
+		lines[i] = lrcPrefix.FindString(lines[i]) + out[n]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// lyricsToTranslate returns the lyrics of tag in the language lang that are
+// translated: those with the default descriptor, or else the first ones.
+func lyricsToTranslate(tag *id3v2.Tag, lang string) (string, bool) {
+	var found *id3v2.UnsynchronisedLyricsFrame
+	for _, f := range tag.GetFrames(tag.CommonID("Unsynchronised lyrics/text transcription")) {
+		uslt, ok := f.(id3v2.UnsynchronisedLyricsFrame)
+		if !ok || !strings.EqualFold(uslt.Language, lang) || uslt.ContentDescriptor == translationDescriptor {
+			continue
+		}
+		if found == nil || uslt.ContentDescriptor == defaultLyricsDescriptor && found.ContentDescriptor != defaultLyricsDescriptor {
+			found = &uslt
+		}
+	}
+	if found == nil {
+		return "", false
+	}
+	return found.Lyrics, true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "undo",
+		usage: "Restore the tags a file had before the last write",
+		run:   runUndo,
+	})
+}
+
+// undoTarget returns the write that the next undo of entries should revert.
+// Every undo in the history cancels the most recent write not yet undone.
+func undoTarget(entries []*journalEntry) *journalEntry {
+	skip := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		switch entries[i].Op {
+		case "undo":
+			skip++
+		case "write":
+			if skip == 0 {
+				return entries[i]
+			}
+			skip--
+		}
+	}
+	return nil
+}
+
+// runUndo implements the undo command.
+func runUndo(fs *flag.FlagSet) func() error {
+	var list bool
+	fs.BoolVar(&list, "list", false, "List the recorded writes instead of undoing")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s undo [flags] file.mp3...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		for _, name := range fs.Args() {
+			abs, err := filepath.Abs(name)
+			if err != nil {
+				return err
+			}
+			entries, err := readJournal(abs)
+			if err != nil {
+				return err
+			}
+			if list {
+				for _, e := range entries {
+					fmt.Printf("%s %s %s\n", e.Time.Format(time.RFC3339), e.Op, e.Path)
+				}
+				continue
+			}
+
+			e := undoTarget(entries)
+			if e == nil {
+				return fmt.Errorf("nothing to undo for %s", name)
+			}
+			if e.Snapshot == "" {
+				return fmt.Errorf("no snapshot was taken for the last write of %s", name)
+			}
+			snap, err := snapshotPath(e.Snapshot)
+			if err != nil {
+				return err
+			}
+			raw, err := os.ReadFile(snap)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("snapshot of %s is missing", name)
+				}
+				return err
+			}
+			if err := replaceRawTagProtected(abs, raw); err != nil {
+				return fmt.Errorf("error restoring %s: %w", name, err)
+			}
+			if err := appendJournal(&journalEntry{Time: time.Now(), Op: "undo", Path: abs}); err != nil {
+				return err
+			}
+			fmt.Printf("Restored tags of %s from %s\n", name, e.Time.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
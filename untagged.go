@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hintSource is a set of hints together with where they were found and how far
+// they can be trusted.
+type hintSource struct {
+	name  string
+	hints *trackHints
+	conf  confidence
+}
+
+// leadingTrack matches the track number that file names often start with, as
+// in "01 - Title", "01. Title" or "1) Title".
+var leadingTrack = regexp.MustCompile(`^(\d{1,3})\s*(?:[-.)_]\s*|\s+)`)
+
+// parseFilename guesses hints for the MP3 file at path from its name, read as
+// "[track] Artist - Title", and from its directory, read as "Artist - Album".
+// It returns nil if the name holds nothing but a track number.
+func parseFilename(path string) *trackHints {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if !strings.Contains(name, " ") {
+		name = strings.ReplaceAll(name, "_", " ")
+	}
+	h := &trackHints{}
+	if m := leadingTrack.FindStringSubmatch(name); m != nil && len(name) > len(m[0]) {
+		n, _ := strconv.Atoi(m[1])
+		h.Track = strconv.Itoa(n)
+		name = name[len(m[0]):]
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+
+	dirArtist, dirAlbum, dirOK := strings.Cut(filepath.Base(filepath.Dir(path)), " - ")
+	if artist, title, ok := strings.Cut(name, " - "); ok {
+		h.Artist, h.Title = strings.TrimSpace(artist), strings.TrimSpace(title)
+		if dirOK && strings.EqualFold(strings.TrimSpace(dirArtist), h.Artist) {
+			h.Album = strings.TrimSpace(dirAlbum)
+		}
+	} else {
+		h.Title = name
+		if dirOK {
+			h.Artist, h.Album = strings.TrimSpace(dirArtist), strings.TrimSpace(dirAlbum)
+		}
+	}
+	return h
+}
+
+// id3v1Hints returns the fields of the ID3v1 tag of the file at path as hints,
+// or nil if it has none.
+func id3v1Hints(path string) (*trackHints, error) {
+	v1, err := readID3v1(path)
+	if err != nil || v1 == nil {
+		return nil, err
+	}
+	h := &trackHints{Artist: v1.Artist, Title: v1.Title, Album: v1.Album}
+	if v1.Track != 0 {
+		h.Track = strconv.Itoa(int(v1.Track))
+	}
+	return h, nil
+}
+
+// gatherHints collects hints for the MP3 file at path, most reliable first. The
+// companion files of download tools are used if opts.hints is set. A file that
+// had no usable tag is also identified by its audio fingerprint if
+// opts.fingerprint is set, and its ID3v1 tag and file name are used.
+func gatherHints(path string, untagged bool, opts *embedOptions) []hintSource {
+	// A stream has no name, neighbouring files or end to take hints from.
+	if opts.stream != nil {
+		return nil
+	}
+	var sources []hintSource
+	if untagged && opts.fingerprint {
+		if m, err := identifyFile(path); err != nil {
+			log.Printf("Error identifying %s: %v", path, err)
+		} else {
+			conf := confLow
+			if m.Score >= fingerprintConfidence {
+				conf = confHigh
+			}
+			sources = append(sources, hintSource{
+				name:  fmt.Sprintf("audio fingerprint (score %.2f)", m.Score),
+				hints: &trackHints{Artist: m.Artist, Title: m.Title, Album: m.Album, MusicBrainz: &m.IDs},
+				conf:  conf,
+			})
+		}
+	}
+	if opts.hints {
+		if h, src, err := readHints(path); err != nil {
+			log.Printf("Error reading %s: %v", src, err)
+		} else if h != nil {
+			sources = append(sources, hintSource{name: filepath.Base(src), hints: h, conf: confHigh})
+		}
+	}
+	if untagged {
+		if h, err := id3v1Hints(path); err != nil {
+			log.Printf("Error reading ID3v1 tag of %s: %v", path, err)
+		} else if h != nil {
+			sources = append(sources, hintSource{name: "ID3v1 tag", hints: h, conf: confHigh})
+		}
+		if h := parseFilename(path); h != nil {
+			sources = append(sources, hintSource{name: "file name", hints: h, conf: confLow})
+		}
+	}
+	return sources
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// urlFrameIDs are the IDs of the URL link frames with just a URL. WXXX, the
+// user-defined URL frame, has a description as well. WFED, which iTunes writes
+// like a text frame, is not one of them.
+var urlFrameIDs = map[string]bool{
+	"WCOM": true, "WCOP": true, "WOAF": true, "WOAR": true, "WOAS": true,
+	"WORS": true, "WPAY": true, "WPUB": true,
+}
+
+// urlFrame is a URL link frame such as WOAR. The id3v2 library does not read
+// URL frames.
+type urlFrame struct {
+	URL string
+}
+
+func (uf urlFrame) Size() int                { return len(uf.URL) }
+func (uf urlFrame) UniqueIdentifier() string { return uf.URL }
+func (uf urlFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, uf.URL)
+	return int64(n), err
+}
+
+// userURLFrame is a WXXX frame: a URL with a description.
+type userURLFrame struct {
+	Encoding    id3v2.Encoding
+	Description string
+	URL         string
+}
+
+// body returns the encoded body of uf.
+func (uf userURLFrame) body() []byte {
+	b := []byte{uf.Encoding.Key}
+	switch {
+	case uf.Encoding.Equals(id3v2.EncodingISO):
+		d := make([]byte, len([]rune(uf.Description)))
+		putLatin1(d, uf.Description)
+		b = append(b, d...)
+	case uf.Encoding.Equals(id3v2.EncodingUTF8):
+		b = append(b, uf.Description...)
+	default:
+		if uf.Encoding.Equals(id3v2.EncodingUTF16) {
+			b = append(b, 0xfe, 0xff)
+		}
+		for _, u := range utf16.Encode([]rune(uf.Description)) {
+			b = binary.BigEndian.AppendUint16(b, u)
+		}
+	}
+	b = append(b, uf.Encoding.TerminationBytes...)
+	return append(b, uf.URL...)
+}
+
+func (uf userURLFrame) Size() int                { return len(uf.body()) }
+func (uf userURLFrame) UniqueIdentifier() string { return uf.Description }
+func (uf userURLFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(uf.body())
+	return int64(n), err
+}
+
+// errBadURLFrame is returned for WXXX frames whose description is not
+// terminated.
+var errBadURLFrame = errors.New("invalid WXXX frame")
+
+// parseURLFrame parses the body of a URL link frame with the given ID. It
+// returns false for IDs that are not URL frames.
+func parseURLFrame(id string, body []byte, version byte) (f id3v2.Framer, ok bool, err error) {
+	if urlFrameIDs[id] {
+		return urlFrame{URL: latin1(body)}, true, nil
+	}
+	if id != "WXXX" {
+		return nil, false, nil
+	}
+	if len(body) == 0 {
+		return nil, true, errBadURLFrame
+	}
+	// The description ends with a NUL character, two bytes long in UTF-16.
+	end := -1
+	if body[0] == 1 || body[0] == 2 {
+		for i := 1; i+1 < len(body); i += 2 {
+			if body[i] == 0 && body[i+1] == 0 {
+				end = i + 2
+				break
+			}
+		}
+	} else if i := bytes.IndexByte(body[1:], 0); i >= 0 {
+		end = 1 + i + 1
+	}
+	if end < 0 {
+		return nil, true, errBadURLFrame
+	}
+	// The library does not read a TXXX frame that ends with the description, so
+	// it is given an empty value.
+	txxx := append(bytes.Clone(body[:end]), 0, 0)
+	udtf, ok := parseRawFrame("TXXX", txxx, version).(id3v2.UserDefinedTextFrame)
+	if !ok {
+		return nil, true, errBadURLFrame
+	}
+	return userURLFrame{Encoding: udtf.Encoding, Description: udtf.Description, URL: latin1(body[end:])}, true, nil
+}
+
+// checkURL returns an error if url cannot be stored in a URL frame, which holds
+// ISO-8859-1 text; other characters have to be percent-encoded.
+func checkURL(url string) error {
+	if !isASCII(url) {
+		return fmt.Errorf("invalid URL %q: percent-encode characters outside of ASCII", url)
+	}
+	return nil
+}
+
+// setURL replaces the URL frames with the given ID of tag with one holding url,
+// or removes them if url is empty.
+func setURL(tag *id3v2.Tag, id, url string) {
+	tag.DeleteFrames(id)
+	if url != "" {
+		tag.AddFrame(id, urlFrame{URL: url})
+	}
+}
+
+// setUserURL sets the WXXX frame with the given description to url, or removes
+// it if url is empty.
+func setUserURL(tag *id3v2.Tag, desc, url string) {
+	deleteFramesFunc(tag, "WXXX", func(_ int, f id3v2.Framer) bool {
+		uf, ok := f.(userURLFrame)
+		return ok && uf.Description == desc
+	})
+	if url != "" {
+		tag.AddFrame("WXXX", userURLFrame{Encoding: tag.DefaultEncoding(), Description: desc, URL: url})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Verbosity levels of batch commands.
+const (
+	levelQuiet   = -1 // only errors
+	levelNormal  = 0  // also progress per file
+	levelVerbose = 1  // also HTTP requests and matching decisions
+)
+
+// verbosity is the level set by -q and -v.
+var verbosity = levelNormal
+
+// structuredLogs is set when -log-file or -log-format is given. Messages are
+// then logged with log/slog, with the file they are about as an attribute;
+// otherwise they are plain lines on standard error.
+var structuredLogs bool
+
+// logsToStderr is set when structured logs go to standard error rather than
+// to a file.
+var logsToStderr bool
+
+// logFlags defines the -q, -v, -log-file and -log-format flags on fs. The
+// returned function sets up the output once fs is parsed.
+func logFlags(fs *flag.FlagSet) func() error {
+	quiet := fs.Bool("q", false, "Quiet: print nothing but errors")
+	verbose := fs.Bool("v", false, "Verbose: also print HTTP requests and how matches were chosen")
+	logFile := fs.String("log-file", "", "Append structured log records to this file instead of printing messages to standard error")
+	logFormat := fs.String("log-format", "text", "Format of structured log records: text or json; given without -log-file, they go to standard error")
+	return func() error {
+		switch {
+		case *quiet && *verbose:
+			return errors.New("-q and -v cannot be combined")
+		case *quiet:
+			verbosity = levelQuiet
+		case *verbose:
+			verbosity = levelVerbose
+			http.DefaultClient.Transport = &loggingTransport{next: http.DefaultTransport}
+		}
+		fs.Visit(func(f *flag.Flag) {
+			structuredLogs = structuredLogs || f.Name == "log-file" || f.Name == "log-format"
+		})
+		if !structuredLogs {
+			return nil
+		}
+		var w io.Writer = os.Stderr
+		logsToStderr = *logFile == ""
+		if *logFile != "" {
+			f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return fmt.Errorf("error opening log file: %w", err)
+			}
+			w = f
+		}
+		level := slog.LevelInfo
+		switch verbosity {
+		case levelQuiet:
+			level = slog.LevelError
+		case levelVerbose:
+			level = slog.LevelDebug
+		}
+		opts := &slog.HandlerOptions{Level: level}
+		switch *logFormat {
+		case "text":
+			slog.SetDefault(slog.New(slog.NewTextHandler(w, opts)))
+		case "json":
+			slog.SetDefault(slog.New(slog.NewJSONHandler(w, opts)))
+		default:
+			return fmt.Errorf("unknown log format: %s", *logFormat)
+		}
+		return nil
+	}
+}
+
+// logFileEvent logs that something happened to the file at path, such as
+// that it was written. Only structured logs record this, as the progress
+// printed to standard output already says so.
+func logFileEvent(path, msg string, args ...any) {
+	if structuredLogs {
+		slog.Info(msg, append([]any{"path", path}, args...)...)
+	}
+}
+
+// logFileError logs that processing the file at path failed with err.
+func logFileError(path string, err error) {
+	if structuredLogs {
+		slog.Error("failed", "path", path, "error", err, "class", errorClass(err))
+		return
+	}
+	log.Printf("%s: %v", path, err)
+}
+
+// infof prints a line of progress, unless in quiet mode.
+func infof(format string, args ...any) {
+	infoTo(os.Stdout, format, args...)
+}
+
+// infoTo is infof writing to w, unless the progress bar is shown.
+func infoTo(w io.Writer, format string, args ...any) {
+	switch {
+	case verbosity < levelNormal:
+	case progress != nil:
+		progress.printf(format, args...)
+	default:
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+}
+
+// debugf logs a detail such as a matching decision in verbose mode.
+func debugf(format string, args ...any) {
+	switch {
+	case structuredLogs:
+		slog.Debug(fmt.Sprintf(format, args...))
+	case verbosity >= levelVerbose:
+		log.Printf(format, args...)
+	}
+}
+
+// loggingTransport logs the HTTP requests made through it.
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		debugf("%s %s: %v (%v)", req.Method, redactURL(req.URL), err, elapsed)
+	} else {
+		debugf("%s %s: %s (%v)", req.Method, redactURL(req.URL), resp.Status, elapsed)
+	}
+	return resp, err
+}
+
+// redactURL returns u with the values of API keys in the query hidden.
+func redactURL(u *url.URL) string {
+	q := u.Query()
+	redacted := false
+	for _, k := range []string{"key", "client", "api_key"} {
+		if q.Has(k) {
+			q.Set(k, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = q.Encode()
+	return c.String()
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/fsnotify/fsnotify"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "watch",
+		usage: "Watch directories and tag new MP3 files automatically",
+		run:   runWatch,
+	})
+}
+
+// watchedFile is what the watcher knows about a file below a watched directory.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+	changed time.Time // when size or modTime were last seen changing
+	done    bool      // processed, or present before the watch started
+}
+
+// observe records the current size and modification time of the file.
+func (f *watchedFile) observe(fi os.FileInfo) {
+	if fi.Size() != f.size || !fi.ModTime().Equal(f.modTime) {
+		f.size, f.modTime, f.changed = fi.Size(), fi.ModTime(), time.Now()
+	}
+}
+
+// partialSuffixes are appended to the name of a file by download tools while
+// they are still working on it, either to the file itself, which is renamed
+// once complete, or to a control file next to it.
+var partialSuffixes = []string{".part", ".partial", ".crdownload", ".download", ".aria2", ".ytdl"}
+
+// downloading reports whether a download tool is still working on the file at
+// path, judging by the files next to it.
+func downloading(path string) bool {
+	for _, suffix := range partialSuffixes {
+		if _, err := os.Stat(path + suffix); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// watchRoot is a watched directory, which may be on a network share that comes
+// and goes. While the share is unavailable its state is kept, so that pending
+// files are picked up again once it is back.
+type watchRoot struct {
+	path   string
+	online bool
+	armed  bool // the existing files have been recorded
+	mount  bool // the directory was the root of a mounted file system when armed
+	files  map[string]*watchedFile
+}
+
+// available reports whether the directory can be used, and why not otherwise.
+// A share that was mounted on the directory and is now gone leaves behind the
+// empty mount point, which is recognized by lying on its parent's device.
+func (r *watchRoot) available() (bool, string) {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return false, err.Error()
+	}
+	if !fi.IsDir() {
+		return false, "not a directory"
+	}
+	if r.mount && !isMountPoint(r.path) {
+		return false, "share is not mounted"
+	}
+	return true, ""
+}
+
+// isMountPoint reports whether dir is the root of a mounted file system.
+func isMountPoint(dir string) bool {
+	dev, ok := deviceID(dir)
+	if !ok {
+		return false
+	}
+	parent, ok := deviceID(filepath.Dir(dir))
+	return ok && dev != parent
+}
+
+// watcher tags new files appearing below a set of directories. Changes are
+// noticed through file system events where available and by rescanning the
+// directories periodically, which also covers network shares.
+type watcher struct {
+	roots    []*watchRoot
+	opts     embedOptions
+	hooks    *hookConfig
+	debounce time.Duration
+	events   *fsnotify.Watcher // nil when only polling
+
+	// due holds files with recent events and when they count as complete.
+	due map[string]time.Time
+}
+
+// arm watches root and all directories below it for events.
+func (w *watcher) arm(root *watchRoot) {
+	if w.events == nil {
+		return
+	}
+	w.watchTree(root.path)
+}
+
+// watchTree adds event watches for dir and its subdirectories. Failures, such as
+// running out of inotify watches, leave the affected directories to polling.
+func (w *watcher) watchTree(dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if err := w.events.Add(path); err != nil {
+				log.Printf("%s: %v (falling back to polling)", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// rootOf returns the watched directory containing path.
+func (w *watcher) rootOf(path string) *watchRoot {
+	for _, root := range w.roots {
+		if rel, err := filepath.Rel(root.path, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return root
+		}
+	}
+	return nil
+}
+
+// handleEvent notes a file system event. Files are processed once no further
+// events arrived for them within the debounce time, so that files still being
+// written are left alone.
+func (w *watcher) handleEvent(ev fsnotify.Event) {
+	if ev.Has(fsnotify.Create) {
+		if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+			w.watchTree(ev.Name)
+			// Files moved in along with the directory produce no events of their own.
+			if paths, err := collectMP3Files([]string{ev.Name}); err == nil {
+				for _, path := range paths {
+					w.due[path] = time.Now().Add(w.debounce)
+				}
+			}
+			return
+		}
+	}
+	if !isMP3(ev.Name) {
+		return
+	}
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		delete(w.due, ev.Name)
+		return
+	}
+	w.due[ev.Name] = time.Now().Add(w.debounce)
+}
+
+// processDue processes the files whose debounce time has passed.
+func (w *watcher) processDue() {
+	now := time.Now()
+	for path, t := range w.due {
+		if now.Before(t) {
+			continue
+		}
+		delete(w.due, path)
+		root := w.rootOf(path)
+		if root == nil || !root.online || !root.armed {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		f := root.files[path]
+		if f == nil {
+			// The file has been quiet since its last event.
+			f = &watchedFile{size: fi.Size(), modTime: fi.ModTime(), changed: t.Add(-w.debounce)}
+			root.files[path] = f
+		}
+		f.observe(fi)
+		if f.done {
+			continue
+		}
+		if !w.ready(path, f) {
+			w.due[path] = time.Now().Add(w.debounce)
+			continue
+		}
+		w.process(root, path, f)
+	}
+}
+
+// ready reports whether the file at path looks complete: its size has not
+// changed for the debounce time and no download tool is working on it.
+func (w *watcher) ready(path string, f *watchedFile) bool {
+	return time.Since(f.changed) >= w.debounce && !downloading(path)
+}
+
+// poll scans root once and processes the new files that look complete.
+func (w *watcher) poll(root *watchRoot) {
+	if ok, why := root.available(); !ok {
+		if root.online {
+			log.Printf("%s: unavailable (%s), pausing", root.path, why)
+			root.online = false
+		}
+		return
+	}
+	if !root.online {
+		if root.armed {
+			// The watches went away with the share, so set them up again.
+			log.Printf("%s: available again, resuming", root.path)
+			w.arm(root)
+		}
+		root.online = true
+	}
+
+	paths, err := collectMP3Files([]string{root.path})
+	if err != nil {
+		// A scan failing halfway says nothing about which files are gone.
+		log.Printf("%s: %v, pausing", root.path, err)
+		root.online = false
+		return
+	}
+	present := map[string]bool{}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		present[path] = true
+		f := root.files[path]
+		if f == nil {
+			root.files[path] = &watchedFile{size: fi.Size(), modTime: fi.ModTime(), changed: time.Now(), done: !root.armed}
+			continue
+		}
+		if f.done {
+			continue
+		}
+		f.observe(fi)
+		if !w.ready(path, f) {
+			// Possibly still being written; look again on the next scan.
+			continue
+		}
+		w.process(root, path, f)
+	}
+	for path := range root.files {
+		if !present[path] {
+			delete(root.files, path)
+		}
+	}
+	if !root.armed {
+		root.mount = isMountPoint(root.path)
+		root.armed = true
+		w.arm(root)
+		log.Printf("Watching %s (%d files)", root.path, len(root.files))
+	}
+}
+
+// process tags the file at path. If this fails because the share went away,
+// the file stays pending and is retried once the share is back.
+func (w *watcher) process(root *watchRoot, path string, f *watchedFile) {
+	if w.hooks.PostEmbed != "" {
+		w.opts.trace = &embedTrace{}
+	}
+	var result embedResult
+	err := w.hooks.pre(path, &w.opts)
+	if err == nil {
+		result, err = embedFile(path, &w.opts)
+	}
+	if err != nil {
+		if ok, _ := root.available(); !ok {
+			if structuredLogs {
+				slog.Warn("failed, will retry", "path", path, "error", err)
+			} else {
+				log.Printf("%s: %v (will retry)", path, err)
+			}
+			return
+		}
+		logFileError(path, err)
+		if w.opts.notify {
+			desktopNotify("mp3extra: "+filepath.Base(path)+" needs review", err.Error())
+		}
+	}
+	// Files that will be retried get the post_embed hook once they are done.
+	w.hooks.post(path, &w.opts, result, err)
+	f.done = true
+	if fi, err := os.Stat(path); err == nil {
+		f.size, f.modTime = fi.Size(), fi.ModTime()
+	}
+}
+
+// runWatch implements the watch command.
+func runWatch(fs *flag.FlagSet) func() error {
+	w := &watcher{}
+	var interval time.Duration
+	var poll bool
+	fs.StringVar(&w.opts.image, "image", "auto", "Path to image file to embed, 'auto' for automatic cover art fetch or empty to skip")
+	fs.Var(&w.opts.lyrics, "lyrics", "Lyrics to embed as [lang[:descriptor]=]file|auto, where 'auto' fetches them automatically (the default), or empty to skip (may be repeated)")
+	fs.StringVar(&w.opts.lang, "lang", "jpn", "Language code for embedded tag (e.g., jpn, eng)")
+	fs.BoolVar(&w.opts.notify, "notify", false, "Show a desktop notification when a file was tagged or needs review")
+	fs.StringVar(&w.opts.lyricsSourceFrame, "lyrics-source-frame", "", "Record the source URL of fetched lyrics in a TXXX frame with this description (e.g., LYRICS_SOURCE)")
+	fs.StringVar(&w.opts.artSourceFrame, "art-source-frame", "", "Record the URL of fetched cover art in a TXXX frame with this description (e.g., ARTWORK_SOURCE)")
+	fs.BoolVar(&w.opts.hints, "hints", true, "Fill in a missing artist, title and album from .info.json or .nfo files next to the MP3 file")
+	fs.BoolVar(&w.opts.fingerprint, "fingerprint", false, "Identify files without any tag by their audio fingerprint via AcoustID (requires fpcalc and an AcoustID key, see auth)")
+	fs.BoolVar(&w.opts.quarantine, "quarantine", false, "Queue automatic lookups without an exact match for 'mp3extra review' instead of failing")
+	fs.BoolVar(&w.opts.save.preserveMtime, "preserve-mtime", false, "Keep the modification time of the tagged files, for sync tools and players that take a newer file for a changed recording")
+	fs.DurationVar(&interval, "interval", 30*time.Second, "How often to rescan the directories")
+	fs.DurationVar(&w.debounce, "debounce", 10*time.Second, "How long the size of a file must stay unchanged before it is tagged")
+	fs.BoolVar(&poll, "poll", false, "Only rescan periodically instead of using file system events, e.g. for network shares")
+	setupLogs := logFlags(fs)
+	setupProviders := providerFlags(fs)
+	setupTLS := tlsFlags(fs)
+	setupHooks := hookFlags(fs)
+	setupMemory := memoryFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s watch [flags] dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	return func() error {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if err := setupMemory(); err != nil {
+			return err
+		}
+		if err := setupLogs(); err != nil {
+			return err
+		}
+		if err := setupProviders(); err != nil {
+			return err
+		}
+		if err := setupTLS(); err != nil {
+			return err
+		}
+		hooks, err := setupHooks()
+		if err != nil {
+			return err
+		}
+		w.hooks = hooks
+		lyricsGiven := false
+		fs.Visit(func(f *flag.Flag) {
+			lyricsGiven = lyricsGiven || f.Name == "lyrics"
+		})
+		if !lyricsGiven {
+			w.opts.lyrics = lyricsSpecs{{source: "auto"}}
+		}
+		if w.opts.fingerprint {
+			if _, _, err := credential(providerAcoustID); err != nil {
+				return fmt.Errorf("-fingerprint: %w", err)
+			}
+		}
+		w.opts.save.snapshot = true
+		w.opts.pictureType = id3v2.PTFrontCover
+
+		for _, dir := range fs.Args() {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			w.roots = append(w.roots, &watchRoot{path: abs, online: true, files: map[string]*watchedFile{}})
+		}
+		w.due = map[string]time.Time{}
+
+		var events <-chan fsnotify.Event
+		var errs <-chan error
+		if !poll {
+			fsw, err := fsnotify.NewWatcher()
+			if err != nil {
+				log.Printf("File system events unavailable, polling only: %v", err)
+			} else {
+				defer fsw.Close()
+				w.events, events, errs = fsw, fsw.Events, fsw.Errors
+			}
+		}
+
+		for _, root := range w.roots {
+			w.poll(root)
+		}
+		rescan := time.NewTicker(interval)
+		defer rescan.Stop()
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case ev := <-events:
+				w.handleEvent(ev)
+			case err := <-errs:
+				log.Print(err)
+			case <-tick.C:
+				w.processDue()
+			case <-rescan.C:
+				for _, root := range w.roots {
+					w.poll(root)
+				}
+			}
+		}
+	}
+}
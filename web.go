@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// webFrames are the text frames kept by -web-optimize, which is what players
+// show while a stream starts.
+var webFrames = []string{"TIT2", "TPE1", "TPE2", "TALB", "TRCK", "TPOS", "TCON", "TYER", "TDRC"}
+
+// Limits for the cover art kept by -web-optimize, unless -image-max-size or
+// -max-art-bytes ask for others.
+const (
+	webArtSize    = 300
+	webArtQuality = 75
+	webArtBytes   = 32 * 1024
+)
+
+// optimizeForWeb cuts tag down for progressive HTTP streaming, where clients
+// read the first bytes of the file for its metadata: only the frames in
+// webFrames and a small front cover are kept. The id3v2 library writes no
+// padding, and save.noPadding keeps the tag from being written in place with
+// any, so the tag is no larger than its frames. Notes on what was removed
+// are added to review.
+func (opts *embedOptions) optimizeForWeb(tag *id3v2.Tag, review *[]string) {
+	cover := coverPicture(tag)
+	removed := 0
+	for id, frames := range tag.AllFrames() {
+		if !slices.Contains(webFrames, id) {
+			removed += len(frames)
+			tag.DeleteFrames(id)
+		}
+	}
+	if cover == nil {
+		*review = append(*review, fmt.Sprintf("Removed %d frames not needed for streaming", removed))
+		return
+	}
+	removed--
+
+	size, limit := opts.imageMaxSize, opts.maxArtBytes
+	if size == 0 {
+		size = webArtSize
+	}
+	if limit == 0 {
+		limit = webArtBytes
+	}
+	quality := opts.imageQuality
+	if quality == 0 {
+		quality = webArtQuality
+	}
+	b, ct, err := fitImage(cover.Picture, cover.MimeType, size, quality)
+	if err == nil && len(b) > limit {
+		b, err = shrinkImage(b, limit)
+	}
+	if err != nil {
+		// Art that cannot be made small enough is not worth delaying the
+		// stream for.
+		*review = append(*review, fmt.Sprintf("Removed %d frames not needed for streaming and the cover art: %v", removed+1, err))
+		return
+	}
+	placePicture(tag, b, ct, id3v2.PTFrontCover, false)
+	*review = append(*review, fmt.Sprintf("Removed %d frames not needed for streaming, reduced cover art from %s to %s",
+		removed, describeImage(cover.Picture), describeImage(b)))
+}